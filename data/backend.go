@@ -0,0 +1,42 @@
+package data
+
+import (
+	"fmt"
+)
+
+// Supported storage backends.
+const (
+	BackendPogreb = "pogreb"
+	BackendBolt   = "bolt"
+)
+
+// Backend is a key-value store used to persist all data.
+type Backend interface {
+	// Get returns the value for key, or nil if key doesn't exist.
+	Get(key []byte) ([]byte, error)
+	// Put sets the value for key.
+	Put(key, value []byte) error
+	// Delete deletes key; deleting a key that doesn't exist is not an error.
+	Delete(key []byte) error
+	// Has returns true if key exists.
+	Has(key []byte) (bool, error)
+	// ForEach calls fn for every key and value in the store, stopping at the first error.
+	// The key and value are only valid until fn returns, and fn must not modify the store.
+	ForEach(fn func(key, value []byte) error) error
+	// Compact attempts to reclaim unused space.
+	Compact() error
+	// Close closes the store.
+	Close() error
+}
+
+// openBackend opens the Backend specified in options.
+func openBackend(options Options) (Backend, error) {
+	switch options.Backend {
+	case BackendPogreb, "":
+		return openPogrebBackend(options)
+	case BackendBolt:
+		return openBoltBackend(options)
+	default:
+		return nil, fmt.Errorf("unsupported database backend %v", options.Backend)
+	}
+}
@@ -0,0 +1,112 @@
+package data
+
+import (
+	"os"
+	"path"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltFilename is the name of the bbolt database file.
+const boltFilename = "nanorss.db"
+
+// boltBucket is the name of the bucket containing all data.
+var boltBucket = []byte("nanorss")
+
+// boltBackend stores data in a bbolt database.
+type boltBackend struct {
+	db *bolt.DB
+	// tempDir is the directory which should be deleted when an in-memory database is closed.
+	tempDir string
+}
+
+// openBoltBackend opens a bbolt database with options.
+// bbolt doesn't support in-memory databases, so a temporary file is used instead.
+func openBoltBackend(options Options) (Backend, error) {
+	backend := &boltBackend{}
+	dir := options.Dir
+	if options.InMemory {
+		tempDir, err := os.MkdirTemp("", "nanorss-bolt")
+		if err != nil {
+			return nil, err
+		}
+		backend.tempDir = tempDir
+		dir = tempDir
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path.Join(dir, boltFilename), 0600, &bolt.Options{Timeout: 10 * time.Second, NoSync: options.InMemory})
+	if err != nil {
+		backend.removeTempDir()
+		return nil, err
+	}
+	backend.db = db
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+	return backend, nil
+}
+
+func (backend *boltBackend) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid during the transaction.
+		if v := tx.Bucket(boltBucket).Get(key); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (backend *boltBackend) Put(key, value []byte) error {
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	})
+}
+
+func (backend *boltBackend) Delete(key []byte) error {
+	return backend.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+func (backend *boltBackend) Has(key []byte) (bool, error) {
+	var exists bool
+	err := backend.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(boltBucket).Get(key) != nil
+		return nil
+	})
+	return exists, err
+}
+
+func (backend *boltBackend) ForEach(fn func(key, value []byte) error) error {
+	return backend.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(fn)
+	})
+}
+
+// Compact does nothing; bbolt reuses free pages and doesn't need to be compacted.
+func (backend *boltBackend) Compact() error {
+	return nil
+}
+
+func (backend *boltBackend) Close() error {
+	defer backend.removeTempDir()
+	return backend.db.Close()
+}
+
+// removeTempDir removes the temporary directory of an in-memory database.
+func (backend *boltBackend) removeTempDir() {
+	if backend.tempDir != "" {
+		os.RemoveAll(backend.tempDir)
+	}
+}
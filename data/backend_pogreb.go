@@ -0,0 +1,95 @@
+package data
+
+import (
+	"fmt"
+	golog "log"
+	"os"
+	"path"
+	"sync/atomic"
+
+	"github.com/akrylysov/pogreb"
+	"github.com/akrylysov/pogreb/fs"
+	log "github.com/sirupsen/logrus"
+)
+
+// memoryDatabases is the number of opened in-memory databases.
+var memoryDatabases atomic.Uint64
+
+func init() {
+	pogrebLog := golog.New(log.New().Writer(), "", 0)
+	pogreb.SetLogger(pogrebLog)
+}
+
+// pogrebBackend stores data in a pogreb database.
+type pogrebBackend struct {
+	db *pogreb.DB
+}
+
+// openPogrebBackend opens a pogreb database with options.
+func openPogrebBackend(options Options) (Backend, error) {
+	pogrebOptions := &pogreb.Options{FileSystem: fs.OS}
+	dir := options.Dir
+	if options.InMemory {
+		pogrebOptions.FileSystem = fs.Mem
+		// The in-memory filesystem is shared by all databases, use a unique (virtual) directory.
+		// Pogreb still creates an empty directory in the real filesystem, so keep it outside of the working directory.
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		dir = path.Join(dir, fmt.Sprintf("nanorss-memory-%v", memoryDatabases.Add(1)))
+	}
+	db, err := pogreb.Open(dir, pogrebOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &pogrebBackend{db: db}, nil
+}
+
+func (backend *pogrebBackend) Get(key []byte) ([]byte, error) {
+	return backend.db.Get(key)
+}
+
+func (backend *pogrebBackend) Put(key, value []byte) error {
+	return backend.db.Put(key, value)
+}
+
+func (backend *pogrebBackend) Delete(key []byte) error {
+	return backend.db.Delete(key)
+}
+
+func (backend *pogrebBackend) Has(key []byte) (bool, error) {
+	return backend.db.Has(key)
+}
+
+func (backend *pogrebBackend) ForEach(fn func(key, value []byte) error) error {
+	it := backend.db.Items()
+	for {
+		k, v, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+}
+
+func (backend *pogrebBackend) Compact() error {
+	result, err := backend.db.Compact()
+	if err != nil {
+		return err
+	}
+	if result.CompactedSegments != 0 {
+		log.WithField("ReclaimedBytes", result.ReclaimedBytes).
+			WithField("ReclaimedRecords", result.ReclaimedRecords).
+			WithField("CompactedSegments", result.CompactedSegments).
+			Info("Cleanup reclaimed space")
+	}
+	return nil
+}
+
+func (backend *pogrebBackend) Close() error {
+	return backend.db.Close()
+}
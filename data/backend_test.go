@@ -0,0 +1,222 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// backends lists all backends which must pass the conformance tests.
+var backends = []string{BackendPogreb, BackendBolt}
+
+// backendConformanceTests is a list of tests that every Backend must pass.
+var backendConformanceTests = map[string]func(t *testing.T, backend Backend){
+	"GetMissing": func(t *testing.T, backend Backend) {
+		value, err := backend.Get([]byte("missing"))
+		assert.NoError(t, err)
+		assert.Nil(t, value)
+
+		exists, err := backend.Has([]byte("missing"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	},
+	"PutGet": func(t *testing.T, backend Backend) {
+		err := backend.Put([]byte("k1"), []byte("v1"))
+		assert.NoError(t, err)
+		err = backend.Put([]byte("k2"), []byte("v2"))
+		assert.NoError(t, err)
+		err = backend.Put([]byte("k1"), []byte("v1-updated"))
+		assert.NoError(t, err)
+
+		value, err := backend.Get([]byte("k1"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v1-updated"), value)
+
+		// Modifying the returned value should not affect the stored value.
+		value[0] = 'x'
+		value, err = backend.Get([]byte("k1"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v1-updated"), value)
+
+		exists, err := backend.Has([]byte("k2"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	},
+	"PutEmpty": func(t *testing.T, backend Backend) {
+		err := backend.Put([]byte("k1"), []byte{})
+		assert.NoError(t, err)
+
+		value, err := backend.Get([]byte("k1"))
+		assert.NoError(t, err)
+		assert.Empty(t, value)
+
+		exists, err := backend.Has([]byte("k1"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	},
+	"Delete": func(t *testing.T, backend Backend) {
+		err := backend.Put([]byte("k1"), []byte("v1"))
+		assert.NoError(t, err)
+
+		err = backend.Delete([]byte("k1"))
+		assert.NoError(t, err)
+		err = backend.Delete([]byte("missing"))
+		assert.NoError(t, err)
+
+		value, err := backend.Get([]byte("k1"))
+		assert.NoError(t, err)
+		assert.Nil(t, value)
+
+		exists, err := backend.Has([]byte("k1"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	},
+	"ForEach": func(t *testing.T, backend Backend) {
+		expected := map[string]string{}
+		for i := 0; i < 100; i++ {
+			key, value := fmt.Sprintf("k%v", i), fmt.Sprintf("v%v", i)
+			expected[key] = value
+			err := backend.Put([]byte(key), []byte(value))
+			assert.NoError(t, err)
+		}
+		err := backend.Delete([]byte("k0"))
+		assert.NoError(t, err)
+		delete(expected, "k0")
+
+		items := map[string]string{}
+		err = backend.ForEach(func(key, value []byte) error {
+			items[string(key)] = string(value)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, items)
+
+		visited := 0
+		stopErr := fmt.Errorf("stop")
+		err = backend.ForEach(func(key, value []byte) error {
+			visited++
+			return stopErr
+		})
+		assert.Equal(t, stopErr, err)
+		assert.Equal(t, 1, visited)
+	},
+	"Compact": func(t *testing.T, backend Backend) {
+		for i := 0; i < 100; i++ {
+			err := backend.Put([]byte(fmt.Sprintf("k%v", i)), []byte("value"))
+			assert.NoError(t, err)
+		}
+		for i := 0; i < 100; i += 2 {
+			err := backend.Delete([]byte(fmt.Sprintf("k%v", i)))
+			assert.NoError(t, err)
+		}
+
+		err := backend.Compact()
+		assert.NoError(t, err)
+
+		value, err := backend.Get([]byte("k1"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+	},
+}
+
+func TestBackendConformance(t *testing.T) {
+	for _, backendName := range backends {
+		for testName, test := range backendConformanceTests {
+			t.Run(backendName+"/"+testName, func(t *testing.T) {
+				backend, err := openBackend(Options{Backend: backendName, InMemory: true})
+				assert.NoError(t, err)
+				defer backend.Close()
+
+				test(t, backend)
+			})
+		}
+	}
+}
+
+func TestBackendPersistence(t *testing.T) {
+	for _, backendName := range backends {
+		t.Run(backendName, func(t *testing.T) {
+			options := Options{Backend: backendName, Dir: t.TempDir()}
+
+			backend, err := openBackend(options)
+			assert.NoError(t, err)
+			err = backend.Put([]byte("k1"), []byte("v1"))
+			assert.NoError(t, err)
+			err = backend.Close()
+			assert.NoError(t, err)
+
+			backend, err = openBackend(options)
+			assert.NoError(t, err)
+			defer backend.Close()
+			value, err := backend.Get([]byte("k1"))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("v1"), value)
+		})
+	}
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, backendName := range backends {
+		t.Run(backendName, func(t *testing.T) {
+			service, err := Open(Options{Backend: backendName, InMemory: true})
+			assert.NoError(t, err)
+			defer service.Close()
+
+			var store Store = service
+
+			user := NewUser("user01")
+			user.Subscriptions = []UserFeed{{URL: "http://sites-site1.com"}}
+			err = store.SaveUser(user)
+			assert.NoError(t, err)
+
+			usernames, err := store.GetUsers()
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"user01"}, usernames)
+
+			item := &Feeditem{
+				Title:    "t1",
+				URL:      "http://sites-site1.com/t1",
+				Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+				Contents: "c1",
+				Key:      &FeeditemKey{FeedURL: "http://sites-site1.com", GUID: "g1"},
+			}
+			err = store.SaveFeeditems(item)
+			assert.NoError(t, err)
+
+			items, err := store.GetFeeditems(user)
+			assert.NoError(t, err)
+			assert.Len(t, items, 1)
+
+			dbItem, err := store.GetFeeditem(item.Key)
+			assert.NoError(t, err)
+			assert.Equal(t, item, dbItem)
+
+			err = store.SetReadStatus(user, item.Key.CreateKey(), true)
+			assert.NoError(t, err)
+			readItems, err := store.GetReadItems(user)
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{item.Key.CreateKey()}, readItems)
+
+			fetchStatus := &FetchStatus{LastSuccess: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)}
+			err = store.SetFetchStatus([]byte("feed1"), fetchStatus)
+			assert.NoError(t, err)
+			dbFetchStatus, err := store.GetFetchStatus([]byte("feed1"))
+			assert.NoError(t, err)
+			assert.Equal(t, &FetchStatus{LastSuccess: fetchStatus.LastSuccess, RecentFetches: []bool{true}}, dbFetchStatus)
+
+			err = store.SetConfigVariable("var1", "value1")
+			assert.NoError(t, err)
+			configVariables, err := store.GetAllConfigVariables()
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{"var1": "value1"}, configVariables)
+
+			store.GC()
+
+			readItems, err = store.GetReadItems(user)
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{item.Key.CreateKey()}, readItems)
+		})
+	}
+}
@@ -1,20 +1,48 @@
 package data
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	log "github.com/sirupsen/logrus"
 )
 
 // This should be separate from regular data classes in case the structures change and we need to restore data from an older version
 
 // backupUser is a backup-friendly version of User.
+// In backups created before subscriptions and pages were stored as structured records, they're only in User.Opml and User.Pagemonitor.
 type backupUser struct {
 	User
-	Username  string
-	ReadItems []string
+	Username     string
+	ReadItems    []string
+	StarredItems []string
+	Tags         map[string][]string  `json:",omitempty"`
+	Notes        map[string]*ItemNote `json:",omitempty"`
+	// ReadHistory contains the user's read events, oldest first.
+	ReadHistory []*backupReadEvent `json:",omitempty"`
+	// BulkRead is the last bulk read operation, which can be undone.
+	BulkRead *backupBulkRead `json:",omitempty"`
+}
+
+// backupReadEvent is a backup-friendly version of ReadEvent.
+type backupReadEvent struct {
+	Key  string
+	Time time.Time
+	Bulk bool `json:",omitempty"`
+}
+
+// backupBulkRead is a backup-friendly version of bulkRead.
+type backupBulkRead struct {
+	Time time.Time
+	Keys []string
 }
 
 // backupFeeditem is a backup-friendly version of Feeditem and its FeeditemKey.
@@ -29,54 +57,334 @@ type backupPagemonitor struct {
 	UserPagemonitor
 }
 
+// backupVersion is the version of the backup format.
+// Backups created before the format was versioned don't have a version, and are compatible with version 1.
+// Version 2 replaced the users' OPML with structured subscriptions.
+// Version 3 replaced the users' Pagemonitor XML with structured pages.
+// Version 4 added the users' read history.
+const backupVersion = 4
+
 // backupData is the toplevel structure exported in a backup.
 type backupData struct {
+	Version      int
 	Users        []*backupUser
 	Feeds        []*backupFeeditem
 	Pagemonitor  []*backupPagemonitor
 	ServerConfig map[string]string
 }
 
-// Backup returns a serialized copy of all data.
-func (service *DBService) Backup() (string, error) {
-	data := backupData{}
+// Compression is the compression format of a backup.
+type Compression string
+
+const (
+	// CompressionNone writes an uncompressed backup.
+	CompressionNone Compression = ""
+	// CompressionGzip compresses the backup with gzip.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd compresses the backup with zstd.
+	CompressionZstd Compression = "zstd"
+)
+
+// ParseCompression returns the Compression matching value; empty and "none" values mean no compression.
+func ParseCompression(value string) (Compression, error) {
+	switch compression := Compression(strings.ToLower(value)); compression {
+	case CompressionNone, "none":
+		return CompressionNone, nil
+	case CompressionGzip, CompressionZstd:
+		return compression, nil
+	default:
+		return CompressionNone, fmt.Errorf("unsupported compression %v", value)
+	}
+}
+
+// Extension returns the file extension for a backup compressed with compression.
+func (compression Compression) Extension() string {
+	switch compression {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// BackupOptions specifies which data should be included in a backup, and how the backup should be written.
+type BackupOptions struct {
+	Compression Compression
+	// ConfigOnly exports only the server configuration.
+	ConfigOnly bool
+	// Username, if not empty, exports only this user and their items; the server configuration is not exported.
+	Username string
+	// Since, if not zero, exports only feed items published and pages updated since this time.
+	Since time.Time
+	// Passphrase, if not empty, encrypts the backup.
+	Passphrase string
+}
+
+// compressWriter wraps w to compress data according to compression.
+func compressWriter(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported compression %v", compression)
+	}
+}
+
+// nopWriteCloser is an io.WriteCloser which doesn't need to be closed.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing.
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// decompressReader detects if r is compressed and returns a reader for the uncompressed data.
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	gzipMagic := []byte{0x1f, 0x8b}
+	zstdMagic := []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	reader := bufio.NewReader(r)
+	header, err := reader.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read backup header: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return gzip.NewReader(reader)
+	case bytes.HasPrefix(header, zstdMagic):
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(reader), nil
+	}
+}
+
+// backupWriter writes a backup as an indented JSON object, one record at a time.
+type backupWriter struct {
+	w          io.Writer
+	fields     int
+	arrayItems int
+	err        error
+}
+
+// write writes value to the output, unless a previous write failed.
+func (writer *backupWriter) write(value string) {
+	if writer.err != nil {
+		return
+	}
+	_, writer.err = io.WriteString(writer.w, value)
+}
 
-	usernames, err := service.GetUsers()
+// writeJSON writes value as indented JSON, unless a previous write failed.
+func (writer *backupWriter) writeJSON(value interface{}, prefix string) {
+	if writer.err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(value, prefix, "  ")
 	if err != nil {
-		return "", fmt.Errorf("failed to get usernames: %w", err)
+		writer.err = fmt.Errorf("failed to marshal json: %w", err)
+		return
+	}
+	_, writer.err = writer.w.Write(data)
+}
+
+// startField writes the name of the next field in the toplevel object.
+func (writer *backupWriter) startField(name string) {
+	if writer.fields == 0 {
+		writer.write("{\n")
+	} else {
+		writer.write(",\n")
+	}
+	writer.fields++
+	writer.write("  \"" + name + "\": ")
+}
+
+// field writes a field of the toplevel object.
+func (writer *backupWriter) field(name string, value interface{}) {
+	writer.startField(name)
+	writer.writeJSON(value, "  ")
+}
+
+// startArray starts an array field in the toplevel object.
+func (writer *backupWriter) startArray(name string) {
+	writer.startField(name)
+	writer.write("[")
+	writer.arrayItems = 0
+}
+
+// arrayItem writes an item of the current array.
+func (writer *backupWriter) arrayItem(value interface{}) {
+	if writer.arrayItems > 0 {
+		writer.write(",")
+	}
+	writer.arrayItems++
+	writer.write("\n    ")
+	writer.writeJSON(value, "    ")
+}
+
+// endArray ends the current array.
+func (writer *backupWriter) endArray() {
+	if writer.arrayItems > 0 {
+		writer.write("\n  ")
+	}
+	writer.write("]")
+}
+
+// close ends the toplevel object and returns the first error that happened while writing.
+func (writer *backupWriter) close() error {
+	if writer.fields == 0 {
+		writer.write("{")
 	}
+	writer.write("\n}\n")
+	return writer.err
+}
+
+// lastBackupConfigVariable is the ServerConfig variable containing the time of the last scheduled backup.
+const lastBackupConfigVariable = "last-backup-time"
 
-	for _, username := range usernames {
-		user, err := service.GetUser(username)
+// GetLastBackupTime returns the time of the last scheduled backup, or a zero time if no backup was made yet.
+func (service *DBService) GetLastBackupTime() (time.Time, error) {
+	var lastBackup time.Time
+	err := service.view(func() error {
+		value, err := service.db.Get(createServerConfigKey(lastBackupConfigVariable))
+		if err != nil || value == nil {
+			return err
+		}
+		lastBackup, err = time.Parse(time.RFC3339, string(value))
 		if err != nil {
-			return "", fmt.Errorf("failed to get user %v: %w", username, err)
+			return fmt.Errorf("cannot parse last backup time: %w", err)
 		}
-		backupUser := &backupUser{User: *user, Username: user.username}
-		data.Users = append(data.Users, backupUser)
+		return nil
+	})
+	return lastBackup, err
+}
+
+// SetLastBackupTime saves the time of the last scheduled backup.
+func (service *DBService) SetLastBackupTime(lastBackup time.Time) error {
+	return service.SetConfigVariable(lastBackupConfigVariable, lastBackup.UTC().Format(time.RFC3339))
+}
+
+// Backup writes a copy of the data selected by options into w.
+// The data is saved into a temporary file while holding the lock, so that a slow w doesn't block updates.
+func (service *DBService) Backup(w io.Writer, options BackupOptions) error {
+	snapshot, err := os.CreateTemp("", "nanorss-snapshot-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(snapshot.Name())
+	defer snapshot.Close()
 
-		// Extract feeds.
-		feeds, err := service.GetFeeditems(user)
+	buffered := bufio.NewWriter(snapshot)
+	err = service.view(func() error {
+		return service.backup(buffered, options)
+	})
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read snapshot file: %w", err)
+	}
+
+	var encrypted io.WriteCloser = nopWriteCloser{w}
+	if options.Passphrase != "" {
+		encrypted, err = newEncryptWriter(w, options.Passphrase)
 		if err != nil {
-			return "", fmt.Errorf("failed to get feeds for user %v: %w", username, err)
+			return err
+		}
+	}
+	output, err := compressWriter(encrypted, options.Compression)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(output, snapshot); err != nil {
+		err = fmt.Errorf("failed to write backup: %w", err)
+	}
+	if closeErr := output.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to finish compression: %w", closeErr)
+	}
+	if closeErr := encrypted.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to finish encryption: %w", closeErr)
+	}
+	return err
+}
+
+// backup writes a copy of the data selected by options into w, without acquiring a lock.
+func (service *DBService) backup(w io.Writer, options BackupOptions) error {
+	writer := &backupWriter{w: w}
+	writer.field("Version", backupVersion)
+
+	users := make([]*User, 0)
+	if !options.ConfigOnly {
+		usernames, err := service.getUsers()
+		if err != nil {
+			return fmt.Errorf("failed to get usernames: %w", err)
+		}
+		for _, username := range usernames {
+			if options.Username != "" && username != options.Username {
+				continue
+			}
+			user, err := service.getUser(username)
+			if err != nil {
+				return fmt.Errorf("failed to get user %v: %w", username, err)
+			}
+			if user == nil {
+				log.WithField("username", username).Warn("Skipping user which is in the users index but doesn't exist")
+				continue
+			}
+			users = append(users, user)
+		}
+		if options.Username != "" && len(users) == 0 {
+			return fmt.Errorf("user %v doesn't exist", options.Username)
+		}
+	}
+
+	writer.startArray("Users")
+	for _, user := range users {
+		backupUser, err := service.createBackupUser(user)
+		if err != nil {
+			return err
+		}
+		writer.arrayItem(backupUser)
+	}
+	writer.endArray()
+
+	writer.startArray("Feeds")
+	exportedFeeditems := make(map[string]bool)
+	for _, user := range users {
+		feeds, err := service.getBackupFeeditems(user)
+		if err != nil {
+			return err
 		}
 		for _, feedItem := range feeds {
+			key := feedItem.Key.CreateKey()
 			// Check if item already exists.
-			exists := false
-			for i := range data.Feeds {
-				if bytes.Equal(data.Feeds[i].FeeditemKey.CreateKey(), feedItem.Key.CreateKey()) {
-					exists = true
-					break
-				}
-			}
-			if exists {
+			if exportedFeeditems[string(key)] {
+				continue
+			}
+			exportedFeeditems[string(key)] = true
+
+			if !options.Since.IsZero() && feedItem.Date.Before(options.Since) {
 				continue
 			}
 
 			// Get contents.
-			contentsKey := feedItem.Key.createContentsKey()
-			contents, err := service.db.Get(contentsKey)
+			contents, err := service.db.Get(feedItem.Key.createContentsKey())
 			if err != nil {
-				return "", fmt.Errorf("failed to get contents for feed item %v: %w", feedItem.Key, err)
+				return fmt.Errorf("failed to get contents for feed item %v: %w", feedItem.Key, err)
 			}
 			if contents != nil {
 				feedItem.Contents = string(contents)
@@ -89,24 +397,31 @@ func (service *DBService) Backup() (string, error) {
 			}
 			backupFeeditem.Feeditem.Key = nil
 
-			data.Feeds = append(data.Feeds, backupFeeditem)
+			writer.arrayItem(backupFeeditem)
 		}
+	}
+	writer.endArray()
 
-		// Extract pages.
-		pages, err := service.GetPages(user)
+	writer.startArray("Pagemonitor")
+	exportedPages := make(map[string]bool)
+	for _, user := range users {
+		if len(user.Pages) == 0 {
+			// User hasn't configured any pages yet.
+			continue
+		}
+		pages, err := service.getPages(user)
 		if err != nil {
-			return "", fmt.Errorf("failed to get pages for user %v: %w", username, err)
+			return fmt.Errorf("failed to get pages for user %v: %w", user.username, err)
 		}
 		for _, page := range pages {
+			key := page.Config.CreateKey()
 			// Check if item already exists.
-			exists := false
-			for i := range data.Pagemonitor {
-				if bytes.Equal(data.Pagemonitor[i].CreateKey(), page.Config.CreateKey()) {
-					exists = true
-					break
-				}
-			}
-			if exists {
+			if exportedPages[string(key)] {
+				continue
+			}
+			exportedPages[string(key)] = true
+
+			if !options.Since.IsZero() && page.Updated.Before(options.Since) {
 				continue
 			}
 
@@ -116,86 +431,538 @@ func (service *DBService) Backup() (string, error) {
 				UserPagemonitor: *page.Config,
 			}
 			backupPagemonitor.PagemonitorPage.Config = nil
-			data.Pagemonitor = append(data.Pagemonitor, backupPagemonitor)
+			writer.arrayItem(backupPagemonitor)
+		}
+	}
+	writer.endArray()
+
+	if options.Username == "" {
+		serverConfig, err := service.getAllConfigVariables()
+		if err != nil {
+			return fmt.Errorf("failed to backup server configuration: %w", err)
 		}
+		writer.field("ServerConfig", serverConfig)
 	}
 
-	serverConfig, err := service.GetAllConfigVariables()
+	return writer.close()
+}
+
+// getBackupFeeditems returns all feed items which should be backed up for user, without their contents.
+func (service *DBService) getBackupFeeditems(user *User) ([]*Feeditem, error) {
+	feeds := make([]*Feeditem, 0)
+	// Users who haven't configured any feeds yet can still have starred, tagged or noted items.
+	if len(user.Subscriptions) > 0 {
+		var err error
+		feeds, err = service.getFeeditems(user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get feeds for user %v: %w", user.username, err)
+		}
+	}
+	// Starred items might belong to feeds the user is no longer subscribed to.
+	starredFeeds, err := service.getStarredFeeditems(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get starred feeds for user %v: %w", user.username, err)
+	}
+	feeds = append(feeds, starredFeeds...)
+	taggedFeeds, err := service.getTaggedFeeditems(user)
 	if err != nil {
-		return "", fmt.Errorf("failed to backup server configuration: %w", err)
+		return nil, fmt.Errorf("failed to get tagged feeds for user %v: %w", user.username, err)
 	}
-	data.ServerConfig = serverConfig
+	feeds = append(feeds, taggedFeeds...)
+	notedFeeds, err := service.getNotedFeeditems(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get noted feeds for user %v: %w", user.username, err)
+	}
+	return append(feeds, notedFeeds...), nil
+}
 
-	for _, user := range data.Users {
-		readItems, err := service.GetReadItems(&user.User)
+// createBackupUser returns a backup-friendly copy of user, together with the user's read statuses, starred items, tags, notes
+// and read history.
+func (service *DBService) createBackupUser(dbUser *User) (*backupUser, error) {
+	user := &backupUser{User: *dbUser, Username: dbUser.username}
+
+	readItems, err := service.getReadItems(&user.User)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get read status for user: %w", err)
+	}
+
+	user.ReadItems = make([]string, 0, len(readItems))
+
+	for _, itemKey := range readItems {
+		user.ReadItems = append(user.ReadItems, string(itemKey))
+	}
+
+	starredItems, err := service.getStarredItems(&user.User)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get starred items for user: %w", err)
+	}
+
+	user.StarredItems = make([]string, 0, len(starredItems))
+
+	for _, itemKey := range starredItems {
+		user.StarredItems = append(user.StarredItems, string(itemKey))
+	}
+
+	tags, err := service.getTags(&user.User)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags for user: %w", err)
+	}
+
+	if len(tags) > 0 {
+		user.Tags = make(map[string][]string, len(tags))
+	}
+
+	for _, tag := range tags {
+		taggedItems, err := service.getTaggedItems(&user.User, tag)
 		if err != nil {
-			return "", fmt.Errorf("failed to get read status for user: %w", err)
+			return nil, fmt.Errorf("failed to get tagged items for user: %w", err)
+		}
+		user.Tags[tag] = make([]string, 0, len(taggedItems))
+		for _, itemKey := range taggedItems {
+			user.Tags[tag] = append(user.Tags[tag], string(itemKey))
 		}
+	}
 
-		user.ReadItems = make([]string, 0, len(readItems))
+	notes, err := service.getNotes(&user.User)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notes for user: %w", err)
+	}
+
+	if len(notes) > 0 {
+		user.Notes = make(map[string]*ItemNote, len(notes))
+	}
+
+	for _, note := range notes {
+		user.Notes[string(note.Key)] = note
+	}
 
-		for _, itemKey := range readItems {
-			user.ReadItems = append(user.ReadItems, string(itemKey))
+	days, err := service.getReadHistoryDays(&user.User)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get read history for user: %w", err)
+	}
+
+	for i := len(days) - 1; i >= 0; i-- {
+		events, err := service.getReadEvents(&user.User, days[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get read history for user: %w", err)
+		}
+		for _, event := range events {
+			user.ReadHistory = append(user.ReadHistory, &backupReadEvent{Key: string(event.Key), Time: event.Time, Bulk: event.Bulk})
 		}
 	}
 
-	value, err := json.MarshalIndent(data, "", "  ")
+	operation, err := service.getBulkRead(&user.User)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal json: %w", err)
+		return nil, fmt.Errorf("failed to get bulk read operation for user: %w", err)
+	}
+
+	if operation != nil {
+		user.BulkRead = &backupBulkRead{Time: operation.Time, Keys: make([]string, 0, len(operation.Keys))}
+		for _, itemKey := range operation.Keys {
+			user.BulkRead.Keys = append(user.BulkRead.Keys, string(itemKey))
+		}
 	}
 
-	return string(value), nil
+	return user, nil
 }
 
-// Restore replaces database data with the provided serialized value.
-func (service *DBService) Restore(value string) error {
-	data := backupData{}
-	failed := false
-	if err := json.Unmarshal([]byte(value), &data); err != nil {
-		return fmt.Errorf("failed to unmarshal json: %w", err)
+// RestoreMode specifies how a backup is combined with existing data.
+type RestoreMode string
+
+const (
+	// RestoreMerge merges the backup into existing data, overwriting records which exist in both;
+	// existing users are kept, and only get the missing subscriptions and pages.
+	RestoreMerge RestoreMode = "merge"
+	// RestoreReplace deletes all existing data before restoring the backup.
+	RestoreReplace RestoreMode = "replace"
+)
+
+// ParseRestoreMode parses a restore mode name; an empty value defaults to RestoreMerge.
+func ParseRestoreMode(value string) (RestoreMode, error) {
+	switch mode := RestoreMode(strings.ToLower(value)); mode {
+	case "":
+		return RestoreMerge, nil
+	case RestoreMerge, RestoreReplace:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported restore mode %v", value)
 	}
+}
 
-	for _, user := range data.Users {
-		user.username = user.Username
-		if err := service.SaveUser(&user.User); err != nil {
-			failed = true
-			log.WithField("user", user).WithError(err).Printf("Error saving user")
+// RestoreOptions specifies how a backup should be restored.
+type RestoreOptions struct {
+	// Mode specifies how to combine the backup with existing data; defaults to RestoreMerge.
+	Mode RestoreMode
+	// DryRun validates and applies the backup, but discards all changes.
+	DryRun bool
+	// Passphrase is used to decrypt encrypted backups.
+	Passphrase string
+}
+
+// RestoreCounts contains the number of restored records per entity type.
+type RestoreCounts struct {
+	Users        int
+	ReadItems    int
+	StarredItems int
+	Tags         int
+	TaggedItems  int
+	Notes        int
+	ReadEvents   int
+	Feeds        int
+	Pagemonitor  int
+	ServerConfig int
+}
+
+// RestoreIssue is a conflicting or skipped record.
+type RestoreIssue struct {
+	Type   string
+	Key    string
+	Reason string
+}
+
+// RestoreReport describes the outcome of a restore.
+type RestoreReport struct {
+	Version   int
+	Mode      RestoreMode
+	DryRun    bool
+	Restored  RestoreCounts
+	Conflicts []RestoreIssue
+	Skipped   []RestoreIssue
+}
+
+func (report *RestoreReport) conflict(entityType, key, reason string) {
+	report.Conflicts = append(report.Conflicts, RestoreIssue{Type: entityType, Key: key, Reason: reason})
+}
+
+func (report *RestoreReport) skip(entityType, key, reason string) {
+	report.Skipped = append(report.Skipped, RestoreIssue{Type: entityType, Key: key, Reason: reason})
+}
+
+// InvalidBackupError is returned when a backup cannot be read or has an invalid format.
+type InvalidBackupError struct {
+	Err error
+}
+
+// Error returns the reason why the backup is invalid.
+func (e *InvalidBackupError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *InvalidBackupError) Unwrap() error {
+	return e.Err
+}
+
+// errDryRun is used to discard changes made by a dry run.
+var errDryRun = fmt.Errorf("dry run")
+
+// readBackup reads a backup (which can be encrypted and compressed) from r and validates its format.
+func readBackup(r io.Reader, passphrase string) (*backupData, error) {
+	decrypted, err := decryptBackupReader(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := decompressReader(decrypted)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data := &backupData{}
+	decoder := json.NewDecoder(reader)
+	if err := decoder.Decode(data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json: %w", err)
+	}
+	// Read the remaining data to check that the backup was not truncated or followed by other data.
+	rest, err := io.ReadAll(io.MultiReader(decoder.Buffered(), reader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("unexpected data after backup")
+	}
+	if data.Version < 0 || data.Version > backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %v", data.Version)
+	}
+	return data, nil
+}
+
+// validateItemKey returns an error if k is not a valid feed item or page key.
+func validateItemKey(k string) error {
+	if IsFeeditemKey([]byte(k)) {
+		if _, err := DecodeFeeditemKey([]byte(k)); err != nil {
+			return err
+		}
+		return nil
+	}
+	if IsPagemonitorKey([]byte(k)) {
+		if _, err := DecodePagemonitorKey([]byte(k)); err != nil {
+			return err
 		}
-		for _, readStatus := range user.ReadItems {
-			if err := service.SetReadStatus(&user.User, []byte(readStatus), true); err != nil {
-				failed = true
-				log.WithField("user", user).WithField("item", readStatus).WithError(err).Printf("Error saving read status")
+		return nil
+	}
+	return fmt.Errorf("unsupported item key")
+}
+
+// Restore reads a backup (which can be encrypted and compressed) from r and restores it into the database.
+// The backup is restored in a single transaction: if restoring fails, the database is left unchanged.
+// Records which cannot be restored are skipped and listed in the report.
+func (service *DBService) Restore(r io.Reader, options RestoreOptions) (*RestoreReport, error) {
+	mode, err := ParseRestoreMode(string(options.Mode))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readBackup(r, options.Passphrase)
+	if err != nil {
+		return nil, &InvalidBackupError{Err: err}
+	}
+
+	report := &RestoreReport{
+		Version:   data.Version,
+		Mode:      mode,
+		DryRun:    options.DryRun,
+		Conflicts: []RestoreIssue{},
+		Skipped:   []RestoreIssue{},
+	}
+	err = service.update(func() error {
+		if mode == RestoreReplace {
+			if err := service.deleteAllData(); err != nil {
+				return err
 			}
 		}
+		if err := service.restore(data, report); err != nil {
+			return err
+		}
+		if options.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+	return report, nil
+}
+
+// deleteAllData deletes all records except the schema version, without acquiring a lock.
+func (service *DBService) deleteAllData() error {
+	keys := make([][]byte, 0)
+	err := service.db.ForEach(func(k, _ []byte) error {
+		if string(k) != schemaVersionKey {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	for _, k := range keys {
+		if err := service.db.Delete(k); err != nil {
+			return fmt.Errorf("failed to delete key %v: %w", string(k), err)
+		}
 	}
-	convertFeeditems := func() []*Feeditem {
-		convertedFeeditems := make([]*Feeditem, 0, len(data.Feeds))
+	return nil
+}
 
-		for _, feedItem := range data.Feeds {
-			feedItem.Key = &feedItem.FeeditemKey
-			convertedFeeditems = append(convertedFeeditems, &feedItem.Feeditem)
+// restore writes data into the database and updates report, without acquiring a lock.
+func (service *DBService) restore(data *backupData, report *RestoreReport) error {
+	restoredUsers := make(map[string]bool, len(data.Users))
+	for _, user := range data.Users {
+		if err := service.restoreUser(user, restoredUsers, report); err != nil {
+			return fmt.Errorf("failed to restore user %v: %w", user.Username, err)
 		}
-		return convertedFeeditems
 	}
-	if err := service.SaveFeeditems(convertFeeditems()...); err != nil {
-		failed = true
-		log.WithError(err).Error("Error saving feed items")
+
+	for _, feedItem := range data.Feeds {
+		key := feedItem.FeeditemKey
+		if key.FeedURL == "" || key.GUID == "" {
+			report.skip("Feed", string(key.CreateKey()), "feed URL or GUID is empty")
+			continue
+		}
+		feedItem.Key = &key
+		if err := service.saveFeeditems(&feedItem.Feeditem); err != nil {
+			return fmt.Errorf("failed to restore feed item %v: %w", string(key.CreateKey()), err)
+		}
+		report.Restored.Feeds++
 	}
+
 	for _, page := range data.Pagemonitor {
-		page.Config = &page.UserPagemonitor
-		if err := service.SavePage(&page.PagemonitorPage); err != nil {
-			failed = true
-			log.WithField("page", page).WithError(err).Error("Error saving page")
+		config := page.UserPagemonitor
+		if config.URL == "" {
+			report.skip("Pagemonitor", string(config.CreateKey()), "URL is empty")
+			continue
+		}
+		page.Config = &config
+		if err := service.savePage(&page.PagemonitorPage); err != nil {
+			return fmt.Errorf("failed to restore page %v: %w", string(config.CreateKey()), err)
 		}
+		report.Restored.Pagemonitor++
+	}
+
+	existingConfig, err := service.getAllConfigVariables()
+	if err != nil {
+		return fmt.Errorf("failed to get config variables: %w", err)
 	}
 	for key, value := range data.ServerConfig {
-		if err := service.SetConfigVariable(key, value); err != nil {
-			failed = true
-			log.WithField("key", key).WithField("value", value).WithError(err).Error("Error saving config variable")
+		if existingValue, ok := existingConfig[key]; ok && existingValue != value {
+			report.conflict("ServerConfig", key, "existing value was overwritten")
+		}
+		if err := service.setConfigVariable(key, value); err != nil {
+			return fmt.Errorf("failed to restore config variable %v: %w", key, err)
+		}
+		report.Restored.ServerConfig++
+	}
+	return nil
+}
+
+// restoreUser writes user and its related records into the database and updates report, without acquiring a lock.
+func (service *DBService) restoreUser(user *backupUser, restoredUsers map[string]bool, report *RestoreReport) error {
+	if user.Username == "" {
+		report.skip("User", user.Username, "username is empty")
+		return nil
+	}
+	if restoredUsers[user.Username] {
+		report.skip("User", user.Username, "duplicate username")
+		return nil
+	}
+	// Legacy configuration which cannot be imported is restored as-is, so that the user can fix it.
+	if user.Opml != "" && len(user.Subscriptions) == 0 {
+		if _, err := user.ImportOPML(user.Opml, false); err != nil {
+			report.skip("Subscriptions", user.Username, err.Error())
+		}
+	}
+	if user.Pagemonitor != "" && len(user.Pages) == 0 {
+		if _, err := user.ImportPagemonitorXML(user.Pagemonitor, false); err != nil {
+			report.skip("Pages", user.Username, err.Error())
+		}
+	}
+
+	existingUser, err := service.getUser(user.Username)
+	if err != nil {
+		return err
+	}
+	if existingUser != nil {
+		// Keep the existing user's credentials and settings, and only add missing subscriptions and pages.
+		for _, feed := range user.Subscriptions {
+			if _, err := existingUser.AddFeed(feed); err != nil {
+				report.skip("Subscription", feed.URL, err.Error())
+			}
+		}
+		for _, pm := range user.Pages {
+			if _, err := existingUser.AddPage(pm); err != nil {
+				report.skip("Page", pm.URL, err.Error())
+			}
+		}
+		if err := service.saveUser(existingUser); err != nil {
+			return err
+		}
+		report.conflict("User", user.Username, "existing user was kept, subscriptions and pages were merged")
+	} else {
+		user.username = user.Username
+		user.newUsername = ""
+		if err := service.saveUser(&user.User); err != nil {
+			return err
+		}
+	}
+	user.username = user.Username
+	user.newUsername = ""
+	restoredUsers[user.Username] = true
+	report.Restored.Users++
+
+	for _, readStatus := range user.ReadItems {
+		if err := validateItemKey(readStatus); err != nil {
+			report.skip("ReadItem", readStatus, err.Error())
+			continue
+		}
+		if err := service.setReadStatus(&user.User, []byte(readStatus), true); err != nil {
+			return err
+		}
+		report.Restored.ReadItems++
+	}
+	for _, starredItem := range user.StarredItems {
+		if err := validateItemKey(starredItem); err != nil {
+			report.skip("StarredItem", starredItem, err.Error())
+			continue
+		}
+		if err := service.setStarred(&user.User, []byte(starredItem), true); err != nil {
+			return err
+		}
+		report.Restored.StarredItems++
+	}
+	for tag, taggedItems := range user.Tags {
+		normalizedTag, err := normalizeTag(tag)
+		if err != nil {
+			report.skip("Tag", tag, err.Error())
+			continue
+		}
+		if err := service.addReferencedKey(user.createTagsKey(), []byte(normalizedTag)); err != nil {
+			return err
+		}
+		report.Restored.Tags++
+		for _, taggedItem := range taggedItems {
+			if err := validateItemKey(taggedItem); err != nil {
+				report.skip("TaggedItem", taggedItem, err.Error())
+				continue
+			}
+			if err := service.setTagged(&user.User, normalizedTag, []byte(taggedItem), true); err != nil {
+				return err
+			}
+			report.Restored.TaggedItems++
+		}
+	}
+	for itemKey, note := range user.Notes {
+		if err := validateItemKey(itemKey); err != nil {
+			report.skip("Note", itemKey, err.Error())
+			continue
+		}
+		if note == nil || note.isEmpty() {
+			report.skip("Note", itemKey, "note is empty")
+			continue
+		}
+		// Highlights were validated when the note was created, and the item might not be restored yet.
+		if err := service.saveNote(&user.User, []byte(itemKey), note); err != nil {
+			return err
 		}
+		report.Restored.Notes++
+	}
+
+	events := make([]*ReadEvent, 0, len(user.ReadHistory))
+	for _, event := range user.ReadHistory {
+		if event == nil {
+			continue
+		}
+		if err := validateItemKey(event.Key); err != nil {
+			report.skip("ReadEvent", event.Key, err.Error())
+			continue
+		}
+		events = append(events, &ReadEvent{Key: []byte(event.Key), Time: event.Time, Bulk: event.Bulk})
+	}
+	restored, err := service.restoreReadEvents(&user.User, events)
+	if err != nil {
+		return err
 	}
-	if failed {
-		return fmt.Errorf("failed to restore at least one item")
+	report.Restored.ReadEvents += restored
+
+	if user.BulkRead != nil {
+		operation := &bulkRead{Time: user.BulkRead.Time, Keys: make([]itemKey, 0, len(user.BulkRead.Keys))}
+		for _, k := range user.BulkRead.Keys {
+			if err := validateItemKey(k); err != nil {
+				report.skip("BulkRead", k, err.Error())
+				continue
+			}
+			operation.Keys = append(operation.Keys, []byte(k))
+		}
+		existingOperation, err := service.getBulkRead(&user.User)
+		if err != nil {
+			return err
+		}
+		// Keep the existing operation if it's newer.
+		if existingOperation == nil || existingOperation.Time.Before(operation.Time) {
+			if err := service.putBulkRead(&user.User, operation); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
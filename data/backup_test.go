@@ -1,6 +1,9 @@
 package data
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,33 +13,25 @@ import (
 var testBackupUsers = []*User{
 	{
 		Password: "pass1",
-		Opml: `<opml version="1.0">` +
-			`<body>` +
-			`<outline text="Updates" title="Updates">` +
-			`<outline text="Site 2" title="Site 2" type="rss" xmlUrl="http://feed1" htmlUrl="http://feed1"/>` +
-			`<outline text="Site 3" title="Site 3" type="rss" xmlUrl="http://feed2" htmlUrl="http://feed2"/>` +
-			`</outline>` +
-			`</body>` +
-			`</opml>`,
-		Pagemonitor: `<pages>` +
-			`<page url="http://site1" match="m1" replace="r1">Site 1</page>` +
-			`<page url="http://site2">Site 2</page>` +
-			`</pages>`,
+		Subscriptions: []UserFeed{
+			{URL: "http://feed1", Title: "Site 2", Type: "rss", Folder: "Updates"},
+			{URL: "http://feed2", Title: "Site 3", Type: "rss", Folder: "Updates"},
+		},
+		Pages: []UserPagemonitor{
+			{URL: "http://site1", Title: "Site 1", Match: "m1", Replace: "r1"},
+			{URL: "http://site2", Title: "Site 2"},
+		},
 		username: "user01",
 	},
 	{
 		Password: "pass2",
-		Opml: `<opml version="1.0">` +
-			`<body>` +
-			`<outline text="Updates" title="Updates">` +
-			`<outline text="Site 2" title="Site 2" type="rss" xmlUrl="http://feed1" htmlUrl="http://feed1"/>` +
-			`<outline text="Site 3" title="Site 3" type="rss" xmlUrl="http://feed2" htmlUrl="http://feed2"/>` +
-			`</outline>` +
-			`</body>` +
-			`</opml>`,
-		Pagemonitor: `<pages>` +
-			`<page url="http://site1" match="m1" replace="r1">Site 1</page>` +
-			`</pages>`,
+		Subscriptions: []UserFeed{
+			{URL: "http://feed1", Title: "Site 2", Type: "rss", Folder: "Updates"},
+			{URL: "http://feed2", Title: "Site 3", Type: "rss", Folder: "Updates"},
+		},
+		Pages: []UserPagemonitor{
+			{URL: "http://site1", Title: "Site 1", Match: "m1", Replace: "r1"},
+		},
 		username: "user02",
 	},
 }
@@ -96,29 +91,65 @@ var testBackupReadStatus = [][]itemKey{
 	},
 }
 
+var testBackupReadHistory = []*ReadEvent{
+	{Key: testBackupFeeditems[0].Key.CreateKey(), Time: time.Date(2019, time.February, 17, 10, 0, 0, 0, time.UTC)},
+	{Key: testBackupPagemonitor[0].Config.CreateKey(), Time: time.Date(2019, time.February, 17, 11, 0, 0, 0, time.UTC)},
+	{Key: testBackupFeeditems[1].Key.CreateKey(), Time: time.Date(2019, time.February, 18, 9, 0, 0, 0, time.UTC), Bulk: true},
+}
+
+var testBackupBulkRead = &bulkRead{
+	Time: time.Date(2019, time.February, 18, 9, 0, 0, 0, time.UTC),
+	Keys: [][]byte{testBackupFeeditems[1].Key.CreateKey()},
+}
+
 const testBackupData = `{
+  "Version": 4,
   "Users": [
     {
       "Password": "pass1",
-      "Opml": "<opml version=\"1.0\"><body><outline text=\"Updates\" title=\"Updates\"><outline text=\"Site 2\" title=\"Site 2\" type=\"rss\" xmlUrl=\"http://feed1\" htmlUrl=\"http://feed1\"/><outline text=\"Site 3\" title=\"Site 3\" type=\"rss\" xmlUrl=\"http://feed2\" htmlUrl=\"http://feed2\"/></outline></body></opml>",
-      "Pagemonitor": "<pages><page url=\"http://site1\" match=\"m1\" replace=\"r1\">Site 1</page><page url=\"http://site2\">Site 2</page></pages>",
+      "Subscriptions": [
+        {"URL": "http://feed1", "Title": "Site 2", "Type": "rss", "Folder": "Updates"},
+        {"URL": "http://feed2", "Title": "Site 3", "Type": "rss", "Folder": "Updates"}
+      ],
+      "Pages": [
+        {"URL": "http://site1", "Title": "Site 1", "Match": "m1", "Replace": "r1"},
+        {"URL": "http://site2", "Title": "Site 2", "Match": "", "Replace": ""}
+      ],
       "Username": "user01",
       "ReadItems": [
         "feed/aHR0cDovL2ZlZWQx/ZzE",
         "feed/aHR0cDovL2ZlZWQx/ZzI",
         "pagemonitor/aHR0cDovL3NpdGUx/bTE/cjE"
-      ]
+      ],
+      "StarredItems": [
+        "feed/aHR0cDovL2ZlZWQy/ZzE"
+      ],
+      "ReadHistory": [
+        {"Key": "feed/aHR0cDovL2ZlZWQx/ZzE", "Time": "2019-02-17T10:00:00Z"},
+        {"Key": "pagemonitor/aHR0cDovL3NpdGUx/bTE/cjE", "Time": "2019-02-17T11:00:00Z"},
+        {"Key": "feed/aHR0cDovL2ZlZWQx/ZzI", "Time": "2019-02-18T09:00:00Z", "Bulk": true}
+      ],
+      "BulkRead": {
+        "Time": "2019-02-18T09:00:00Z",
+        "Keys": ["feed/aHR0cDovL2ZlZWQx/ZzI"]
+      }
     },
     {
       "Password": "pass2",
-      "Opml": "<opml version=\"1.0\"><body><outline text=\"Updates\" title=\"Updates\"><outline text=\"Site 2\" title=\"Site 2\" type=\"rss\" xmlUrl=\"http://feed1\" htmlUrl=\"http://feed1\"/><outline text=\"Site 3\" title=\"Site 3\" type=\"rss\" xmlUrl=\"http://feed2\" htmlUrl=\"http://feed2\"/></outline></body></opml>",
-      "Pagemonitor": "<pages><page url=\"http://site1\" match=\"m1\" replace=\"r1\">Site 1</page></pages>",
+      "Subscriptions": [
+        {"URL": "http://feed1", "Title": "Site 2", "Type": "rss", "Folder": "Updates"},
+        {"URL": "http://feed2", "Title": "Site 3", "Type": "rss", "Folder": "Updates"}
+      ],
+      "Pages": [
+        {"URL": "http://site1", "Title": "Site 1", "Match": "m1", "Replace": "r1"}
+      ],
       "Username": "user02",
       "ReadItems": [
         "feed/aHR0cDovL2ZlZWQx/ZzE",
         "feed/aHR0cDovL2ZlZWQy/ZzE",
         "pagemonitor/aHR0cDovL3NpdGUy//"
-      ]
+      ],
+      "StarredItems": []
     }
   ],
   "Feeds": [
@@ -176,10 +207,7 @@ const testBackupData = `{
   }
 }`
 
-func TestBackup(t *testing.T) {
-	err := resetDb()
-	assert.NoError(t, err)
-
+func saveTestBackupData() {
 	for _, user := range testBackupUsers {
 		dbService.SaveUser(user)
 	}
@@ -188,27 +216,94 @@ func TestBackup(t *testing.T) {
 		dbService.SavePage(page)
 	}
 
-	dbService.SetReadStatus(testBackupUsers[0], testBackupFeeditems[0].Key.CreateKey(), true)
-	dbService.SetReadStatus(testBackupUsers[0], testBackupFeeditems[1].Key.CreateKey(), true)
-	dbService.SetReadStatus(testBackupUsers[0], testBackupPagemonitor[0].Config.CreateKey(), true)
-	dbService.SetReadStatus(testBackupUsers[1], testBackupFeeditems[0].Key.CreateKey(), true)
-	dbService.SetReadStatus(testBackupUsers[1], testBackupFeeditems[2].Key.CreateKey(), true)
-	dbService.SetReadStatus(testBackupUsers[1], testBackupPagemonitor[1].Config.CreateKey(), true)
+	// Read statuses are saved directly, to have a predictable read history.
+	dbService.update(func() error {
+		for i, user := range testBackupUsers {
+			for _, k := range testBackupReadStatus[i] {
+				dbService.setReadStatus(user, k, true)
+			}
+		}
+		dbService.restoreReadEvents(testBackupUsers[0], testBackupReadHistory)
+		return dbService.putBulkRead(testBackupUsers[0], testBackupBulkRead)
+	})
+
+	dbService.SetStarred(testBackupUsers[0], testBackupFeeditems[2].Key.CreateKey(), true)
 
 	dbService.SetConfigVariable("k1", "v1")
 	dbService.SetConfigVariable("k2", "v2")
+}
+
+func TestBackup(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	saveTestBackupData()
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, testBackupData, data.String())
+}
+
+// blockingWriter blocks all writes until unblock is closed.
+type blockingWriter struct {
+	started chan struct{}
+	unblock chan struct{}
+	data    bytes.Buffer
+}
 
-	data, err := dbService.Backup()
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.started:
+	default:
+		close(w.started)
+	}
+	<-w.unblock
+	return w.data.Write(p)
+}
+
+func TestBackupSlowWriter(t *testing.T) {
+	err := resetDb()
 	assert.NoError(t, err)
-	assert.JSONEq(t, testBackupData, data)
+
+	saveTestBackupData()
+
+	writer := &blockingWriter{started: make(chan struct{}), unblock: make(chan struct{})}
+	backupErr := make(chan error)
+	go func() {
+		backupErr <- dbService.Backup(writer, BackupOptions{})
+	}()
+	<-writer.started
+
+	updated := make(chan error)
+	go func() {
+		updated <- dbService.SetConfigVariable("k3", "v3")
+	}()
+	select {
+	case err := <-updated:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Error("update was blocked by a slow backup writer")
+	}
+
+	close(writer.unblock)
+	assert.NoError(t, <-backupErr)
+	assert.JSONEq(t, testBackupData, writer.data.String())
 }
 
 func TestRestore(t *testing.T) {
 	err := resetDb()
 	assert.NoError(t, err)
 
-	err = dbService.Restore(testBackupData)
+	report, err := dbService.Restore(strings.NewReader(testBackupData), RestoreOptions{})
 	assert.NoError(t, err)
+	assert.Equal(t, &RestoreReport{
+		Version:   4,
+		Mode:      RestoreMerge,
+		Restored:  RestoreCounts{Users: 2, ReadItems: 6, StarredItems: 1, ReadEvents: 3, Feeds: 3, Pagemonitor: 2, ServerConfig: 2},
+		Conflicts: []RestoreIssue{},
+		Skipped:   []RestoreIssue{},
+	}, report)
 
 	dbUsers, err := getAllUsers()
 	assert.NoError(t, err)
@@ -222,7 +317,35 @@ func TestRestore(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, testBackupReadStatus[1], readStatus)
 
-	user := &User{username: "user01", Opml: testBackupUsers[0].Opml, Pagemonitor: testBackupUsers[0].Pagemonitor}
+	starredItems, err := dbService.GetStarredItems(testBackupUsers[0])
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{testBackupFeeditems[2].Key.CreateKey()}, starredItems)
+
+	starredItems, err = dbService.GetStarredItems(testBackupUsers[1])
+	assert.NoError(t, err)
+	assert.Empty(t, starredItems)
+
+	recentlyRead, err := dbService.GetRecentlyRead(testBackupUsers[0], 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ReadEvent{testBackupReadHistory[1], testBackupReadHistory[0]}, recentlyRead)
+
+	stats, err := dbService.GetReadingStats(testBackupUsers[1], time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+
+	// Restoring the same backup again doesn't duplicate read events.
+	report, err = dbService.Restore(strings.NewReader(testBackupData), RestoreOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Restored.ReadEvents)
+	recentlyRead, err = dbService.GetRecentlyRead(testBackupUsers[0], 10)
+	assert.NoError(t, err)
+	assert.Len(t, recentlyRead, 2)
+
+	changed, err := dbService.UndoMarkRead(testBackupUsers[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	user := &User{username: "user01", Subscriptions: testBackupUsers[0].Subscriptions, Pages: testBackupUsers[0].Pages}
 	dbFeeditems, err := getFeedItems(user)
 	assert.NoError(t, err)
 	assert.Equal(t, testBackupFeeditems, dbFeeditems)
@@ -235,3 +358,487 @@ func TestRestore(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2"}, values)
 }
+
+func TestBackupFormat(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	saveTestBackupData()
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{})
+	assert.NoError(t, err)
+
+	var compact, indented bytes.Buffer
+	err = json.Compact(&compact, data.Bytes())
+	assert.NoError(t, err)
+	err = json.Indent(&indented, compact.Bytes(), "", "  ")
+	assert.NoError(t, err)
+	assert.Equal(t, indented.String()+"\n", data.String())
+
+	err = resetDb()
+	assert.NoError(t, err)
+
+	data.Reset()
+	err = dbService.Backup(&data, BackupOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n"+
+		"  \"Version\": 4,\n"+
+		"  \"Users\": [],\n"+
+		"  \"Feeds\": [],\n"+
+		"  \"Pagemonitor\": [],\n"+
+		"  \"ServerConfig\": {}\n"+
+		"}\n", data.String())
+}
+
+func TestBackupRestoreCompressed(t *testing.T) {
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd} {
+		err := resetDb()
+		assert.NoError(t, err)
+
+		saveTestBackupData()
+
+		var data bytes.Buffer
+		err = dbService.Backup(&data, BackupOptions{Compression: compression})
+		assert.NoError(t, err)
+		assert.False(t, json.Valid(data.Bytes()))
+
+		err = resetDb()
+		assert.NoError(t, err)
+
+		_, err = dbService.Restore(&data, RestoreOptions{})
+		assert.NoError(t, err)
+
+		data.Reset()
+		err = dbService.Backup(&data, BackupOptions{})
+		assert.NoError(t, err)
+		assert.JSONEq(t, testBackupData, data.String())
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for value, expected := range map[string]Compression{"": CompressionNone, "none": CompressionNone, "gzip": CompressionGzip, "ZSTD": CompressionZstd} {
+		compression, err := ParseCompression(value)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, compression)
+	}
+
+	_, err := ParseCompression("bzip2")
+	assert.EqualError(t, err, "unsupported compression bzip2")
+}
+
+func TestBackupDanglingUser(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	saveTestBackupData()
+	err = dbService.update(func() error {
+		return dbService.addReferencedKey([]byte(userKeyPrefix), []byte("user03"))
+	})
+	assert.NoError(t, err)
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, testBackupData, data.String())
+
+	data.Reset()
+	err = dbService.Backup(&data, BackupOptions{Username: "user03"})
+	assert.EqualError(t, err, "user user03 doesn't exist")
+}
+
+func TestBackupConfigOnly(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	saveTestBackupData()
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{ConfigOnly: true})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Version":4,"Users":[],"Feeds":[],"Pagemonitor":[],"ServerConfig":{"k1":"v1","k2":"v2"}}`, data.String())
+}
+
+func TestBackupUser(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	saveTestBackupData()
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{Username: "user02"})
+	assert.NoError(t, err)
+
+	backup := backupData{}
+	err = json.Unmarshal(data.Bytes(), &backup)
+	assert.NoError(t, err)
+	assert.Len(t, backup.Users, 1)
+	assert.Equal(t, "user02", backup.Users[0].Username)
+	assert.Len(t, backup.Feeds, 3)
+	assert.Len(t, backup.Pagemonitor, 1)
+	assert.Equal(t, "http://site1", backup.Pagemonitor[0].URL)
+	assert.Nil(t, backup.ServerConfig)
+
+	err = dbService.Backup(&data, BackupOptions{Username: "user03"})
+	assert.EqualError(t, err, "user user03 doesn't exist")
+}
+
+func TestBackupUnconvertedConfiguration(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Opml = "<opml"
+	user.Pagemonitor = "<pages"
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{})
+	assert.NoError(t, err)
+
+	backup := backupData{}
+	err = json.Unmarshal(data.Bytes(), &backup)
+	assert.NoError(t, err)
+	assert.Len(t, backup.Users, 1)
+	assert.Equal(t, "<opml", backup.Users[0].Opml)
+	assert.Equal(t, "<pages", backup.Users[0].Pagemonitor)
+}
+
+func TestBackupUnconfiguredUser(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("default")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{})
+	assert.NoError(t, err)
+
+	backup := backupData{}
+	err = json.Unmarshal(data.Bytes(), &backup)
+	assert.NoError(t, err)
+	assert.Len(t, backup.Users, 1)
+	assert.Equal(t, "default", backup.Users[0].Username)
+	assert.Empty(t, backup.Feeds)
+	assert.Empty(t, backup.Pagemonitor)
+}
+
+func TestBackupSince(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	saveTestBackupData()
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{Since: time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+
+	backup := backupData{}
+	err = json.Unmarshal(data.Bytes(), &backup)
+	assert.NoError(t, err)
+	assert.Len(t, backup.Users, 2)
+	guids := make([]string, 0, len(backup.Feeds))
+	for _, feedItem := range backup.Feeds {
+		guids = append(guids, feedItem.FeedURL+" "+feedItem.GUID)
+	}
+	assert.Equal(t, []string{"http://feed1 g2", "http://feed2 g1"}, guids)
+	assert.Len(t, backup.Pagemonitor, 2)
+
+	data.Reset()
+	err = dbService.Backup(&data, BackupOptions{Since: time.Date(2019, time.February, 16, 23, 4, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+
+	backup = backupData{}
+	err = json.Unmarshal(data.Bytes(), &backup)
+	assert.NoError(t, err)
+	assert.Empty(t, backup.Feeds)
+	assert.Len(t, backup.Pagemonitor, 1)
+	assert.Equal(t, "http://site2", backup.Pagemonitor[0].URL)
+}
+
+func TestParseRestoreMode(t *testing.T) {
+	for value, expected := range map[string]RestoreMode{"": RestoreMerge, "merge": RestoreMerge, "Replace": RestoreReplace} {
+		mode, err := ParseRestoreMode(value)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, mode)
+	}
+
+	_, err := ParseRestoreMode("append")
+	assert.EqualError(t, err, "unsupported restore mode append")
+}
+
+func TestRestoreMergeConflicts(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	existingUser := &User{
+		Password:      "old",
+		Admin:         true,
+		Subscriptions: []UserFeed{{URL: "http://feed3", Title: "Site 4"}, {URL: "http://feed1", Title: "Renamed"}},
+		username:      "user01",
+	}
+	err = dbService.SaveUser(existingUser)
+	assert.NoError(t, err)
+	otherUser := &User{Password: "other", username: "user03"}
+	err = dbService.SaveUser(otherUser)
+	assert.NoError(t, err)
+	err = dbService.SetConfigVariable("k1", "old")
+	assert.NoError(t, err)
+	err = dbService.SetConfigVariable("k2", "v2")
+	assert.NoError(t, err)
+
+	report, err := dbService.Restore(strings.NewReader(testBackupData), RestoreOptions{Mode: RestoreMerge})
+	assert.NoError(t, err)
+	assert.Equal(t, []RestoreIssue{
+		{Type: "User", Key: "user01", Reason: "existing user was kept, subscriptions and pages were merged"},
+		{Type: "ServerConfig", Key: "k1", Reason: "existing value was overwritten"},
+	}, report.Conflicts)
+	assert.Empty(t, report.Skipped)
+
+	// The existing user's password and settings are not overwritten.
+	mergedUser := &User{
+		Password: "old",
+		Admin:    true,
+		Subscriptions: []UserFeed{
+			{URL: "http://feed3", Title: "Site 4"},
+			{URL: "http://feed1", Title: "Renamed"},
+			{URL: "http://feed2", Title: "Site 3", Type: "rss", Folder: "Updates"},
+		},
+		Pages:    testBackupUsers[0].Pages,
+		username: "user01",
+	}
+	dbUsers, err := getAllUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*User{mergedUser, testBackupUsers[1], otherUser}, dbUsers)
+
+	readStatus, err := dbService.GetReadItems(existingUser)
+	assert.NoError(t, err)
+	assert.Equal(t, testBackupReadStatus[0], readStatus)
+
+	values, err := dbService.GetAllConfigVariables()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2"}, values)
+}
+
+func TestRestoreReplace(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	otherUser := &User{Password: "other", username: "user03"}
+	err = dbService.SaveUser(otherUser)
+	assert.NoError(t, err)
+	err = dbService.SetConfigVariable("k3", "v3")
+	assert.NoError(t, err)
+	otherItem := &Feeditem{Title: "t4", Date: time.Date(2019, time.February, 16, 23, 5, 0, 0, time.UTC), Key: &FeeditemKey{FeedURL: "http://feed3", GUID: "g1"}}
+	err = dbService.SaveFeeditems(otherItem)
+	assert.NoError(t, err)
+	err = dbService.db.Put([]byte(schemaVersionKey), []byte("2"))
+	assert.NoError(t, err)
+
+	report, err := dbService.Restore(strings.NewReader(testBackupData), RestoreOptions{Mode: RestoreReplace})
+	assert.NoError(t, err)
+	assert.Equal(t, RestoreReplace, report.Mode)
+	assert.Empty(t, report.Conflicts)
+
+	var data bytes.Buffer
+	err = dbService.Backup(&data, BackupOptions{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, testBackupData, data.String())
+
+	dbItem, err := dbService.GetFeeditem(otherItem.Key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbItem)
+
+	version, err := dbService.db.Get([]byte(schemaVersionKey))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), version)
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	for _, mode := range []RestoreMode{RestoreMerge, RestoreReplace} {
+		err := resetDb()
+		assert.NoError(t, err)
+
+		user := &User{Password: "old", username: "user01"}
+		err = dbService.SaveUser(user)
+		assert.NoError(t, err)
+
+		report, err := dbService.Restore(strings.NewReader(testBackupData), RestoreOptions{Mode: mode, DryRun: true})
+		assert.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, RestoreCounts{Users: 2, ReadItems: 6, StarredItems: 1, ReadEvents: 3, Feeds: 3, Pagemonitor: 2, ServerConfig: 2}, report.Restored)
+
+		dbUsers, err := getAllUsers()
+		assert.NoError(t, err)
+		assert.Equal(t, []*User{user}, dbUsers)
+
+		values, err := dbService.GetAllConfigVariables()
+		assert.NoError(t, err)
+		assert.Empty(t, values)
+	}
+}
+
+func TestRestoreSkipInvalidRecords(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	report, err := dbService.Restore(strings.NewReader(`{
+  "Version": 1,
+  "Users": [
+    {"Username": "", "Password": "pass1"},
+    {"Username": "user01", "Opml": "<opml", "Password": "pass1"},
+    {
+      "Username": "user02",
+      "Password": "pass2",
+      "ReadItems": ["feed/aHR0cDovL2ZlZWQx/ZzE", "user/dXNlcjAx"],
+      "StarredItems": ["feed/!"],
+      "Tags": {" ": ["feed/aHR0cDovL2ZlZWQx/ZzE"], "t1": ["feed/aHR0cDovL2ZlZWQx/ZzE", "other"]},
+      "Notes": {"feed/aHR0cDovL2ZlZWQx/ZzE": {"Text": ""}}
+    },
+    {"Username": "user02", "Password": "pass3"}
+  ],
+  "Feeds": [
+    {"FeedURL": "http://feed1", "GUID": "g1", "Title": "t1"},
+    {"FeedURL": "http://feed1", "GUID": "", "Title": "t2"}
+  ],
+  "Pagemonitor": [
+    {"URL": "", "Contents": "p1"}
+  ]
+}`), RestoreOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, RestoreCounts{Users: 2, ReadItems: 1, Tags: 1, TaggedItems: 1, Feeds: 1}, report.Restored)
+	assert.Empty(t, report.Conflicts)
+	assert.Equal(t, []RestoreIssue{
+		{Type: "User", Key: "", Reason: "username is empty"},
+		{Type: "Subscriptions", Key: "user01", Reason: "cannot parse opml xml: XML syntax error on line 1: unexpected EOF"},
+		{Type: "ReadItem", Key: "user/dXNlcjAx", Reason: "unsupported item key"},
+		{Type: "StarredItem", Key: "feed/!", Reason: "invalid format of Feeditem key: feed/!"},
+	}, report.Skipped[:4])
+	assert.ElementsMatch(t, []RestoreIssue{
+		{Type: "Tag", Key: " ", Reason: "tag cannot be empty"},
+		{Type: "TaggedItem", Key: "other", Reason: "unsupported item key"},
+		{Type: "Note", Key: "feed/aHR0cDovL2ZlZWQx/ZzE", Reason: "note is empty"},
+		{Type: "User", Key: "user02", Reason: "duplicate username"},
+		{Type: "Feed", Key: "feed/aHR0cDovL2ZlZWQx/", Reason: "feed URL or GUID is empty"},
+		{Type: "Pagemonitor", Key: "pagemonitor///", Reason: "URL is empty"},
+	}, report.Skipped[4:])
+
+	dbUser, err := dbService.GetUser("user02")
+	assert.NoError(t, err)
+	assert.Equal(t, "pass2", dbUser.Password)
+
+	// Invalid OPML is kept, so that it can be fixed.
+	dbUser, err = dbService.GetUser("user01")
+	assert.NoError(t, err)
+	assert.Equal(t, "<opml", dbUser.Opml)
+}
+
+func TestRestoreLegacyOPML(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	report, err := dbService.Restore(strings.NewReader(`{
+  "Version": 1,
+  "Users": [
+    {
+      "Username": "user01",
+      "Password": "pass1",
+      "Opml": "<opml version=\"1.0\"><body><outline text=\"Updates\" title=\"Updates\"><outline text=\"Site 2\" title=\"Site 2\" type=\"rss\" xmlUrl=\"http://feed1\" htmlUrl=\"http://feed1\"/><outline text=\"Site 3\" title=\"Site 3\" type=\"rss\" xmlUrl=\"http://feed2\" htmlUrl=\"http://feed2\"/></outline></body></opml>"
+    }
+  ]
+}`), RestoreOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, RestoreCounts{Users: 1}, report.Restored)
+	assert.Empty(t, report.Skipped)
+
+	dbUser, err := dbService.GetUser("user01")
+	assert.NoError(t, err)
+	assert.Equal(t, testBackupUsers[0].Subscriptions, dbUser.Subscriptions)
+}
+
+func TestRestoreLegacyPagemonitor(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	report, err := dbService.Restore(strings.NewReader(`{
+  "Version": 2,
+  "Users": [
+    {
+      "Username": "user01",
+      "Password": "pass1",
+      "Pagemonitor": "<pages><page url=\"http://site1\" match=\"m1\" replace=\"r1\">Site 1</page><page url=\"http://site2\">Site 2</page></pages>"
+    },
+    {"Username": "user02", "Pagemonitor": "<pages>"}
+  ]
+}`), RestoreOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, RestoreCounts{Users: 2}, report.Restored)
+	assert.Equal(t, []RestoreIssue{
+		{Type: "Pages", Key: "user02", Reason: "cannot parse pagemonitor xml: XML syntax error on line 1: unexpected EOF"},
+	}, report.Skipped)
+
+	dbUser, err := dbService.GetUser("user01")
+	assert.NoError(t, err)
+	assert.Equal(t, testBackupUsers[0].Pages, dbUser.Pages)
+	assert.Empty(t, dbUser.Pagemonitor)
+
+	// Invalid Pagemonitor XML is kept, so that it can be fixed.
+	dbUser, err = dbService.GetUser("user02")
+	assert.NoError(t, err)
+	assert.Equal(t, "<pages>", dbUser.Pagemonitor)
+	assert.Empty(t, dbUser.Pages)
+}
+
+func TestRestoreInvalidBackup(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	report, err := dbService.Restore(strings.NewReader(`{"Version": 5, "Users": [{"Username": "user01"}]}`), RestoreOptions{})
+	assert.EqualError(t, err, "unsupported backup version 5")
+	assert.IsType(t, &InvalidBackupError{}, err)
+	assert.Nil(t, report)
+
+	report, err = dbService.Restore(strings.NewReader(`{"Users": [{"Username": "user01"}]`), RestoreOptions{})
+	assert.EqualError(t, err, "failed to unmarshal json: unexpected EOF")
+	assert.Nil(t, report)
+
+	report, err = dbService.Restore(strings.NewReader(`{"Users": []}{}`), RestoreOptions{})
+	assert.EqualError(t, err, "unexpected data after backup")
+	assert.Nil(t, report)
+
+	report, err = dbService.Restore(strings.NewReader(`{}`), RestoreOptions{Mode: "append"})
+	assert.EqualError(t, err, "unsupported restore mode append")
+	assert.Nil(t, report)
+
+	report, err = dbService.Restore(strings.NewReader(`{"Users": [{"Username": "user01"}]}`), RestoreOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Version)
+
+	dbUsers, err := getAllUsers()
+	assert.NoError(t, err)
+	assert.Len(t, dbUsers, 1)
+}
+
+func TestLastBackupTime(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	lastBackup, err := dbService.GetLastBackupTime()
+	assert.NoError(t, err)
+	assert.True(t, lastBackup.IsZero())
+
+	backupTime := time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)
+	err = dbService.SetLastBackupTime(backupTime.In(time.FixedZone("UTC+1", 60*60)))
+	assert.NoError(t, err)
+
+	lastBackup, err = dbService.GetLastBackupTime()
+	assert.NoError(t, err)
+	assert.Equal(t, backupTime, lastBackup)
+
+	values, err := dbService.GetAllConfigVariables()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"last-backup-time": "2019-02-16T23:00:00Z"}, values)
+}
@@ -1,70 +1,172 @@
 package data
 
 import (
-	golog "log"
+	"io"
 	"os"
 	"path"
 	"sync"
+	"time"
 
-	"github.com/akrylysov/pogreb"
-	"github.com/akrylysov/pogreb/fs"
 	log "github.com/sirupsen/logrus"
 )
 
-func init() {
-	pogrebLog := golog.New(log.New().Writer(), "", 0)
-	pogreb.SetLogger(pogrebLog)
+// Options specifies how the database should be opened.
+type Options struct {
+	// Backend is the storage backend, BackendPogreb or BackendBolt.
+	Backend string
+	// Dir is the directory containing the database files.
+	Dir string
+	// InMemory opens a temporary database which is removed once closed.
+	InMemory bool
+	// DryRun keeps all changes (including migrations) in memory, without saving them into the database.
+	DryRun bool
+	// Retention is the global default retention policy.
+	Retention RetentionPolicy
 }
 
 // DefaultOptions returns default options for the database, customized based on environment variables.
-func DefaultOptions() pogreb.Options {
-	return pogreb.Options{
-		FileSystem: fs.OS,
+func DefaultOptions() Options {
+	dbPath, ok := os.LookupEnv("DATABASE_DIR")
+	if !ok {
+		dbPath = path.Join(os.TempDir(), "nanorss")
+	}
+	backend, ok := os.LookupEnv("DATABASE_BACKEND")
+	if !ok || backend == "" {
+		backend = BackendPogreb
+	}
+	return Options{
+		Backend:   backend,
+		Dir:       dbPath,
+		Retention: defaultRetentionPolicy(),
 	}
 }
 
+// Store provides functions to read and write all data.
+type Store interface {
+	GetUsers() ([]string, error)
+	GetUser(username string) (*User, error)
+	SaveUser(*User) error
+	AddUserFeed(username string, feed UserFeed) error
+
+	GetFeeditem(*FeeditemKey) (*Feeditem, error)
+	GetFeeditems(*User) ([]*Feeditem, error)
+	SaveFeeditems(...*Feeditem) error
+
+	GetPage(*UserPagemonitor) (*PagemonitorPage, error)
+	GetPages(*User) ([]*PagemonitorPage, error)
+	SavePage(*PagemonitorPage) error
+
+	GetReadItems(*User) ([][]byte, error)
+	SetReadStatus(user *User, itemKey []byte, read bool) error
+	SetReadStatuses(user *User, itemKeys [][]byte, read bool) (int, error)
+	MarkRead(user *User, filter ReadFilter) (int, error)
+	UndoMarkRead(*User) (int, error)
+	GetRecentlyRead(user *User, limit int) ([]*ReadEvent, error)
+	GetReadingStats(user *User, from time.Time) ([]*ReadingStats, error)
+	GetAllReadingStats(from time.Time) ([]*ReadingStats, error)
+	SetReadStatusForAll(itemKey []byte, read bool) error
+
+	GetStarredItems(*User) ([][]byte, error)
+	SetStarred(user *User, itemKey []byte, starred bool) error
+
+	GetTags(*User) ([]string, error)
+	CreateTag(user *User, tag string) error
+	RenameTag(user *User, tag, newTag string) error
+	DeleteTag(user *User, tag string) error
+	GetTaggedItems(user *User, tag string) ([][]byte, error)
+	SetTagged(user *User, tag string, itemKey []byte, tagged bool) error
+
+	GetNote(user *User, itemKey []byte) (*ItemNote, error)
+	GetNotes(*User) ([]*ItemNote, error)
+	SaveNote(user *User, itemKey []byte, note *ItemNote) error
+	DeleteNote(user *User, itemKey []byte) error
+
+	GetFetchStatus(key []byte) (*FetchStatus, error)
+	GetFeedStats(user *User) ([]*FeedStats, error)
+	SetFetchStatus(key []byte, fetchStatus *FetchStatus) error
+
+	SetLastSeen(key []byte) error
+	GetDefaultRetentionPolicy() RetentionPolicy
+	Search(user *User, query SearchQuery) ([]*SearchResult, error)
+	GC()
+	Fsck(repair bool) (*FsckReport, error)
+
+	GetOrCreateConfigVariable(varName string, generator func() (string, error)) (string, error)
+	SetConfigVariable(varName, varValue string) error
+	GetAllConfigVariables() (map[string]string, error)
+
+	Backup(w io.Writer, options BackupOptions) error
+	GetLastBackupTime() (time.Time, error)
+	SetLastBackupTime(lastBackup time.Time) error
+	Restore(r io.Reader, options RestoreOptions) (*RestoreReport, error)
+
+	Close()
+}
+
+// Ensure that DBService implements Store.
+var _ Store = &DBService{}
+
 // DBService provides services for reading and writing structs in the database.
 type DBService struct {
-	db *pogreb.DB
+	// db is replaced with an overlay while a transaction is running;
+	// it should only be accessed while holding userLock.
+	db Backend
 
 	userLock sync.RWMutex
+	// retention is the global default retention policy.
+	retention RetentionPolicy
+	// journalPending is set if a commit failed and its journal needs to be replayed.
+	journalPending bool
 }
 
 // Open opens the database with options and returns a DBService instance.
-func Open(options pogreb.Options) (*DBService, error) {
-	dbPath, ok := os.LookupEnv("DATABASE_DIR")
-	if !ok {
-		dbPath = path.Join(os.TempDir(), "nanorss")
-	}
-	log.WithField("dir", dbPath).WithField("inmemory", options.FileSystem == fs.Mem).Info("Opening database")
-	db, err := pogreb.Open(dbPath, &options)
+func Open(options Options) (*DBService, error) {
+	log.WithField("backend", options.Backend).WithField("dir", options.Dir).WithField("inmemory", options.InMemory).
+		WithField("dryrun", options.DryRun).Info("Opening database")
+	db, err := openBackend(options)
 	if err != nil {
 		return nil, err
 	}
-	return &DBService{db: db}, nil
+	if options.DryRun {
+		db = newOverlayBackend(db)
+	}
+	service := &DBService{db: db, retention: options.Retention}
+	if err := service.replayJournal(); err != nil {
+		service.Close()
+		return nil, err
+	}
+	if err := service.migrate(); err != nil {
+		service.Close()
+		return nil, err
+	}
+	return service, nil
 }
 
 // GC deletes expired items and attempts to perform a database cleanup.
 func (service *DBService) GC() {
+	service.applyRetentionPolicies()
 	service.deleteStaleFetchStatuses()
 	service.deleteStaleReadStatuses()
+	service.deleteExpiredReadHistory()
+	service.deleteStaleSearchDocuments()
 
-	result, err := service.db.Compact()
+	err := service.view(func() error {
+		return service.db.Compact()
+	})
 	if err != nil {
 		log.WithError(err).Error("Cleanup failed")
 	}
-	if result.CompactedSegments != 0 {
-		log.WithField("ReclaimedBytes", result.ReclaimedBytes).
-			WithField("ReclaimedRecords", result.ReclaimedRecords).
-			WithField("CompactedSegments", result.CompactedSegments).
-			Info("Cleanup reclaimed space")
-	}
 }
 
 // Close closes the underlying database.
 func (service *DBService) Close() {
 	log.Info("Closing database")
-	if service != nil && service.db != nil {
+	if service == nil {
+		return
+	}
+	service.userLock.Lock()
+	defer service.userLock.Unlock()
+	if service.db != nil {
 		err := service.db.Close()
 		if err != nil {
 			log.Fatal(err)
@@ -83,10 +185,21 @@ func (service *DBService) view(txn func() error) error {
 }
 
 // update will acquire a write lock on the database and execute txn.
+// All changes done by txn are kept in memory and applied atomically once txn completes;
+// if txn returns an error, changes are discarded.
 // Returns the error returned by txn.
 func (service *DBService) update(txn func() error) error {
 	service.userLock.Lock()
 	defer service.userLock.Unlock()
 
-	return txn()
+	batch := newOverlayBackend(service.db)
+	service.db = batch
+	defer func() { service.db = batch.base }()
+
+	if err := txn(); err != nil {
+		return err
+	}
+
+	service.db = batch.base
+	return service.commit(batch)
 }
@@ -1,30 +1,30 @@
 package data
 
 import (
-	"github.com/akrylysov/pogreb"
-	"github.com/akrylysov/pogreb/fs"
+	"os"
 )
 
 var dbService *DBService
 
 func resetDb() (err error) {
 	if dbService != nil {
-		it := dbService.db.Items()
-		for {
-			k, _, err := it.Next()
-			if err == pogreb.ErrIterationDone {
-				break
-			} else if err != nil {
-				return err
-			}
-			err = dbService.db.Delete(k)
-			if err != nil {
+		keys := make([][]byte, 0)
+		err := dbService.db.ForEach(func(k, _ []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := dbService.db.Delete(k); err != nil {
 				return err
 			}
 		}
-		return
+		return nil
 	}
-	opts := pogreb.Options{FileSystem: fs.Mem}
+	// Allows to run all tests with another backend.
+	opts := Options{Backend: os.Getenv("DATABASE_BACKEND"), InMemory: true}
 
 	dbService, err = Open(opts)
 	return
@@ -0,0 +1,256 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted backups are wrapped in an envelope:
+//
+//	magic (7 bytes) | version (1 byte) | scrypt log2(N), r, p (1 byte each) | salt (16 bytes)
+//
+// followed by chunks:
+//
+//	final flag (1 byte) | ciphertext length (4 bytes, big endian) | AES-256-GCM ciphertext
+//
+// Each chunk is encrypted with a nonce containing the chunk number, and authenticates the envelope header,
+// final flag and length; this prevents chunks from being reordered, modified or truncated.
+
+// encryptionMagic identifies an encrypted backup.
+var encryptionMagic = []byte("NRSSENC")
+
+// encryptionVersion is the version of the encrypted backup envelope.
+const encryptionVersion = 1
+
+const (
+	encryptionSaltSize   = 16
+	encryptionHeaderSize = 7 + 1 + 3 + encryptionSaltSize
+	encryptionKeySize    = 32
+	encryptionChunkSize  = 64 * 1024
+
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+
+	// Limits for scrypt parameters read from an envelope, to avoid excessive memory usage.
+	maxScryptLogN = 20
+	maxScryptR    = 32
+	maxScryptP    = 16
+)
+
+// ErrPassphraseRequired is returned when restoring an encrypted backup without a passphrase.
+var ErrPassphraseRequired = errors.New("backup is encrypted, a passphrase is required")
+
+// errDecryptionFailed is returned when a chunk cannot be decrypted.
+var errDecryptionFailed = errors.New("cannot decrypt backup: wrong passphrase or corrupted data")
+
+// createBackupCipher derives the encryption key from passphrase and the envelope header.
+func createBackupCipher(passphrase string, header []byte) (cipher.AEAD, error) {
+	logN, r, p := int(header[8]), int(header[9]), int(header[10])
+	if logN < 1 || logN > maxScryptLogN || r < 1 || r > maxScryptR || p < 1 || p > maxScryptP {
+		return nil, fmt.Errorf("unsupported key derivation parameters")
+	}
+	salt := header[11:encryptionHeaderSize]
+
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<logN, r, p, encryptionKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for chunk number counter.
+func chunkNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+// chunkAdditionalData returns the authenticated data for a chunk.
+func chunkAdditionalData(header []byte, final bool, length int) []byte {
+	additionalData := make([]byte, len(header)+1+4)
+	copy(additionalData, header)
+	if final {
+		additionalData[len(header)] = 1
+	}
+	binary.BigEndian.PutUint32(additionalData[len(header)+1:], uint32(length))
+	return additionalData
+}
+
+// encryptWriter encrypts data written into it as a sequence of chunks.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	buffer  []byte
+	counter uint64
+}
+
+// newEncryptWriter writes the envelope header into w and returns a writer which will encrypt data with passphrase.
+// The writer must be closed to write the final chunk.
+func newEncryptWriter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	header := make([]byte, encryptionHeaderSize)
+	copy(header, encryptionMagic)
+	header[7] = encryptionVersion
+	header[8], header[9], header[10] = scryptLogN, scryptR, scryptP
+	if _, err := rand.Read(header[11:]); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := createBackupCipher(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write encryption header: %w", err)
+	}
+	return &encryptWriter{w: w, aead: aead, header: header, buffer: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+// writeChunk encrypts and writes the buffered data.
+func (writer *encryptWriter) writeChunk(final bool) error {
+	length := len(writer.buffer) + writer.aead.Overhead()
+	additionalData := chunkAdditionalData(writer.header, final, length)
+	ciphertext := writer.aead.Seal(nil, chunkNonce(writer.aead, writer.counter), writer.buffer, additionalData)
+	writer.counter++
+	writer.buffer = writer.buffer[:0]
+
+	if _, err := writer.w.Write(additionalData[len(writer.header):]); err != nil {
+		return err
+	}
+	_, err := writer.w.Write(ciphertext)
+	return err
+}
+
+// Write buffers and encrypts p.
+func (writer *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Only write full chunks once more data arrives, so that the final chunk is written by Close.
+		if len(writer.buffer) == encryptionChunkSize {
+			if err := writer.writeChunk(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(writer.buffer[len(writer.buffer):encryptionChunkSize], p)
+		writer.buffer = writer.buffer[:len(writer.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close writes the final chunk.
+func (writer *encryptWriter) Close() error {
+	return writer.writeChunk(true)
+}
+
+// decryptReader decrypts chunks written by encryptWriter.
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	buffer  []byte
+	counter uint64
+	final   bool
+}
+
+// readChunk reads and decrypts the next chunk.
+func (reader *decryptReader) readChunk() error {
+	chunkHeader := make([]byte, 1+4)
+	if _, err := io.ReadFull(reader.r, chunkHeader); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("encrypted backup is truncated")
+		}
+		return err
+	}
+	final := chunkHeader[0] == 1
+	length := int(binary.BigEndian.Uint32(chunkHeader[1:]))
+	if chunkHeader[0] > 1 || length < reader.aead.Overhead() || length > encryptionChunkSize+reader.aead.Overhead() {
+		return errDecryptionFailed
+	}
+
+	ciphertext := make([]byte, length)
+	if _, err := io.ReadFull(reader.r, ciphertext); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("encrypted backup is truncated")
+		}
+		return err
+	}
+	plaintext, err := reader.aead.Open(nil, chunkNonce(reader.aead, reader.counter), ciphertext, chunkAdditionalData(reader.header, final, length))
+	if err != nil {
+		return errDecryptionFailed
+	}
+	reader.counter++
+	reader.buffer = plaintext
+	reader.final = final
+	return nil
+}
+
+// Read returns decrypted data.
+func (reader *decryptReader) Read(p []byte) (int, error) {
+	for len(reader.buffer) == 0 {
+		if reader.final {
+			return 0, io.EOF
+		}
+		if err := reader.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, reader.buffer)
+	reader.buffer = reader.buffer[n:]
+	return n, nil
+}
+
+// isEncrypted returns true if reader contains an encrypted backup.
+func isEncrypted(reader *bufio.Reader) (bool, error) {
+	header, err := reader.Peek(len(encryptionMagic))
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read backup header: %w", err)
+	}
+	return bytes.Equal(header, encryptionMagic), nil
+}
+
+// decryptBackupReader detects if r is encrypted and returns a reader for the decrypted data.
+// Unencrypted backups are returned as-is.
+func decryptBackupReader(r io.Reader, passphrase string) (io.Reader, error) {
+	reader := bufio.NewReader(r)
+	encrypted, err := isEncrypted(reader)
+	if err != nil || !encrypted {
+		return reader, err
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+
+	header := make([]byte, encryptionHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if header[7] != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption version %v", header[7])
+	}
+	aead, err := createBackupCipher(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := &decryptReader{r: reader, aead: aead, header: header}
+	// Check the passphrase before returning the reader.
+	if err := decrypted.readChunk(); err != nil {
+		return nil, err
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encryptTestData(t *testing.T, data []byte, passphrase string) []byte {
+	var encrypted bytes.Buffer
+	writer, err := newEncryptWriter(&encrypted, passphrase)
+	assert.NoError(t, err)
+	_, err = writer.Write(data)
+	assert.NoError(t, err)
+	err = writer.Close()
+	assert.NoError(t, err)
+	return encrypted.Bytes()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	for _, size := range []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3 * encryptionChunkSize} {
+		data := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+
+		encrypted := encryptTestData(t, data, "pass")
+		assert.True(t, bytes.HasPrefix(encrypted, encryptionMagic))
+		assert.NotContains(t, string(encrypted), "0123456789")
+
+		reader, err := decryptBackupReader(bytes.NewReader(encrypted), "pass")
+		assert.NoError(t, err)
+		decrypted, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decrypted)
+	}
+}
+
+func TestDecryptUnencrypted(t *testing.T) {
+	reader, err := decryptBackupReader(strings.NewReader("{}"), "pass")
+	assert.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	data := bytes.Repeat([]byte("data"), encryptionChunkSize/2)
+	encrypted := encryptTestData(t, data, "pass")
+
+	decrypt := func(encrypted []byte, passphrase string) error {
+		reader, err := decryptBackupReader(bytes.NewReader(encrypted), passphrase)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(reader)
+		return err
+	}
+
+	err := decrypt(encrypted, "")
+	assert.Equal(t, ErrPassphraseRequired, err)
+
+	err = decrypt(encrypted, "wrong")
+	assert.Equal(t, errDecryptionFailed, err)
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	err = decrypt(tampered, "pass")
+	assert.Equal(t, errDecryptionFailed, err)
+
+	tampered = append([]byte{}, encrypted...)
+	tampered[11] ^= 1
+	err = decrypt(tampered, "pass")
+	assert.Equal(t, errDecryptionFailed, err)
+
+	err = decrypt(encrypted[:len(encrypted)-1], "pass")
+	assert.EqualError(t, err, "encrypted backup is truncated")
+
+	firstChunkSize := encryptionHeaderSize + 1 + 4 + encryptionChunkSize + 16
+	err = decrypt(encrypted[:firstChunkSize], "pass")
+	assert.EqualError(t, err, "encrypted backup is truncated")
+
+	tampered = append([]byte{}, encrypted...)
+	tampered[7] = encryptionVersion + 1
+	err = decrypt(tampered, "pass")
+	assert.EqualError(t, err, "unsupported encryption version 2")
+
+	tampered = append([]byte{}, encrypted...)
+	tampered[8] = maxScryptLogN + 1
+	err = decrypt(tampered, "pass")
+	assert.EqualError(t, err, "unsupported key derivation parameters")
+}
+
+func TestBackupRestoreEncrypted(t *testing.T) {
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		err := resetDb()
+		assert.NoError(t, err)
+
+		saveTestBackupData()
+
+		var data bytes.Buffer
+		err = dbService.Backup(&data, BackupOptions{Compression: compression, Passphrase: "pass"})
+		assert.NoError(t, err)
+		assert.False(t, json.Valid(data.Bytes()))
+		assert.NotContains(t, data.String(), "pass1")
+		encrypted := data.Bytes()
+
+		err = resetDb()
+		assert.NoError(t, err)
+
+		report, err := dbService.Restore(bytes.NewReader(encrypted), RestoreOptions{})
+		assert.ErrorIs(t, err, ErrPassphraseRequired)
+		assert.Nil(t, report)
+
+		report, err = dbService.Restore(bytes.NewReader(encrypted), RestoreOptions{Passphrase: "wrong"})
+		assert.ErrorIs(t, err, errDecryptionFailed)
+		assert.Nil(t, report)
+
+		report, err = dbService.Restore(bytes.NewReader(encrypted[:len(encrypted)-20]), RestoreOptions{Passphrase: "pass"})
+		assert.Error(t, err)
+		assert.Nil(t, report)
+
+		users, err := getAllUsers()
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+
+		_, err = dbService.Restore(bytes.NewReader(encrypted), RestoreOptions{Passphrase: "pass"})
+		assert.NoError(t, err)
+
+		data.Reset()
+		err = dbService.Backup(&data, BackupOptions{})
+		assert.NoError(t, err)
+		assert.JSONEq(t, testBackupData, data.String())
+	}
+}
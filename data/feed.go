@@ -46,6 +46,17 @@ func (feedItem *Feeditem) decode(val []byte) error {
 // GetFeeditem retrieves a Feeditem for the FeeditemKey.
 // If item doesn't exist, returns nil.
 func (s *DBService) GetFeeditem(key *FeeditemKey) (*Feeditem, error) {
+	var feeditem *Feeditem
+	err := s.view(func() error {
+		var err error
+		feeditem, err = s.getFeeditem(key)
+		return err
+	})
+	return feeditem, err
+}
+
+// getFeeditem retrieves a Feeditem for the FeeditemKey, without acquiring a lock.
+func (s *DBService) getFeeditem(key *FeeditemKey) (*Feeditem, error) {
 	feeditem := &Feeditem{Key: key}
 	value, err := s.db.Get(key.CreateKey())
 	if err != nil {
@@ -72,6 +83,13 @@ func (s *DBService) GetFeeditem(key *FeeditemKey) (*Feeditem, error) {
 
 // SaveFeeditems saves feedItems in the database.
 func (s *DBService) SaveFeeditems(feedItems ...*Feeditem) (err error) {
+	return s.update(func() error {
+		return s.saveFeeditems(feedItems...)
+	})
+}
+
+// saveFeeditems saves feedItems in the database, without acquiring a lock.
+func (s *DBService) saveFeeditems(feedItems ...*Feeditem) error {
 	for _, feedItem := range feedItems {
 		if err := s.addReferencedKey(feedItem.Key.createIndexKey(), []byte(feedItem.Key.GUID)); err != nil {
 			return fmt.Errorf("failed to add feed item %v to feed index: %w", feedItem.Key, err)
@@ -86,14 +104,14 @@ func (s *DBService) SaveFeeditems(feedItems ...*Feeditem) (err error) {
 			Updated: feedItem.Updated,
 		}
 
-		previousItem, err := s.GetFeeditem(feedItem.Key)
+		previousItem, err := s.getFeeditem(feedItem.Key)
 		if err != nil {
 			log.WithField("key", feedItem.Key).WithError(err).Error("Failed to read previous item")
 		} else if previousItem != nil {
 			saveFeedItem.Date = feedItem.Date.In(previousItem.Date.Location())
 		}
 
-		if err := s.SetLastSeen(key); err != nil {
+		if err := s.setLastSeen(key); err != nil {
 			return fmt.Errorf("cannot set last seen time: %w", err)
 		}
 
@@ -121,16 +139,28 @@ func (s *DBService) SaveFeeditems(feedItems ...*Feeditem) (err error) {
 		if err := s.db.Put(contentsKey, []byte(feedItem.Contents)); err != nil {
 			return fmt.Errorf("cannot save feed item contents: %w", err)
 		}
+
+		if err := s.indexDocument(key, feedItem.Title, htmlText(feedItem.Contents)); err != nil {
+			return fmt.Errorf("cannot add feed item to search index: %w", err)
+		}
 	}
 	return nil
 }
 
 // GetFeeditems returns all Feeditem items for user.
 func (s *DBService) GetFeeditems(user *User) ([]*Feeditem, error) {
-	feeds, err := user.GetFeeds()
-	if err != nil {
-		return nil, err
-	}
+	var feedItems []*Feeditem
+	err := s.view(func() error {
+		var err error
+		feedItems, err = s.getFeeditems(user)
+		return err
+	})
+	return feedItems, err
+}
+
+// getFeeditems returns all Feeditem items for user, without acquiring a lock.
+func (s *DBService) getFeeditems(user *User) ([]*Feeditem, error) {
+	feeds := user.GetFeeds()
 
 	feedItems := make([]*Feeditem, 0)
 	for i := range feeds {
@@ -143,7 +173,7 @@ func (s *DBService) GetFeeditems(user *User) ([]*Feeditem, error) {
 		}
 		for j := range indexItems {
 			itemKey := FeeditemKey{
-				FeedURL: feed.URL,
+				FeedURL: feed.ItemsURL(),
 				GUID:    string(indexItems[j]),
 			}
 
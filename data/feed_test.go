@@ -142,6 +142,14 @@ func TestSaveReadItemTTLExpired(t *testing.T) {
 	dbItem, err := dbService.GetFeeditem(item.Key)
 	assert.NoError(t, err)
 	assert.Nil(t, dbItem)
+
+	itemsIndexKey := append(feedKey.CreateKey(), []byte(separator)...)
+	value, err := dbService.db.Get(itemsIndexKey)
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	value, err = dbService.db.Get(createShardKey(itemsIndexKey, 1, 0))
+	assert.NoError(t, err)
+	assert.Nil(t, value)
 }
 
 func TestSaveReadItemTTLNotExpired(t *testing.T) {
@@ -211,16 +219,32 @@ func TestSaveReadAllItems(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := User{
-		Opml: `<opml version="1.0">` +
-			`<body>` +
-			`<outline text="Updates" title="Updates">` +
-			`<outline text="Site 2" title="Site 2" type="rss" xmlUrl="http://feed1" htmlUrl="http://feed1"/>` +
-			`<outline text="Site 3" title="Site 3" type="rss" xmlUrl="http://feed2" htmlUrl="http://feed2"/>` +
-			`</outline>` +
-			`</body>` +
-			`</opml>`,
+		Subscriptions: []UserFeed{
+			{URL: "http://feed1", Title: "Site 2", Type: "rss", Folder: "Updates"},
+			{URL: "http://feed2", Title: "Site 3", Type: "rss", Folder: "Updates"},
+		},
 	}
 	dbItems, err := getFeedItems(&user)
 	assert.NoError(t, err)
 	assert.EqualValues(t, items, dbItems)
 }
+
+func TestGetFeedItemsPathPrefix(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	docsFeed := UserFeed{URL: "http://site1/sitemap.xml", Type: "sitemap", PathPrefix: "/docs/"}
+	blogFeed := UserFeed{URL: "http://site1/sitemap.xml", Type: "sitemap", PathPrefix: "/blog/"}
+	item1 := &Feeditem{Title: "t1", Contents: "c1", Key: &FeeditemKey{FeedURL: docsFeed.ItemsURL(), GUID: "http://site1/docs/page1"}}
+	item2 := &Feeditem{Title: "t2", Contents: "c2", Key: &FeeditemKey{FeedURL: blogFeed.ItemsURL(), GUID: "http://site1/blog/post1"}}
+	err = dbService.SaveFeeditems(item1, item2)
+	assert.NoError(t, err)
+
+	// Subscribers with different path prefixes should only get their own items.
+	dbItems, err := getFeedItems(&User{Subscriptions: []UserFeed{docsFeed}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*Feeditem{item1}, dbItems)
+	dbItems, err = getFeedItems(&User{Subscriptions: []UserFeed{blogFeed}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, []*Feeditem{item2}, dbItems)
+}
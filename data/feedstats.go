@@ -0,0 +1,148 @@
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// FeedStats contains statistics for one of the user's feeds (or monitored pages), computed from stored items.
+type FeedStats struct {
+	URL   string
+	Title string
+	// Items is the number of items currently stored for the feed.
+	Items int
+	// ItemsPerWeek is the average number of items posted per week, between the oldest and newest stored item.
+	ItemsPerWeek float64
+	// AverageSize is the average size of item contents, in bytes.
+	AverageSize int
+	// LastItem is the date of the newest item, or zero if the feed has no items.
+	LastItem time.Time
+	// Unread is the number of items not read by the user.
+	Unread int
+	// Fetches is the number of recent fetches of the feed; FetchSuccessRate is the fraction of them which were successful.
+	Fetches          int
+	FetchSuccessRate float64
+}
+
+// addItem adds an item to stats.
+func (stats *FeedStats) addItem(date time.Time, size int, read bool) {
+	stats.Items++
+	stats.AverageSize += size
+	if date.After(stats.LastItem) {
+		stats.LastItem = date
+	}
+	if !read {
+		stats.Unread++
+	}
+}
+
+// addFetchStatus adds the fetch success rate from fetchStatus to stats.
+func (stats *FeedStats) addFetchStatus(fetchStatus *FetchStatus) {
+	if fetchStatus == nil {
+		return
+	}
+	successes := 0
+	for _, success := range fetchStatus.RecentFetches {
+		if success {
+			successes++
+		}
+	}
+	stats.Fetches = len(fetchStatus.RecentFetches)
+	if stats.Fetches > 0 {
+		stats.FetchSuccessRate = float64(successes) / float64(stats.Fetches)
+	}
+}
+
+// GetFeedStats returns statistics for all of the user's feeds and pages, in the same order as in the user's configuration.
+func (s *DBService) GetFeedStats(user *User) ([]*FeedStats, error) {
+	var feedStats []*FeedStats
+	err := s.view(func() error {
+		var err error
+		feedStats, err = s.getFeedStats(user)
+		return err
+	})
+	return feedStats, err
+}
+
+// getFeedStats returns statistics for all of the user's feeds and pages, without acquiring a lock.
+func (s *DBService) getFeedStats(user *User) ([]*FeedStats, error) {
+	readItems, err := s.getReadItems(user)
+	if err != nil {
+		return nil, err
+	}
+	readStatuses := make(map[string]bool, len(readItems))
+	for _, k := range readItems {
+		readStatuses[string(k)] = true
+	}
+
+	feeds := user.GetFeeds()
+	pages := user.GetPages()
+	feedStats := make([]*FeedStats, 0, len(feeds)+len(pages))
+
+	for i := range feeds {
+		feed := &feeds[i]
+		stats := &FeedStats{URL: feed.URL, Title: feed.Title}
+
+		guids, err := s.getReferencedKeys(feed.createItemsIndexKey())
+		if err != nil {
+			return nil, fmt.Errorf("cannot get items of feed %v: %w", feed.URL, err)
+		}
+		var firstItem time.Time
+		for _, guid := range guids {
+			key := &FeeditemKey{FeedURL: feed.ItemsURL(), GUID: string(guid)}
+			value, err := s.db.Get(key.CreateKey())
+			if err != nil {
+				return nil, fmt.Errorf("cannot get feed item %v: %w", key, err)
+			}
+			if value == nil {
+				continue
+			}
+			feedItem := &Feeditem{}
+			if err := feedItem.decode(value); err != nil {
+				return nil, fmt.Errorf("cannot decode feed item %v: %w", key, err)
+			}
+			contents, err := s.db.Get(key.createContentsKey())
+			if err != nil {
+				return nil, fmt.Errorf("cannot get contents of feed item %v: %w", key, err)
+			}
+			stats.addItem(feedItem.Date, len(contents), readStatuses[string(key.CreateKey())])
+			if firstItem.IsZero() || feedItem.Date.Before(firstItem) {
+				firstItem = feedItem.Date
+			}
+		}
+		if stats.Items > 0 {
+			stats.AverageSize /= stats.Items
+		}
+		if weeks := stats.LastItem.Sub(firstItem).Hours() / (24 * 7); stats.Items > 1 && weeks > 0 {
+			stats.ItemsPerWeek = float64(stats.Items-1) / weeks
+		}
+
+		fetchStatus, err := s.getFetchStatus(createFetchStatusKey(feed.CreateKey()))
+		if err != nil {
+			return nil, err
+		}
+		stats.addFetchStatus(fetchStatus)
+		feedStats = append(feedStats, stats)
+	}
+
+	for i := range pages {
+		pm := &pages[i]
+		stats := &FeedStats{URL: pm.URL, Title: pm.Title}
+
+		page, err := s.getPage(pm)
+		if err != nil {
+			return nil, err
+		}
+		if page != nil {
+			stats.addItem(page.Updated, len(page.Contents), readStatuses[string(pm.CreateKey())])
+		}
+
+		fetchStatus, err := s.getFetchStatus(createFetchStatusKey(pm.CreateKey()))
+		if err != nil {
+			return nil, err
+		}
+		stats.addFetchStatus(fetchStatus)
+		feedStats = append(feedStats, stats)
+	}
+	return feedStats, nil
+}
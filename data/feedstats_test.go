@@ -0,0 +1,124 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFeedStats(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{
+		{URL: "http://feed1", Title: "Feed 1"},
+		{URL: "http://feed2", Title: "Feed 2"},
+	}
+	user.Pages = []UserPagemonitor{
+		{URL: "http://site1", Title: "Site 1"},
+		{URL: "http://site2", Title: "Site 2"},
+	}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	items := []*Feeditem{
+		{
+			Title:    "t1",
+			Date:     time.Date(2019, time.February, 1, 0, 0, 0, 0, time.UTC),
+			Contents: "contents1",
+			Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+		},
+		{
+			Title:    "t2",
+			Date:     time.Date(2019, time.February, 15, 0, 0, 0, 0, time.UTC),
+			Contents: "c2",
+			Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g2"},
+		},
+		{
+			Title:    "t3",
+			Date:     time.Date(2019, time.February, 8, 0, 0, 0, 0, time.UTC),
+			Contents: "c3",
+			Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g3"},
+		},
+	}
+	err = dbService.SaveFeeditems(items...)
+	assert.NoError(t, err)
+
+	page := &PagemonitorPage{Contents: "page", Updated: time.Date(2019, time.February, 16, 0, 0, 0, 0, time.UTC), Config: &user.Pages[0]}
+	err = dbService.SavePage(page)
+	assert.NoError(t, err)
+
+	_, err = dbService.SetReadStatuses(user, [][]byte{items[0].Key.CreateKey(), page.Config.CreateKey()}, true)
+	assert.NoError(t, err)
+
+	for _, fetchStatus := range []*FetchStatus{
+		{LastSuccess: time.Date(2019, time.February, 16, 0, 0, 0, 0, time.UTC)},
+		{LastFailure: time.Date(2019, time.February, 16, 1, 0, 0, 0, time.UTC)},
+		{LastSuccess: time.Date(2019, time.February, 16, 2, 0, 0, 0, time.UTC)},
+		{LastSuccess: time.Date(2019, time.February, 16, 3, 0, 0, 0, time.UTC)},
+	} {
+		err = dbService.SetFetchStatus(user.Subscriptions[0].CreateKey(), fetchStatus)
+		assert.NoError(t, err)
+	}
+	err = dbService.SetFetchStatus(user.Pages[0].CreateKey(), &FetchStatus{LastFailure: time.Date(2019, time.February, 16, 0, 0, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+
+	stats, err := dbService.GetFeedStats(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []*FeedStats{
+		{
+			URL:              "http://feed1",
+			Title:            "Feed 1",
+			Items:            3,
+			ItemsPerWeek:     1,
+			AverageSize:      4,
+			LastItem:         time.Date(2019, time.February, 15, 0, 0, 0, 0, time.UTC),
+			Unread:           2,
+			Fetches:          4,
+			FetchSuccessRate: 0.75,
+		},
+		{URL: "http://feed2", Title: "Feed 2"},
+		{
+			URL:         "http://site1",
+			Title:       "Site 1",
+			Items:       1,
+			AverageSize: 4,
+			LastItem:    time.Date(2019, time.February, 16, 0, 0, 0, 0, time.UTC),
+			Fetches:     1,
+		},
+		{URL: "http://site2", Title: "Site 2"},
+	}, stats)
+}
+
+func TestGetFeedStatsRecentFetches(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	defaultMaxRecentFetches := maxRecentFetches
+	defer func() { maxRecentFetches = defaultMaxRecentFetches }()
+	maxRecentFetches = 2
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed1", Title: "Feed 1"}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	// Only the last fetches are counted.
+	for _, fetchStatus := range []*FetchStatus{
+		{LastFailure: time.Date(2019, time.February, 16, 0, 0, 0, 0, time.UTC)},
+		{LastFailure: time.Date(2019, time.February, 16, 1, 0, 0, 0, time.UTC)},
+		{LastSuccess: time.Date(2019, time.February, 16, 2, 0, 0, 0, time.UTC)},
+		{LastSuccess: time.Date(2019, time.February, 16, 3, 0, 0, 0, time.UTC)},
+	} {
+		err = dbService.SetFetchStatus(user.Subscriptions[0].CreateKey(), fetchStatus)
+		assert.NoError(t, err)
+	}
+
+	stats, err := dbService.GetFeedStats(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []*FeedStats{
+		{URL: "http://feed1", Title: "Feed 1", Fetches: 2, FetchSuccessRate: 1},
+	}, stats)
+}
@@ -9,10 +9,24 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// maxRecentFetches is the number of fetch results kept in a FetchStatus.
+var maxRecentFetches = 100
+
 // FetchStatus keeps track of successful and failed fetches.
+// RecentFetches contains the results of the last fetches (true if successful), oldest first; it's updated by SetFetchStatus.
 type FetchStatus struct {
-	LastSuccess time.Time
-	LastFailure time.Time
+	LastSuccess   time.Time
+	LastFailure   time.Time
+	RecentFetches []bool
+}
+
+// addRecentFetch adds a fetch result, discarding the oldest results if there are more than maxRecentFetches.
+func (fetchStatus *FetchStatus) addRecentFetch(success bool) {
+	recentFetches := append(fetchStatus.RecentFetches, success)
+	if len(recentFetches) > maxRecentFetches {
+		recentFetches = recentFetches[len(recentFetches)-maxRecentFetches:]
+	}
+	fetchStatus.RecentFetches = recentFetches
 }
 
 // decode deserializes a FetchStatus.
@@ -44,11 +58,22 @@ func (s *DBService) getFetchStatus(k []byte) (*FetchStatus, error) {
 func (s *DBService) GetFetchStatus(key []byte) (fetchStatus *FetchStatus, err error) {
 	k := createFetchStatusKey(key)
 
-	return s.getFetchStatus(k)
+	err = s.view(func() error {
+		fetchStatus, err = s.getFetchStatus(k)
+		return err
+	})
+	return fetchStatus, err
 }
 
 // SetFetchStatus creates or updates the fetch status for key.
 func (s *DBService) SetFetchStatus(key []byte, fetchStatus *FetchStatus) error {
+	return s.update(func() error {
+		return s.setFetchStatus(key, fetchStatus)
+	})
+}
+
+// setFetchStatus creates or updates the fetch status for key, without acquiring a lock.
+func (s *DBService) setFetchStatus(key []byte, fetchStatus *FetchStatus) error {
 	k := createFetchStatusKey(key)
 
 	previousFetchStatus, err := s.getFetchStatus(k)
@@ -65,9 +90,11 @@ func (s *DBService) SetFetchStatus(key []byte, fetchStatus *FetchStatus) error {
 	var emptyTime time.Time
 	if fetchStatus.LastSuccess != emptyTime {
 		newFetchStatus.LastSuccess = fetchStatus.LastSuccess
+		newFetchStatus.addRecentFetch(true)
 	}
 	if fetchStatus.LastFailure != emptyTime {
 		newFetchStatus.LastFailure = fetchStatus.LastFailure
+		newFetchStatus.addRecentFetch(false)
 	}
 
 	var value bytes.Buffer
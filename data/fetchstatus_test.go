@@ -28,7 +28,7 @@ func TestSaveGetFetchStatus(t *testing.T) {
 
 	dbFetchStatus, err := dbService.GetFetchStatus(key)
 	assert.NoError(t, err)
-	assert.Equal(t, fetchStatus, dbFetchStatus)
+	assert.Equal(t, &FetchStatus{LastSuccess: fetchStatus.LastSuccess, RecentFetches: []bool{true}}, dbFetchStatus)
 }
 
 func TestUpdateFetchStatus(t *testing.T) {
@@ -47,8 +47,9 @@ func TestUpdateFetchStatus(t *testing.T) {
 	dbFetchStatus, err := dbService.GetFetchStatus(key)
 	assert.NoError(t, err)
 	assert.Equal(t, &FetchStatus{
-		LastSuccess: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
-		LastFailure: time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+		LastSuccess:   time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		LastFailure:   time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+		RecentFetches: []bool{true, false},
 	}, dbFetchStatus)
 
 	fetchStatus = &FetchStatus{LastFailure: time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC)}
@@ -58,8 +59,38 @@ func TestUpdateFetchStatus(t *testing.T) {
 	dbFetchStatus, err = dbService.GetFetchStatus(key)
 	assert.NoError(t, err)
 	assert.Equal(t, &FetchStatus{
-		LastSuccess: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
-		LastFailure: time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+		LastSuccess:   time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		LastFailure:   time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+		RecentFetches: []bool{true, false, false},
+	}, dbFetchStatus)
+}
+
+func TestUpdateFetchStatusRecentFetchesLimit(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	defaultMaxRecentFetches := maxRecentFetches
+	defer func() { maxRecentFetches = defaultMaxRecentFetches }()
+	maxRecentFetches = 3
+
+	key := []byte("i1")
+	for _, fetchStatus := range []*FetchStatus{
+		{LastFailure: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)},
+		{LastFailure: time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC)},
+		{LastSuccess: time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC)},
+		{LastFailure: time.Date(2019, time.February, 16, 23, 3, 0, 0, time.UTC)},
+		{LastSuccess: time.Date(2019, time.February, 16, 23, 4, 0, 0, time.UTC)},
+	} {
+		err = dbService.SetFetchStatus(key, fetchStatus)
+		assert.NoError(t, err)
+	}
+
+	dbFetchStatus, err := dbService.GetFetchStatus(key)
+	assert.NoError(t, err)
+	assert.Equal(t, &FetchStatus{
+		LastSuccess:   time.Date(2019, time.February, 16, 23, 4, 0, 0, time.UTC),
+		LastFailure:   time.Date(2019, time.February, 16, 23, 3, 0, 0, time.UTC),
+		RecentFetches: []bool{true, false, true},
 	}, dbFetchStatus)
 }
 
@@ -86,5 +117,5 @@ func TestCleanupStaleFetchStatus(t *testing.T) {
 
 	dbFetchStatus2, err := dbService.GetFetchStatus(key2)
 	assert.NoError(t, err)
-	assert.Equal(t, fetchStatus2, dbFetchStatus2)
+	assert.Equal(t, &FetchStatus{LastFailure: fetchStatus2.LastFailure, RecentFetches: []bool{false}}, dbFetchStatus2)
 }
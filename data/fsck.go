@@ -0,0 +1,458 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Categories of problems found by Fsck.
+const (
+	// FsckInvalidIndex is an index which cannot be decoded; it's not repaired.
+	FsckInvalidIndex = "invalid-index"
+	// FsckMissingUser is an entry in the user index without a user.
+	FsckMissingUser = "missing-user"
+	// FsckUnindexedUser is a user which is missing from the user index.
+	FsckUnindexedUser = "unindexed-user"
+	// FsckMissingFeeditem is an entry in a feed index without a feed item.
+	FsckMissingFeeditem = "missing-feeditem"
+	// FsckUnindexedFeeditem is a feed item which is missing from its feed index, and will never expire.
+	FsckUnindexedFeeditem = "unindexed-feeditem"
+	// FsckOrphanedContents are contents of a feed item which doesn't exist.
+	FsckOrphanedContents = "orphaned-contents"
+	// FsckStaleLastSeen is a last seen time (or last seen index entry) for an item which doesn't exist.
+	FsckStaleLastSeen = "stale-lastseen"
+	// FsckMissingFetchStatus is an entry in the fetch status index without a fetch status.
+	FsckMissingFetchStatus = "missing-fetchstatus"
+	// FsckDanglingReadStatus is a read status for an item which doesn't exist.
+	FsckDanglingReadStatus = "dangling-readstatus"
+	// FsckDanglingStarred is a starred item which doesn't exist.
+	FsckDanglingStarred = "dangling-starred"
+	// FsckDanglingTagged is a tagged item which doesn't exist.
+	FsckDanglingTagged = "dangling-tagged"
+	// FsckMissingNote is an entry in the notes index without a note.
+	FsckMissingNote = "missing-note"
+	// FsckMissingReadHistory is an entry in the read history index without read events.
+	FsckMissingReadHistory = "missing-readhistory"
+	// FsckStaleSearchDocument is a search document for an item which doesn't exist.
+	FsckStaleSearchDocument = "stale-search-document"
+	// FsckMissingSearchDocument is an entry in a search term index without a search document.
+	FsckMissingSearchDocument = "missing-search-document"
+	// FsckStaleShard is an index shard which doesn't match its index header, left by an interrupted reshard or a deleted index.
+	FsckStaleShard = "stale-shard"
+	// FsckStaleJournal is a journal chunk without a journal header, left by an interrupted commit.
+	FsckStaleJournal = "stale-journal"
+	// FsckOrphanedUserData is a key which belongs to a user who doesn't exist.
+	FsckOrphanedUserData = "orphaned-user-data"
+)
+
+// FsckReport lists the problems found by Fsck.
+type FsckReport struct {
+	Repaired bool
+	// Problems contains the affected keys (or index entries), grouped by category.
+	Problems map[string][]string
+}
+
+// Count returns the total number of problems in the report.
+func (report *FsckReport) Count() int {
+	count := 0
+	for _, problems := range report.Problems {
+		count += len(problems)
+	}
+	return count
+}
+
+// userDataPrefixes are key prefixes followed by an encoded username.
+var userDataPrefixes = []string{readStatusPrefix, readHistoryPrefix, bulkReadPrefix, starredPrefix, tagsPrefix, taggedPrefix, notePrefix, notesPrefix}
+
+// fsckRun keeps the state of a running Fsck.
+type fsckRun struct {
+	s      *DBService
+	repair bool
+	report *FsckReport
+}
+
+// problem adds a problem to the report, and calls fix if problems should be repaired.
+func (run *fsckRun) problem(category, description string, fix func() error) error {
+	run.report.Problems[category] = append(run.report.Problems[category], description)
+	if !run.repair || fix == nil {
+		return nil
+	}
+	if err := fix(); err != nil {
+		return fmt.Errorf("cannot repair %v %v: %w", category, description, err)
+	}
+	return nil
+}
+
+// getReferencedKeys returns all keys from the prefix index, or reports the index as invalid if it cannot be decoded.
+func (run *fsckRun) getReferencedKeys(prefix []byte) ([][]byte, bool) {
+	indexKeys, err := run.s.getReferencedKeys(prefix)
+	if err != nil {
+		log.WithField("index", string(prefix)).WithError(err).Error("Failed to read index")
+		run.report.Problems[FsckInvalidIndex] = append(run.report.Problems[FsckInvalidIndex], string(prefix))
+		return nil, false
+	}
+	return indexKeys, true
+}
+
+// checkIndexEntries calls check for every entry of the prefix index; entries for which check returns false are reported in category.
+// Repairing the problem deletes the entry from the index.
+func (run *fsckRun) checkIndexEntries(prefix []byte, category string, check func(entry []byte) (bool, error)) error {
+	indexKeys, ok := run.getReferencedKeys(prefix)
+	if !ok {
+		return nil
+	}
+	for _, entry := range indexKeys {
+		valid, err := check(entry)
+		if err != nil {
+			return err
+		}
+		if valid {
+			continue
+		}
+		entry := entry
+		err = run.problem(category, string(prefix)+": "+string(entry), func() error {
+			return run.s.deleteReferencedKey(prefix, entry)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fsck checks all indexes and keys for inconsistencies, and returns the problems found, grouped by category.
+// If repair is true, problems are fixed by deleting the broken entries (or adding missing index entries);
+// all repairs are saved in a single transaction.
+func (s *DBService) Fsck(repair bool) (*FsckReport, error) {
+	run := &fsckRun{s: s, repair: repair, report: &FsckReport{Repaired: repair, Problems: make(map[string][]string)}}
+
+	txn := s.view
+	if repair {
+		txn = s.update
+	}
+	if err := txn(run.check); err != nil {
+		return nil, err
+	}
+
+	for category, problems := range run.report.Problems {
+		sort.Strings(problems)
+		log.WithField("category", category).WithField("count", len(problems)).WithField("repaired", repair).Warn("Database check found problems")
+	}
+	return run.report, nil
+}
+
+// check runs all checks, without acquiring a lock.
+func (run *fsckRun) check() error {
+	keys := make([]string, 0)
+	shardKeys := make(map[string][]string)
+	err := run.s.db.ForEach(func(key, value []byte) error {
+		if i := strings.Index(string(key), indexShardSeparator); i >= 0 {
+			shardKeys[string(key[:i])] = append(shardKeys[string(key[:i])], string(key))
+		} else {
+			keys = append(keys, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot list keys: %w", err)
+	}
+
+	exists := func(key []byte) (bool, error) {
+		return run.s.db.Has(key)
+	}
+
+	if err := run.checkShards(shardKeys); err != nil {
+		return err
+	}
+
+	usernames, err := run.checkUsers(keys)
+	if err != nil {
+		return err
+	}
+	if err := run.checkFeeditems(keys); err != nil {
+		return err
+	}
+
+	if err := run.checkIndexEntries([]byte(lastSeenKeyPrefix), FsckStaleLastSeen, exists); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, lastSeenKeyPrefix+separator) {
+			continue
+		}
+		found, err := exists([]byte(strings.TrimPrefix(key, lastSeenKeyPrefix+separator)))
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		key := key
+		if err := run.problem(FsckStaleLastSeen, key, func() error { return run.s.db.Delete([]byte(key)) }); err != nil {
+			return err
+		}
+	}
+
+	err = run.checkIndexEntries([]byte(fetchStatusKeyPrefix), FsckMissingFetchStatus, func(entry []byte) (bool, error) {
+		return exists(createFetchStatusKey(entry))
+	})
+	if err != nil {
+		return err
+	}
+
+	documentKeys, _ := run.getReferencedKeys([]byte(searchDocumentPrefix))
+	for _, key := range documentKeys {
+		found, err := exists(key)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		key := key
+		err = run.problem(FsckStaleSearchDocument, string(key), func() error {
+			if err := run.s.unindexDocument(key); err != nil {
+				return err
+			}
+			return run.s.deleteReferencedKey([]byte(searchDocumentPrefix), key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, searchTermPrefix+separator) {
+			continue
+		}
+		err := run.checkIndexEntries([]byte(key), FsckMissingSearchDocument, func(entry []byte) (bool, error) {
+			return exists(createSearchDocumentKey(entry))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, username := range usernames {
+		if err := run.checkUserData(&User{username: username}, exists); err != nil {
+			return err
+		}
+	}
+
+	return run.checkOrphanedUserData(keys, shardKeys, usernames)
+}
+
+// checkShards checks that every shard key belongs to an index, and matches the number of shards in the index header.
+// Journal chunks are only valid while the journal header exists.
+// Repairing the problem deletes the shard.
+func (run *fsckRun) checkShards(shardKeys map[string][]string) error {
+	prefixes := make([]string, 0, len(shardKeys))
+	for prefix := range shardKeys {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		value, err := run.s.db.Get([]byte(prefix))
+		if err != nil {
+			return err
+		}
+		category := FsckStaleShard
+		valid := func(shardKey string) bool { return false }
+		if prefix == journalKey {
+			category = FsckStaleJournal
+			if len(value) > 0 {
+				continue
+			}
+		} else if len(value) > 0 && !isLegacyIndex(value) {
+			header := &indexHeader{}
+			if err := header.decode(value); err != nil {
+				// Invalid indexes are reported when they're used, and are not repaired.
+				continue
+			}
+			valid = func(shardKey string) bool {
+				suffix := strings.SplitN(strings.TrimPrefix(shardKey, prefix+indexShardSeparator), "-", 2)
+				if len(suffix) != 2 {
+					return false
+				}
+				shards, err := strconv.ParseUint(suffix[0], 10, 64)
+				if err != nil || shards != header.shards {
+					return false
+				}
+				shard, err := strconv.ParseUint(suffix[1], 10, 64)
+				return err == nil && shard < shards
+			}
+		}
+
+		for _, shardKey := range shardKeys[prefix] {
+			if valid(shardKey) {
+				continue
+			}
+			shardKey := shardKey
+			if err := run.problem(category, shardKey, func() error { return run.s.db.Delete([]byte(shardKey)) }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkUsers checks the user index, and returns the usernames of all existing users.
+func (run *fsckRun) checkUsers(keys []string) ([]string, error) {
+	usernames := make([]string, 0)
+	indexed := make(map[string]bool)
+	err := run.checkIndexEntries([]byte(userKeyPrefix), FsckMissingUser, func(entry []byte) (bool, error) {
+		indexed[string(entry)] = true
+		found, err := run.s.db.Has(createUserKey(string(entry)))
+		if found {
+			usernames = append(usernames, string(entry))
+		}
+		return found, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		parts := strings.Split(key, separator)
+		if len(parts) != 2 || parts[0] != userKeyPrefix {
+			continue
+		}
+		username, err := decodePart(parts[1])
+		if err != nil || indexed[username] {
+			continue
+		}
+		usernames = append(usernames, username)
+		err = run.problem(FsckUnindexedUser, key, func() error {
+			return run.s.addReferencedKey([]byte(userKeyPrefix), []byte(username))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return usernames, nil
+}
+
+// checkFeeditems checks that all feed item indexes are consistent with stored feed items and their contents.
+func (run *fsckRun) checkFeeditems(keys []string) error {
+	indexedItems := make(map[string]bool)
+	for _, key := range keys {
+		parts := strings.Split(key, separator)
+		if len(parts) != 3 || parts[0] != feedKeyPrefix || parts[2] != "" {
+			continue
+		}
+		prefix := []byte(key)
+		err := run.checkIndexEntries(prefix, FsckMissingFeeditem, func(guid []byte) (bool, error) {
+			itemKey := key + encodePart(string(guid))
+			found, err := run.s.db.Has([]byte(itemKey))
+			if found {
+				indexedItems[itemKey] = true
+			}
+			return found, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		parts := strings.Split(key, separator)
+		if parts[0] != feedKeyPrefix {
+			continue
+		}
+		if len(parts) == 3 && parts[2] != "" && !indexedItems[key] {
+			guid, err := decodePart(parts[2])
+			if err != nil {
+				continue
+			}
+			prefix := []byte(parts[0] + separator + parts[1] + separator)
+			err = run.problem(FsckUnindexedFeeditem, key, func() error {
+				return run.s.addReferencedKey(prefix, []byte(guid))
+			})
+			if err != nil {
+				return err
+			}
+		} else if len(parts) == 4 && separator+parts[3] == feedContentsSuffix {
+			found, err := run.s.db.Has([]byte(strings.TrimSuffix(key, feedContentsSuffix)))
+			if err != nil {
+				return err
+			}
+			if found {
+				continue
+			}
+			key := key
+			if err := run.problem(FsckOrphanedContents, key, func() error { return run.s.db.Delete([]byte(key)) }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkUserData checks that all of user's indexes reference existing items.
+func (run *fsckRun) checkUserData(user *User, exists func(key []byte) (bool, error)) error {
+	if err := run.checkIndexEntries(user.createReadStatusPrefix(), FsckDanglingReadStatus, exists); err != nil {
+		return err
+	}
+	if err := run.checkIndexEntries(user.createStarredPrefix(), FsckDanglingStarred, exists); err != nil {
+		return err
+	}
+	tags, ok := run.getReferencedKeys(user.createTagsKey())
+	if ok {
+		for _, tag := range tags {
+			if err := run.checkIndexEntries(user.createTaggedPrefix(string(tag)), FsckDanglingTagged, exists); err != nil {
+				return err
+			}
+		}
+	}
+	err := run.checkIndexEntries(user.createNotesIndexKey(), FsckMissingNote, func(entry []byte) (bool, error) {
+		return exists(user.createNoteKey(entry))
+	})
+	if err != nil {
+		return err
+	}
+	return run.checkIndexEntries(user.createReadHistoryIndexKey(), FsckMissingReadHistory, func(entry []byte) (bool, error) {
+		return exists(user.createReadHistoryKey(string(entry)))
+	})
+}
+
+// checkOrphanedUserData checks that all per-user keys belong to an existing user.
+// Repairing an orphaned index also deletes its shards.
+func (run *fsckRun) checkOrphanedUserData(keys []string, shardKeys map[string][]string, usernames []string) error {
+	users := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		users[username] = true
+	}
+	isUserDataPrefix := make(map[string]bool, len(userDataPrefixes))
+	for _, prefix := range userDataPrefixes {
+		isUserDataPrefix[prefix] = true
+	}
+
+	for _, key := range keys {
+		parts := strings.SplitN(key, separator, 3)
+		if len(parts) < 2 || !isUserDataPrefix[parts[0]] {
+			continue
+		}
+		username, err := decodePart(parts[1])
+		if err != nil || users[username] {
+			continue
+		}
+		key := key
+		err = run.problem(FsckOrphanedUserData, key, func() error {
+			for _, shardKey := range shardKeys[key] {
+				if err := run.s.db.Delete([]byte(shardKey)); err != nil {
+					return err
+				}
+			}
+			return run.s.db.Delete([]byte(key))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
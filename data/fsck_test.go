@@ -0,0 +1,192 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsckConsistent(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	defaultMaxIndexShardSize := maxIndexShardSize
+	defer func() { maxIndexShardSize = defaultMaxIndexShardSize }()
+	maxIndexShardSize = 1
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed1", Title: "Feed 1"}}
+	user.Pages = []UserPagemonitor{{URL: "http://site1", Title: "Site 1"}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+	// Adding more users reshards the user index.
+	err = dbService.SaveUser(NewUser("user02"))
+	assert.NoError(t, err)
+	err = dbService.SaveUser(NewUser("user03"))
+	assert.NoError(t, err)
+
+	item := &Feeditem{Title: "t1", Contents: "c1", Date: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC), Key: &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+	err = dbService.SavePage(&PagemonitorPage{Contents: "p1", Config: &user.Pages[0]})
+	assert.NoError(t, err)
+	err = dbService.SetFetchStatus(user.Subscriptions[0].CreateKey(), &FetchStatus{LastSuccess: time.Now()})
+	assert.NoError(t, err)
+
+	err = dbService.SetReadStatus(user, item.Key.CreateKey(), true)
+	assert.NoError(t, err)
+	err = dbService.SetStarred(user, user.Pages[0].CreateKey(), true)
+	assert.NoError(t, err)
+	err = dbService.SetTagged(user, "tag1", item.Key.CreateKey(), true)
+	assert.NoError(t, err)
+	err = dbService.SaveNote(user, item.Key.CreateKey(), &ItemNote{Text: "note"})
+	assert.NoError(t, err)
+
+	report, err := dbService.Fsck(false)
+	assert.NoError(t, err)
+	assert.Equal(t, &FsckReport{Problems: map[string][]string{}}, report)
+	assert.Equal(t, 0, report.Count())
+}
+
+func TestFsckRepair(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item1 := &Feeditem{Title: "t1", Contents: "c1", Key: &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}}
+	item2 := &Feeditem{Title: "t2", Contents: "c2", Key: &FeeditemKey{FeedURL: "http://feed1", GUID: "g2"}}
+	err = dbService.SaveFeeditems(item1, item2)
+	assert.NoError(t, err)
+	err = dbService.SetReadStatus(user, item2.Key.CreateKey(), true)
+	assert.NoError(t, err)
+	err = dbService.SetStarred(user, item2.Key.CreateKey(), true)
+	assert.NoError(t, err)
+	err = dbService.SetTagged(user, "tag1", item2.Key.CreateKey(), true)
+	assert.NoError(t, err)
+
+	// Delete item2 without cleaning up any references.
+	err = dbService.db.Delete(item2.Key.CreateKey())
+	assert.NoError(t, err)
+
+	// Add an item without adding it to the feed index.
+	item3Key := &FeeditemKey{FeedURL: "http://feed1", GUID: "g3"}
+	value, err := (&Feeditem{Title: "t3"}).encode()
+	assert.NoError(t, err)
+	err = dbService.db.Put(item3Key.CreateKey(), value)
+	assert.NoError(t, err)
+
+	// Add index entries without values.
+	err = dbService.addReferencedKey([]byte(userKeyPrefix), []byte("user02"))
+	assert.NoError(t, err)
+	err = dbService.addReferencedKey([]byte(fetchStatusKeyPrefix), []byte("feed/aHR0cDovL2ZlZWQy"))
+	assert.NoError(t, err)
+	err = dbService.addReferencedKey(user.createNotesIndexKey(), item1.Key.CreateKey())
+	assert.NoError(t, err)
+	err = dbService.addReferencedKey(user.createReadHistoryIndexKey(), []byte("2019-02-16"))
+	assert.NoError(t, err)
+
+	// Add a user without adding it to the user index.
+	var userValue bytes.Buffer
+	err = gob.NewEncoder(&userValue).Encode(NewUser("user03"))
+	assert.NoError(t, err)
+	err = dbService.db.Put(createUserKey("user03"), userValue.Bytes())
+	assert.NoError(t, err)
+
+	// Add a search term entry without a search document.
+	item4Key := (&FeeditemKey{FeedURL: "http://feed1", GUID: "g4"}).CreateKey()
+	err = dbService.addReferencedKey(createSearchTermKey("t4"), item4Key)
+	assert.NoError(t, err)
+
+	// Add shards left by an interrupted reshard, a shard without an index header, and a journal chunk without a journal header.
+	// Committing a transaction overwrites the first journal chunks, so the leftover chunk has a higher number.
+	reshardedKey := createShardKey([]byte(userKeyPrefix), 2, 1)
+	err = dbService.db.Put(reshardedKey, encodeShard([][]byte{[]byte("user01")}))
+	assert.NoError(t, err)
+	headerlessKey := createShardKey(createSearchTermKey("t5"), 1, 0)
+	err = dbService.db.Put(headerlessKey, encodeShard([][]byte{item1.Key.CreateKey()}))
+	assert.NoError(t, err)
+	err = dbService.db.Put(createJournalChunkKey(5), []byte("chunk"))
+	assert.NoError(t, err)
+
+	// Add data for a user who doesn't exist.
+	deletedUser := &User{username: "deleted"}
+	err = dbService.SetStarred(deletedUser, item1.Key.CreateKey(), true)
+	assert.NoError(t, err)
+
+	item2Key := string(item2.Key.CreateKey())
+	expectedProblems := map[string][]string{
+		FsckMissingUser:           {"user: user02"},
+		FsckUnindexedUser:         {"user/dXNlcjAz"},
+		FsckMissingFeeditem:       {"feed/aHR0cDovL2ZlZWQx/: g2"},
+		FsckUnindexedFeeditem:     {string(item3Key.CreateKey())},
+		FsckOrphanedContents:      {item2Key + "/contents"},
+		FsckStaleLastSeen:         {"lastseen/" + item2Key, "lastseen: " + item2Key},
+		FsckMissingFetchStatus:    {"fetchstatus: feed/aHR0cDovL2ZlZWQy"},
+		FsckStaleSearchDocument:   {item2Key},
+		FsckMissingSearchDocument: {string(createSearchTermKey("t4")) + ": " + string(item4Key)},
+		FsckStaleShard:            {string(headerlessKey), string(reshardedKey)},
+		FsckStaleJournal:          {string(createJournalChunkKey(5))},
+		FsckDanglingReadStatus:    {string(user.createReadStatusPrefix()) + ": " + item2Key},
+		FsckDanglingStarred:       {string(user.createStarredPrefix()) + ": " + item2Key},
+		FsckDanglingTagged:        {string(user.createTaggedPrefix("tag1")) + ": " + item2Key},
+		FsckMissingNote:           {string(user.createNotesIndexKey()) + ": " + string(item1.Key.CreateKey())},
+		FsckMissingReadHistory:    {string(user.createReadHistoryIndexKey()) + ": 2019-02-16"},
+		FsckOrphanedUserData:      {string(deletedUser.createStarredPrefix())},
+	}
+
+	report, err := dbService.Fsck(false)
+	assert.NoError(t, err)
+	assert.Equal(t, &FsckReport{Problems: expectedProblems}, report)
+	assert.Equal(t, 19, report.Count())
+
+	// Checking without repairing doesn't change anything.
+	report, err = dbService.Fsck(false)
+	assert.NoError(t, err)
+	assert.Equal(t, &FsckReport{Problems: expectedProblems}, report)
+
+	report, err = dbService.Fsck(true)
+	assert.NoError(t, err)
+	assert.Equal(t, &FsckReport{Repaired: true, Problems: expectedProblems}, report)
+
+	report, err = dbService.Fsck(false)
+	assert.NoError(t, err)
+	assert.Equal(t, &FsckReport{Problems: map[string][]string{}}, report)
+
+	users, err := dbService.GetUsers()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"user01", "user03"}, users)
+	readItems, err := dbService.GetReadItems(user)
+	assert.NoError(t, err)
+	assert.Empty(t, readItems)
+	guids, err := dbService.getReferencedKeys(item1.Key.createIndexKey())
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("g1"), []byte("g3")}, guids)
+	contents, err := dbService.db.Get(item2.Key.createContentsKey())
+	assert.NoError(t, err)
+	assert.Nil(t, contents)
+	document, err := dbService.getSearchDocument(item2.Key.CreateKey())
+	assert.NoError(t, err)
+	assert.Nil(t, document)
+	exists, err := dbService.db.Has(deletedUser.createStarredPrefix())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	for _, key := range [][]byte{reshardedKey, headerlessKey, createJournalChunkKey(5)} {
+		exists, err = dbService.db.Has(key)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	}
+	termKeys, err := dbService.getReferencedKeys(createSearchTermKey("t4"))
+	assert.NoError(t, err)
+	assert.Empty(t, termKeys)
+	err = dbService.db.ForEach(func(key, value []byte) error {
+		assert.False(t, bytes.HasPrefix(key, deletedUser.createStarredPrefix()))
+		return nil
+	})
+	assert.NoError(t, err)
+}
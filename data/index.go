@@ -2,82 +2,406 @@ package data
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
 )
 
+// An index is split into shards, each shard is stored in a separate key.
+// The index key itself contains an indexHeader, specifying the number of shards.
+// Adding or removing a key only needs to read and rewrite a single shard.
+//
+// Previous versions stored the whole index as a gob-encoded [][]byte in the index key;
+// such indexes are converted into the sharded format on the first write.
+
+// indexHeaderMagic is the first byte of an index header.
+// Gob never starts a message with a zero byte, so this can be used to tell apart legacy indexes.
+const indexHeaderMagic = 0x00
+
+// indexShardSeparator separates the index key from the shard suffix.
+// It's not a valid base64 character, so shard keys cannot conflict with item keys.
+const indexShardSeparator = "#"
+
+// maxIndexShardSize is the number of entries in a shard which will trigger resharding.
+var maxIndexShardSize = 256
+
+// indexHeader describes how an index is stored.
+type indexHeader struct {
+	shards uint64
+}
+
+// encode serializes an indexHeader.
+func (header *indexHeader) encode() []byte {
+	value := make([]byte, 1, 1+binary.MaxVarintLen64)
+	value[0] = indexHeaderMagic
+	return binary.AppendUvarint(value, header.shards)
+}
+
+// decode deserializes an indexHeader.
+func (header *indexHeader) decode(value []byte) error {
+	if len(value) < 2 || value[0] != indexHeaderMagic {
+		return fmt.Errorf("not an index header")
+	}
+	shards, n := binary.Uvarint(value[1:])
+	if n <= 0 || shards == 0 {
+		return fmt.Errorf("invalid index header")
+	}
+	header.shards = shards
+	return nil
+}
+
+// isLegacyIndex returns true if value is a gob-encoded index used by previous versions.
+func isLegacyIndex(value []byte) bool {
+	return len(value) > 0 && value[0] != indexHeaderMagic
+}
+
+// decodeLegacyIndex deserializes a gob-encoded index used by previous versions.
+func decodeLegacyIndex(value []byte) ([][]byte, error) {
+	indexKeys := make([][]byte, 0)
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&indexKeys); err != nil {
+		return nil, err
+	}
+	return indexKeys, nil
+}
+
+// createShardKey creates the key of shard number shard in the prefix index, split into shards.
+func createShardKey(prefix []byte, shards, shard uint64) []byte {
+	suffix := indexShardSeparator + strconv.FormatUint(shards, 10) + "-" + strconv.FormatUint(shard, 10)
+	return append(append([]byte{}, prefix...), []byte(suffix)...)
+}
+
+// shardForKey returns the shard which should contain key.
+func shardForKey(key []byte, shards uint64) uint64 {
+	hash := fnv.New64a()
+	hash.Write(key)
+	return hash.Sum64() % shards
+}
+
+// encodeShard serializes a (sorted) list of keys.
+func encodeShard(keys [][]byte) []byte {
+	size := 0
+	for _, key := range keys {
+		size += binary.MaxVarintLen64 + len(key)
+	}
+	value := make([]byte, 0, size)
+	for _, key := range keys {
+		value = binary.AppendUvarint(value, uint64(len(key)))
+		value = append(value, key...)
+	}
+	return value
+}
+
+// decodeShard deserializes a list of keys.
+func decodeShard(value []byte) ([][]byte, error) {
+	keys := make([][]byte, 0)
+	for len(value) > 0 {
+		length, n := binary.Uvarint(value)
+		if n <= 0 || uint64(len(value)-n) < length {
+			return nil, fmt.Errorf("invalid index shard")
+		}
+		value = value[n:]
+		keys = append(keys, value[:length:length])
+		value = value[length:]
+	}
+	return keys, nil
+}
+
+// findKey returns the position of key in a sorted list of keys, and if the key exists.
+func findKey(keys [][]byte, key []byte) (int, bool) {
+	i := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], key) >= 0
+	})
+	return i, i < len(keys) && bytes.Equal(keys[i], key)
+}
+
+// getIndexHeader returns the header of the prefix index, converting legacy indexes if necessary.
+// Returns nil if the index doesn't exist.
+func (service *DBService) getIndexHeader(prefix []byte) (*indexHeader, error) {
+	value, err := service.db.Get(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	if isLegacyIndex(value) {
+		return service.convertLegacyIndex(prefix, value)
+	}
+	header := &indexHeader{}
+	if err := header.decode(value); err != nil {
+		return nil, fmt.Errorf("cannot decode index %v: %w", string(prefix), err)
+	}
+	return header, nil
+}
+
+// convertLegacyIndex converts a gob-encoded index into the sharded format.
+func (service *DBService) convertLegacyIndex(prefix, value []byte) (*indexHeader, error) {
+	indexKeys, err := decodeLegacyIndex(value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode legacy index %v: %w", string(prefix), err)
+	}
+	header := &indexHeader{shards: 1}
+	for uint64(len(indexKeys)) > header.shards*uint64(maxIndexShardSize)/2 {
+		header.shards *= 2
+	}
+	if err := service.writeShards(prefix, header, indexKeys); err != nil {
+		return nil, fmt.Errorf("cannot convert legacy index %v: %w", string(prefix), err)
+	}
+	return header, nil
+}
+
+// writeShards distributes keys into shards and saves the index.
+// The header is saved last, so that the index stays consistent if writing a shard fails.
+func (service *DBService) writeShards(prefix []byte, header *indexHeader, keys [][]byte) error {
+	shards := make([][][]byte, header.shards)
+	for _, key := range keys {
+		shard := shardForKey(key, header.shards)
+		i, exists := findKey(shards[shard], key)
+		if exists {
+			continue
+		}
+		shards[shard] = append(shards[shard], nil)
+		copy(shards[shard][i+1:], shards[shard][i:])
+		shards[shard][i] = key
+	}
+	for i, shard := range shards {
+		if err := service.db.Put(createShardKey(prefix, header.shards, uint64(i)), encodeShard(shard)); err != nil {
+			return err
+		}
+	}
+	return service.db.Put(prefix, header.encode())
+}
+
+// getShard returns the contents of a shard from the prefix index.
+func (service *DBService) getShard(prefix []byte, header *indexHeader, shard uint64) ([][]byte, error) {
+	value, err := service.db.Get(createShardKey(prefix, header.shards, shard))
+	if err != nil {
+		return nil, err
+	}
+	return decodeShard(value)
+}
+
+// reshard doubles the number of shards in the prefix index, or more if needed to fit all of its keys.
+func (service *DBService) reshard(prefix []byte, header *indexHeader) error {
+	indexKeys, err := service.getReferencedKeys(prefix)
+	if err != nil {
+		return err
+	}
+	newHeader := &indexHeader{shards: header.shards * 2}
+	for uint64(len(indexKeys)) > newHeader.shards*uint64(maxIndexShardSize)/2 {
+		newHeader.shards *= 2
+	}
+	if err := service.writeShards(prefix, newHeader, indexKeys); err != nil {
+		return err
+	}
+	for i := uint64(0); i < header.shards; i++ {
+		if err := service.db.Delete(createShardKey(prefix, header.shards, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // getReferencedKeys will return a list of keys referenced by an index key.
 func (service *DBService) getReferencedKeys(prefix []byte) ([][]byte, error) {
-	index, err := service.db.Get(prefix)
+	value, err := service.db.Get(prefix)
 	if err != nil {
 		return nil, err
 	}
-	if len(index) == 0 {
+	if len(value) == 0 {
 		return [][]byte{}, nil
 	}
+	if isLegacyIndex(value) {
+		return decodeLegacyIndex(value)
+	}
 
+	header := &indexHeader{}
+	if err := header.decode(value); err != nil {
+		return nil, fmt.Errorf("cannot decode index %v: %w", string(prefix), err)
+	}
 	indexKeys := make([][]byte, 0)
-	if err := gob.NewDecoder(bytes.NewBuffer(index)).Decode(&indexKeys); err != nil {
-		return nil, err
+	for i := uint64(0); i < header.shards; i++ {
+		shardKeys, err := service.getShard(prefix, header, i)
+		if err != nil {
+			return nil, err
+		}
+		indexKeys = append(indexKeys, shardKeys...)
 	}
 	return indexKeys, nil
 }
 
 // addReferencedKey will add key to the prefix index.
-// If lessFn is provided, this function will be used to sort values.
 func (service *DBService) addReferencedKey(prefix, key []byte) error {
-	index, err := service.db.Get(prefix)
+	header, err := service.getIndexHeader(prefix)
 	if err != nil {
 		return err
 	}
+	if header == nil {
+		return service.writeShards(prefix, &indexHeader{shards: 1}, [][]byte{key})
+	}
 
-	indexKeys := [][]byte{}
-	if len(index) > 0 {
-		if err := gob.NewDecoder(bytes.NewBuffer(index)).Decode(&indexKeys); err != nil {
-			return err
-		}
+	shard := shardForKey(key, header.shards)
+	shardKeys, err := service.getShard(prefix, header, shard)
+	if err != nil {
+		return err
 	}
 
 	// Check if key already exists in index.
-	for i := range indexKeys {
-		if bytes.Equal(key, indexKeys[i]) {
-			return nil
-		}
+	i, exists := findKey(shardKeys, key)
+	if exists {
+		return nil
 	}
-	indexKeys = append(indexKeys, key)
+	shardKeys = append(shardKeys, nil)
+	copy(shardKeys[i+1:], shardKeys[i:])
+	shardKeys[i] = key
 
-	var updatedValue bytes.Buffer
-	if err := gob.NewEncoder(&updatedValue).Encode(indexKeys); err != nil {
+	if err := service.db.Put(createShardKey(prefix, header.shards, shard), encodeShard(shardKeys)); err != nil {
 		return err
 	}
-	return service.db.Put(prefix, updatedValue.Bytes())
+	if len(shardKeys) > maxIndexShardSize {
+		return service.reshard(prefix, header)
+	}
+	return nil
 }
 
 // deleteReferencedKey will remove key from the prefix index.
 func (service *DBService) deleteReferencedKey(prefix, key []byte) error {
-	index, err := service.db.Get(prefix)
+	header, err := service.getIndexHeader(prefix)
+	if err != nil || header == nil {
+		return err
+	}
+
+	shard := shardForKey(key, header.shards)
+	shardKeys, err := service.getShard(prefix, header, shard)
 	if err != nil {
 		return err
 	}
 
-	indexKeys := [][]byte{}
-	if len(index) > 0 {
-		if err := gob.NewDecoder(bytes.NewBuffer(index)).Decode(&indexKeys); err != nil {
-			return err
+	i, exists := findKey(shardKeys, key)
+	if !exists {
+		return nil
+	}
+	shardKeys = append(shardKeys[:i], shardKeys[i+1:]...)
+
+	return service.db.Put(createShardKey(prefix, header.shards, shard), encodeShard(shardKeys))
+}
+
+// updateReferencedKeys adds keys to (or removes keys from) the prefix index.
+// Every affected shard is read and rewritten only once, no matter how many keys it contains.
+// Returns the keys which were added or removed.
+func (service *DBService) updateReferencedKeys(prefix []byte, keys [][]byte, add bool) ([][]byte, error) {
+	header, err := service.getIndexHeader(prefix)
+	if err != nil {
+		return nil, err
+	}
+	createIndex := header == nil
+	if createIndex {
+		if !add {
+			return nil, nil
 		}
+		header = &indexHeader{shards: 1}
 	}
 
-	updatedIndex := make([][]byte, 0, len(index))
-	for i := range indexKeys {
-		indexKey := indexKeys[i]
-		if bytes.Equal(key, indexKey) {
+	shards := make(map[uint64][][]byte)
+	changedShards := make(map[uint64]bool)
+	changed := make([][]byte, 0)
+	for _, key := range keys {
+		shard := shardForKey(key, header.shards)
+		shardKeys, ok := shards[shard]
+		if !ok && !createIndex {
+			if shardKeys, err = service.getShard(prefix, header, shard); err != nil {
+				return nil, err
+			}
+		}
+
+		i, exists := findKey(shardKeys, key)
+		if exists == add {
+			shards[shard] = shardKeys
 			continue
 		}
-		updatedIndex = append(updatedIndex, indexKey)
+		if add {
+			shardKeys = append(shardKeys, nil)
+			copy(shardKeys[i+1:], shardKeys[i:])
+			shardKeys[i] = key
+		} else {
+			shardKeys = append(shardKeys[:i], shardKeys[i+1:]...)
+		}
+		shards[shard] = shardKeys
+		changedShards[shard] = true
+		changed = append(changed, key)
+	}
+	if len(changed) == 0 {
+		return nil, nil
 	}
 
-	var updatedValue bytes.Buffer
-	if err := gob.NewEncoder(&updatedValue).Encode(updatedIndex); err != nil {
+	oversized := false
+	for shard := range changedShards {
+		if err := service.db.Put(createShardKey(prefix, header.shards, shard), encodeShard(shards[shard])); err != nil {
+			return nil, err
+		}
+		oversized = oversized || len(shards[shard]) > maxIndexShardSize
+	}
+	if createIndex {
+		if err := service.db.Put(prefix, header.encode()); err != nil {
+			return nil, err
+		}
+	}
+	if oversized {
+		return changed, service.reshard(prefix, header)
+	}
+	return changed, nil
+}
+
+// deleteIndex deletes the prefix index and all of its shards.
+func (service *DBService) deleteIndex(prefix []byte) error {
+	value, err := service.db.Get(prefix)
+	if err != nil || len(value) == 0 {
 		return err
 	}
-	return service.db.Put(prefix, updatedValue.Bytes())
+	if !isLegacyIndex(value) {
+		header := &indexHeader{}
+		if err := header.decode(value); err != nil {
+			return fmt.Errorf("cannot decode index %v: %w", string(prefix), err)
+		}
+		for i := uint64(0); i < header.shards; i++ {
+			if err := service.db.Delete(createShardKey(prefix, header.shards, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return service.db.Delete(prefix)
+}
+
+// hasReferencedKey returns true if key exists in the prefix index.
+func (service *DBService) hasReferencedKey(prefix, key []byte) (bool, error) {
+	value, err := service.db.Get(prefix)
+	if err != nil || len(value) == 0 {
+		return false, err
+	}
+	if isLegacyIndex(value) {
+		indexKeys, err := decodeLegacyIndex(value)
+		if err != nil {
+			return false, err
+		}
+		for _, indexKey := range indexKeys {
+			if bytes.Equal(indexKey, key) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	header := &indexHeader{}
+	if err := header.decode(value); err != nil {
+		return false, fmt.Errorf("cannot decode index %v: %w", string(prefix), err)
+	}
+	shardKeys, err := service.getShard(prefix, header, shardForKey(key, header.shards))
+	if err != nil {
+		return false, err
+	}
+	_, exists := findKey(shardKeys, key)
+	return exists, nil
 }
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddDeleteReferencedKeys(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	prefix := []byte("testindex")
+
+	indexKeys, err := dbService.getReferencedKeys(prefix)
+	assert.NoError(t, err)
+	assert.Empty(t, indexKeys)
+
+	err = dbService.addReferencedKey(prefix, []byte("k1"))
+	assert.NoError(t, err)
+	err = dbService.addReferencedKey(prefix, []byte("k2"))
+	assert.NoError(t, err)
+	err = dbService.addReferencedKey(prefix, []byte("k1"))
+	assert.NoError(t, err)
+
+	indexKeys, err = dbService.getReferencedKeys(prefix)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{[]byte("k1"), []byte("k2")}, indexKeys)
+
+	err = dbService.deleteReferencedKey(prefix, []byte("k1"))
+	assert.NoError(t, err)
+	err = dbService.deleteReferencedKey(prefix, []byte("k3"))
+	assert.NoError(t, err)
+	err = dbService.deleteReferencedKey([]byte("missingindex"), []byte("k1"))
+	assert.NoError(t, err)
+
+	indexKeys, err = dbService.getReferencedKeys(prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("k2")}, indexKeys)
+}
+
+func TestReshardIndex(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	defaultMaxIndexShardSize := maxIndexShardSize
+	defer func() { maxIndexShardSize = defaultMaxIndexShardSize }()
+	maxIndexShardSize = 4
+
+	prefix := []byte("testindex")
+	expectedKeys := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("k%v", i))
+		expectedKeys = append(expectedKeys, key)
+		err := dbService.addReferencedKey(prefix, key)
+		assert.NoError(t, err)
+	}
+
+	header, err := dbService.getIndexHeader(prefix)
+	assert.NoError(t, err)
+	assert.Greater(t, header.shards, uint64(1))
+
+	indexKeys, err := dbService.getReferencedKeys(prefix)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expectedKeys, indexKeys)
+
+	// Shards from before resharding should be deleted.
+	exists, err := dbService.db.Has(createShardKey(prefix, 1, 0))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	for i := 0; i < 100; i += 2 {
+		err := dbService.deleteReferencedKey(prefix, []byte(fmt.Sprintf("k%v", i)))
+		assert.NoError(t, err)
+	}
+	indexKeys, err = dbService.getReferencedKeys(prefix)
+	assert.NoError(t, err)
+	assert.Len(t, indexKeys, 50)
+	for i := 1; i < 100; i += 2 {
+		assert.Contains(t, indexKeys, []byte(fmt.Sprintf("k%v", i)))
+	}
+}
+
+func TestUpdateReferencedKeys(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	defaultMaxIndexShardSize := maxIndexShardSize
+	defer func() { maxIndexShardSize = defaultMaxIndexShardSize }()
+	maxIndexShardSize = 4
+
+	prefix := []byte("testindex")
+
+	changed, err := dbService.updateReferencedKeys(prefix, [][]byte{[]byte("k1")}, false)
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+	exists, err := dbService.db.Has(prefix)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	expectedKeys := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		expectedKeys = append(expectedKeys, []byte(fmt.Sprintf("k%v", i)))
+	}
+	changed, err = dbService.updateReferencedKeys(prefix, append(expectedKeys, []byte("k1")), true)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedKeys, changed)
+
+	header, err := dbService.getIndexHeader(prefix)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, header.shards, uint64(50))
+
+	indexKeys, err := dbService.getReferencedKeys(prefix)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expectedKeys, indexKeys)
+
+	changed, err = dbService.updateReferencedKeys(prefix, [][]byte{[]byte("k1"), []byte("k2"), []byte("k100")}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("k100")}, changed)
+
+	deleteKeys := make([][]byte, 0, 50)
+	for i := 0; i <= 100; i += 2 {
+		deleteKeys = append(deleteKeys, []byte(fmt.Sprintf("k%v", i)))
+	}
+	changed, err = dbService.updateReferencedKeys(prefix, append(deleteKeys, []byte("k101")), false)
+	assert.NoError(t, err)
+	assert.Equal(t, deleteKeys, changed)
+
+	indexKeys, err = dbService.getReferencedKeys(prefix)
+	assert.NoError(t, err)
+	assert.Len(t, indexKeys, 50)
+	for i := 1; i < 100; i += 2 {
+		assert.Contains(t, indexKeys, []byte(fmt.Sprintf("k%v", i)))
+	}
+}
+
+func TestConvertLegacyIndex(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	prefix := []byte(userKeyPrefix)
+	var legacyValue bytes.Buffer
+	err = gob.NewEncoder(&legacyValue).Encode([][]byte{[]byte("user01"), []byte("user02")})
+	assert.NoError(t, err)
+	err = dbService.db.Put(prefix, legacyValue.Bytes())
+	assert.NoError(t, err)
+
+	usernames, err := dbService.GetUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user01", "user02"}, usernames)
+
+	// Reading the index should not modify it.
+	value, err := dbService.db.Get(prefix)
+	assert.NoError(t, err)
+	assert.Equal(t, legacyValue.Bytes(), value)
+
+	err = dbService.addReferencedKey(prefix, []byte("user03"))
+	assert.NoError(t, err)
+
+	value, err = dbService.db.Get(prefix)
+	assert.NoError(t, err)
+	assert.False(t, isLegacyIndex(value))
+
+	usernames, err = dbService.GetUsers()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"user01", "user02", "user03"}, usernames)
+}
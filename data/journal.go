@@ -0,0 +1,177 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// journalKey is the key containing the number of journal chunks from a transaction which is being committed.
+// Changes are stored in chunks (journal#0, journal#1...), so that large transactions don't exceed the maximum value size.
+// If the journal exists when the database is opened, the transaction is applied again.
+const journalKey = "journal"
+
+// journalChunkSize is the approximate maximum size of a journal chunk, in bytes.
+var journalChunkSize = 16 * 1024 * 1024
+
+// createJournalChunkKey creates a key for journal chunk i.
+func createJournalChunkKey(i int) []byte {
+	return []byte(journalKey + indexShardSeparator + strconv.Itoa(i))
+}
+
+// journalEntry is a single change in a transaction.
+type journalEntry struct {
+	Key     []byte
+	Value   []byte
+	Deleted bool
+}
+
+// journalEntries returns all changes from overlay as a list of journal entries.
+func (overlay *overlayBackend) journalEntries() []journalEntry {
+	entries := make([]journalEntry, 0, len(overlay.changes))
+	for key, value := range overlay.changes {
+		entries = append(entries, journalEntry{Key: []byte(key), Value: value, Deleted: value == nil})
+	}
+	// Apply changes in a predictable order.
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+	})
+	return entries
+}
+
+// applyJournalEntries writes all journal entries into db.
+func applyJournalEntries(db Backend, entries []journalEntry) error {
+	for _, entry := range entries {
+		var err error
+		if entry.Deleted {
+			err = db.Delete(entry.Key)
+		} else {
+			err = db.Put(entry.Key, entry.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot apply change to key %v: %w", string(entry.Key), err)
+		}
+	}
+	return nil
+}
+
+// commit applies all changes from batch to the database.
+// Changes are first saved to the journal, so that they can be applied again if commit is interrupted.
+func (service *DBService) commit(batch *overlayBackend) error {
+	if service.journalPending {
+		// A previous commit failed, finish it before applying new changes.
+		if err := service.replayJournal(); err != nil {
+			return err
+		}
+	}
+
+	entries := batch.journalEntries()
+	if len(entries) == 0 {
+		return nil
+	}
+	if len(entries) == 1 {
+		// A single change is already atomic.
+		return applyJournalEntries(service.db, entries)
+	}
+
+	chunks, err := service.saveJournalChunks(entries)
+	if err != nil {
+		return err
+	}
+	// The journal is only valid once all chunks are saved.
+	if err := service.db.Put([]byte(journalKey), []byte(strconv.Itoa(chunks))); err != nil {
+		return fmt.Errorf("cannot save journal: %w", err)
+	}
+	if err := applyJournalEntries(service.db, entries); err != nil {
+		service.journalPending = true
+		return err
+	}
+	if err := service.deleteJournal(chunks); err != nil {
+		service.journalPending = true
+		return err
+	}
+	return nil
+}
+
+// saveJournalChunks splits entries into chunks of up to journalChunkSize bytes and saves them into the database.
+// Returns the number of saved chunks.
+func (service *DBService) saveJournalChunks(entries []journalEntry) (int, error) {
+	chunks := 0
+	saveChunk := func(chunk []journalEntry) error {
+		var value bytes.Buffer
+		if err := gob.NewEncoder(&value).Encode(chunk); err != nil {
+			return fmt.Errorf("cannot encode journal chunk: %w", err)
+		}
+		if err := service.db.Put(createJournalChunkKey(chunks), value.Bytes()); err != nil {
+			return fmt.Errorf("cannot save journal chunk: %w", err)
+		}
+		chunks++
+		return nil
+	}
+
+	start, size := 0, 0
+	for i := range entries {
+		entrySize := len(entries[i].Key) + len(entries[i].Value)
+		if i > start && size+entrySize > journalChunkSize {
+			if err := saveChunk(entries[start:i]); err != nil {
+				return 0, err
+			}
+			start, size = i, 0
+		}
+		size += entrySize
+	}
+	if err := saveChunk(entries[start:]); err != nil {
+		return 0, err
+	}
+	return chunks, nil
+}
+
+// deleteJournal deletes the journal and its chunks.
+func (service *DBService) deleteJournal(chunks int) error {
+	if err := service.db.Delete([]byte(journalKey)); err != nil {
+		return fmt.Errorf("cannot delete journal: %w", err)
+	}
+	for i := 0; i < chunks; i++ {
+		if err := service.db.Delete(createJournalChunkKey(i)); err != nil {
+			return fmt.Errorf("cannot delete journal chunk: %w", err)
+		}
+	}
+	return nil
+}
+
+// replayJournal applies changes from an interrupted commit.
+func (service *DBService) replayJournal() error {
+	value, err := service.db.Get([]byte(journalKey))
+	if err != nil {
+		return fmt.Errorf("cannot read journal: %w", err)
+	}
+	if value != nil {
+		log.Warn("Applying changes from an interrupted transaction")
+		chunks, err := strconv.Atoi(string(value))
+		if err != nil {
+			return fmt.Errorf("cannot decode journal: %w", err)
+		}
+		for i := 0; i < chunks; i++ {
+			value, err := service.db.Get(createJournalChunkKey(i))
+			if err != nil {
+				return fmt.Errorf("cannot read journal chunk: %w", err)
+			}
+			entries := make([]journalEntry, 0)
+			if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&entries); err != nil {
+				return fmt.Errorf("cannot decode journal chunk: %w", err)
+			}
+			if err := applyJournalEntries(service.db, entries); err != nil {
+				return err
+			}
+		}
+		if err := service.deleteJournal(chunks); err != nil {
+			return err
+		}
+	}
+	service.journalPending = false
+	return nil
+}
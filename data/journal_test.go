@@ -0,0 +1,279 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingBackend returns an error when putting failKey.
+type failingBackend struct {
+	Backend
+	failKey string
+}
+
+func (backend *failingBackend) Put(key, value []byte) error {
+	if string(key) == backend.failKey {
+		return fmt.Errorf("put failed")
+	}
+	return backend.Backend.Put(key, value)
+}
+
+func TestUpdateRollback(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	txnErr := fmt.Errorf("txn failed")
+	err = dbService.update(func() error {
+		if err := dbService.db.Put([]byte("k1"), []byte("v1")); err != nil {
+			return err
+		}
+		if err := dbService.addReferencedKey([]byte("testindex"), []byte("k1")); err != nil {
+			return err
+		}
+		return txnErr
+	})
+	assert.Equal(t, txnErr, err)
+
+	value, err := dbService.db.Get([]byte("k1"))
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	indexKeys, err := dbService.getReferencedKeys([]byte("testindex"))
+	assert.NoError(t, err)
+	assert.Empty(t, indexKeys)
+}
+
+func TestUpdateCommit(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	err = dbService.db.Put([]byte("k0"), []byte("v0"))
+	assert.NoError(t, err)
+
+	err = dbService.update(func() error {
+		if err := dbService.db.Put([]byte("k1"), []byte("v1")); err != nil {
+			return err
+		}
+		if err := dbService.db.Put([]byte("k2"), []byte("v2")); err != nil {
+			return err
+		}
+		return dbService.db.Delete([]byte("k0"))
+	})
+	assert.NoError(t, err)
+
+	items := map[string]string{}
+	err = dbService.db.ForEach(func(key, value []byte) error {
+		items[string(key)] = string(value)
+		return nil
+	})
+	assert.NoError(t, err)
+	delete(items, schemaVersionKey)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2"}, items)
+}
+
+func TestReplayJournalOnOpen(t *testing.T) {
+	options := Options{Dir: t.TempDir()}
+
+	service, err := Open(options)
+	assert.NoError(t, err)
+
+	// Simulate a commit which was interrupted after saving the journal.
+	chunks := [][]journalEntry{
+		{{Key: []byte("k1"), Value: []byte("v1")}},
+		{{Key: []byte("k2"), Deleted: true}},
+	}
+	for i, entries := range chunks {
+		var chunk bytes.Buffer
+		err = gob.NewEncoder(&chunk).Encode(entries)
+		assert.NoError(t, err)
+		err = service.db.Put(createJournalChunkKey(i), chunk.Bytes())
+		assert.NoError(t, err)
+	}
+	err = service.db.Put([]byte("k2"), []byte("v2"))
+	assert.NoError(t, err)
+	err = service.db.Put([]byte(journalKey), []byte("2"))
+	assert.NoError(t, err)
+	service.Close()
+
+	service, err = Open(options)
+	assert.NoError(t, err)
+	defer service.Close()
+
+	value, err := service.db.Get([]byte("k1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value)
+	exists, err := service.db.Has([]byte("k2"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	for _, key := range [][]byte{[]byte(journalKey), createJournalChunkKey(0), createJournalChunkKey(1)} {
+		exists, err = service.db.Has(key)
+		assert.NoError(t, err)
+		assert.False(t, exists, string(key))
+	}
+}
+
+func TestIgnoreIncompleteJournalOnOpen(t *testing.T) {
+	options := Options{Dir: t.TempDir()}
+
+	service, err := Open(options)
+	assert.NoError(t, err)
+
+	// Simulate a commit which was interrupted before all journal chunks were saved.
+	var chunk bytes.Buffer
+	err = gob.NewEncoder(&chunk).Encode([]journalEntry{{Key: []byte("k1"), Value: []byte("v1")}})
+	assert.NoError(t, err)
+	err = service.db.Put(createJournalChunkKey(0), chunk.Bytes())
+	assert.NoError(t, err)
+	service.Close()
+
+	service, err = Open(options)
+	assert.NoError(t, err)
+	defer service.Close()
+
+	exists, err := service.db.Has([]byte("k1"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCommitJournalChunks(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	defaultJournalChunkSize := journalChunkSize
+	journalChunkSize = 8
+	defer func() { journalChunkSize = defaultJournalChunkSize }()
+
+	backend := &failingBackend{Backend: dbService.db, failKey: "k3"}
+	dbService.db = backend
+	defer func() { dbService.db = backend.Backend }()
+
+	err = dbService.update(func() error {
+		for _, key := range []string{"k1", "k2", "k3"} {
+			if err := dbService.db.Put([]byte(key), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.Error(t, err)
+
+	// Each change should be saved into a separate chunk.
+	value, err := dbService.db.Get([]byte(journalKey))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("3"), value)
+	for i := 0; i < 3; i++ {
+		exists, err := dbService.db.Has(createJournalChunkKey(i))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	backend.failKey = ""
+	err = dbService.replayJournal()
+	assert.NoError(t, err)
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		value, err := dbService.db.Get([]byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), value, key)
+	}
+	for _, key := range [][]byte{[]byte(journalKey), createJournalChunkKey(0), createJournalChunkKey(1), createJournalChunkKey(2)} {
+		exists, err := dbService.db.Has(key)
+		assert.NoError(t, err)
+		assert.False(t, exists, string(key))
+	}
+}
+
+func TestCommitFailure(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	backend := &failingBackend{Backend: dbService.db, failKey: "k2"}
+	dbService.db = backend
+	defer func() { dbService.db = backend.Backend }()
+
+	err = dbService.update(func() error {
+		if err := dbService.db.Put([]byte("k1"), []byte("v1")); err != nil {
+			return err
+		}
+		return dbService.db.Put([]byte("k2"), []byte("v2"))
+	})
+	assert.Error(t, err)
+	assert.True(t, dbService.journalPending)
+
+	exists, err := dbService.db.Has([]byte(journalKey))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// The next transaction should finish applying the journal first.
+	backend.failKey = ""
+	err = dbService.update(func() error {
+		return dbService.db.Put([]byte("k3"), []byte("v3"))
+	})
+	assert.NoError(t, err)
+	assert.False(t, dbService.journalPending)
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		exists, err := dbService.db.Has([]byte(key))
+		assert.NoError(t, err)
+		assert.True(t, exists, key)
+	}
+	exists, err = dbService.db.Has([]byte(journalKey))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestConcurrentSetReadStatus(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	expectedKeys := make([][]byte, 0, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		key := (&FeeditemKey{FeedURL: "http://site1", GUID: fmt.Sprintf("g%v", i)}).CreateKey()
+		expectedKeys = append(expectedKeys, key)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := dbService.SetReadStatus(user, key, true)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	readItems, err := dbService.GetReadItems(user)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, expectedKeys, readItems)
+}
+
+func TestConcurrentGC(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		varName := fmt.Sprintf("k%v", i)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			err := dbService.SetConfigVariable(varName, "v")
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			dbService.GC()
+		}()
+	}
+	wg.Wait()
+
+	config, err := dbService.GetAllConfigVariables()
+	assert.NoError(t, err)
+	assert.Len(t, config, 10)
+}
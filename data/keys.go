@@ -98,14 +98,14 @@ const feedContentsSuffix = separator + "contents"
 
 // CreateKey creates a key for a UserFeed entry.
 func (feed *UserFeed) CreateKey() []byte {
-	keyURL := encodePart(feed.URL)
+	keyURL := encodePart(feed.ItemsURL())
 	return []byte(feedKeyPrefix + separator + keyURL)
 }
 
 // createItemsIndexKey creates an index key for a Feeditem entries in a Feed.
 // This should generate the same key as FeeditemKey.createIndexKey.
 func (feed *UserFeed) createItemsIndexKey() []byte {
-	keyURL := encodePart(feed.URL)
+	keyURL := encodePart(feed.ItemsURL())
 	return []byte(feedKeyPrefix + separator + keyURL + separator)
 }
 
@@ -160,6 +160,83 @@ func (user *User) createReadStatusPrefix() []byte {
 	return []byte(readStatusPrefix + separator + encodePart(user.username))
 }
 
+// readHistoryPrefix is the key prefix for a user's read history.
+const readHistoryPrefix = "readhistory"
+
+// createReadHistoryIndexKey creates an index key for days which have read events from user.
+func (user *User) createReadHistoryIndexKey() []byte {
+	return []byte(readHistoryPrefix + separator + encodePart(user.username))
+}
+
+// createReadHistoryKey creates a key for user's read events on day.
+func (user *User) createReadHistoryKey(day string) []byte {
+	return []byte(readHistoryPrefix + separator + encodePart(user.username) + separator + day)
+}
+
+// bulkReadPrefix is the key prefix for the last bulk read operation of a user.
+const bulkReadPrefix = "bulkread"
+
+// createBulkReadKey creates a key for user's last bulk read operation.
+func (user *User) createBulkReadKey() []byte {
+	return []byte(bulkReadPrefix + separator + encodePart(user.username))
+}
+
+// starredPrefix is the key prefix for starred items.
+const starredPrefix = "starred"
+
+// createStarredPrefix creates a starred items key prefix for user.
+func (user *User) createStarredPrefix() []byte {
+	return []byte(starredPrefix + separator + encodePart(user.username))
+}
+
+// tagsPrefix is the key prefix for the list of a user's tags.
+const tagsPrefix = "tags"
+
+// createTagsKey creates a tag list key for user.
+func (user *User) createTagsKey() []byte {
+	return []byte(tagsPrefix + separator + encodePart(user.username))
+}
+
+// taggedPrefix is the key prefix for tagged items.
+const taggedPrefix = "tagged"
+
+// createTaggedPrefix creates a tagged items key prefix for user and tag.
+func (user *User) createTaggedPrefix(tag string) []byte {
+	return []byte(taggedPrefix + separator + encodePart(user.username) + separator + encodePart(tag))
+}
+
+// notePrefix is the key prefix for item notes.
+const notePrefix = "note"
+
+// createNoteKey creates a key for user's note on item k.
+func (user *User) createNoteKey(k []byte) []byte {
+	return append([]byte(notePrefix+separator+encodePart(user.username)+separator), k...)
+}
+
+// notesPrefix is the key prefix for the index of items with notes.
+const notesPrefix = "notes"
+
+// createNotesIndexKey creates an index key for items which have notes from user.
+func (user *User) createNotesIndexKey() []byte {
+	return []byte(notesPrefix + separator + encodePart(user.username))
+}
+
+// searchTermPrefix is the key prefix for the search index of a term.
+const searchTermPrefix = "search"
+
+// createSearchTermKey creates a search index key for term.
+func createSearchTermKey(term string) []byte {
+	return []byte(searchTermPrefix + separator + encodePart(term))
+}
+
+// searchDocumentPrefix is the key prefix for indexed search documents.
+const searchDocumentPrefix = "searchdoc"
+
+// createSearchDocumentKey creates a key for the indexed search document of itemKey.
+func createSearchDocumentKey(itemKey []byte) []byte {
+	return append([]byte(searchDocumentPrefix+separator), itemKey...)
+}
+
 // serverConfigKeyPrefix is the key prefix for a ServerConfig item.
 const serverConfigKeyPrefix = "serverconfig"
 
@@ -0,0 +1,137 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// schemaVersionKey is the key containing the schema version of the database.
+const schemaVersionKey = "schemaversion"
+
+// migration updates the database from the previous schema version.
+type migration struct {
+	description string
+	migrate     func(s *DBService) error
+}
+
+// migrations is an ordered list of all migrations.
+// The schema version is the number of applied migrations; new migrations should only be added to the end of the list.
+var migrations = []migration{
+	{description: "Convert indexes into the sharded format", migrate: (*DBService).convertLegacyIndexes},
+	{description: "Build the search index", migrate: (*DBService).indexAllDocuments},
+	{description: "Convert OPML into structured subscriptions", migrate: (*DBService).convertOPMLSubscriptions},
+	{description: "Convert Pagemonitor XML into structured pages", migrate: (*DBService).convertPagemonitorPages},
+}
+
+// SchemaVersion returns the latest schema version supported by this version of nanoRSS.
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// getSchemaVersion returns the schema version of the database.
+func (s *DBService) getSchemaVersion() (int, error) {
+	value, err := s.db.Get([]byte(schemaVersionKey))
+	if err != nil {
+		return 0, fmt.Errorf("cannot read schema version: %w", err)
+	}
+	if value != nil {
+		version, err := strconv.Atoi(string(value))
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse schema version: %w", err)
+		}
+		return version, nil
+	}
+
+	// Databases created before schema versioning was added don't have a version.
+	empty := true
+	errNotEmpty := fmt.Errorf("database is not empty")
+	err = s.db.ForEach(func(key, value []byte) error {
+		empty = false
+		return errNotEmpty
+	})
+	if err != nil && err != errNotEmpty {
+		return 0, fmt.Errorf("cannot check if database is empty: %w", err)
+	}
+	if empty {
+		return SchemaVersion(), nil
+	}
+	return 0, nil
+}
+
+// setSchemaVersion saves the schema version of the database.
+func (s *DBService) setSchemaVersion(version int) error {
+	return s.db.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(version)))
+}
+
+// migrate applies all pending migrations.
+func (s *DBService) migrate() error {
+	version, err := s.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion() {
+		return fmt.Errorf("database schema version %v is newer than the latest supported version %v", version, SchemaVersion())
+	}
+	if version == SchemaVersion() {
+		exists, err := s.db.Has([]byte(schemaVersionKey))
+		if err != nil || exists {
+			return err
+		}
+		return s.setSchemaVersion(version)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		migration := migrations[i]
+		logger := log.WithField("version", i+1).WithField("migration", migration.description)
+		logger.Info("Applying migration")
+		// Each migration is applied atomically together with the updated schema version.
+		err := s.update(func() error {
+			if err := migration.migrate(s); err != nil {
+				return err
+			}
+			return s.setSchemaVersion(i + 1)
+		})
+		if err != nil {
+			logger.WithError(err).Error("Migration failed")
+			return fmt.Errorf("migration to schema version %v failed: %w", i+1, err)
+		}
+	}
+	log.WithField("version", SchemaVersion()).Info("Database schema is up to date")
+	return nil
+}
+
+// convertLegacyIndexes converts all indexes from a gob-encoded list into the sharded format.
+func (s *DBService) convertLegacyIndexes() error {
+	indexKeys := [][]byte{
+		[]byte(userKeyPrefix),
+		[]byte(lastSeenKeyPrefix),
+		[]byte(fetchStatusKeyPrefix),
+		[]byte(serverConfigKeyPrefix),
+	}
+	err := s.db.ForEach(func(key, value []byte) error {
+		if !isLegacyIndex(value) || bytes.Contains(key, []byte(indexShardSeparator)) {
+			return nil
+		}
+		keyString := string(key)
+		isReadStatusIndex := strings.HasPrefix(keyString, readStatusPrefix+separator)
+		isFeedIndex := strings.HasPrefix(keyString, feedKeyPrefix+separator) && strings.HasSuffix(keyString, separator)
+		if isReadStatusIndex || isFeedIndex {
+			indexKeys = append(indexKeys, append([]byte{}, key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, indexKey := range indexKeys {
+		if _, err := s.getIndexHeader(indexKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeLegacyIndex(t *testing.T, keys ...string) []byte {
+	indexKeys := make([][]byte, len(keys))
+	for i := range keys {
+		indexKeys[i] = []byte(keys[i])
+	}
+	var value bytes.Buffer
+	err := gob.NewEncoder(&value).Encode(indexKeys)
+	assert.NoError(t, err)
+	return value.Bytes()
+}
+
+func createLegacyDatabase(t *testing.T, backend Backend) {
+	user := &User{username: "user01"}
+	feedItemKey := &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}
+
+	var userValue bytes.Buffer
+	err := gob.NewEncoder(&userValue).Encode(user)
+	assert.NoError(t, err)
+	err = backend.Put(user.createKey(), userValue.Bytes())
+	assert.NoError(t, err)
+
+	err = backend.Put([]byte(userKeyPrefix), encodeLegacyIndex(t, "user01"))
+	assert.NoError(t, err)
+	err = backend.Put(user.createReadStatusPrefix(), encodeLegacyIndex(t, string(feedItemKey.CreateKey())))
+	assert.NoError(t, err)
+	err = backend.Put(feedItemKey.createIndexKey(), encodeLegacyIndex(t, feedItemKey.GUID))
+	assert.NoError(t, err)
+}
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+	service, err := Open(Options{InMemory: true})
+	assert.NoError(t, err)
+	defer service.Close()
+
+	value, err := service.db.Get([]byte(schemaVersionKey))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(fmt.Sprint(SchemaVersion())), value)
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	createLegacyDatabase(t, dbService.db)
+
+	err = dbService.migrate()
+	assert.NoError(t, err)
+
+	user := &User{username: "user01"}
+	feedItemKey := &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}
+	for _, indexKey := range [][]byte{[]byte(userKeyPrefix), user.createReadStatusPrefix(), feedItemKey.createIndexKey()} {
+		value, err := dbService.db.Get(indexKey)
+		assert.NoError(t, err)
+		assert.False(t, isLegacyIndex(value))
+	}
+
+	usernames, err := dbService.GetUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user01"}, usernames)
+
+	readItems, err := dbService.GetReadItems(user)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{feedItemKey.CreateKey()}, readItems)
+
+	version, err := dbService.getSchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion(), version)
+}
+
+func TestMigrateOrder(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	defaultMigrations := migrations
+	defer func() { migrations = defaultMigrations }()
+
+	applied := make([]string, 0)
+	migrations = []migration{
+		{description: "m1", migrate: func(s *DBService) error { applied = append(applied, "m1"); return nil }},
+		{description: "m2", migrate: func(s *DBService) error { applied = append(applied, "m2"); return nil }},
+		{description: "m3", migrate: func(s *DBService) error { return fmt.Errorf("failed") }},
+	}
+
+	err = dbService.setSchemaVersion(1)
+	assert.NoError(t, err)
+
+	err = dbService.migrate()
+	assert.Error(t, err)
+	assert.Equal(t, []string{"m2"}, applied)
+
+	version, err := dbService.getSchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, version)
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	options := Options{Dir: t.TempDir()}
+
+	backend, err := openBackend(options)
+	assert.NoError(t, err)
+	err = backend.Put([]byte(schemaVersionKey), []byte(fmt.Sprint(SchemaVersion()+1)))
+	assert.NoError(t, err)
+	err = backend.Close()
+	assert.NoError(t, err)
+
+	service, err := Open(options)
+	assert.Error(t, err)
+	assert.Nil(t, service)
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	options := Options{Dir: t.TempDir()}
+
+	backend, err := openBackend(options)
+	assert.NoError(t, err)
+	createLegacyDatabase(t, backend)
+	err = backend.Close()
+	assert.NoError(t, err)
+
+	options.DryRun = true
+	service, err := Open(options)
+	assert.NoError(t, err)
+	usernames, err := service.GetUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user01"}, usernames)
+	err = service.SaveUser(NewUser("user02"))
+	assert.NoError(t, err)
+	service.Close()
+
+	backend, err = openBackend(options)
+	assert.NoError(t, err)
+	defer backend.Close()
+	value, err := backend.Get([]byte(schemaVersionKey))
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	value, err = backend.Get([]byte(userKeyPrefix))
+	assert.NoError(t, err)
+	assert.Equal(t, encodeLegacyIndex(t, "user01"), value)
+}
@@ -0,0 +1,271 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Notes are private to each user; an item with notes is never deleted by GC.
+
+// Highlight is a quoted range of a feed item's contents.
+type Highlight struct {
+	// Start and End are byte offsets in Feeditem.Contents.
+	Start int
+	End   int
+	// Text is the highlighted text, copied from the item when the highlight is saved.
+	Text string
+}
+
+// ItemNote keeps a user's Markdown note and highlights for an item.
+type ItemNote struct {
+	Text       string
+	Highlights []Highlight `json:",omitempty"`
+	Updated    time.Time
+	Key        []byte `json:"-"`
+}
+
+// encode serializes an ItemNote.
+func (note *ItemNote) encode() ([]byte, error) {
+	key := note.Key
+	defer func() { note.Key = key }()
+	note.Key = nil
+
+	var value bytes.Buffer
+	if err := gob.NewEncoder(&value).Encode(note); err != nil {
+		return nil, err
+	}
+	return value.Bytes(), nil
+}
+
+// decode deserializes an ItemNote.
+func (note *ItemNote) decode(val []byte) error {
+	return gob.NewDecoder(bytes.NewBuffer(val)).Decode(note)
+}
+
+// isEmpty returns true if note has no text and no highlights.
+func (note *ItemNote) isEmpty() bool {
+	return note.Text == "" && len(note.Highlights) == 0
+}
+
+// GetNote returns user's note for item k.
+// If the item has no note, returns nil.
+func (s *DBService) GetNote(user *User, k itemKey) (*ItemNote, error) {
+	var note *ItemNote
+	err := s.view(func() error {
+		var err error
+		note, err = s.getNote(user, k)
+		return err
+	})
+	return note, err
+}
+
+// getNote returns user's note for item k, without acquiring a lock.
+func (s *DBService) getNote(user *User, k itemKey) (*ItemNote, error) {
+	value, err := s.db.Get(user.createNoteKey(k))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get note for item %v: %w", string(k), err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	note := &ItemNote{}
+	if err := note.decode(value); err != nil {
+		return nil, fmt.Errorf("cannot decode note for item %v: %w", string(k), err)
+	}
+	note.Key = k
+	return note, nil
+}
+
+// GetNotes returns all notes of user.
+func (s *DBService) GetNotes(user *User) ([]*ItemNote, error) {
+	var notes []*ItemNote
+	err := s.view(func() error {
+		var err error
+		notes, err = s.getNotes(user)
+		return err
+	})
+	return notes, err
+}
+
+// getNotes returns all notes of user, without acquiring a lock.
+func (s *DBService) getNotes(user *User) ([]*ItemNote, error) {
+	items, err := s.getReferencedKeys(user.createNotesIndexKey())
+	if err != nil {
+		log.WithField("username", user.username).WithError(err).Error("Failed to get notes index")
+		return nil, err
+	}
+
+	notes := make([]*ItemNote, 0, len(items))
+	for _, k := range items {
+		note, err := s.getNote(user, k)
+		if err != nil {
+			return nil, err
+		}
+		if note != nil {
+			notes = append(notes, note)
+		}
+	}
+	return notes, nil
+}
+
+// InvalidHighlightsError is returned when highlights don't match the item's contents.
+type InvalidHighlightsError struct {
+	Err error
+}
+
+// Error returns the reason why the highlights are invalid.
+func (e *InvalidHighlightsError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *InvalidHighlightsError) Unwrap() error {
+	return e.Err
+}
+
+// validateHighlights checks that highlights match the contents of item k, and sets their text.
+func (s *DBService) validateHighlights(k itemKey, highlights []Highlight) error {
+	if len(highlights) == 0 {
+		return nil
+	}
+	if !IsFeeditemKey(k) {
+		return &InvalidHighlightsError{Err: fmt.Errorf("highlights are only supported for feed items")}
+	}
+	feeditemKey, err := DecodeFeeditemKey(k)
+	if err != nil {
+		return err
+	}
+	feedItem, err := s.getFeeditem(feeditemKey)
+	if err != nil {
+		return err
+	}
+	if feedItem == nil {
+		return &InvalidHighlightsError{Err: fmt.Errorf("item %v doesn't exist", string(k))}
+	}
+
+	for i := range highlights {
+		highlight := &highlights[i]
+		if highlight.Start < 0 || highlight.Start >= highlight.End || highlight.End > len(feedItem.Contents) {
+			return &InvalidHighlightsError{Err: fmt.Errorf("highlight %v-%v is out of range", highlight.Start, highlight.End)}
+		}
+		highlight.Text = feedItem.Contents[highlight.Start:highlight.End]
+	}
+	return nil
+}
+
+// saveNote saves user's note for item k, without acquiring a lock.
+func (s *DBService) saveNote(user *User, k itemKey, note *ItemNote) error {
+	if note.isEmpty() {
+		return s.deleteNote(user, k)
+	}
+
+	value, err := note.encode()
+	if err != nil {
+		return fmt.Errorf("cannot marshal note: %w", err)
+	}
+	if err := s.db.Put(user.createNoteKey(k), value); err != nil {
+		return fmt.Errorf("cannot save note: %w", err)
+	}
+	return s.addReferencedKey(user.createNotesIndexKey(), k)
+}
+
+// SaveNote saves user's note for item k, replacing the previous note.
+// Highlights are checked against the item's contents; saving an empty note deletes it.
+func (s *DBService) SaveNote(user *User, k itemKey, note *ItemNote) error {
+	return s.update(func() error {
+		if err := s.validateHighlights(k, note.Highlights); err != nil {
+			return err
+		}
+		if note.Updated.IsZero() {
+			note.Updated = time.Now()
+		}
+		return s.saveNote(user, k, note)
+	})
+}
+
+// deleteNote deletes user's note for item k, without acquiring a lock.
+func (s *DBService) deleteNote(user *User, k itemKey) error {
+	if err := s.db.Delete(user.createNoteKey(k)); err != nil {
+		return fmt.Errorf("cannot delete note: %w", err)
+	}
+	return s.deleteReferencedKey(user.createNotesIndexKey(), k)
+}
+
+// DeleteNote deletes user's note for item k.
+func (s *DBService) DeleteNote(user *User, k itemKey) error {
+	return s.update(func() error {
+		return s.deleteNote(user, k)
+	})
+}
+
+// hasNotes returns true if any user has a note for item k.
+func (s *DBService) hasNotes(k itemKey) (bool, error) {
+	usernames, err := s.getUsers()
+	if err != nil {
+		return false, err
+	}
+	for _, username := range usernames {
+		user := User{username: username}
+		noted, err := s.hasReferencedKey(user.createNotesIndexKey(), k)
+		if err != nil || noted {
+			return noted, err
+		}
+	}
+	return false, nil
+}
+
+// renameNotes moves notes to the new username.
+func (s *DBService) renameNotes(user *User) error {
+	newUser := &User{username: user.newUsername}
+
+	notes, err := s.getNotes(user)
+	if err != nil {
+		return err
+	}
+
+	for _, note := range notes {
+		if err := s.saveNote(newUser, note.Key, note); err != nil {
+			log.WithField("key", note.Key).WithField("user", newUser.username).WithError(err).Error("Failed to save note for new username")
+			return err
+		}
+		if err := s.deleteNote(user, note.Key); err != nil {
+			log.WithField("key", note.Key).WithField("user", user.username).WithError(err).Error("Failed to delete note for old username")
+			return err
+		}
+	}
+	return nil
+}
+
+// getNotedFeeditems returns all feed items which have notes from user, without acquiring a lock.
+func (s *DBService) getNotedFeeditems(user *User) ([]*Feeditem, error) {
+	items, err := s.getReferencedKeys(user.createNotesIndexKey())
+	if err != nil {
+		return nil, err
+	}
+
+	feedItems := make([]*Feeditem, 0, len(items))
+	for _, k := range items {
+		if !IsFeeditemKey(k) {
+			continue
+		}
+		itemKey, err := DecodeFeeditemKey(k)
+		if err != nil {
+			log.WithField("key", string(k)).WithError(err).Error("Failed to decode noted item key")
+			continue
+		}
+		feedItem, err := s.getFeeditem(itemKey)
+		if err != nil {
+			log.WithField("key", string(k)).WithError(err).Error("Failed to get noted item")
+			continue
+		}
+		if feedItem != nil {
+			feedItems = append(feedItems, feedItem)
+		}
+	}
+	return feedItems, nil
+}
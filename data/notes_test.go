@@ -0,0 +1,248 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveGetNote(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	item := &Feeditem{
+		Title:    "t1",
+		URL:      "http://item1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "<p>Some interesting text</p>",
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+	key := item.Key.CreateKey()
+
+	dbNote, err := dbService.GetNote(&user, key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbNote)
+
+	note := &ItemNote{
+		Text:       "Read this *later*",
+		Highlights: []Highlight{{Start: 8, End: 19}},
+		Updated:    time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC),
+	}
+	err = dbService.SaveNote(&user, key, note)
+	assert.NoError(t, err)
+
+	expectedNote := &ItemNote{
+		Text:       "Read this *later*",
+		Highlights: []Highlight{{Start: 8, End: 19, Text: "interesting"}},
+		Updated:    time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC),
+		Key:        key,
+	}
+	dbNote, err = dbService.GetNote(&user, key)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedNote, dbNote)
+
+	notes, err := dbService.GetNotes(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ItemNote{expectedNote}, notes)
+
+	otherUser := User{username: "user02"}
+	dbNote, err = dbService.GetNote(&otherUser, key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbNote)
+
+	// Saving an empty note deletes it.
+	err = dbService.SaveNote(&user, key, &ItemNote{})
+	assert.NoError(t, err)
+
+	dbNote, err = dbService.GetNote(&user, key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbNote)
+	notes, err = dbService.GetNotes(&user)
+	assert.NoError(t, err)
+	assert.Empty(t, notes)
+}
+
+func TestSaveNoteInvalidHighlights(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	item := &Feeditem{
+		Title:    "t1",
+		Contents: "Some text",
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+
+	err = dbService.SaveNote(&user, item.Key.CreateKey(), &ItemNote{Highlights: []Highlight{{Start: 5, End: 10}}})
+	assert.EqualError(t, err, "highlight 5-10 is out of range")
+	var invalidHighlights *InvalidHighlightsError
+	assert.ErrorAs(t, err, &invalidHighlights)
+	err = dbService.SaveNote(&user, item.Key.CreateKey(), &ItemNote{Highlights: []Highlight{{Start: 4, End: 4}}})
+	assert.EqualError(t, err, "highlight 4-4 is out of range")
+
+	missingKey := &FeeditemKey{FeedURL: "http://feed1", GUID: "g2"}
+	err = dbService.SaveNote(&user, missingKey.CreateKey(), &ItemNote{Highlights: []Highlight{{Start: 0, End: 1}}})
+	assert.EqualError(t, err, "item "+string(missingKey.CreateKey())+" doesn't exist")
+
+	pageKey := &UserPagemonitor{URL: "http://site1"}
+	err = dbService.SaveNote(&user, pageKey.CreateKey(), &ItemNote{Highlights: []Highlight{{Start: 0, End: 1}}})
+	assert.EqualError(t, err, "highlights are only supported for feed items")
+
+	// Notes without highlights can be added to any item.
+	err = dbService.SaveNote(&user, pageKey.CreateKey(), &ItemNote{Text: "note"})
+	assert.NoError(t, err)
+
+	notes, err := dbService.GetNotes(&user)
+	assert.NoError(t, err)
+	assert.Len(t, notes, 1)
+	assert.Equal(t, "note", notes[0].Text)
+	assert.False(t, notes[0].Updated.IsZero())
+}
+
+func TestNotedItemTTLExpired(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+	var oldTTL = itemTTL
+	itemTTL = time.Nanosecond * 0
+	defer func() { itemTTL = oldTTL }()
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	notedItem := &Feeditem{
+		Title:    "t1",
+		URL:      "http://item1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c1",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	item := &Feeditem{
+		Title:    "t2",
+		URL:      "http://item2",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c2",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g2"},
+	}
+	feedKey := &UserFeed{URL: item.Key.FeedURL}
+	err = dbService.SaveFeeditems(notedItem, item)
+	assert.NoError(t, err)
+	err = dbService.SaveNote(user, notedItem.Key.CreateKey(), &ItemNote{Text: "keep"})
+	assert.NoError(t, err)
+
+	err = dbService.SetFetchStatus(feedKey.CreateKey(), &FetchStatus{LastSuccess: time.Time{}})
+	assert.NoError(t, err)
+
+	err = dbService.deleteStaleFetchStatuses()
+	assert.NoError(t, err)
+
+	dbItem, err := dbService.GetFeeditem(item.Key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbItem)
+
+	dbItem, err = dbService.GetFeeditem(notedItem.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, notedItem, dbItem)
+
+	err = dbService.DeleteNote(user, notedItem.Key.CreateKey())
+	assert.NoError(t, err)
+
+	err = dbService.deleteStaleFetchStatuses()
+	assert.NoError(t, err)
+
+	dbItem, err = dbService.GetFeeditem(notedItem.Key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbItem)
+}
+
+func TestRenameUserNotes(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	key := []byte("i1")
+	note := &ItemNote{Text: "note", Updated: time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC)}
+	err = dbService.SaveNote(user, key, note)
+	assert.NoError(t, err)
+
+	err = user.SetUsername("user02")
+	assert.NoError(t, err)
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	notes, err := dbService.GetNotes(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ItemNote{{Text: "note", Updated: note.Updated, Key: key}}, notes)
+
+	oldUser := User{username: "user01"}
+	notes, err = dbService.GetNotes(&oldUser)
+	assert.NoError(t, err)
+	assert.Empty(t, notes)
+	dbNote, err := dbService.GetNote(&oldUser, key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbNote)
+}
+
+func TestBackupNotes(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed2"}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item := &Feeditem{
+		Title:    "t1",
+		URL:      "http://item1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c1",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+	note := &ItemNote{
+		Text:       "note",
+		Highlights: []Highlight{{Start: 0, End: 1}},
+		Updated:    time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC),
+	}
+	err = dbService.SaveNote(user, item.Key.CreateKey(), note)
+	assert.NoError(t, err)
+
+	var backup bytes.Buffer
+	err = dbService.Backup(&backup, BackupOptions{})
+	assert.NoError(t, err)
+
+	err = resetDb()
+	assert.NoError(t, err)
+	_, err = dbService.Restore(&backup, RestoreOptions{})
+	assert.NoError(t, err)
+
+	dbItem, err := dbService.GetFeeditem(item.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, item, dbItem)
+
+	notes, err := dbService.GetNotes(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ItemNote{{
+		Text:       "note",
+		Highlights: []Highlight{{Start: 0, End: 1, Text: "c"}},
+		Updated:    note.Updated,
+		Key:        item.Key.CreateKey(),
+	}}, notes)
+}
@@ -0,0 +1,70 @@
+package data
+
+// overlayBackend keeps all changes in memory, without modifying the underlying Backend.
+type overlayBackend struct {
+	base Backend
+	// changes contains updated values; a nil value means that the key was deleted.
+	changes map[string][]byte
+}
+
+// newOverlayBackend creates an overlayBackend on top of base.
+func newOverlayBackend(base Backend) *overlayBackend {
+	return &overlayBackend{base: base, changes: make(map[string][]byte)}
+}
+
+func (overlay *overlayBackend) Get(key []byte) ([]byte, error) {
+	if value, ok := overlay.changes[string(key)]; ok {
+		if value == nil {
+			return nil, nil
+		}
+		return append([]byte{}, value...), nil
+	}
+	return overlay.base.Get(key)
+}
+
+func (overlay *overlayBackend) Put(key, value []byte) error {
+	overlay.changes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (overlay *overlayBackend) Delete(key []byte) error {
+	overlay.changes[string(key)] = nil
+	return nil
+}
+
+func (overlay *overlayBackend) Has(key []byte) (bool, error) {
+	if value, ok := overlay.changes[string(key)]; ok {
+		return value != nil, nil
+	}
+	return overlay.base.Has(key)
+}
+
+func (overlay *overlayBackend) ForEach(fn func(key, value []byte) error) error {
+	err := overlay.base.ForEach(func(key, value []byte) error {
+		if _, ok := overlay.changes[string(key)]; ok {
+			return nil
+		}
+		return fn(key, value)
+	})
+	if err != nil {
+		return err
+	}
+	for key, value := range overlay.changes {
+		if value == nil {
+			continue
+		}
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact does nothing, since all changes are kept in memory.
+func (overlay *overlayBackend) Compact() error {
+	return nil
+}
+
+func (overlay *overlayBackend) Close() error {
+	return overlay.base.Close()
+}
@@ -38,6 +38,17 @@ func (page *PagemonitorPage) decode(val []byte) error {
 // GetPage retrieves a PagemonitorPage for the UserPagemonitor configuration.
 // If page doesn't exist, returns nil.
 func (s *DBService) GetPage(pm *UserPagemonitor) (*PagemonitorPage, error) {
+	var page *PagemonitorPage
+	err := s.view(func() error {
+		var err error
+		page, err = s.getPage(pm)
+		return err
+	})
+	return page, err
+}
+
+// getPage retrieves a PagemonitorPage for the UserPagemonitor configuration, without acquiring a lock.
+func (s *DBService) getPage(pm *UserPagemonitor) (*PagemonitorPage, error) {
 	page := &PagemonitorPage{Config: pm}
 	value, err := s.db.Get(pm.CreateKey())
 
@@ -57,6 +68,13 @@ func (s *DBService) GetPage(pm *UserPagemonitor) (*PagemonitorPage, error) {
 
 // SavePage saves a PagemonitorPage.
 func (s *DBService) SavePage(page *PagemonitorPage) error {
+	return s.update(func() error {
+		return s.savePage(page)
+	})
+}
+
+// savePage saves a PagemonitorPage, without acquiring a lock.
+func (s *DBService) savePage(page *PagemonitorPage) error {
 	key := page.Config.CreateKey()
 
 	getPreviousPage := func(key []byte) (*PagemonitorPage, error) {
@@ -92,15 +110,27 @@ func (s *DBService) SavePage(page *PagemonitorPage) error {
 		return nil
 	}
 
+	if err := s.indexDocument(key, "", page.Contents); err != nil {
+		return fmt.Errorf("cannot add page to search index: %w", err)
+	}
+
 	return s.db.Put(key, value)
 }
 
 // GetPages returns all PagemonitorPage items ffor user.
 func (s *DBService) GetPages(user *User) ([]*PagemonitorPage, error) {
-	userPages, err := user.GetPages()
-	if err != nil {
-		return nil, err
-	}
+	var pages []*PagemonitorPage
+	err := s.view(func() error {
+		var err error
+		pages, err = s.getPages(user)
+		return err
+	})
+	return pages, err
+}
+
+// getPages returns all PagemonitorPage items for user, without acquiring a lock.
+func (s *DBService) getPages(user *User) ([]*PagemonitorPage, error) {
+	userPages := user.GetPages()
 
 	pages := make([]*PagemonitorPage, 0)
 	for i := range userPages {
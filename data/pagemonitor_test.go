@@ -65,10 +65,7 @@ func TestSavePage(t *testing.T) {
 	pages := []PagemonitorPage{page1, page2}
 
 	user := User{
-		Pagemonitor: `<pages>` +
-			`<page url="http://site1.com" match="m1" replace="r1">Page 1 (match/replace)</page>` +
-			`<page url="http://site1.com">Page 1</page>` +
-			`</pages>`,
+		Pages:    []UserPagemonitor{userPage1, userPage2},
 		username: "user01",
 	}
 	err = dbService.SaveUser(&user)
@@ -0,0 +1,261 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pagemonitorDocument is the toplevel element of the Pagemonitor XML configuration.
+type pagemonitorDocument struct {
+	XMLName xml.Name          `xml:"pages"`
+	Pages   []UserPagemonitor `xml:"page"`
+}
+
+// normalize trims whitespace from page's URL and title, and uses the URL as the title if no title is set.
+// Match and Replace are regular expressions and are kept as-is.
+func (pm *UserPagemonitor) normalize() {
+	pm.URL = strings.TrimSpace(pm.URL)
+	pm.Title = strings.TrimSpace(pm.Title)
+	if pm.Title == "" {
+		pm.Title = pm.URL
+	}
+}
+
+// Validate checks that pm has a valid URL, match regex and replacement.
+func (pm *UserPagemonitor) Validate() error {
+	if pm.URL == "" {
+		return fmt.Errorf("page URL cannot be empty")
+	}
+	pageURL, err := url.Parse(pm.URL)
+	if err != nil {
+		return fmt.Errorf("cannot parse page URL %v: %w", pm.URL, err)
+	}
+	if !pageURL.IsAbs() {
+		return fmt.Errorf("page URL %v is not absolute", pm.URL)
+	}
+	if pm.Match == "" {
+		if pm.Replace != "" {
+			return fmt.Errorf("replace cannot be used without match")
+		}
+		return nil
+	}
+	regex, err := regexp.Compile(pm.Match)
+	if err != nil {
+		return fmt.Errorf("cannot compile match regex: %w", err)
+	}
+	return validateReplacement(regex, pm.Replace)
+}
+
+// validateReplacement checks that all $name and ${name} references in replace refer to a capturing group in regex.
+// Regexp.ReplaceAllString silently replaces unknown references with an empty string.
+func validateReplacement(regex *regexp.Regexp, replace string) error {
+	groups := make(map[string]bool, regex.NumSubexp()+1)
+	for i, name := range regex.SubexpNames() {
+		groups[fmt.Sprint(i)] = true
+		if name != "" {
+			groups[name] = true
+		}
+	}
+	isNameChar := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+
+	for i := 0; i < len(replace); i++ {
+		if replace[i] != '$' {
+			continue
+		}
+		if i+1 < len(replace) && replace[i+1] == '$' {
+			i++
+			continue
+		}
+		braces := i+1 < len(replace) && replace[i+1] == '{'
+		start := i + 1
+		if braces {
+			start++
+		}
+		end := start
+		for end < len(replace) && isNameChar(replace[end]) {
+			end++
+		}
+		if end == start || braces && (end >= len(replace) || replace[end] != '}') {
+			// Malformed references are inserted as-is.
+			continue
+		}
+		if name := replace[start:end]; !groups[name] {
+			return fmt.Errorf("replace references unknown group %v", name)
+		}
+		i = end - 1
+		if braces {
+			i = end
+		}
+	}
+	return nil
+}
+
+// GetPages returns a copy of all of the user's monitored pages.
+func (user *User) GetPages() []UserPagemonitor {
+	return append([]UserPagemonitor{}, user.Pages...)
+}
+
+// findPage returns the index of the page with key, or -1 if the user is not monitoring such a page.
+func (user *User) findPage(key []byte) int {
+	for i := range user.Pages {
+		if bytes.Equal(user.Pages[i].CreateKey(), key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddPage adds pm to the monitored pages, unless a page with the same URL, match and replacement already exists.
+// Returns true if the pages were changed; the changes will be saved when SaveUser is called.
+func (user *User) AddPage(pm UserPagemonitor) (bool, error) {
+	pm.normalize()
+	if err := pm.Validate(); err != nil {
+		return false, err
+	}
+	if user.findPage(pm.CreateKey()) >= 0 {
+		return false, nil
+	}
+	user.Pages = append(user.Pages, pm)
+	return true, nil
+}
+
+// UpdatePage replaces the monitored page with key with pm.
+// The changes will be saved when SaveUser is called.
+func (user *User) UpdatePage(key []byte, pm UserPagemonitor) error {
+	index := user.findPage(key)
+	if index < 0 {
+		return fmt.Errorf("page %v doesn't exist", string(key))
+	}
+	pm.normalize()
+	if err := pm.Validate(); err != nil {
+		return err
+	}
+	if newKey := pm.CreateKey(); !bytes.Equal(newKey, key) && user.findPage(newKey) >= 0 {
+		return fmt.Errorf("page %v already exists", pm.URL)
+	}
+	user.Pages[index] = pm
+	return nil
+}
+
+// DeletePage removes the monitored page with key.
+// Returns false if the user is not monitoring such a page; the changes will be saved when SaveUser is called.
+func (user *User) DeletePage(key []byte) bool {
+	index := user.findPage(key)
+	if index < 0 {
+		return false
+	}
+	user.Pages = append(user.Pages[:index], user.Pages[index+1:]...)
+	return true
+}
+
+// ImportPagemonitorXML adds all pages from the Pagemonitor XML configuration to the user's pages, and returns the number of added pages.
+// If replace is true, all other pages are removed.
+// A successful import also clears the Pagemonitor XML which couldn't be converted from a previous version.
+// The changes will be saved when SaveUser is called.
+func (user *User) ImportPagemonitorXML(pagemonitor string, replace bool) (int, error) {
+	pages, err := parsePagemonitorXML(pagemonitor)
+	if err != nil {
+		return 0, err
+	}
+	for i := range pages {
+		pages[i].normalize()
+		if err := pages[i].Validate(); err != nil {
+			return 0, fmt.Errorf("invalid page %v: %w", pages[i].Title, err)
+		}
+	}
+
+	if replace {
+		user.Pages = nil
+	}
+	added := 0
+	for _, pm := range pages {
+		if index := user.findPage(pm.CreateKey()); index >= 0 {
+			user.Pages[index] = pm
+			continue
+		}
+		user.Pages = append(user.Pages, pm)
+		added++
+	}
+	user.Pagemonitor = ""
+	return added, nil
+}
+
+// ExportPagemonitorXML returns the user's pages in the Pagemonitor XML format.
+func (user *User) ExportPagemonitorXML() (string, error) {
+	document := &pagemonitorDocument{Pages: user.GetPages()}
+	pagemonitor, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal pagemonitor xml: %w", err)
+	}
+	return xml.Header + string(pagemonitor), nil
+}
+
+// parsePagemonitorXML returns all pages from the Pagemonitor XML configuration.
+func parsePagemonitorXML(pagemonitor string) ([]UserPagemonitor, error) {
+	document := &pagemonitorDocument{}
+	if err := xml.Unmarshal([]byte(pagemonitor), document); err != nil {
+		return nil, fmt.Errorf("cannot parse pagemonitor xml: %w", err)
+	}
+	if document.Pages == nil {
+		return []UserPagemonitor{}, nil
+	}
+	return document.Pages, nil
+}
+
+// convertPagemonitorPages converts the Pagemonitor XML configuration of all users into structured pages.
+// Pages are validated in the same way as ImportPagemonitorXML does.
+// Users with an invalid configuration are not converted, and keep the original XML in User.Pagemonitor until it's fixed and imported.
+func (s *DBService) convertPagemonitorPages() error {
+	// legacyUser is the User record at this schema version.
+	type legacyUser struct {
+		Password      string
+		Subscriptions []UserFeed
+		Pagemonitor   string
+		MailToken     string
+		Admin         bool
+		RetentionPolicy
+	}
+
+	usernames, err := s.getUsers()
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		value, err := s.db.Get(createUserKey(username))
+		if err != nil {
+			return fmt.Errorf("cannot read User %v: %w", username, err)
+		}
+		if value == nil {
+			continue
+		}
+		legacy := &legacyUser{}
+		if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(legacy); err != nil {
+			return fmt.Errorf("cannot decode User %v: %w", username, err)
+		}
+		if strings.TrimSpace(legacy.Pagemonitor) == "" {
+			continue
+		}
+
+		user, err := s.getUser(username)
+		if err != nil {
+			return err
+		}
+		if _, err := user.ImportPagemonitorXML(legacy.Pagemonitor, true); err != nil {
+			log.WithField("username", username).WithField("pagemonitor", legacy.Pagemonitor).WithError(err).Error("Failed to convert Pagemonitor XML into pages, it will be kept until the user imports it again")
+			continue
+		}
+		if err := s.saveUser(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
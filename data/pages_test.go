@@ -0,0 +1,189 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPagemonitorXML = `<pages>` +
+	`<page url="https://site1.com" match="m1" replace="r1">Page 1</page>` +
+	`<page url="http://site2.com">Page 2</page>` +
+	`</pages>`
+
+func TestParsePagemonitorXML(t *testing.T) {
+	pages, err := parsePagemonitorXML(testPagemonitorXML)
+	assert.NoError(t, err)
+	assert.Equal(t, []UserPagemonitor{
+		{URL: "https://site1.com", Title: "Page 1", Match: "m1", Replace: "r1"},
+		{URL: "http://site2.com", Title: "Page 2"},
+	}, pages)
+
+	pages, err = parsePagemonitorXML(`<pages></pages>`)
+	assert.NoError(t, err)
+	assert.Equal(t, []UserPagemonitor{}, pages)
+
+	_, err = parsePagemonitorXML(`<pages><page`)
+	assert.Error(t, err)
+}
+
+func TestImportExportPagemonitorXML(t *testing.T) {
+	user := &User{Pages: []UserPagemonitor{{URL: "http://site2.com", Title: "Old title"}, {URL: "http://site3.com", Title: "Page 3"}}}
+
+	added, err := user.ImportPagemonitorXML(testPagemonitorXML, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, []UserPagemonitor{
+		{URL: "http://site2.com", Title: "Page 2"},
+		{URL: "http://site3.com", Title: "Page 3"},
+		{URL: "https://site1.com", Title: "Page 1", Match: "m1", Replace: "r1"},
+	}, user.Pages)
+
+	pagemonitor, err := user.ExportPagemonitorXML()
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<pages>
+  <page url="http://site2.com">Page 2</page>
+  <page url="http://site3.com">Page 3</page>
+  <page url="https://site1.com" match="m1" replace="r1">Page 1</page>
+</pages>`, pagemonitor)
+
+	exported := &User{}
+	added, err = exported.ImportPagemonitorXML(pagemonitor, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, added)
+	assert.Equal(t, user.Pages, exported.Pages)
+
+	added, err = user.ImportPagemonitorXML(`<pages><page url="http://site4.com"/></pages>`, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, []UserPagemonitor{{URL: "http://site4.com", Title: "http://site4.com"}}, user.Pages)
+}
+
+func TestImportInvalidPagemonitorXML(t *testing.T) {
+	user := &User{Pages: []UserPagemonitor{{URL: "http://site1.com", Title: "Page 1"}}}
+
+	_, err := user.ImportPagemonitorXML(`<pages><page`, true)
+	assert.Error(t, err)
+
+	_, err = user.ImportPagemonitorXML(`<pages><page url="http://site2.com" match="(">Page 2</page></pages>`, true)
+	assert.EqualError(t, err, "invalid page Page 2: cannot compile match regex: error parsing regexp: missing closing ): `(`")
+
+	assert.Equal(t, []UserPagemonitor{{URL: "http://site1.com", Title: "Page 1"}}, user.Pages)
+}
+
+func TestValidatePage(t *testing.T) {
+	for _, page := range []UserPagemonitor{
+		{URL: "http://site1.com"},
+		{URL: "http://site1.com", Match: "(?s)^.*Price: ([0-9]+).*$", Replace: "$1"},
+		{URL: "http://site1.com", Match: "(?P<price>[0-9]+)(.*)", Replace: "${price} ${2}$$ $0"},
+		{URL: "http://site1.com", Match: "[0-9]+", Replace: "$ $. ${x"},
+	} {
+		assert.NoError(t, page.Validate(), page.Replace)
+	}
+
+	for _, test := range []struct {
+		page     UserPagemonitor
+		expected string
+	}{
+		{page: UserPagemonitor{}, expected: "page URL cannot be empty"},
+		{page: UserPagemonitor{URL: "site1.com"}, expected: "page URL site1.com is not absolute"},
+		{page: UserPagemonitor{URL: "http://site1.com", Match: "[0-9"}, expected: "cannot compile match regex: error parsing regexp: missing closing ]: `[0-9`"},
+		{page: UserPagemonitor{URL: "http://site1.com", Replace: "r1"}, expected: "replace cannot be used without match"},
+		{page: UserPagemonitor{URL: "http://site1.com", Match: "([0-9]+)", Replace: "$2"}, expected: "replace references unknown group 2"},
+		{page: UserPagemonitor{URL: "http://site1.com", Match: "([0-9]+)", Replace: "$1x"}, expected: "replace references unknown group 1x"},
+		{page: UserPagemonitor{URL: "http://site1.com", Match: "(?P<price>[0-9]+)", Replace: "${cost}"}, expected: "replace references unknown group cost"},
+	} {
+		assert.EqualError(t, test.page.Validate(), test.expected)
+	}
+}
+
+func TestAddUpdateDeletePage(t *testing.T) {
+	user := &User{Pages: []UserPagemonitor{{URL: "http://site1.com", Title: "Page 1"}}}
+
+	changed, err := user.AddPage(UserPagemonitor{URL: " http://site1.com ", Match: "([0-9]+)", Replace: "$1"})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = user.AddPage(UserPagemonitor{URL: "http://site1.com", Title: "Page 1 copy"})
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = user.AddPage(UserPagemonitor{URL: "http://site2.com", Match: "("})
+	assert.Error(t, err)
+
+	assert.Equal(t, []UserPagemonitor{
+		{URL: "http://site1.com", Title: "Page 1"},
+		{URL: "http://site1.com", Title: "http://site1.com", Match: "([0-9]+)", Replace: "$1"},
+	}, user.GetPages())
+
+	key := user.Pages[1].CreateKey()
+	err = user.UpdatePage(key, UserPagemonitor{URL: "http://site1.com", Title: "Page 1 (numbers)", Match: "[0-9]+"})
+	assert.NoError(t, err)
+
+	err = user.UpdatePage(key, UserPagemonitor{URL: "http://site1.com"})
+	assert.EqualError(t, err, "page pagemonitor/aHR0cDovL3NpdGUxLmNvbQ/KFswLTldKyk/JDE doesn't exist")
+
+	key = user.Pages[1].CreateKey()
+	err = user.UpdatePage(key, UserPagemonitor{URL: "http://site1.com"})
+	assert.EqualError(t, err, "page http://site1.com already exists")
+
+	err = user.UpdatePage(key, UserPagemonitor{URL: "http://site1.com", Replace: "r1"})
+	assert.EqualError(t, err, "replace cannot be used without match")
+
+	assert.Equal(t, []UserPagemonitor{
+		{URL: "http://site1.com", Title: "Page 1"},
+		{URL: "http://site1.com", Title: "Page 1 (numbers)", Match: "[0-9]+"},
+	}, user.GetPages())
+
+	assert.False(t, user.DeletePage([]byte("pagemonitor/aHR0cDovL3NpdGUyLmNvbQ//")))
+	assert.True(t, user.DeletePage(key))
+	assert.Equal(t, []UserPagemonitor{{URL: "http://site1.com", Title: "Page 1"}}, user.GetPages())
+}
+
+func TestConvertPagemonitorPages(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	type legacyUser struct {
+		Password      string
+		Subscriptions []UserFeed
+		Pagemonitor   string
+	}
+	saveLegacyUser := func(username string, user legacyUser) {
+		var value bytes.Buffer
+		err := gob.NewEncoder(&value).Encode(&user)
+		assert.NoError(t, err)
+		err = dbService.db.Put(createUserKey(username), value.Bytes())
+		assert.NoError(t, err)
+		err = dbService.addReferencedKey([]byte(userKeyPrefix), []byte(username))
+		assert.NoError(t, err)
+	}
+	saveLegacyUser("user01", legacyUser{Password: "pass1", Subscriptions: []UserFeed{{URL: "http://site1.com/rss", Title: "Site 1"}}, Pagemonitor: testPagemonitorXML})
+	saveLegacyUser("user02", legacyUser{Password: "pass2", Pagemonitor: `<pages><page`})
+	saveLegacyUser("user03", legacyUser{Password: "pass3"})
+	saveLegacyUser("user04", legacyUser{Password: "pass4", Pagemonitor: `<pages><page url="site5">Page 5</page></pages>`})
+
+	err = dbService.update(dbService.convertPagemonitorPages)
+	assert.NoError(t, err)
+
+	users, err := getAllUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*User{
+		{
+			Password:      "pass1",
+			Subscriptions: []UserFeed{{URL: "http://site1.com/rss", Title: "Site 1"}},
+			Pages: []UserPagemonitor{
+				{URL: "https://site1.com", Title: "Page 1", Match: "m1", Replace: "r1"},
+				{URL: "http://site2.com", Title: "Page 2"},
+			},
+			username: "user01",
+		},
+		// Users with an invalid configuration (or invalid pages) keep it, so that it can be fixed and imported again.
+		{Password: "pass2", Pagemonitor: `<pages><page`, username: "user02"},
+		{Password: "pass3", username: "user03"},
+		{Password: "pass4", Pagemonitor: `<pages><page url="site5">Page 5</page></pages>`, username: "user04"},
+	}, users)
+}
@@ -0,0 +1,483 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The read history of a user is split into one record per day (in UTC), containing all read events from that day.
+// Adding an event only needs to rewrite the current day's record.
+
+// readHistoryDayFormat is the format of days in read history keys.
+const readHistoryDayFormat = "2006-01-02"
+
+// readHistoryTTL specifies how long read events are kept.
+var readHistoryTTL = 90 * 24 * time.Hour
+
+// ReadEvent is a single time when an item was marked as read.
+type ReadEvent struct {
+	Key  []byte
+	Time time.Time
+	// Bulk is true if the item was marked as read by a bulk operation, and not opened by the user.
+	Bulk bool
+}
+
+// ReadingStats is the number of items from a feed (or monitored page) read on a single day.
+type ReadingStats struct {
+	// Date is the start of the day, in UTC.
+	Date time.Time
+	// FeedURL is the feed or page URL.
+	FeedURL string
+	// Read is the number of items opened by the user.
+	Read int
+	// MarkedRead is the number of items marked as read by a bulk operation.
+	MarkedRead int
+}
+
+// bulkRead is the last bulk read operation of a user, which can be undone.
+type bulkRead struct {
+	Time time.Time
+	Keys [][]byte
+}
+
+// encodeReadEvents serializes a list of ReadEvent items.
+func encodeReadEvents(events []*ReadEvent) ([]byte, error) {
+	var value bytes.Buffer
+	if err := gob.NewEncoder(&value).Encode(events); err != nil {
+		return nil, err
+	}
+	return value.Bytes(), nil
+}
+
+// decodeReadEvents deserializes a list of ReadEvent items.
+func decodeReadEvents(value []byte) ([]*ReadEvent, error) {
+	events := make([]*ReadEvent, 0)
+	if len(value) == 0 {
+		return events, nil
+	}
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// itemFeedURL returns the URL of the feed (or monitored page) containing the item with key k.
+func itemFeedURL(k itemKey) (string, error) {
+	if IsFeeditemKey(k) {
+		feeditemKey, err := DecodeFeeditemKey(k)
+		if err != nil {
+			return "", err
+		}
+		return feeditemKey.FeedURL, nil
+	} else if IsPagemonitorKey(k) {
+		pm, err := DecodePagemonitorKey(k)
+		if err != nil {
+			return "", err
+		}
+		return pm.URL, nil
+	}
+	return "", fmt.Errorf("unknown item key format %v", string(k))
+}
+
+// getReadEvents returns all of user's read events from day, without acquiring a lock.
+func (s *DBService) getReadEvents(user *User, day string) ([]*ReadEvent, error) {
+	value, err := s.db.Get(user.createReadHistoryKey(day))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get read history for %v: %w", day, err)
+	}
+	events, err := decodeReadEvents(value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode read history for %v: %w", day, err)
+	}
+	return events, nil
+}
+
+// saveReadEvents replaces all of user's read events from day, without acquiring a lock.
+func (s *DBService) saveReadEvents(user *User, day string, events []*ReadEvent) error {
+	if len(events) == 0 {
+		if err := s.db.Delete(user.createReadHistoryKey(day)); err != nil {
+			return fmt.Errorf("cannot delete read history for %v: %w", day, err)
+		}
+		return s.deleteReferencedKey(user.createReadHistoryIndexKey(), []byte(day))
+	}
+	value, err := encodeReadEvents(events)
+	if err != nil {
+		return fmt.Errorf("cannot encode read history for %v: %w", day, err)
+	}
+	if err := s.db.Put(user.createReadHistoryKey(day), value); err != nil {
+		return fmt.Errorf("cannot save read history for %v: %w", day, err)
+	}
+	return s.addReferencedKey(user.createReadHistoryIndexKey(), []byte(day))
+}
+
+// addReadEvents adds a read event for every key into the user's read history, without acquiring a lock.
+func (s *DBService) addReadEvents(user *User, keys []itemKey, readTime time.Time, bulk bool) error {
+	day := readTime.UTC().Format(readHistoryDayFormat)
+	events, err := s.getReadEvents(user, day)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		events = append(events, &ReadEvent{Key: k, Time: readTime, Bulk: bulk})
+	}
+	return s.saveReadEvents(user, day, events)
+}
+
+// saveBulkRead saves keys marked as read by a bulk operation into the read history,
+// and replaces the previous bulk operation so that it can be undone, without acquiring a lock.
+func (s *DBService) saveBulkRead(user *User, keys []itemKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	operation := &bulkRead{Time: time.Now().UTC(), Keys: keys}
+	if err := s.addReadEvents(user, keys, operation.Time, true); err != nil {
+		return err
+	}
+	return s.putBulkRead(user, operation)
+}
+
+// putBulkRead replaces the user's last bulk read operation, without acquiring a lock.
+func (s *DBService) putBulkRead(user *User, operation *bulkRead) error {
+	var value bytes.Buffer
+	if err := gob.NewEncoder(&value).Encode(operation); err != nil {
+		return fmt.Errorf("cannot encode bulk read operation: %w", err)
+	}
+	return s.db.Put(user.createBulkReadKey(), value.Bytes())
+}
+
+// restoreReadEvents merges events into the user's read history, skipping events which already exist, without acquiring a lock.
+// Returns the number of added events.
+func (s *DBService) restoreReadEvents(user *User, events []*ReadEvent) (int, error) {
+	dayEvents := make(map[string][]*ReadEvent)
+	for _, event := range events {
+		day := event.Time.UTC().Format(readHistoryDayFormat)
+		dayEvents[day] = append(dayEvents[day], event)
+	}
+
+	restored := 0
+	for day, events := range dayEvents {
+		existingEvents, err := s.getReadEvents(user, day)
+		if err != nil {
+			return 0, err
+		}
+		mergedEvents := existingEvents
+		for _, event := range events {
+			exists := false
+			for _, existingEvent := range existingEvents {
+				if bytes.Equal(existingEvent.Key, event.Key) && existingEvent.Time.Equal(event.Time) && existingEvent.Bulk == event.Bulk {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				mergedEvents = append(mergedEvents, event)
+				restored++
+			}
+		}
+		sort.SliceStable(mergedEvents, func(i, j int) bool {
+			return mergedEvents[i].Time.Before(mergedEvents[j].Time)
+		})
+		if err := s.saveReadEvents(user, day, mergedEvents); err != nil {
+			return 0, err
+		}
+	}
+	return restored, nil
+}
+
+// getBulkRead returns the user's last bulk read operation, or nil if there is nothing to undo, without acquiring a lock.
+func (s *DBService) getBulkRead(user *User) (*bulkRead, error) {
+	value, err := s.db.Get(user.createBulkReadKey())
+	if err != nil {
+		return nil, fmt.Errorf("cannot get bulk read operation: %w", err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	operation := &bulkRead{}
+	if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(operation); err != nil {
+		return nil, fmt.Errorf("cannot decode bulk read operation: %w", err)
+	}
+	return operation, nil
+}
+
+// getOpenedSince returns keys of all items opened by user after since, without acquiring a lock.
+func (s *DBService) getOpenedSince(user *User, since time.Time) (map[string]bool, error) {
+	days, err := s.getReadHistoryDays(user)
+	if err != nil {
+		return nil, err
+	}
+	sinceDay := since.UTC().Format(readHistoryDayFormat)
+	opened := make(map[string]bool)
+	for _, day := range days {
+		if day < sinceDay {
+			continue
+		}
+		events, err := s.getReadEvents(user, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if !event.Bulk && event.Time.After(since) {
+				opened[string(event.Key)] = true
+			}
+		}
+	}
+	return opened, nil
+}
+
+// UndoMarkRead marks all items which were marked as read by the last bulk operation as unread,
+// and removes them from the read history. Items which were opened after the bulk operation stay read.
+// Returns the number of items which changed their read status.
+func (s *DBService) UndoMarkRead(user *User) (int, error) {
+	var changed int
+	err := s.update(func() error {
+		operation, err := s.getBulkRead(user)
+		if err != nil || operation == nil {
+			return err
+		}
+
+		opened, err := s.getOpenedSince(user, operation.Time)
+		if err != nil {
+			return err
+		}
+		undoneKeys := make(map[string]bool, len(operation.Keys))
+		keys := make([]itemKey, 0, len(operation.Keys))
+		for _, k := range operation.Keys {
+			if !opened[string(k)] {
+				undoneKeys[string(k)] = true
+				keys = append(keys, k)
+			}
+		}
+
+		changedKeys, err := s.updateReferencedKeys(user.createReadStatusPrefix(), keys, false)
+		if err != nil {
+			return err
+		}
+		changed = len(changedKeys)
+
+		day := operation.Time.UTC().Format(readHistoryDayFormat)
+		events, err := s.getReadEvents(user, day)
+		if err != nil {
+			return err
+		}
+		keptEvents := make([]*ReadEvent, 0, len(events))
+		for _, event := range events {
+			if !event.Bulk || !event.Time.Equal(operation.Time) || !undoneKeys[string(event.Key)] {
+				keptEvents = append(keptEvents, event)
+			}
+		}
+		if err := s.saveReadEvents(user, day, keptEvents); err != nil {
+			return err
+		}
+
+		return s.db.Delete(user.createBulkReadKey())
+	})
+	return changed, err
+}
+
+// getReadHistoryDays returns all days which have read events from user, newest first, without acquiring a lock.
+func (s *DBService) getReadHistoryDays(user *User) ([]string, error) {
+	dayKeys, err := s.getReferencedKeys(user.createReadHistoryIndexKey())
+	if err != nil {
+		return nil, fmt.Errorf("cannot get read history index: %w", err)
+	}
+	days := make([]string, len(dayKeys))
+	for i := range dayKeys {
+		days[i] = string(dayKeys[i])
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	return days, nil
+}
+
+// GetRecentlyRead returns up to limit items most recently opened by user, newest first.
+// Items marked as read by a bulk operation are not included.
+func (s *DBService) GetRecentlyRead(user *User, limit int) ([]*ReadEvent, error) {
+	recentlyRead := make([]*ReadEvent, 0, limit)
+	err := s.view(func() error {
+		days, err := s.getReadHistoryDays(user)
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			events, err := s.getReadEvents(user, day)
+			if err != nil {
+				return err
+			}
+			for i := len(events) - 1; i >= 0; i-- {
+				if len(recentlyRead) >= limit {
+					return nil
+				}
+				if !events[i].Bulk {
+					recentlyRead = append(recentlyRead, events[i])
+				}
+			}
+		}
+		return nil
+	})
+	return recentlyRead, err
+}
+
+// GetReadingStats returns the number of items read by user for every day since from (or the whole read history if from is zero),
+// and for every feed or monitored page. Results are ordered by date and feed URL.
+func (s *DBService) GetReadingStats(user *User, from time.Time) ([]*ReadingStats, error) {
+	stats := make(map[string]*ReadingStats)
+	err := s.view(func() error {
+		return s.addReadingStats(user, from, stats)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortReadingStats(stats), nil
+}
+
+// GetAllReadingStats returns the same statistics as GetReadingStats, combined for all users.
+func (s *DBService) GetAllReadingStats(from time.Time) ([]*ReadingStats, error) {
+	stats := make(map[string]*ReadingStats)
+	err := s.view(func() error {
+		usernames, err := s.getUsers()
+		if err != nil {
+			return err
+		}
+		for _, username := range usernames {
+			if err := s.addReadingStats(&User{username: username}, from, stats); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortReadingStats(stats), nil
+}
+
+// addReadingStats counts user's read events since from and adds them to stats, without acquiring a lock.
+// stats are grouped by day and feed URL.
+func (s *DBService) addReadingStats(user *User, from time.Time, stats map[string]*ReadingStats) error {
+	days, err := s.getReadHistoryDays(user)
+	if err != nil {
+		return err
+	}
+	fromDay := from.UTC().Format(readHistoryDayFormat)
+	for _, day := range days {
+		if !from.IsZero() && day < fromDay {
+			continue
+		}
+		date, err := time.Parse(readHistoryDayFormat, day)
+		if err != nil {
+			log.WithField("day", day).WithError(err).Error("Failed to parse read history day")
+			continue
+		}
+		events, err := s.getReadEvents(user, day)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			feedURL, err := itemFeedURL(event.Key)
+			if err != nil {
+				log.WithField("key", string(event.Key)).WithError(err).Error("Failed to get feed of read item")
+				continue
+			}
+			statsKey := day + separator + feedURL
+			feedStats, ok := stats[statsKey]
+			if !ok {
+				feedStats = &ReadingStats{Date: date, FeedURL: feedURL}
+				stats[statsKey] = feedStats
+			}
+			if event.Bulk {
+				feedStats.MarkedRead++
+			} else {
+				feedStats.Read++
+			}
+		}
+	}
+	return nil
+}
+
+// sortReadingStats returns stats ordered by date and feed URL.
+func sortReadingStats(stats map[string]*ReadingStats) []*ReadingStats {
+	sortedStats := make([]*ReadingStats, 0, len(stats))
+	for _, feedStats := range stats {
+		sortedStats = append(sortedStats, feedStats)
+	}
+	sort.Slice(sortedStats, func(i, j int) bool {
+		if !sortedStats[i].Date.Equal(sortedStats[j].Date) {
+			return sortedStats[i].Date.Before(sortedStats[j].Date)
+		}
+		return sortedStats[i].FeedURL < sortedStats[j].FeedURL
+	})
+	return sortedStats
+}
+
+// renameReadHistory moves the read history and the last bulk read operation to the new username.
+func (s *DBService) renameReadHistory(user *User) error {
+	newUser := &User{username: user.newUsername}
+
+	days, err := s.getReadHistoryDays(user)
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		events, err := s.getReadEvents(user, day)
+		if err != nil {
+			return err
+		}
+		if err := s.saveReadEvents(newUser, day, events); err != nil {
+			log.WithField("day", day).WithField("user", newUser.username).WithError(err).Error("Failed to save read history for new username")
+			return err
+		}
+		if err := s.saveReadEvents(user, day, nil); err != nil {
+			log.WithField("day", day).WithField("user", user.username).WithError(err).Error("Failed to delete read history for old username")
+			return err
+		}
+	}
+
+	value, err := s.db.Get(user.createBulkReadKey())
+	if err != nil || value == nil {
+		return err
+	}
+	if err := s.db.Put(newUser.createBulkReadKey(), value); err != nil {
+		return err
+	}
+	return s.db.Delete(user.createBulkReadKey())
+}
+
+// deleteExpiredReadHistory deletes read events older than readHistoryTTL.
+func (s *DBService) deleteExpiredReadHistory() error {
+	usernames, err := s.GetUsers()
+	if err != nil {
+		return err
+	}
+	expiredDay := time.Now().UTC().Add(-readHistoryTTL).Format(readHistoryDayFormat)
+	for _, username := range usernames {
+		user := User{username: username}
+
+		// Use a separate transaction for every user to avoid blocking the database for too long.
+		err := s.update(func() error {
+			days, err := s.getReadHistoryDays(&user)
+			if err != nil {
+				return err
+			}
+			for _, day := range days {
+				if day >= expiredDay {
+					continue
+				}
+				log.WithField("day", day).Debug("Deleting expired read history")
+				if err := s.saveReadEvents(&user, day, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.WithField("username", username).WithError(err).Error("Failed to delete expired read history")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readEventKeys(events []*ReadEvent) []string {
+	keys := make([]string, len(events))
+	for i := range events {
+		keys[i] = string(events[i].Key)
+	}
+	return keys
+}
+
+func TestReadHistory(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed1", Title: "Feed 1"}, {URL: "http://feed2", Title: "Feed 2"}}
+	user.Pages = []UserPagemonitor{{URL: "http://site1", Title: "Site 1"}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item1 := &Feeditem{Title: "t1", Key: &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}}
+	item2 := &Feeditem{Title: "t2", Key: &FeeditemKey{FeedURL: "http://feed1", GUID: "g2"}}
+	item3 := &Feeditem{Title: "t3", Key: &FeeditemKey{FeedURL: "http://feed2", GUID: "g1"}}
+	page := &PagemonitorPage{Config: &UserPagemonitor{URL: "http://site1"}}
+	err = dbService.SaveFeeditems(item1, item2, item3)
+	assert.NoError(t, err)
+	err = dbService.SavePage(page)
+	assert.NoError(t, err)
+
+	startTime := time.Now()
+	for _, key := range [][]byte{item1.Key.CreateKey(), page.Config.CreateKey(), item1.Key.CreateKey()} {
+		err = dbService.SetReadStatus(user, key, true)
+		assert.NoError(t, err)
+	}
+
+	recentlyRead, err := dbService.GetRecentlyRead(user, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(page.Config.CreateKey()), string(item1.Key.CreateKey())}, readEventKeys(recentlyRead))
+	assert.False(t, recentlyRead[0].Time.Before(recentlyRead[1].Time))
+	assert.False(t, recentlyRead[1].Time.Before(startTime))
+	assert.False(t, recentlyRead[0].Bulk)
+
+	recentlyRead, err = dbService.GetRecentlyRead(user, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(page.Config.CreateKey())}, readEventKeys(recentlyRead))
+
+	changed, err := dbService.MarkRead(user, ReadFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	// Items marked as read by a bulk operation are not in the recently read list.
+	recentlyRead, err = dbService.GetRecentlyRead(user, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(page.Config.CreateKey()), string(item1.Key.CreateKey())}, readEventKeys(recentlyRead))
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	stats, err := dbService.GetReadingStats(user, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*ReadingStats{
+		{Date: today, FeedURL: "http://feed1", Read: 1, MarkedRead: 1},
+		{Date: today, FeedURL: "http://feed2", MarkedRead: 1},
+		{Date: today, FeedURL: "http://site1", Read: 1},
+	}, stats)
+
+	stats, err = dbService.GetReadingStats(user, today.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+
+	// Items which were read before the bulk operation stay read.
+	err = dbService.SetReadStatus(user, item2.Key.CreateKey(), false)
+	assert.NoError(t, err)
+	changed, err = dbService.UndoMarkRead(user)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	dbReadItems, err := dbService.GetReadItems(user)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{item1.Key.CreateKey(), page.Config.CreateKey()}, dbReadItems)
+
+	stats, err = dbService.GetReadingStats(user, today)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ReadingStats{
+		{Date: today, FeedURL: "http://feed1", Read: 1},
+		{Date: today, FeedURL: "http://site1", Read: 1},
+	}, stats)
+
+	changed, err = dbService.UndoMarkRead(user)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, changed)
+}
+
+func TestSetReadStatusesUndo(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	key1 := []byte("i1")
+	key2 := []byte("i2")
+
+	changed, err := dbService.SetReadStatuses(&user, [][]byte{key1, key2}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	// Operations which didn't change anything don't replace the previous operation.
+	changed, err = dbService.SetReadStatuses(&user, [][]byte{key1}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, changed)
+
+	changed, err = dbService.UndoMarkRead(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	dbReadItems, err := dbService.GetReadItems(&user)
+	assert.NoError(t, err)
+	assert.Empty(t, dbReadItems)
+
+	stats, err := dbService.GetReadingStats(&user, time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+}
+
+func TestUndoMarkReadOpenedItems(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	key1 := []byte("i1")
+	key2 := []byte("i2")
+
+	changed, err := dbService.SetReadStatuses(&user, [][]byte{key1, key2}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	// Items opened after the bulk operation stay read.
+	err = dbService.SetReadStatus(&user, key2, false)
+	assert.NoError(t, err)
+	err = dbService.SetReadStatus(&user, key2, true)
+	assert.NoError(t, err)
+
+	changed, err = dbService.UndoMarkRead(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	dbReadItems, err := dbService.GetReadItems(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{key2}, dbReadItems)
+
+	recentlyRead, err := dbService.GetRecentlyRead(&user, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(key2)}, readEventKeys(recentlyRead))
+}
+
+func TestDeleteExpiredReadHistory(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	key1 := (&FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}).CreateKey()
+	key2 := (&FeeditemKey{FeedURL: "http://feed1", GUID: "g2"}).CreateKey()
+
+	expiredTime := time.Now().UTC().Add(-readHistoryTTL).AddDate(0, 0, -1)
+	err = dbService.update(func() error {
+		return dbService.addReadEvents(user, [][]byte{key1}, expiredTime, false)
+	})
+	assert.NoError(t, err)
+	err = dbService.SetReadStatus(user, key2, true)
+	assert.NoError(t, err)
+
+	recentlyRead, err := dbService.GetRecentlyRead(user, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(key2), string(key1)}, readEventKeys(recentlyRead))
+
+	err = dbService.deleteExpiredReadHistory()
+	assert.NoError(t, err)
+
+	recentlyRead, err = dbService.GetRecentlyRead(user, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(key2)}, readEventKeys(recentlyRead))
+
+	exists, err := dbService.db.Has(user.createReadHistoryKey(expiredTime.Format(readHistoryDayFormat)))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestRenameUserTransferReadHistory(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	key1 := (&FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}).CreateKey()
+	key2 := (&FeeditemKey{FeedURL: "http://feed1", GUID: "g2"}).CreateKey()
+	err = dbService.SetReadStatus(&user, key1, true)
+	assert.NoError(t, err)
+	_, err = dbService.SetReadStatuses(&user, [][]byte{key2}, true)
+	assert.NoError(t, err)
+
+	user.SetUsername("user02")
+	err = dbService.SaveUser(&user)
+	assert.NoError(t, err)
+
+	recentlyRead, err := dbService.GetRecentlyRead(&user, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(key1)}, readEventKeys(recentlyRead))
+
+	changed, err := dbService.UndoMarkRead(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changed)
+
+	oldUser := User{username: "user01"}
+
+	recentlyRead, err = dbService.GetRecentlyRead(&oldUser, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, recentlyRead)
+
+	changed, err = dbService.UndoMarkRead(&oldUser)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, changed)
+}
+
+func TestGetAllReadingStats(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user1 := NewUser("user01")
+	err = dbService.SaveUser(user1)
+	assert.NoError(t, err)
+	user2 := NewUser("user02")
+	err = dbService.SaveUser(user2)
+	assert.NoError(t, err)
+
+	key1 := (&FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}).CreateKey()
+	key2 := (&FeeditemKey{FeedURL: "http://feed2", GUID: "g1"}).CreateKey()
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	err = dbService.update(func() error {
+		return dbService.addReadEvents(user1, [][]byte{key1, key2}, yesterday, false)
+	})
+	assert.NoError(t, err)
+	err = dbService.SetReadStatus(user1, key1, true)
+	assert.NoError(t, err)
+	err = dbService.SetReadStatus(user2, key1, true)
+	assert.NoError(t, err)
+	_, err = dbService.SetReadStatuses(user2, [][]byte{key2}, true)
+	assert.NoError(t, err)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	stats, err := dbService.GetAllReadingStats(time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*ReadingStats{
+		{Date: today.AddDate(0, 0, -1), FeedURL: "http://feed1", Read: 1},
+		{Date: today.AddDate(0, 0, -1), FeedURL: "http://feed2", Read: 1},
+		{Date: today, FeedURL: "http://feed1", Read: 2},
+		{Date: today, FeedURL: "http://feed2", MarkedRead: 1},
+	}, stats)
+
+	stats, err = dbService.GetAllReadingStats(today)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ReadingStats{
+		{Date: today, FeedURL: "http://feed1", Read: 2},
+		{Date: today, FeedURL: "http://feed2", MarkedRead: 1},
+	}, stats)
+}
@@ -1,29 +1,49 @@
 package data
 
 import (
+	"fmt"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 )
 
 type itemKey = []byte
 
+// ReadFilter selects items to mark as read; an item has to match all non-empty fields.
+// An empty ReadFilter selects all items from the user's subscriptions and monitored pages.
+type ReadFilter struct {
+	// FeedURL, if not empty, selects only items from this feed (or monitored page).
+	FeedURL string
+	// Folder, if not empty, selects only items from feeds in this folder or its nested folders.
+	Folder string
+	// Tag, if not empty, selects only items with this tag, including items from feeds the user is no longer subscribed to.
+	Tag string
+	// Before, if not zero, selects only items with a date (or pages updated) before this time.
+	Before time.Time
+}
+
 // GetReadItems returns a list of items this user has read.
 func (s *DBService) GetReadItems(user *User) ([]itemKey, error) {
 	var items []itemKey
 	err := s.view(func() error {
-		readStatusPrefix := user.createReadStatusPrefix()
-
 		var err error
-		items, err = s.getReferencedKeys(readStatusPrefix)
-		if err != nil {
-			log.WithField("username", user.username).WithError(err).Error("Failed to get read status index")
-			return err
-		}
-		return nil
+		items, err = s.getReadItems(user)
+		return err
 	})
 
 	return items, err
 }
 
+// getReadItems returns a list of items this user has read, without acquiring a lock.
+func (s *DBService) getReadItems(user *User) ([]itemKey, error) {
+	items, err := s.getReferencedKeys(user.createReadStatusPrefix())
+	if err != nil {
+		log.WithField("username", user.username).WithError(err).Error("Failed to get read status index")
+		return nil, err
+	}
+	return items, nil
+}
+
 // setReadStatus sets the read status for item, true for read, false for unread.
 func (s *DBService) setReadStatus(user *User, k itemKey, read bool) error {
 	readStatusPrefix := user.createReadStatusPrefix()
@@ -34,15 +54,154 @@ func (s *DBService) setReadStatus(user *User, k itemKey, read bool) error {
 }
 
 // SetReadStatus sets the read status for item, true for read, false for unread.
+// If the item was previously unread, the read event is saved into the user's read history.
 func (s *DBService) SetReadStatus(user *User, k itemKey, read bool) error {
-	return s.view(func() error {
-		return s.setReadStatus(user, k, read)
+	return s.update(func() error {
+		changed, err := s.updateReferencedKeys(user.createReadStatusPrefix(), []itemKey{k}, read)
+		if err != nil || !read || len(changed) == 0 {
+			return err
+		}
+		return s.addReadEvents(user, changed, time.Now().UTC(), false)
+	})
+}
+
+// SetReadStatuses sets the read status for all keys, true for read, false for unread.
+// The read status index is updated only once; returns the number of items which changed their read status.
+// Marking items as read can be reverted with UndoMarkRead.
+func (s *DBService) SetReadStatuses(user *User, keys []itemKey, read bool) (int, error) {
+	var changed int
+	err := s.update(func() error {
+		changedKeys, err := s.updateReferencedKeys(user.createReadStatusPrefix(), keys, read)
+		if err != nil {
+			return err
+		}
+		changed = len(changedKeys)
+		if !read {
+			return nil
+		}
+		return s.saveBulkRead(user, changedKeys)
+	})
+	return changed, err
+}
+
+// MarkRead marks all items matching filter as read, and returns the number of items which were previously unread.
+// The read status index is updated only once; this can be reverted with UndoMarkRead.
+func (s *DBService) MarkRead(user *User, filter ReadFilter) (int, error) {
+	var changed int
+	err := s.update(func() error {
+		keys, err := s.getFilteredItems(user, filter)
+		if err != nil {
+			return err
+		}
+		changedKeys, err := s.updateReferencedKeys(user.createReadStatusPrefix(), keys, true)
+		if err != nil {
+			return err
+		}
+		changed = len(changedKeys)
+		return s.saveBulkRead(user, changedKeys)
 	})
+	return changed, err
+}
+
+// getFilteredItems returns keys of all items matching filter, without acquiring a lock.
+func (s *DBService) getFilteredItems(user *User, filter ReadFilter) ([]itemKey, error) {
+	feeds := user.GetFeeds()
+	feedFolders := make(map[string]string, len(feeds))
+	filterItemsURL := filter.FeedURL
+	for _, feed := range feeds {
+		feedFolders[feed.ItemsURL()] = feed.Folder
+		if feed.URL == filter.FeedURL {
+			filterItemsURL = feed.ItemsURL()
+		}
+	}
+
+	var keys []itemKey
+	if filter.Tag != "" {
+		var err error
+		if keys, err = s.getTaggedItems(user, filter.Tag); err != nil {
+			return nil, err
+		}
+	} else {
+		for i := range feeds {
+			if filter.FeedURL != "" && filter.FeedURL != feeds[i].URL {
+				continue
+			}
+			guids, err := s.getReferencedKeys(feeds[i].createItemsIndexKey())
+			if err != nil {
+				return nil, fmt.Errorf("cannot get items of feed %v: %w", feeds[i].URL, err)
+			}
+			for _, guid := range guids {
+				key := &FeeditemKey{FeedURL: feeds[i].ItemsURL(), GUID: string(guid)}
+				keys = append(keys, key.CreateKey())
+			}
+		}
+		for _, pm := range user.GetPages() {
+			keys = append(keys, pm.CreateKey())
+		}
+	}
+
+	filteredKeys := make([]itemKey, 0, len(keys))
+	for _, key := range keys {
+		var date time.Time
+		if IsFeeditemKey(key) {
+			feeditemKey, err := DecodeFeeditemKey(key)
+			if err != nil {
+				log.WithField("key", string(key)).WithError(err).Error("Failed to decode feed item key")
+				continue
+			}
+			if filter.FeedURL != "" && filterItemsURL != feeditemKey.FeedURL {
+				continue
+			}
+			if folder, ok := feedFolders[feeditemKey.FeedURL]; filter.Folder != "" && (!ok || !FolderContains(filter.Folder, folder)) {
+				continue
+			}
+			if !filter.Before.IsZero() {
+				value, err := s.db.Get(key)
+				if err != nil {
+					return nil, fmt.Errorf("cannot get feed item %v: %w", feeditemKey, err)
+				}
+				if value == nil {
+					continue
+				}
+				feedItem := &Feeditem{}
+				if err := feedItem.decode(value); err != nil {
+					return nil, fmt.Errorf("cannot decode feed item %v: %w", feeditemKey, err)
+				}
+				date = feedItem.Date
+			}
+		} else if IsPagemonitorKey(key) {
+			pm, err := DecodePagemonitorKey(key)
+			if err != nil {
+				log.WithField("key", string(key)).WithError(err).Error("Failed to decode page key")
+				continue
+			}
+			if filter.Folder != "" || (filter.FeedURL != "" && filter.FeedURL != pm.URL) {
+				continue
+			}
+			if !filter.Before.IsZero() {
+				page, err := s.getPage(pm)
+				if err != nil {
+					return nil, err
+				}
+				if page == nil {
+					continue
+				}
+				date = page.Updated
+			}
+		} else {
+			continue
+		}
+		if !filter.Before.IsZero() && !date.Before(filter.Before) {
+			continue
+		}
+		filteredKeys = append(filteredKeys, key)
+	}
+	return filteredKeys, nil
 }
 
 // SetReadStatusForAll sets the read status for item (for all users), true for read, false for unread.
 func (s *DBService) SetReadStatusForAll(k itemKey, read bool) error {
-	return s.view(func() error {
+	return s.update(func() error {
 		indexKeys, err := s.getReferencedKeys([]byte(userKeyPrefix))
 		if err != nil {
 			log.WithError(err).Error("Failed to decode list of usernames")
@@ -74,38 +233,32 @@ func (s *DBService) renameReadStatus(user *User) error {
 		return err
 	}
 
-	for i := range readItemsIndex {
-		k := readItemsIndex[i]
-
-		if err := s.deleteReferencedKey(oldReadStatusIndexKey, k); err != nil {
-			log.WithField("key", k).WithField("user", user.username).WithError(err).Error("Failed to delete read status from old username index")
-			return err
-		}
+	if _, err := s.updateReferencedKeys(newReadStatusIndexKey, readItemsIndex, true); err != nil {
+		log.WithField("user", newUser.username).WithError(err).Error("Failed to add read status to index for new username")
+		return err
+	}
 
-		if err := s.addReferencedKey(newReadStatusIndexKey, k); err != nil {
-			log.WithField("key", k).WithField("user", newUser.username).WithError(err).Error("Failed to create read status to index for new username")
-			return err
-		}
+	if err := s.deleteIndex(oldReadStatusIndexKey); err != nil {
+		log.WithField("user", user.username).WithError(err).Error("Failed to delete old username read status index")
+		return err
 	}
 	return nil
 }
 
 // deleteStaleReadStatuses deletes all read statuses which are referring to items which no longer exist.
 func (s *DBService) deleteStaleReadStatuses() error {
-	return s.view(func() error {
-		userIndexKeys, err := s.getReferencedKeys([]byte(userKeyPrefix))
-		if err != nil {
-			log.WithError(err).Error("Failed to decode list of usernames")
-			return err
-		}
-		for i := range userIndexKeys {
-			username := string(userIndexKeys[i])
-			user := User{username: username}
+	usernames, err := s.GetUsers()
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		user := User{username: username}
 
-			readItemsIndex, err := s.getReferencedKeys([]byte(user.createReadStatusPrefix()))
+		// Use a separate transaction for every user to avoid blocking the database for too long.
+		err := s.update(func() error {
+			readItemsIndex, err := s.getReadItems(&user)
 			if err != nil {
-				log.WithField("username", username).WithError(err).Error("Failed to get read status index")
-				continue
+				return err
 			}
 
 			for j := range readItemsIndex {
@@ -120,12 +273,15 @@ func (s *DBService) deleteStaleReadStatuses() error {
 					log.Debug("Deleting invalid read status")
 
 					if err := s.setReadStatus(&user, k, false); err != nil {
-						log.WithField("key", string(k)).WithError(err).Error("Failed to delete read status")
-						continue
+						return fmt.Errorf("failed to delete read status %v: %w", string(k), err)
 					}
 				}
 			}
+			return nil
+		})
+		if err != nil {
+			log.WithField("username", username).WithError(err).Error("Failed to delete stale read statuses")
 		}
-		return nil
-	})
+	}
+	return nil
 }
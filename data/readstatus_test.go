@@ -2,6 +2,7 @@ package data
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -104,6 +105,10 @@ func TestRenameUserTransferReadStatusSuccess(t *testing.T) {
 	dbReadItems, err = dbService.GetReadItems(&oldUser)
 	assert.NoError(t, err)
 	assert.Empty(t, dbReadItems)
+
+	value, err := dbService.db.Get([]byte(oldUser.createReadStatusPrefix()))
+	assert.NoError(t, err)
+	assert.Nil(t, value)
 }
 
 func TestRenameUserTransferReadStatusAlreadyExists(t *testing.T) {
@@ -256,3 +261,91 @@ func TestSetReadStatusForAll(t *testing.T) {
 	assert.NoError(t, err)
 	assert.ElementsMatch(t, readItems, dbReadItems)
 }
+
+func TestSetReadStatuses(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	key1 := []byte("i1")
+	key2 := []byte("i2")
+	key3 := []byte("i3")
+
+	err = dbService.SetReadStatus(&user, key1, true)
+	assert.NoError(t, err)
+
+	changed, err := dbService.SetReadStatuses(&user, [][]byte{key1, key2, key3}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	dbReadItems, err := dbService.GetReadItems(&user)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{key1, key2, key3}, dbReadItems)
+
+	changed, err = dbService.SetReadStatuses(&user, [][]byte{key1, key3, []byte("i4")}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changed)
+
+	dbReadItems, err = dbService.GetReadItems(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{key2}, dbReadItems)
+}
+
+func TestMarkRead(t *testing.T) {
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{
+		{URL: "http://feed1", Title: "Feed 1", Folder: "News"},
+		{URL: "http://feed2", Title: "Feed 2", Folder: "News/Local"},
+		{URL: "http://feed3", Title: "Feed 3"},
+	}
+	user.Pages = []UserPagemonitor{{URL: "http://site1", Title: "Site 1"}}
+
+	item1 := &Feeditem{Title: "t1", Date: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC), Key: &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"}}
+	item2 := &Feeditem{Title: "t2", Date: time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC), Key: &FeeditemKey{FeedURL: "http://feed2", GUID: "g1"}}
+	item3 := &Feeditem{Title: "t3", Date: time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC), Key: &FeeditemKey{FeedURL: "http://feed3", GUID: "g1"}}
+	unsubscribedItem := &Feeditem{Title: "t4", Date: time.Date(2019, time.February, 15, 23, 0, 0, 0, time.UTC), Key: &FeeditemKey{FeedURL: "http://feed4", GUID: "g1"}}
+	page := &PagemonitorPage{Updated: time.Date(2019, time.February, 15, 23, 0, 0, 0, time.UTC), Config: &UserPagemonitor{URL: "http://site1"}}
+
+	prepareDb := func() {
+		err := resetDb()
+		assert.NoError(t, err)
+		err = dbService.SaveUser(user)
+		assert.NoError(t, err)
+		err = dbService.SaveFeeditems(item1, item2, item3, unsubscribedItem)
+		assert.NoError(t, err)
+		err = dbService.SavePage(page)
+		assert.NoError(t, err)
+		err = dbService.SetTagged(user, "tag1", item3.Key.CreateKey(), true)
+		assert.NoError(t, err)
+		err = dbService.SetTagged(user, "tag1", unsubscribedItem.Key.CreateKey(), true)
+		assert.NoError(t, err)
+		err = dbService.SetReadStatus(user, item1.Key.CreateKey(), true)
+		assert.NoError(t, err)
+	}
+
+	for _, test := range []struct {
+		filter   ReadFilter
+		expected [][]byte
+	}{
+		{ReadFilter{}, [][]byte{item1.Key.CreateKey(), item2.Key.CreateKey(), item3.Key.CreateKey(), page.Config.CreateKey()}},
+		{ReadFilter{FeedURL: "http://feed2"}, [][]byte{item1.Key.CreateKey(), item2.Key.CreateKey()}},
+		{ReadFilter{FeedURL: "http://site1"}, [][]byte{item1.Key.CreateKey(), page.Config.CreateKey()}},
+		{ReadFilter{Folder: "News"}, [][]byte{item1.Key.CreateKey(), item2.Key.CreateKey()}},
+		{ReadFilter{Folder: "News/Local"}, [][]byte{item1.Key.CreateKey(), item2.Key.CreateKey()}},
+		{ReadFilter{Tag: "tag1"}, [][]byte{item1.Key.CreateKey(), item3.Key.CreateKey(), unsubscribedItem.Key.CreateKey()}},
+		{ReadFilter{Tag: "tag1", Folder: "News"}, [][]byte{item1.Key.CreateKey()}},
+		{ReadFilter{Before: time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC)}, [][]byte{item1.Key.CreateKey(), page.Config.CreateKey()}},
+		{ReadFilter{Folder: "News", Before: time.Date(2019, time.February, 18, 0, 0, 0, 0, time.UTC)}, [][]byte{item1.Key.CreateKey(), item2.Key.CreateKey()}},
+	} {
+		prepareDb()
+
+		changed, err := dbService.MarkRead(user, test.filter)
+		assert.NoError(t, err)
+		assert.Equal(t, len(test.expected)-1, changed)
+
+		dbReadItems, err := dbService.GetReadItems(user)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, test.expected, dbReadItems)
+	}
+}
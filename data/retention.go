@@ -0,0 +1,244 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RetentionPolicy specifies how long feed items are kept.
+// A zero value means that the setting is inherited from a less specific policy:
+// a feed policy overrides the user policy, which overrides the global default.
+// Unread, starred, tagged and noted items are never deleted.
+type RetentionPolicy struct {
+	// MaxAgeDays is the number of days an item is kept after it disappeared from its feed.
+	MaxAgeDays int `xml:"maxAgeDays,attr,omitempty" json:",omitempty"`
+	// MaxItems is the maximum number of items kept for a feed; 0 means unlimited.
+	// Items which are still present in the feed are always kept, otherwise they would reappear as new items.
+	MaxItems int `xml:"maxItems,attr,omitempty" json:",omitempty"`
+}
+
+// Validate checks that policy values are valid.
+func (policy RetentionPolicy) Validate() error {
+	if policy.MaxAgeDays < 0 {
+		return fmt.Errorf("max age days cannot be negative")
+	}
+	if policy.MaxItems < 0 {
+		return fmt.Errorf("max items cannot be negative")
+	}
+	return nil
+}
+
+// override returns policy with values replaced by non-zero values from other.
+func (policy RetentionPolicy) override(other RetentionPolicy) RetentionPolicy {
+	if other.MaxAgeDays != 0 {
+		policy.MaxAgeDays = other.MaxAgeDays
+	}
+	if other.MaxItems != 0 {
+		policy.MaxItems = other.MaxItems
+	}
+	return policy
+}
+
+// merge combines policies of users subscribed to the same feed,
+// so that items are kept for as long as at least one of them needs them.
+func (policy RetentionPolicy) merge(other RetentionPolicy) RetentionPolicy {
+	if other.MaxAgeDays > policy.MaxAgeDays {
+		policy.MaxAgeDays = other.MaxAgeDays
+	}
+	if policy.MaxItems != 0 && (other.MaxItems == 0 || other.MaxItems > policy.MaxItems) {
+		policy.MaxItems = other.MaxItems
+	}
+	return policy
+}
+
+// maxAge returns how long an item is kept after it was last seen.
+func (policy RetentionPolicy) maxAge() time.Duration {
+	if policy.MaxAgeDays > 0 {
+		return time.Duration(policy.MaxAgeDays) * 24 * time.Hour
+	}
+	return itemTTL
+}
+
+// defaultRetentionPolicy returns the global retention policy, customized based on environment variables.
+func defaultRetentionPolicy() RetentionPolicy {
+	parseEnv := func(varName string) int {
+		valueStr, ok := os.LookupEnv(varName)
+		if !ok || valueStr == "" {
+			return 0
+		}
+		value, err := strconv.Atoi(valueStr)
+		if err != nil || value < 0 {
+			log.WithField("variable", varName).WithField("value", valueStr).WithError(err).Error("Cannot parse environment value")
+			return 0
+		}
+		return value
+	}
+	return RetentionPolicy{
+		MaxAgeDays: parseEnv("RETENTION_MAX_AGE_DAYS"),
+		MaxItems:   parseEnv("RETENTION_MAX_ITEMS"),
+	}
+}
+
+// GetDefaultRetentionPolicy returns the global retention policy.
+func (s *DBService) GetDefaultRetentionPolicy() RetentionPolicy {
+	policy := s.retention
+	if policy.MaxAgeDays == 0 {
+		policy.MaxAgeDays = int(itemTTL / (24 * time.Hour))
+	}
+	return policy
+}
+
+// feedRetention is the effective retention policy of a feed.
+type feedRetention struct {
+	policy RetentionPolicy
+	// subscribers is the list of users subscribed to the feed.
+	subscribers []*User
+}
+
+// getFeedRetentionPolicies returns the effective retention policy for every feed with at least one subscriber.
+func (s *DBService) getFeedRetentionPolicies() (map[string]*feedRetention, error) {
+	usernames, err := s.getUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	feeds := make(map[string]*feedRetention)
+	for _, username := range usernames {
+		user, err := s.getUser(username)
+		if err != nil || user == nil {
+			log.WithField("username", username).WithError(err).Error("Failed to get user")
+			continue
+		}
+		userFeeds := user.GetFeeds()
+		userPolicy := s.retention.override(user.RetentionPolicy)
+		for _, feed := range userFeeds {
+			if err := feed.RetentionPolicy.Validate(); err != nil {
+				log.WithField("username", username).WithField("feed", feed.URL).WithError(err).Error("Ignoring invalid feed retention policy")
+				feed.RetentionPolicy = RetentionPolicy{}
+			}
+			policy := userPolicy.override(feed.RetentionPolicy)
+			retention, ok := feeds[feed.ItemsURL()]
+			if !ok {
+				feeds[feed.ItemsURL()] = &feedRetention{policy: policy, subscribers: []*User{user}}
+				continue
+			}
+			retention.policy = retention.policy.merge(policy)
+			retention.subscribers = append(retention.subscribers, user)
+		}
+	}
+	return feeds, nil
+}
+
+// applyRetentionPolicies deletes items which are no longer needed according to the retention policy of their feed.
+func (s *DBService) applyRetentionPolicies() error {
+	var feeds map[string]*feedRetention
+	err := s.view(func() error {
+		var err error
+		feeds, err = s.getFeedRetentionPolicies()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for feedURL, retention := range feeds {
+		// Use a separate transaction for every feed to avoid blocking the database for too long.
+		err := s.update(func() error {
+			return s.applyRetentionPolicy(feedURL, retention, now)
+		})
+		if err != nil {
+			log.WithField("feed", feedURL).WithError(err).Error("Failed to apply retention policy")
+		}
+	}
+	return nil
+}
+
+// applyRetentionPolicy deletes items from feedURL which are no longer needed according to retention.
+func (s *DBService) applyRetentionPolicy(feedURL string, retention *feedRetention, now time.Time) error {
+	type retentionItem struct {
+		key      []byte
+		guid     []byte
+		date     time.Time
+		lastSeen time.Time
+	}
+
+	prefix := (&UserFeed{URL: feedURL}).createItemsIndexKey()
+	indexKeys, err := s.getReferencedKeys(prefix)
+	if err != nil {
+		return fmt.Errorf("cannot get index for feed %v: %w", feedURL, err)
+	}
+
+	items := make([]*retentionItem, 0, len(indexKeys))
+	for _, guid := range indexKeys {
+		itemKey := FeeditemKey{FeedURL: feedURL, GUID: string(guid)}
+		item := &retentionItem{key: itemKey.CreateKey(), guid: guid, lastSeen: now}
+
+		value, err := s.db.Get(item.key)
+		if err != nil {
+			return fmt.Errorf("cannot get item %v: %w", string(item.key), err)
+		}
+		feedItem := &Feeditem{}
+		if err := feedItem.decode(value); err == nil {
+			item.date = feedItem.Date
+		}
+
+		lastSeenValue, err := s.db.Get(createLastSeenKey(item.key))
+		if err != nil {
+			return fmt.Errorf("cannot get last seen time of item %v: %w", string(item.key), err)
+		}
+		if lastSeenValue != nil {
+			if err := item.lastSeen.UnmarshalBinary(lastSeenValue); err != nil {
+				log.WithField("key", string(item.key)).WithError(err).Error("Failed to get last seen time value")
+				item.lastSeen = now
+			}
+		}
+		items = append(items, item)
+	}
+
+	// Newest items are kept first.
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].date.After(items[j].date)
+	})
+
+	maxAge := retention.policy.maxAge()
+	// An item is still in the feed if its last seen time was updated recently.
+	inFeedAge := 2 * skipUpdateTTL
+	for i, item := range items {
+		expired := !now.Before(item.lastSeen.Add(maxAge))
+		overLimit := retention.policy.MaxItems > 0 && i >= retention.policy.MaxItems && !now.Before(item.lastSeen.Add(inFeedAge))
+		if !expired && !overLimit {
+			continue
+		}
+
+		keep, err := s.isUnreadOrKept(item.key, retention.subscribers)
+		if err != nil {
+			return err
+		}
+		if keep {
+			continue
+		}
+
+		log.WithField("key", string(item.key)).Debug("Deleting item according to retention policy")
+		if err := s.purgeItem(prefix, item.key, item.guid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isUnreadOrKept returns true if any of users hasn't read item k yet, or if the item is starred, tagged or has notes.
+func (s *DBService) isUnreadOrKept(k itemKey, users []*User) (bool, error) {
+	for _, user := range users {
+		read, err := s.hasReferencedKey(user.createReadStatusPrefix(), k)
+		if err != nil || !read {
+			return !read, err
+		}
+	}
+	return s.isKept(k)
+}
@@ -0,0 +1,184 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestLastSeen(t *testing.T, key *FeeditemKey, lastSeen time.Time) {
+	value, err := lastSeen.MarshalBinary()
+	assert.NoError(t, err)
+	err = dbService.db.Put(createLastSeenKey(key.CreateKey()), value)
+	assert.NoError(t, err)
+}
+
+func createRetentionTestItems(t *testing.T, feedURL string, count int) []*Feeditem {
+	items := make([]*Feeditem, count)
+	for i := range items {
+		items[i] = &Feeditem{
+			Title:    fmt.Sprintf("t%v", i),
+			URL:      fmt.Sprintf("http://item%v", i),
+			Date:     time.Date(2019, time.February, 16, 23, i, 0, 0, time.UTC),
+			Contents: fmt.Sprintf("c%v", i),
+			Key:      &FeeditemKey{FeedURL: feedURL, GUID: fmt.Sprintf("g%v", i)},
+		}
+	}
+	err := dbService.SaveFeeditems(items...)
+	assert.NoError(t, err)
+	return items
+}
+
+func assertItemsExist(t *testing.T, items []*Feeditem, expected ...bool) {
+	for i, item := range items {
+		dbItem, err := dbService.GetFeeditem(item.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[i], dbItem != nil, item.Key.GUID)
+	}
+}
+
+func TestRetentionPolicyOverrideMerge(t *testing.T) {
+	global := RetentionPolicy{MaxAgeDays: 14}
+	user := global.override(RetentionPolicy{MaxItems: 10})
+	assert.Equal(t, RetentionPolicy{MaxAgeDays: 14, MaxItems: 10}, user)
+	feed := user.override(RetentionPolicy{MaxAgeDays: 2})
+	assert.Equal(t, RetentionPolicy{MaxAgeDays: 2, MaxItems: 10}, feed)
+
+	assert.Equal(t, RetentionPolicy{MaxAgeDays: 14, MaxItems: 20}, feed.merge(RetentionPolicy{MaxAgeDays: 14, MaxItems: 20}))
+	assert.Equal(t, RetentionPolicy{MaxAgeDays: 2, MaxItems: 0}, feed.merge(RetentionPolicy{MaxAgeDays: 1}))
+
+	assert.NoError(t, feed.Validate())
+	assert.Error(t, RetentionPolicy{MaxAgeDays: -1}.Validate())
+	assert.Error(t, RetentionPolicy{MaxItems: -1}.Validate())
+}
+
+func TestDefaultRetentionPolicy(t *testing.T) {
+	t.Setenv("RETENTION_MAX_AGE_DAYS", "30")
+	t.Setenv("RETENTION_MAX_ITEMS", "invalid")
+
+	assert.Equal(t, RetentionPolicy{MaxAgeDays: 30}, DefaultOptions().Retention)
+
+	service, err := Open(Options{InMemory: true})
+	assert.NoError(t, err)
+	defer service.Close()
+	assert.Equal(t, RetentionPolicy{MaxAgeDays: 14}, service.GetDefaultRetentionPolicy())
+}
+
+func TestApplyRetentionMaxAge(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed1", RetentionPolicy: RetentionPolicy{MaxAgeDays: 2}}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	items := createRetentionTestItems(t, "http://feed1", 4)
+	now := time.Now()
+	setTestLastSeen(t, items[0].Key, now.Add(-3*24*time.Hour))
+	setTestLastSeen(t, items[1].Key, now.Add(-3*24*time.Hour))
+	setTestLastSeen(t, items[2].Key, now.Add(-3*24*time.Hour))
+	setTestLastSeen(t, items[3].Key, now.Add(-24*time.Hour))
+
+	for _, item := range items {
+		err = dbService.SetReadStatus(user, item.Key.CreateKey(), true)
+		assert.NoError(t, err)
+	}
+	// Unread and starred items should be kept.
+	err = dbService.SetReadStatus(user, items[1].Key.CreateKey(), false)
+	assert.NoError(t, err)
+	err = dbService.SetStarred(user, items[2].Key.CreateKey(), true)
+	assert.NoError(t, err)
+
+	err = dbService.applyRetentionPolicies()
+	assert.NoError(t, err)
+
+	assertItemsExist(t, items, false, true, true, true)
+
+	dbItems, err := dbService.GetFeeditems(user)
+	assert.NoError(t, err)
+	assert.Len(t, dbItems, 3)
+}
+
+func TestApplyRetentionMaxItems(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed1"}}
+	user.MaxItems = 2
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	items := createRetentionTestItems(t, "http://feed1", 5)
+	now := time.Now()
+	for _, item := range items[:3] {
+		setTestLastSeen(t, item.Key, now.Add(-2*24*time.Hour))
+	}
+	for _, item := range items[:4] {
+		err = dbService.SetReadStatus(user, item.Key.CreateKey(), true)
+		assert.NoError(t, err)
+	}
+
+	err = dbService.applyRetentionPolicies()
+	assert.NoError(t, err)
+
+	// Items 3 and 4 are the newest; older items are over the limit and no longer in the feed.
+	assertItemsExist(t, items, false, false, false, true, true)
+}
+
+func TestApplyRetentionMaxItemsStillInFeed(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed1", RetentionPolicy: RetentionPolicy{MaxItems: 1}}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	items := createRetentionTestItems(t, "http://feed1", 3)
+	for _, item := range items {
+		err = dbService.SetReadStatus(user, item.Key.CreateKey(), true)
+		assert.NoError(t, err)
+	}
+
+	err = dbService.applyRetentionPolicies()
+	assert.NoError(t, err)
+
+	// Items which are still in the feed would be fetched again, and should be kept.
+	assertItemsExist(t, items, true, true, true)
+}
+
+func TestApplyRetentionSharedFeed(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user1 := NewUser("user01")
+	user1.Subscriptions = []UserFeed{{URL: "http://feed1"}}
+	user1.MaxAgeDays = 1
+	err = dbService.SaveUser(user1)
+	assert.NoError(t, err)
+	user2 := NewUser("user02")
+	user2.Subscriptions = []UserFeed{{URL: "http://feed1", RetentionPolicy: RetentionPolicy{MaxAgeDays: 5}}}
+	err = dbService.SaveUser(user2)
+	assert.NoError(t, err)
+
+	items := createRetentionTestItems(t, "http://feed1", 2)
+	now := time.Now()
+	setTestLastSeen(t, items[0].Key, now.Add(-6*24*time.Hour))
+	setTestLastSeen(t, items[1].Key, now.Add(-3*24*time.Hour))
+	for _, item := range items {
+		for _, user := range []*User{user1, user2} {
+			err = dbService.SetReadStatus(user, item.Key.CreateKey(), true)
+			assert.NoError(t, err)
+		}
+	}
+
+	err = dbService.applyRetentionPolicies()
+	assert.NoError(t, err)
+
+	// Items are kept as long as any subscriber's policy requires.
+	assertItemsExist(t, items, false, true)
+}
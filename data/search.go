@@ -0,0 +1,469 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+)
+
+// The search index is an inverted index: every term key references all items containing the term.
+// Tokens of every indexed item are stored in a separate search document, which is used
+// to remove the item from the index, match phrases and rank results.
+
+// maxTermLength is the maximum length of an indexed term; longer terms are ignored.
+const maxTermLength = 64
+
+// titleWeight is how much more a term in the title affects the rank, compared to the same term in contents.
+const titleWeight = 2
+
+// defaultSearchLimit is the maximum number of results returned if the limit is not specified.
+const defaultSearchLimit = 50
+
+// searchDocument contains the tokens of an indexed item.
+type searchDocument struct {
+	Title    []string
+	Contents []string
+}
+
+// encode serializes a searchDocument.
+func (document *searchDocument) encode() ([]byte, error) {
+	var value bytes.Buffer
+	if err := gob.NewEncoder(&value).Encode(document); err != nil {
+		return nil, err
+	}
+	return value.Bytes(), nil
+}
+
+// decode deserializes a searchDocument.
+func (document *searchDocument) decode(val []byte) error {
+	return gob.NewDecoder(bytes.NewBuffer(val)).Decode(document)
+}
+
+// terms returns all unique terms in document.
+func (document *searchDocument) terms() map[string]bool {
+	terms := make(map[string]bool, len(document.Title)+len(document.Contents))
+	for _, term := range document.Title {
+		terms[term] = true
+	}
+	for _, term := range document.Contents {
+		terms[term] = true
+	}
+	return terms
+}
+
+// tokenize splits text into lowercase terms.
+func tokenize(text string) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	terms := tokens[:0]
+	for _, token := range tokens {
+		if len(token) <= maxTermLength {
+			terms = append(terms, token)
+		}
+	}
+	return terms
+}
+
+// htmlText returns the text contents of an HTML fragment.
+func htmlText(contents string) string {
+	var text strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(contents))
+	skip := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return text.String()
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			skip = string(name) == "script" || string(name) == "style"
+			text.WriteString(" ")
+		case html.EndTagToken, html.SelfClosingTagToken:
+			skip = false
+			text.WriteString(" ")
+		case html.TextToken:
+			if !skip {
+				text.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+// indexDocument adds an item to the search index, replacing the previous version.
+func (s *DBService) indexDocument(key []byte, title, contents string) error {
+	if err := s.unindexDocument(key); err != nil {
+		return err
+	}
+
+	document := &searchDocument{Title: tokenize(title), Contents: tokenize(contents)}
+	for term := range document.terms() {
+		if err := s.addReferencedKey(createSearchTermKey(term), key); err != nil {
+			return fmt.Errorf("failed to add item to search index of term %v: %w", term, err)
+		}
+	}
+
+	value, err := document.encode()
+	if err != nil {
+		return fmt.Errorf("cannot marshal search document: %w", err)
+	}
+	if err := s.db.Put(createSearchDocumentKey(key), value); err != nil {
+		return fmt.Errorf("cannot save search document: %w", err)
+	}
+	return s.addReferencedKey([]byte(searchDocumentPrefix), key)
+}
+
+// getSearchDocument returns the indexed search document for key, or nil if the item is not indexed.
+func (s *DBService) getSearchDocument(key []byte) (*searchDocument, error) {
+	value, err := s.db.Get(createSearchDocumentKey(key))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	document := &searchDocument{}
+	if err := document.decode(value); err != nil {
+		return nil, fmt.Errorf("cannot decode search document %v: %w", string(key), err)
+	}
+	return document, nil
+}
+
+// unindexDocument removes an item from the search index.
+func (s *DBService) unindexDocument(key []byte) error {
+	document, err := s.getSearchDocument(key)
+	if err != nil || document == nil {
+		return err
+	}
+
+	for term := range document.terms() {
+		if err := s.deleteReferencedKey(createSearchTermKey(term), key); err != nil {
+			return fmt.Errorf("failed to delete item from search index of term %v: %w", term, err)
+		}
+	}
+	if err := s.db.Delete(createSearchDocumentKey(key)); err != nil {
+		return fmt.Errorf("cannot delete search document: %w", err)
+	}
+	return s.deleteReferencedKey([]byte(searchDocumentPrefix), key)
+}
+
+// SearchQuery specifies which items should be returned by Search.
+type SearchQuery struct {
+	// Query contains the search terms; terms in double quotes are matched as a phrase.
+	// All terms and phrases must be present in the item.
+	Query string
+	// FeedURL, if not empty, returns only items from this feed (or monitored page).
+	FeedURL string
+	// From and To, if not zero, return only items with a date in this range.
+	From time.Time
+	To   time.Time
+	// Read, if not nil, returns only read (or only unread) items.
+	Read *bool
+	// Limit is the maximum number of returned results.
+	Limit int
+}
+
+// SearchResult is an item matching a SearchQuery.
+type SearchResult struct {
+	Key   []byte
+	Title string
+	Date  time.Time
+	Read  bool
+	Score float64
+}
+
+// parseSearchQuery splits query into phrases; each phrase is a list of terms.
+// Terms outside of double quotes are returned as single-term phrases.
+func parseSearchQuery(query string) [][]string {
+	phrases := make([][]string, 0)
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			// Inside quotes.
+			if terms := tokenize(part); len(terms) > 0 {
+				phrases = append(phrases, terms)
+			}
+			continue
+		}
+		for _, term := range tokenize(part) {
+			phrases = append(phrases, []string{term})
+		}
+	}
+	return phrases
+}
+
+// containsPhrase returns true if tokens contain all terms of phrase in the same order.
+func containsPhrase(tokens, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(tokens); i++ {
+		match := true
+		for j := range phrase {
+			if tokens[i+j] != phrase[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// countTerm returns how many times term appears in tokens.
+func countTerm(tokens []string, term string) int {
+	count := 0
+	for _, token := range tokens {
+		if token == term {
+			count++
+		}
+	}
+	return count
+}
+
+// Search returns items matching query from the user's feeds and monitored pages, ordered by relevance.
+func (s *DBService) Search(user *User, query SearchQuery) ([]*SearchResult, error) {
+	var results []*SearchResult
+	err := s.view(func() error {
+		var err error
+		results, err = s.search(user, query)
+		return err
+	})
+	return results, err
+}
+
+// search returns items matching query, without acquiring a lock.
+func (s *DBService) search(user *User, query SearchQuery) ([]*SearchResult, error) {
+	phrases := parseSearchQuery(query.Query)
+	if len(phrases) == 0 {
+		return []*SearchResult{}, nil
+	}
+
+	// Only search in the user's subscriptions.
+	feeds := user.GetFeeds()
+	pages := user.GetPages()
+	subscriptions := make(map[string]bool, len(feeds)+len(pages))
+	for _, feed := range feeds {
+		if query.FeedURL == "" || query.FeedURL == feed.URL {
+			subscriptions[feed.ItemsURL()] = true
+		}
+	}
+	pageURLs := make(map[string]bool, len(pages))
+	for _, page := range pages {
+		if query.FeedURL == "" || query.FeedURL == page.URL {
+			pageURLs[string(page.CreateKey())] = true
+		}
+	}
+	isSubscribed := func(key []byte) bool {
+		if IsFeeditemKey(key) {
+			itemKey, err := DecodeFeeditemKey(key)
+			return err == nil && subscriptions[itemKey.FeedURL]
+		}
+		return IsPagemonitorKey(key) && pageURLs[string(key)]
+	}
+
+	// Find candidates which contain all terms.
+	termIDF := make(map[string]float64)
+	documentKeys, err := s.getReferencedKeys([]byte(searchDocumentPrefix))
+	if err != nil {
+		return nil, err
+	}
+	totalDocuments := float64(len(documentKeys))
+	var candidates map[string][]byte
+	for _, phrase := range phrases {
+		for _, term := range phrase {
+			if _, ok := termIDF[term]; ok {
+				continue
+			}
+			termKeys, err := s.getReferencedKeys(createSearchTermKey(term))
+			if err != nil {
+				return nil, err
+			}
+			termIDF[term] = math.Log(1 + totalDocuments/float64(len(termKeys)+1))
+
+			termCandidates := make(map[string][]byte, len(termKeys))
+			for _, key := range termKeys {
+				if candidates == nil || candidates[string(key)] != nil {
+					termCandidates[string(key)] = key
+				}
+			}
+			candidates = termCandidates
+		}
+	}
+
+	readItems, err := s.getReadItems(user)
+	if err != nil {
+		return nil, err
+	}
+	readStatuses := make(map[string]bool, len(readItems))
+	for _, key := range readItems {
+		readStatuses[string(key)] = true
+	}
+
+	results := make([]*SearchResult, 0)
+	for _, key := range candidates {
+		if !isSubscribed(key) {
+			continue
+		}
+		read := readStatuses[string(key)]
+		if query.Read != nil && *query.Read != read {
+			continue
+		}
+
+		document, err := s.getSearchDocument(key)
+		if err != nil {
+			return nil, err
+		}
+		if document == nil {
+			continue
+		}
+		phrasesMatch := true
+		for _, phrase := range phrases {
+			if len(phrase) > 1 && !containsPhrase(document.Title, phrase) && !containsPhrase(document.Contents, phrase) {
+				phrasesMatch = false
+				break
+			}
+		}
+		if !phrasesMatch {
+			continue
+		}
+
+		result, err := s.getSearchResult(key)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			continue
+		}
+		if (!query.From.IsZero() && result.Date.Before(query.From)) || (!query.To.IsZero() && result.Date.After(query.To)) {
+			continue
+		}
+		result.Read = read
+
+		for term, idf := range termIDF {
+			tf := titleWeight*countTerm(document.Title, term) + countTerm(document.Contents, term)
+			if tf > 0 {
+				result.Score += (1 + math.Log(float64(tf))) * idf
+			}
+		}
+		results = append(results, result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Date.After(results[j].Date)
+	})
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// getSearchResult returns the title and date of a feed item or page.
+// Returns nil if the item doesn't exist.
+func (s *DBService) getSearchResult(key []byte) (*SearchResult, error) {
+	value, err := s.db.Get(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	if IsFeeditemKey(key) {
+		feedItem := &Feeditem{}
+		if err := feedItem.decode(value); err != nil {
+			return nil, fmt.Errorf("cannot decode feed item %v: %w", string(key), err)
+		}
+		return &SearchResult{Key: key, Title: feedItem.Title, Date: feedItem.Date}, nil
+	}
+	page := &PagemonitorPage{}
+	if err := page.decode(value); err != nil {
+		return nil, fmt.Errorf("cannot decode page %v: %w", string(key), err)
+	}
+	return &SearchResult{Key: key, Date: page.Updated}, nil
+}
+
+// deleteStaleSearchDocuments removes all items which no longer exist from the search index.
+func (s *DBService) deleteStaleSearchDocuments() error {
+	var documentKeys [][]byte
+	err := s.view(func() error {
+		var err error
+		documentKeys, err = s.getReferencedKeys([]byte(searchDocumentPrefix))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range documentKeys {
+		// Use a separate transaction for every item to avoid blocking the database for too long.
+		err := s.update(func() error {
+			exists, err := s.db.Has(key)
+			if err != nil || exists {
+				return err
+			}
+			log.WithField("key", string(key)).Debug("Deleting stale search document")
+			return s.unindexDocument(key)
+		})
+		if err != nil {
+			log.WithField("key", string(key)).WithError(err).Error("Failed to delete stale search document")
+		}
+	}
+	return nil
+}
+
+// indexAllDocuments adds all existing feed items and pages to the search index.
+func (s *DBService) indexAllDocuments() error {
+	keys := make([][]byte, 0)
+	err := s.db.ForEach(func(key, value []byte) error {
+		keyString := string(key)
+		isItem := (IsFeeditemKey(key) && strings.Count(keyString, separator) == 2 && !strings.HasSuffix(keyString, separator)) ||
+			(IsPagemonitorKey(key) && strings.Count(keyString, separator) == 3)
+		if isItem && !strings.Contains(keyString, indexShardSeparator) {
+			keys = append(keys, append([]byte{}, key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if IsFeeditemKey(key) {
+			itemKey, err := DecodeFeeditemKey(key)
+			if err != nil {
+				log.WithField("key", string(key)).WithError(err).Error("Failed to decode feed item key")
+				continue
+			}
+			feedItem, err := s.getFeeditem(itemKey)
+			if err != nil || feedItem == nil {
+				log.WithField("key", string(key)).WithError(err).Error("Failed to get feed item")
+				continue
+			}
+			if err := s.indexDocument(key, feedItem.Title, htmlText(feedItem.Contents)); err != nil {
+				return err
+			}
+		} else {
+			pagemonitorKey, err := DecodePagemonitorKey(key)
+			if err != nil {
+				log.WithField("key", string(key)).WithError(err).Error("Failed to decode page key")
+				continue
+			}
+			page, err := s.getPage(pagemonitorKey)
+			if err != nil || page == nil {
+				log.WithField("key", string(key)).WithError(err).Error("Failed to get page")
+				continue
+			}
+			if err := s.indexDocument(key, "", page.Contents); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
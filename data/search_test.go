@@ -0,0 +1,260 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createSearchUser() *User {
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{
+		{URL: "http://feed1", Title: "Feed 1", Type: "rss"},
+		{URL: "http://feed2", Title: "Feed 2", Type: "rss"},
+	}
+	user.Pages = []UserPagemonitor{{URL: "http://site1", Title: "Site 1"}}
+	return user
+}
+
+func searchResultKeys(results []*SearchResult) []string {
+	keys := make([]string, len(results))
+	for i := range results {
+		keys[i] = string(results[i].Key)
+	}
+	return keys
+}
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"hello", "world", "2019", "привет"}, tokenize("Hello, World! 2019 Привет"))
+	assert.Equal(t, []string{}, tokenize(" ,. "))
+	assert.Equal(t, []string{"bold", "text", "more", "text"}, tokenize(htmlText(`<p><b>Bold</b>text<script>var x;</script><br/>more text</p>`)))
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	assert.Equal(t, [][]string{{"first"}, {"hello", "world"}, {"last"}}, parseSearchQuery(`First "Hello, world" last`))
+	assert.Equal(t, [][]string{{"unterminated", "phrase"}}, parseSearchQuery(`"unterminated phrase`))
+	assert.Equal(t, [][]string{}, parseSearchQuery(`"" ,`))
+}
+
+func TestSearch(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := createSearchUser()
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item1 := &Feeditem{
+		Title:    "Hello world",
+		Contents: "<p>Some text about the world</p>",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	item2 := &Feeditem{
+		Title:    "Other news",
+		Contents: "The world says hello",
+		Date:     time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed2", GUID: "g1"},
+	}
+	unsubscribedItem := &Feeditem{
+		Title:    "Hello world",
+		Contents: "Hello world",
+		Date:     time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed3", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item1, item2, unsubscribedItem)
+	assert.NoError(t, err)
+
+	page := &PagemonitorPage{
+		Contents: "Page says hello to the world",
+		Updated:  time.Date(2019, time.February, 15, 23, 0, 0, 0, time.UTC),
+		Config:   &UserPagemonitor{URL: "http://site1"},
+	}
+	err = dbService.SavePage(page)
+	assert.NoError(t, err)
+
+	err = dbService.SetReadStatus(user, item2.Key.CreateKey(), true)
+	assert.NoError(t, err)
+
+	results, err := dbService.Search(user, SearchQuery{Query: "hello world"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(item1.Key.CreateKey()), string(item2.Key.CreateKey()), string(page.Config.CreateKey())}, searchResultKeys(results))
+	assert.Equal(t, "Hello world", results[0].Title)
+	assert.Equal(t, item1.Date, results[0].Date)
+	assert.False(t, results[0].Read)
+	assert.True(t, results[1].Read)
+	assert.Equal(t, page.Updated, results[2].Date)
+
+	results, err = dbService.Search(user, SearchQuery{Query: `"world says"`})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(item2.Key.CreateKey())}, searchResultKeys(results))
+
+	results, err = dbService.Search(user, SearchQuery{Query: "hello", FeedURL: "http://feed1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(item1.Key.CreateKey())}, searchResultKeys(results))
+
+	results, err = dbService.Search(user, SearchQuery{Query: "world", FeedURL: "http://site1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(page.Config.CreateKey())}, searchResultKeys(results))
+
+	results, err = dbService.Search(user, SearchQuery{
+		Query: "world",
+		From:  time.Date(2019, time.February, 16, 0, 0, 0, 0, time.UTC),
+		To:    time.Date(2019, time.February, 17, 0, 0, 0, 0, time.UTC),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(item1.Key.CreateKey())}, searchResultKeys(results))
+
+	read := false
+	results, err = dbService.Search(user, SearchQuery{Query: "world", Read: &read, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(item1.Key.CreateKey())}, searchResultKeys(results))
+
+	results, err = dbService.Search(user, SearchQuery{Query: "missing"})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestSearchUpdatedItem(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := createSearchUser()
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item := &Feeditem{
+		Title:    "Old title",
+		Contents: "Old contents",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+
+	item.Title = "New title"
+	item.Contents = "New contents"
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+
+	results, err := dbService.Search(user, SearchQuery{Query: "old"})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = dbService.Search(user, SearchQuery{Query: "new contents"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(item.Key.CreateKey())}, searchResultKeys(results))
+
+	termKeys, err := dbService.getReferencedKeys(createSearchTermKey("old"))
+	assert.NoError(t, err)
+	assert.Empty(t, termKeys)
+}
+
+func TestSearchExpiredItem(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+	var oldTTL = itemTTL
+	itemTTL = time.Nanosecond * 0
+	defer func() { itemTTL = oldTTL }()
+
+	user := createSearchUser()
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item := &Feeditem{
+		Title: "Expired item",
+		Date:  time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Key:   &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+
+	err = dbService.SetFetchStatus((&UserFeed{URL: item.Key.FeedURL}).CreateKey(), &FetchStatus{LastSuccess: time.Time{}})
+	assert.NoError(t, err)
+	err = dbService.deleteStaleFetchStatuses()
+	assert.NoError(t, err)
+
+	results, err := dbService.Search(user, SearchQuery{Query: "expired"})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	documentKeys, err := dbService.getReferencedKeys([]byte(searchDocumentPrefix))
+	assert.NoError(t, err)
+	assert.Empty(t, documentKeys)
+}
+
+func TestDeleteStaleSearchDocuments(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := createSearchUser()
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item := &Feeditem{
+		Title: "Stale item",
+		Date:  time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Key:   &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+
+	err = dbService.db.Delete(item.Key.CreateKey())
+	assert.NoError(t, err)
+
+	err = dbService.deleteStaleSearchDocuments()
+	assert.NoError(t, err)
+
+	documentKeys, err := dbService.getReferencedKeys([]byte(searchDocumentPrefix))
+	assert.NoError(t, err)
+	assert.Empty(t, documentKeys)
+	termKeys, err := dbService.getReferencedKeys(createSearchTermKey("stale"))
+	assert.NoError(t, err)
+	assert.Empty(t, termKeys)
+}
+
+func TestIndexAllDocuments(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := createSearchUser()
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item := &Feeditem{
+		Title:    "Existing item",
+		Contents: "Existing contents",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+	page := &PagemonitorPage{
+		Contents: "Existing page",
+		Updated:  time.Date(2019, time.February, 15, 23, 0, 0, 0, time.UTC),
+		Config:   &UserPagemonitor{URL: "http://site1"},
+	}
+	err = dbService.SavePage(page)
+	assert.NoError(t, err)
+
+	// Simulate a database created before the search index was added.
+	err = dbService.update(func() error {
+		if err := dbService.unindexDocument(item.Key.CreateKey()); err != nil {
+			return err
+		}
+		return dbService.unindexDocument(page.Config.CreateKey())
+	})
+	assert.NoError(t, err)
+
+	results, err := dbService.Search(user, SearchQuery{Query: "existing"})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	err = dbService.update(dbService.indexAllDocuments)
+	assert.NoError(t, err)
+
+	results, err = dbService.Search(user, SearchQuery{Query: "existing"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{string(item.Key.CreateKey()), string(page.Config.CreateKey())}, searchResultKeys(results))
+}
@@ -7,6 +7,18 @@ import (
 // GetOrCreateConfigVariable returns the value for the varName ServerConfig variable,
 // or if there's no entry, uses generator to create and save a value.
 func (s *DBService) GetOrCreateConfigVariable(varName string, generator func() (string, error)) (string, error) {
+	var varValue string
+	err := s.update(func() error {
+		var err error
+		varValue, err = s.getOrCreateConfigVariable(varName, generator)
+		return err
+	})
+	return varValue, err
+}
+
+// getOrCreateConfigVariable returns the value for the varName ServerConfig variable,
+// or uses generator to create and save a value; without acquiring a lock.
+func (s *DBService) getOrCreateConfigVariable(varName string, generator func() (string, error)) (string, error) {
 	varKey := createServerConfigKey(varName)
 	value, err := s.db.Get(varKey)
 	if err != nil {
@@ -35,6 +47,13 @@ func (s *DBService) GetOrCreateConfigVariable(varName string, generator func() (
 
 // SetConfigVariable returns the value for the varName ServerConfig variable, or nil if no value is saved.
 func (s *DBService) SetConfigVariable(varName, varValue string) error {
+	return s.update(func() error {
+		return s.setConfigVariable(varName, varValue)
+	})
+}
+
+// setConfigVariable sets the value for the varName ServerConfig variable, without acquiring a lock.
+func (s *DBService) setConfigVariable(varName, varValue string) error {
 	varKey := createServerConfigKey(varName)
 	if err := s.addReferencedKey([]byte(serverConfigKeyPrefix), []byte(varName)); err != nil {
 		return err
@@ -47,6 +66,17 @@ func (s *DBService) SetConfigVariable(varName, varValue string) error {
 
 // GetAllConfigVariables returns all ServerConfig variables in a key-value map.
 func (s *DBService) GetAllConfigVariables() (map[string]string, error) {
+	var vars map[string]string
+	err := s.view(func() error {
+		var err error
+		vars, err = s.getAllConfigVariables()
+		return err
+	})
+	return vars, err
+}
+
+// getAllConfigVariables returns all ServerConfig variables in a key-value map, without acquiring a lock.
+func (s *DBService) getAllConfigVariables() (map[string]string, error) {
 	indexKeys, err := s.getReferencedKeys([]byte(serverConfigKeyPrefix))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get index for server config keys: %w", err)
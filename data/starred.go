@@ -0,0 +1,113 @@
+package data
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Starred items are never deleted by GC, even if the user is no longer subscribed to their feed.
+
+// GetStarredItems returns a list of items this user has starred.
+func (s *DBService) GetStarredItems(user *User) ([]itemKey, error) {
+	var items []itemKey
+	err := s.view(func() error {
+		var err error
+		items, err = s.getStarredItems(user)
+		return err
+	})
+
+	return items, err
+}
+
+// getStarredItems returns a list of items this user has starred, without acquiring a lock.
+func (s *DBService) getStarredItems(user *User) ([]itemKey, error) {
+	items, err := s.getReferencedKeys(user.createStarredPrefix())
+	if err != nil {
+		log.WithField("username", user.username).WithError(err).Error("Failed to get starred items index")
+		return nil, err
+	}
+	return items, nil
+}
+
+// setStarred stars or unstars an item, without acquiring a lock.
+func (s *DBService) setStarred(user *User, k itemKey, starred bool) error {
+	starredPrefix := user.createStarredPrefix()
+	if starred {
+		return s.addReferencedKey(starredPrefix, k)
+	}
+	return s.deleteReferencedKey(starredPrefix, k)
+}
+
+// SetStarred stars or unstars an item, true for starred, false for not starred.
+func (s *DBService) SetStarred(user *User, k itemKey, starred bool) error {
+	return s.update(func() error {
+		return s.setStarred(user, k, starred)
+	})
+}
+
+// isStarred returns true if any user has starred item k.
+func (s *DBService) isStarred(k itemKey) (bool, error) {
+	usernames, err := s.getUsers()
+	if err != nil {
+		return false, err
+	}
+	for _, username := range usernames {
+		user := User{username: username}
+		starred, err := s.hasReferencedKey(user.createStarredPrefix(), k)
+		if err != nil || starred {
+			return starred, err
+		}
+	}
+	return false, nil
+}
+
+// renameStarred moves starred items to the new username.
+func (s *DBService) renameStarred(user *User) error {
+	oldStarredIndexKey := user.createStarredPrefix()
+	newUser := &User{username: user.newUsername}
+	newStarredIndexKey := newUser.createStarredPrefix()
+
+	starredItems, err := s.getStarredItems(user)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.updateReferencedKeys(newStarredIndexKey, starredItems, true); err != nil {
+		log.WithField("user", newUser.username).WithError(err).Error("Failed to add starred items to index for new username")
+		return err
+	}
+
+	if err := s.deleteIndex(oldStarredIndexKey); err != nil {
+		log.WithField("user", user.username).WithError(err).Error("Failed to delete old username starred index")
+		return err
+	}
+	return nil
+}
+
+// getStarredFeeditems returns all feed items starred by user, without acquiring a lock.
+func (s *DBService) getStarredFeeditems(user *User) ([]*Feeditem, error) {
+	starredItems, err := s.getStarredItems(user)
+	if err != nil {
+		return nil, err
+	}
+
+	feedItems := make([]*Feeditem, 0, len(starredItems))
+	for _, k := range starredItems {
+		if !IsFeeditemKey(k) {
+			continue
+		}
+		itemKey, err := DecodeFeeditemKey(k)
+		if err != nil {
+			log.WithField("key", string(k)).WithError(err).Error("Failed to decode starred item key")
+			continue
+		}
+		feedItem, err := s.getFeeditem(itemKey)
+		if err != nil {
+			log.WithField("key", string(k)).WithError(err).Error("Failed to get starred item")
+			continue
+		}
+		if feedItem != nil {
+			feedItems = append(feedItems, feedItem)
+		}
+	}
+	return feedItems, nil
+}
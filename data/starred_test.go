@@ -0,0 +1,177 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveGetStarred(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	dbStarredItems, err := dbService.GetStarredItems(&user)
+	assert.NoError(t, err)
+	assert.Empty(t, dbStarredItems)
+
+	key1 := []byte("i1")
+	key2 := []byte("i2")
+
+	err = dbService.SetStarred(&user, key1, true)
+	assert.NoError(t, err)
+	err = dbService.SetStarred(&user, key2, true)
+	assert.NoError(t, err)
+	err = dbService.SetStarred(&user, key1, false)
+	assert.NoError(t, err)
+
+	dbStarredItems, err = dbService.GetStarredItems(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{key2}, dbStarredItems)
+
+	otherUser := User{username: "user02"}
+	dbStarredItems, err = dbService.GetStarredItems(&otherUser)
+	assert.NoError(t, err)
+	assert.Empty(t, dbStarredItems)
+}
+
+func TestStarredItemTTLExpired(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+	var oldTTL = itemTTL
+	itemTTL = time.Nanosecond * 0
+	defer func() { itemTTL = oldTTL }()
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	starredItem := &Feeditem{
+		Title:    "t1",
+		URL:      "http://item1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c1",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	item := &Feeditem{
+		Title:    "t2",
+		URL:      "http://item2",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c2",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g2"},
+	}
+	feedKey := &UserFeed{URL: item.Key.FeedURL}
+	err = dbService.SaveFeeditems(starredItem, item)
+	assert.NoError(t, err)
+	err = dbService.SetStarred(user, starredItem.Key.CreateKey(), true)
+	assert.NoError(t, err)
+
+	err = dbService.SetFetchStatus(feedKey.CreateKey(), &FetchStatus{LastSuccess: time.Time{}})
+	assert.NoError(t, err)
+
+	err = dbService.deleteStaleFetchStatuses()
+	assert.NoError(t, err)
+
+	dbItem, err := dbService.GetFeeditem(item.Key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbItem)
+
+	dbItem, err = dbService.GetFeeditem(starredItem.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, starredItem, dbItem)
+
+	// Fetch status should be kept until the item is unstarred.
+	fetchStatus, err := dbService.GetFetchStatus(feedKey.CreateKey())
+	assert.NoError(t, err)
+	assert.NotNil(t, fetchStatus)
+
+	err = dbService.SetStarred(user, starredItem.Key.CreateKey(), false)
+	assert.NoError(t, err)
+
+	err = dbService.deleteStaleFetchStatuses()
+	assert.NoError(t, err)
+
+	dbItem, err = dbService.GetFeeditem(starredItem.Key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbItem)
+
+	fetchStatus, err = dbService.GetFetchStatus(feedKey.CreateKey())
+	assert.NoError(t, err)
+	assert.Nil(t, fetchStatus)
+}
+
+func TestRenameUserStarred(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	key := []byte("i1")
+	err = dbService.SetStarred(user, key, true)
+	assert.NoError(t, err)
+
+	err = user.SetUsername("user02")
+	assert.NoError(t, err)
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	dbStarredItems, err := dbService.GetStarredItems(user)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{key}, dbStarredItems)
+
+	oldUser := User{username: "user01"}
+	dbStarredItems, err = dbService.GetStarredItems(&oldUser)
+	assert.NoError(t, err)
+	assert.Empty(t, dbStarredItems)
+
+	value, err := dbService.db.Get(oldUser.createStarredPrefix())
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestBackupStarredUnsubscribed(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed2"}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item := &Feeditem{
+		Title:    "t1",
+		URL:      "http://item1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c1",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+	err = dbService.SetStarred(user, item.Key.CreateKey(), true)
+	assert.NoError(t, err)
+
+	var backup bytes.Buffer
+	err = dbService.Backup(&backup, BackupOptions{})
+	assert.NoError(t, err)
+
+	err = resetDb()
+	assert.NoError(t, err)
+	_, err = dbService.Restore(&backup, RestoreOptions{})
+	assert.NoError(t, err)
+
+	dbItem, err := dbService.GetFeeditem(item.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, item, dbItem)
+
+	starredItems, err := dbService.GetStarredItems(user)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{item.Key.CreateKey()}, starredItems)
+}
@@ -0,0 +1,365 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// folderSeparator separates nested folders in UserFeed.Folder.
+// A folder name containing the separator (or folderEscape) is escaped with folderEscape, for example `AC\/DC`.
+const folderSeparator = "/"
+
+// folderEscape escapes the folder separator in folder names.
+const folderEscape = `\`
+
+// folderNameEscaper escapes a folder name, so that it can be joined with folderSeparator.
+var folderNameEscaper = strings.NewReplacer(folderEscape, folderEscape+folderEscape, folderSeparator, folderEscape+folderSeparator)
+
+// splitFolder returns the unescaped names of folder and all of its parent folders, starting with the topmost parent.
+// folderEscape is kept as-is unless it's followed by folderSeparator or another folderEscape.
+func splitFolder(folder string) []string {
+	names := make([]string, 0)
+	var name strings.Builder
+	for i := 0; i < len(folder); i++ {
+		rest := folder[i:]
+		if strings.HasPrefix(rest, folderEscape+folderSeparator) || strings.HasPrefix(rest, folderEscape+folderEscape) {
+			i += len(folderEscape)
+			name.WriteByte(folder[i])
+		} else if strings.HasPrefix(rest, folderSeparator) {
+			names = append(names, name.String())
+			name.Reset()
+		} else {
+			name.WriteByte(folder[i])
+		}
+	}
+	return append(names, name.String())
+}
+
+// joinFolder escapes folder names and joins them into a nested folder.
+func joinFolder(names []string) string {
+	escapedNames := make([]string, len(names))
+	for i, name := range names {
+		escapedNames[i] = folderNameEscaper.Replace(name)
+	}
+	return strings.Join(escapedNames, folderSeparator)
+}
+
+// opmlOutline is an outline element in OPML; outlines without an xmlUrl are folders.
+type opmlOutline struct {
+	Text string `xml:"text,attr,omitempty"`
+	UserFeed
+	Children []*opmlOutline `xml:"outline"`
+}
+
+// opmlDocument is the toplevel OPML element.
+type opmlDocument struct {
+	XMLName  xml.Name       `xml:"opml"`
+	Version  string         `xml:"version,attr"`
+	Outlines []*opmlOutline `xml:"body>outline"`
+}
+
+// normalize trims whitespace from feed's values and uses the URL as the title if no title is set.
+func (feed *UserFeed) normalize() {
+	feed.URL = strings.TrimSpace(feed.URL)
+	feed.Title = strings.TrimSpace(feed.Title)
+	if feed.Title == "" {
+		feed.Title = feed.URL
+	}
+	folders := splitFolder(feed.Folder)
+	nonEmptyFolders := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		if folder = strings.TrimSpace(folder); folder != "" {
+			nonEmptyFolders = append(nonEmptyFolders, folder)
+		}
+	}
+	feed.Folder = joinFolder(nonEmptyFolders)
+}
+
+// Validate checks that feed has a valid URL and retention policy.
+func (feed *UserFeed) Validate() error {
+	if feed.URL == "" {
+		return fmt.Errorf("feed URL cannot be empty")
+	}
+	feedURL, err := url.Parse(feed.URL)
+	if err != nil {
+		return fmt.Errorf("cannot parse feed URL %v: %w", feed.URL, err)
+	}
+	if !feedURL.IsAbs() {
+		return fmt.Errorf("feed URL %v is not absolute", feed.URL)
+	}
+	return feed.RetentionPolicy.Validate()
+}
+
+// ItemsURL returns the FeedURL used by the feed's items.
+// Sitemaps are filtered by PathPrefix when they're fetched, so that each combination of URL and path prefixes
+// gets its own items, and subscribers with different prefixes don't share items.
+func (feed *UserFeed) ItemsURL() string {
+	prefixes := strings.Fields(feed.PathPrefix)
+	if len(prefixes) == 0 {
+		return feed.URL
+	}
+	sort.Strings(prefixes)
+	return feed.URL + "#" + strings.Join(prefixes, " ")
+}
+
+// FolderContains returns true if folder is parent or one of its nested folders.
+// Both folders should be normalized, so that an escaped separator cannot be mistaken for a nested folder.
+func FolderContains(parent, folder string) bool {
+	return folder == parent || strings.HasPrefix(folder, parent+folderSeparator)
+}
+
+// GetFolders returns all folders containing the user's subscriptions, including their parent folders, sorted by name.
+func (user *User) GetFolders() []string {
+	folders := make(map[string]bool)
+	for _, feed := range user.Subscriptions {
+		if feed.Folder == "" {
+			continue
+		}
+		names := splitFolder(feed.Folder)
+		for i := range names {
+			folders[joinFolder(names[:i+1])] = true
+		}
+	}
+	sortedFolders := make([]string, 0, len(folders))
+	for folder := range folders {
+		sortedFolders = append(sortedFolders, folder)
+	}
+	sort.Strings(sortedFolders)
+	return sortedFolders
+}
+
+// GetFeeds returns a copy of all of the user's subscriptions.
+func (user *User) GetFeeds() []UserFeed {
+	return append([]UserFeed{}, user.Subscriptions...)
+}
+
+// findFeed returns the index of the subscription to feedURL, or -1 if the user is not subscribed to feedURL.
+func (user *User) findFeed(feedURL string) int {
+	for i := range user.Subscriptions {
+		if user.Subscriptions[i].URL == feedURL {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddFeed adds a subscription to feed, unless a feed with the same URL already exists.
+// Returns true if the subscriptions were changed; the changes will be saved when SaveUser is called.
+func (user *User) AddFeed(feed UserFeed) (bool, error) {
+	feed.normalize()
+	if err := feed.Validate(); err != nil {
+		return false, err
+	}
+	if user.findFeed(feed.URL) >= 0 {
+		return false, nil
+	}
+	user.Subscriptions = append(user.Subscriptions, feed)
+	return true, nil
+}
+
+// AddUserFeed adds a subscription to feed for username, unless a feed with the same URL already exists.
+// The user is loaded and saved in a single transaction, so that concurrent changes to the user are not lost.
+func (s *DBService) AddUserFeed(username string, feed UserFeed) error {
+	return s.update(func() error {
+		user, err := s.getUser(username)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return fmt.Errorf("user %v doesn't exist", username)
+		}
+		changed, err := user.AddFeed(feed)
+		if err != nil || !changed {
+			return err
+		}
+		return s.saveUser(user)
+	})
+}
+
+// UpdateFeed replaces the subscription to feedURL with feed.
+// The changes will be saved when SaveUser is called.
+func (user *User) UpdateFeed(feedURL string, feed UserFeed) error {
+	index := user.findFeed(feedURL)
+	if index < 0 {
+		return fmt.Errorf("feed %v doesn't exist", feedURL)
+	}
+	feed.normalize()
+	if err := feed.Validate(); err != nil {
+		return err
+	}
+	if feed.URL != feedURL && user.findFeed(feed.URL) >= 0 {
+		return fmt.Errorf("feed %v already exists", feed.URL)
+	}
+	user.Subscriptions[index] = feed
+	return nil
+}
+
+// DeleteFeed removes the subscription to feedURL.
+// Returns false if the user is not subscribed to feedURL; the changes will be saved when SaveUser is called.
+func (user *User) DeleteFeed(feedURL string) bool {
+	index := user.findFeed(feedURL)
+	if index < 0 {
+		return false
+	}
+	user.Subscriptions = append(user.Subscriptions[:index], user.Subscriptions[index+1:]...)
+	return true
+}
+
+// ImportOPML adds all feeds from opml to the user's subscriptions, and returns the number of added feeds.
+// Feeds which already exist are updated. If replace is true, all other subscriptions are removed.
+// A successful import also clears the OPML which couldn't be converted from a previous version.
+// The changes will be saved when SaveUser is called.
+func (user *User) ImportOPML(opml string, replace bool) (int, error) {
+	feeds, err := parseOPML(opml)
+	if err != nil {
+		return 0, err
+	}
+	for i := range feeds {
+		feeds[i].normalize()
+		if err := feeds[i].Validate(); err != nil {
+			return 0, fmt.Errorf("invalid feed %v: %w", feeds[i].Title, err)
+		}
+	}
+
+	if replace {
+		user.Subscriptions = nil
+	}
+	added := 0
+	for _, feed := range feeds {
+		if index := user.findFeed(feed.URL); index >= 0 {
+			user.Subscriptions[index] = feed
+			continue
+		}
+		user.Subscriptions = append(user.Subscriptions, feed)
+		added++
+	}
+	user.Opml = ""
+	return added, nil
+}
+
+// ExportOPML returns the user's subscriptions in the OPML format.
+func (user *User) ExportOPML() (string, error) {
+	document := &opmlDocument{Version: "1.0", Outlines: []*opmlOutline{}}
+	folders := make(map[string]*opmlOutline)
+
+	var findFolder func(folder string) *[]*opmlOutline
+	findFolder = func(folder string) *[]*opmlOutline {
+		if folder == "" {
+			return &document.Outlines
+		}
+		if outline, ok := folders[folder]; ok {
+			return &outline.Children
+		}
+		names := splitFolder(folder)
+		parent, title := joinFolder(names[:len(names)-1]), names[len(names)-1]
+		outline := &opmlOutline{Text: title, UserFeed: UserFeed{Title: title}}
+		parentOutlines := findFolder(parent)
+		*parentOutlines = append(*parentOutlines, outline)
+		folders[folder] = outline
+		return &outline.Children
+	}
+
+	for _, feed := range user.Subscriptions {
+		outlines := findFolder(feed.Folder)
+		*outlines = append(*outlines, &opmlOutline{Text: feed.Title, UserFeed: feed})
+	}
+
+	opml, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal opml: %w", err)
+	}
+	return xml.Header + string(opml), nil
+}
+
+// parseOPML returns all feeds from opml.
+// Outlines without a feed URL are treated as folders.
+func parseOPML(opml string) ([]UserFeed, error) {
+	document := &opmlDocument{}
+	if err := xml.Unmarshal([]byte(opml), document); err != nil {
+		return nil, fmt.Errorf("cannot parse opml xml: %w", err)
+	}
+	feeds := []UserFeed{}
+	var findFeeds func(outlines []*opmlOutline, folder string)
+	findFeeds = func(outlines []*opmlOutline, folder string) {
+		for _, outline := range outlines {
+			if outline.Title == "" {
+				outline.Title = outline.Text
+			}
+			if outline.URL != "" {
+				feed := outline.UserFeed
+				feed.Folder = folder
+				feeds = append(feeds, feed)
+				continue
+			}
+			childFolder := folderNameEscaper.Replace(strings.TrimSpace(outline.Title))
+			if folder != "" && childFolder != "" {
+				childFolder = folder + folderSeparator + childFolder
+			} else if childFolder == "" {
+				childFolder = folder
+			}
+			findFeeds(outline.Children, childFolder)
+		}
+	}
+	findFeeds(document.Outlines, "")
+	return feeds, nil
+}
+
+// convertOPMLSubscriptions converts the OPML string of all users into structured subscriptions.
+// Feeds are validated in the same way as ImportOPML does.
+// Users with an invalid OPML are not converted, and keep the original OPML in User.Opml until it's fixed and imported.
+func (s *DBService) convertOPMLSubscriptions() error {
+	// legacyUser is the User record at this schema version; fields converted by later migrations are kept as-is.
+	type legacyUser struct {
+		Password      string
+		Opml          string
+		Subscriptions []UserFeed
+		Pagemonitor   string
+		MailToken     string
+		Admin         bool
+		RetentionPolicy
+	}
+
+	usernames, err := s.getUsers()
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		value, err := s.db.Get(createUserKey(username))
+		if err != nil {
+			return fmt.Errorf("cannot read User %v: %w", username, err)
+		}
+		if value == nil {
+			continue
+		}
+		legacy := &legacyUser{}
+		if err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(legacy); err != nil {
+			return fmt.Errorf("cannot decode User %v: %w", username, err)
+		}
+		if strings.TrimSpace(legacy.Opml) == "" {
+			continue
+		}
+
+		imported := &User{}
+		if _, err := imported.ImportOPML(legacy.Opml, false); err != nil {
+			log.WithField("username", username).WithField("opml", legacy.Opml).WithError(err).Error("Failed to convert OPML into subscriptions, it will be kept until the user imports it again")
+			continue
+		}
+		legacy.Opml = ""
+		legacy.Subscriptions = imported.Subscriptions
+		var converted bytes.Buffer
+		if err := gob.NewEncoder(&converted).Encode(legacy); err != nil {
+			return fmt.Errorf("cannot encode User %v: %w", username, err)
+		}
+		if err := s.db.Put(createUserKey(username), converted.Bytes()); err != nil {
+			return fmt.Errorf("cannot save User %v: %w", username, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testOPML = `<opml version="1.0">` +
+	`<head><title>My OPML list</title></head>` +
+	`<body>` +
+	`<outline text="Sites" title="Sites"><outline text="Site 1" title="Site 1" type="rss" xmlUrl="http://sites-site1.com" htmlUrl="http://sites-site1.com"/></outline>` +
+	`<outline text="Updates" title="Updates">` +
+	`<outline text="Site 2" title="Site 2" type="rss" xmlUrl="http://updates-site2.com" htmlUrl="http://updates-site2.com"/>` +
+	`<outline text="Nested">` +
+	`<outline text="Site 3" type="rss" xmlUrl="http://updates-site3.com" maxAgeDays="5"/>` +
+	`</outline>` +
+	`</outline>` +
+	`<outline text="Site 4" title="Site 4" xmlUrl="http://site4.com"/>` +
+	`</body>` +
+	`</opml>`
+
+func TestParseOPML(t *testing.T) {
+	feeds, err := parseOPML(testOPML)
+	assert.NoError(t, err)
+	assert.Equal(t, []UserFeed{
+		{URL: "http://sites-site1.com", Title: "Site 1", Type: "rss", Folder: "Sites"},
+		{URL: "http://updates-site2.com", Title: "Site 2", Type: "rss", Folder: "Updates"},
+		{URL: "http://updates-site3.com", Title: "Site 3", Type: "rss", Folder: "Updates/Nested", RetentionPolicy: RetentionPolicy{MaxAgeDays: 5}},
+		{URL: "http://site4.com", Title: "Site 4"},
+	}, feeds)
+
+	_, err = parseOPML(`<opml version="1.0"><body><outline`)
+	assert.Error(t, err)
+}
+
+func TestImportExportOPML(t *testing.T) {
+	user := &User{Subscriptions: []UserFeed{{URL: "http://site4.com", Title: "Old title"}, {URL: "http://site5.com", Title: "Site 5"}}}
+
+	added, err := user.ImportOPML(testOPML, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, added)
+	assert.Equal(t, []UserFeed{
+		{URL: "http://site4.com", Title: "Site 4"},
+		{URL: "http://site5.com", Title: "Site 5"},
+		{URL: "http://sites-site1.com", Title: "Site 1", Type: "rss", Folder: "Sites"},
+		{URL: "http://updates-site2.com", Title: "Site 2", Type: "rss", Folder: "Updates"},
+		{URL: "http://updates-site3.com", Title: "Site 3", Type: "rss", Folder: "Updates/Nested", RetentionPolicy: RetentionPolicy{MaxAgeDays: 5}},
+	}, user.Subscriptions)
+
+	opml, err := user.ExportOPML()
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <body>
+    <outline text="Site 4" xmlUrl="http://site4.com" title="Site 4"></outline>
+    <outline text="Site 5" xmlUrl="http://site5.com" title="Site 5"></outline>
+    <outline text="Sites" title="Sites">
+      <outline text="Site 1" xmlUrl="http://sites-site1.com" title="Site 1" type="rss"></outline>
+    </outline>
+    <outline text="Updates" title="Updates">
+      <outline text="Site 2" xmlUrl="http://updates-site2.com" title="Site 2" type="rss"></outline>
+      <outline text="Nested" title="Nested">
+        <outline text="Site 3" xmlUrl="http://updates-site3.com" title="Site 3" type="rss" maxAgeDays="5"></outline>
+      </outline>
+    </outline>
+  </body>
+</opml>`, opml)
+
+	exported := &User{}
+	added, err = exported.ImportOPML(opml, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, added)
+	assert.Equal(t, user.Subscriptions, exported.Subscriptions)
+
+	added, err = user.ImportOPML(`<opml version="1.0"><body><outline text="Site 6" xmlUrl="http://site6.com"/></body></opml>`, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, []UserFeed{{URL: "http://site6.com", Title: "Site 6"}}, user.Subscriptions)
+}
+
+func TestImportExportOPMLFolderSeparator(t *testing.T) {
+	user := &User{}
+
+	added, err := user.ImportOPML(`<opml version="1.0"><body>`+
+		`<outline text="AC/DC"><outline text="Live \ Bootlegs"><outline text="Site 1" xmlUrl="http://site1.com"/></outline></outline>`+
+		`</body></opml>`, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, []UserFeed{{URL: "http://site1.com", Title: "Site 1", Folder: `AC\/DC/Live \\ Bootlegs`}}, user.Subscriptions)
+	assert.Equal(t, []string{`AC\/DC`, `AC\/DC/Live \\ Bootlegs`}, user.GetFolders())
+
+	opml, err := user.ExportOPML()
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <body>
+    <outline text="AC/DC" title="AC/DC">
+      <outline text="Live \ Bootlegs" title="Live \ Bootlegs">
+        <outline text="Site 1" xmlUrl="http://site1.com" title="Site 1"></outline>
+      </outline>
+    </outline>
+  </body>
+</opml>`, opml)
+
+	exported := &User{}
+	_, err = exported.ImportOPML(opml, false)
+	assert.NoError(t, err)
+	assert.Equal(t, user.Subscriptions, exported.Subscriptions)
+}
+
+func TestImportInvalidOPML(t *testing.T) {
+	user := &User{Subscriptions: []UserFeed{{URL: "http://site1.com", Title: "Site 1"}}}
+
+	_, err := user.ImportOPML(`<opml version="1.0"><body><outline`, true)
+	assert.Error(t, err)
+
+	_, err = user.ImportOPML(`<opml version="1.0"><body><outline text="Site 2" xmlUrl="site2"/></body></opml>`, true)
+	assert.EqualError(t, err, "invalid feed Site 2: feed URL site2 is not absolute")
+
+	_, err = user.ImportOPML(`<opml version="1.0"><body><outline text="Site 2" xmlUrl="http://site2.com" maxItems="-1"/></body></opml>`, true)
+	assert.EqualError(t, err, "invalid feed Site 2: max items cannot be negative")
+
+	assert.Equal(t, []UserFeed{{URL: "http://site1.com", Title: "Site 1"}}, user.Subscriptions)
+}
+
+func TestAddFeed(t *testing.T) {
+	user := &User{Subscriptions: []UserFeed{{URL: "http://site1.com", Title: "Site 1", Type: "rss"}}}
+
+	changed, err := user.AddFeed(UserFeed{URL: " mailto:sender@site2.com ", Title: "Site <2>", Type: "email", Folder: " Mail / "})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = user.AddFeed(UserFeed{URL: "http://site1.com", Title: "Site 1"})
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = user.AddFeed(UserFeed{URL: "http://site3.com"})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = user.AddFeed(UserFeed{URL: "http://site4.com", Folder: ` Music / AC\/DC / C:\Temp `})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	assert.Equal(t, []UserFeed{
+		{URL: "http://site1.com", Title: "Site 1", Type: "rss"},
+		{URL: "mailto:sender@site2.com", Title: "Site <2>", Type: "email", Folder: "Mail"},
+		{URL: "http://site3.com", Title: "http://site3.com"},
+		{URL: "http://site4.com", Title: "http://site4.com", Folder: `Music/AC\/DC/C:\\Temp`},
+	}, user.GetFeeds())
+}
+
+func TestAddInvalidFeed(t *testing.T) {
+	user := &User{}
+
+	_, err := user.AddFeed(UserFeed{URL: " "})
+	assert.EqualError(t, err, "feed URL cannot be empty")
+
+	_, err = user.AddFeed(UserFeed{URL: "site1.com"})
+	assert.EqualError(t, err, "feed URL site1.com is not absolute")
+
+	_, err = user.AddFeed(UserFeed{URL: "http://site1.com", RetentionPolicy: RetentionPolicy{MaxAgeDays: -1}})
+	assert.EqualError(t, err, "max age days cannot be negative")
+
+	assert.Empty(t, user.GetFeeds())
+}
+
+func TestUpdateDeleteFeed(t *testing.T) {
+	user := &User{Subscriptions: []UserFeed{{URL: "http://site1.com", Title: "Site 1"}, {URL: "http://site2.com", Title: "Site 2"}}}
+
+	err := user.UpdateFeed("http://site1.com", UserFeed{URL: "http://site1.com/rss", Title: "Site 1", Folder: "News"})
+	assert.NoError(t, err)
+
+	err = user.UpdateFeed("http://site3.com", UserFeed{URL: "http://site3.com"})
+	assert.EqualError(t, err, "feed http://site3.com doesn't exist")
+
+	err = user.UpdateFeed("http://site2.com", UserFeed{URL: "http://site1.com/rss"})
+	assert.EqualError(t, err, "feed http://site1.com/rss already exists")
+
+	err = user.UpdateFeed("http://site2.com", UserFeed{URL: ""})
+	assert.EqualError(t, err, "feed URL cannot be empty")
+
+	assert.Equal(t, []UserFeed{
+		{URL: "http://site1.com/rss", Title: "Site 1", Folder: "News"},
+		{URL: "http://site2.com", Title: "Site 2"},
+	}, user.GetFeeds())
+
+	assert.False(t, user.DeleteFeed("http://site1.com"))
+	assert.True(t, user.DeleteFeed("http://site1.com/rss"))
+	assert.Equal(t, []UserFeed{{URL: "http://site2.com", Title: "Site 2"}}, user.GetFeeds())
+}
+
+func TestSaveSubscriptions(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	_, err = user.ImportOPML(testOPML, false)
+	assert.NoError(t, err)
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	dbUser, err := dbService.GetUser("user01")
+	assert.NoError(t, err)
+	assert.Equal(t, user.GetFeeds(), dbUser.GetFeeds())
+}
+
+func TestAddUserFeed(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://site1.com", Title: "Site 1"}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	err = dbService.AddUserFeed("user01", UserFeed{URL: "mailto:sender@site2.com", Title: "Site 2", Type: "email"})
+	assert.NoError(t, err)
+	err = dbService.AddUserFeed("user01", UserFeed{URL: "http://site1.com", Title: "Site 1 updated"})
+	assert.NoError(t, err)
+
+	dbUser, err := dbService.GetUser("user01")
+	assert.NoError(t, err)
+	assert.Equal(t, []UserFeed{
+		{URL: "http://site1.com", Title: "Site 1"},
+		{URL: "mailto:sender@site2.com", Title: "Site 2", Type: "email"},
+	}, dbUser.GetFeeds())
+
+	err = dbService.AddUserFeed("user01", UserFeed{URL: "site3.com"})
+	assert.EqualError(t, err, "feed URL site3.com is not absolute")
+
+	err = dbService.AddUserFeed("user02", UserFeed{URL: "http://site1.com"})
+	assert.EqualError(t, err, "user user02 doesn't exist")
+
+	users, err := dbService.GetUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user01"}, users)
+}
+
+func TestConvertOPMLSubscriptions(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	type legacyUser struct {
+		Password    string
+		Opml        string
+		Pagemonitor string
+	}
+	saveLegacyUser := func(username string, user legacyUser) {
+		var value bytes.Buffer
+		err := gob.NewEncoder(&value).Encode(&user)
+		assert.NoError(t, err)
+		err = dbService.db.Put(createUserKey(username), value.Bytes())
+		assert.NoError(t, err)
+		err = dbService.addReferencedKey([]byte(userKeyPrefix), []byte(username))
+		assert.NoError(t, err)
+	}
+	saveLegacyUser("user01", legacyUser{Password: "pass1", Opml: testOPML, Pagemonitor: "pagemonitor1"})
+	saveLegacyUser("user02", legacyUser{Password: "pass2", Opml: `<opml version="1.0"><body><outline`})
+	saveLegacyUser("user03", legacyUser{Password: "pass3"})
+	saveLegacyUser("user04", legacyUser{Password: "pass4", Opml: `<opml version="1.0"><body><outline text="Site 5" xmlUrl="site5"/></body></opml>`})
+
+	err = dbService.update(dbService.convertOPMLSubscriptions)
+	assert.NoError(t, err)
+
+	users, err := getAllUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*User{
+		{
+			Password: "pass1",
+			Subscriptions: []UserFeed{
+				{URL: "http://sites-site1.com", Title: "Site 1", Type: "rss", Folder: "Sites"},
+				{URL: "http://updates-site2.com", Title: "Site 2", Type: "rss", Folder: "Updates"},
+				{URL: "http://updates-site3.com", Title: "Site 3", Type: "rss", Folder: "Updates/Nested", RetentionPolicy: RetentionPolicy{MaxAgeDays: 5}},
+				{URL: "http://site4.com", Title: "Site 4"},
+			},
+			// Fields converted by later migrations are kept.
+			Pagemonitor: "pagemonitor1",
+			username:    "user01",
+		},
+		// Users with an invalid OPML (or invalid feeds) keep it, so that it can be fixed and imported again.
+		{Password: "pass2", Opml: `<opml version="1.0"><body><outline`, username: "user02"},
+		{Password: "pass3", username: "user03"},
+		{Password: "pass4", Opml: `<opml version="1.0"><body><outline text="Site 5" xmlUrl="site5"/></body></opml>`, username: "user04"},
+	}, users)
+
+	dbUser, err := dbService.GetUser("user04")
+	assert.NoError(t, err)
+	_, err = dbUser.ImportOPML(`<opml version="1.0"><body><outline text="Site 5" xmlUrl="http://site5"/></body></opml>`, true)
+	assert.NoError(t, err)
+	assert.Equal(t, &User{Password: "pass4", Subscriptions: []UserFeed{{URL: "http://site5", Title: "Site 5"}}, username: "user04"}, dbUser)
+}
+
+func TestGetFolders(t *testing.T) {
+	user := &User{}
+	assert.Equal(t, []string{}, user.GetFolders())
+
+	_, err := user.ImportOPML(testOPML, false)
+	assert.NoError(t, err)
+	user.Subscriptions = append(user.Subscriptions, UserFeed{URL: "http://site5.com", Folder: "Archive/2019/Old"})
+	assert.Equal(t, []string{"Archive", "Archive/2019", "Archive/2019/Old", "Sites", "Updates", "Updates/Nested"}, user.GetFolders())
+}
+
+func TestFolderContains(t *testing.T) {
+	assert.True(t, FolderContains("Updates", "Updates"))
+	assert.True(t, FolderContains("Updates", "Updates/Nested"))
+	assert.False(t, FolderContains("Updates", "Updates 2"))
+	assert.False(t, FolderContains("Updates/Nested", "Updates"))
+	assert.False(t, FolderContains("Updates", ""))
+	assert.False(t, FolderContains("AC", `AC\/DC`))
+	assert.True(t, FolderContains(`AC\/DC`, `AC\/DC/Live`))
+}
+
+func TestFeedItemsURL(t *testing.T) {
+	assert.Equal(t, "http://site1/sitemap.xml", (&UserFeed{URL: "http://site1/sitemap.xml"}).ItemsURL())
+	assert.Equal(t, "http://site1/sitemap.xml", (&UserFeed{URL: "http://site1/sitemap.xml", PathPrefix: " "}).ItemsURL())
+	assert.Equal(t, "http://site1/sitemap.xml#/blog/ /docs/", (&UserFeed{URL: "http://site1/sitemap.xml", PathPrefix: " /docs/  /blog/"}).ItemsURL())
+}
@@ -0,0 +1,286 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Tags are user-defined labels which can be assigned to feed items and pages.
+// Like starred items, tagged items are never deleted by GC.
+
+// maxTagLength is the maximum length of a tag name.
+const maxTagLength = 100
+
+// InvalidTagError is returned when a tag name is invalid or refers to a tag which doesn't exist.
+type InvalidTagError struct {
+	Err error
+}
+
+// Error returns the reason why the tag is invalid.
+func (e *InvalidTagError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *InvalidTagError) Unwrap() error {
+	return e.Err
+}
+
+// normalizeTag removes extra whitespace from tag and checks that it's a valid tag name.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return "", &InvalidTagError{Err: fmt.Errorf("tag cannot be empty")}
+	}
+	if len(tag) > maxTagLength {
+		return "", &InvalidTagError{Err: fmt.Errorf("tag cannot be longer than %v characters", maxTagLength)}
+	}
+	return tag, nil
+}
+
+// GetTags returns all tags of user, sorted by name.
+func (s *DBService) GetTags(user *User) ([]string, error) {
+	var tags []string
+	err := s.view(func() error {
+		var err error
+		tags, err = s.getTags(user)
+		return err
+	})
+	return tags, err
+}
+
+// getTags returns all tags of user, sorted by name, without acquiring a lock.
+func (s *DBService) getTags(user *User) ([]string, error) {
+	tagKeys, err := s.getReferencedKeys(user.createTagsKey())
+	if err != nil {
+		log.WithField("username", user.username).WithError(err).Error("Failed to get tags index")
+		return nil, err
+	}
+	tags := make([]string, len(tagKeys))
+	for i := range tagKeys {
+		tags[i] = string(tagKeys[i])
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// hasTag returns true if user has created tag.
+func (s *DBService) hasTag(user *User, tag string) (bool, error) {
+	return s.hasReferencedKey(user.createTagsKey(), []byte(tag))
+}
+
+// CreateTag creates a new tag for user; does nothing if the tag already exists.
+func (s *DBService) CreateTag(user *User, tag string) error {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	return s.update(func() error {
+		return s.addReferencedKey(user.createTagsKey(), []byte(tag))
+	})
+}
+
+// RenameTag renames tag to newTag, keeping all tagged items.
+// If newTag already exists, both tags are merged.
+func (s *DBService) RenameTag(user *User, tag, newTag string) error {
+	newTag, err := normalizeTag(newTag)
+	if err != nil {
+		return err
+	}
+	return s.update(func() error {
+		exists, err := s.hasTag(user, tag)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return &InvalidTagError{Err: fmt.Errorf("tag %v doesn't exist", tag)}
+		}
+		if tag == newTag {
+			return nil
+		}
+
+		items, err := s.getTaggedItems(user, tag)
+		if err != nil {
+			return err
+		}
+		for _, k := range items {
+			if err := s.addReferencedKey(user.createTaggedPrefix(newTag), k); err != nil {
+				return fmt.Errorf("failed to add item to renamed tag: %w", err)
+			}
+		}
+		if err := s.addReferencedKey(user.createTagsKey(), []byte(newTag)); err != nil {
+			return err
+		}
+		return s.deleteTag(user, tag)
+	})
+}
+
+// DeleteTag deletes tag and unassigns it from all items.
+func (s *DBService) DeleteTag(user *User, tag string) error {
+	return s.update(func() error {
+		return s.deleteTag(user, tag)
+	})
+}
+
+// deleteTag deletes tag and unassigns it from all items, without acquiring a lock.
+func (s *DBService) deleteTag(user *User, tag string) error {
+	items, err := s.getTaggedItems(user, tag)
+	if err != nil {
+		return err
+	}
+	taggedPrefix := user.createTaggedPrefix(tag)
+	for _, k := range items {
+		if err := s.deleteReferencedKey(taggedPrefix, k); err != nil {
+			return fmt.Errorf("failed to delete item from tag index: %w", err)
+		}
+	}
+	return s.deleteReferencedKey(user.createTagsKey(), []byte(tag))
+}
+
+// GetTaggedItems returns a list of items which have tag assigned.
+func (s *DBService) GetTaggedItems(user *User, tag string) ([]itemKey, error) {
+	var items []itemKey
+	err := s.view(func() error {
+		var err error
+		items, err = s.getTaggedItems(user, tag)
+		return err
+	})
+	return items, err
+}
+
+// getTaggedItems returns a list of items which have tag assigned, without acquiring a lock.
+func (s *DBService) getTaggedItems(user *User, tag string) ([]itemKey, error) {
+	items, err := s.getReferencedKeys(user.createTaggedPrefix(tag))
+	if err != nil {
+		log.WithField("username", user.username).WithField("tag", tag).WithError(err).Error("Failed to get tagged items index")
+		return nil, err
+	}
+	return items, nil
+}
+
+// setTagged assigns or unassigns tag, without acquiring a lock.
+// Assigning a tag which doesn't exist yet will create it.
+func (s *DBService) setTagged(user *User, tag string, k itemKey, tagged bool) error {
+	if tagged {
+		if err := s.addReferencedKey(user.createTagsKey(), []byte(tag)); err != nil {
+			return err
+		}
+		return s.addReferencedKey(user.createTaggedPrefix(tag), k)
+	}
+	return s.deleteReferencedKey(user.createTaggedPrefix(tag), k)
+}
+
+// SetTagged assigns (if tagged is true) or unassigns (if tagged is false) tag to item k.
+func (s *DBService) SetTagged(user *User, tag string, k itemKey, tagged bool) error {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return err
+	}
+	return s.update(func() error {
+		return s.setTagged(user, tag, k, tagged)
+	})
+}
+
+// isTagged returns true if any user has assigned a tag to item k.
+func (s *DBService) isTagged(k itemKey) (bool, error) {
+	usernames, err := s.getUsers()
+	if err != nil {
+		return false, err
+	}
+	for _, username := range usernames {
+		user := User{username: username}
+		tags, err := s.getTags(&user)
+		if err != nil {
+			return false, err
+		}
+		for _, tag := range tags {
+			tagged, err := s.hasReferencedKey(user.createTaggedPrefix(tag), k)
+			if err != nil || tagged {
+				return tagged, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// isKept returns true if item k is starred, tagged or has notes from any user, and should never be deleted.
+func (s *DBService) isKept(k itemKey) (bool, error) {
+	starred, err := s.isStarred(k)
+	if err != nil || starred {
+		return starred, err
+	}
+	tagged, err := s.isTagged(k)
+	if err != nil || tagged {
+		return tagged, err
+	}
+	return s.hasNotes(k)
+}
+
+// renameTags moves all tags to the new username.
+func (s *DBService) renameTags(user *User) error {
+	newUser := &User{username: user.newUsername}
+
+	tags, err := s.getTags(user)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		items, err := s.getTaggedItems(user, tag)
+		if err != nil {
+			return err
+		}
+		for _, k := range items {
+			if err := s.setTagged(newUser, tag, k, true); err != nil {
+				log.WithField("key", k).WithField("user", newUser.username).WithError(err).Error("Failed to add tagged item to index for new username")
+				return err
+			}
+		}
+		if err := s.addReferencedKey(newUser.createTagsKey(), []byte(tag)); err != nil {
+			return err
+		}
+		if err := s.deleteTag(user, tag); err != nil {
+			log.WithField("tag", tag).WithField("user", user.username).WithError(err).Error("Failed to delete tag from old username index")
+			return err
+		}
+	}
+	return nil
+}
+
+// getTaggedFeeditems returns all feed items tagged by user, without acquiring a lock.
+func (s *DBService) getTaggedFeeditems(user *User) ([]*Feeditem, error) {
+	tags, err := s.getTags(user)
+	if err != nil {
+		return nil, err
+	}
+
+	feedItems := make([]*Feeditem, 0)
+	for _, tag := range tags {
+		items, err := s.getTaggedItems(user, tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range items {
+			if !IsFeeditemKey(k) {
+				continue
+			}
+			itemKey, err := DecodeFeeditemKey(k)
+			if err != nil {
+				log.WithField("key", string(k)).WithError(err).Error("Failed to decode tagged item key")
+				continue
+			}
+			feedItem, err := s.getFeeditem(itemKey)
+			if err != nil {
+				log.WithField("key", string(k)).WithError(err).Error("Failed to get tagged item")
+				continue
+			}
+			if feedItem != nil {
+				feedItems = append(feedItems, feedItem)
+			}
+		}
+	}
+	return feedItems, nil
+}
@@ -0,0 +1,276 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateGetTags(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	tags, err := dbService.GetTags(&user)
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+
+	err = dbService.CreateTag(&user, " digest ")
+	assert.NoError(t, err)
+	err = dbService.CreateTag(&user, "archive")
+	assert.NoError(t, err)
+	err = dbService.CreateTag(&user, "digest")
+	assert.NoError(t, err)
+
+	tags, err = dbService.GetTags(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"archive", "digest"}, tags)
+
+	otherUser := User{username: "user02"}
+	tags, err = dbService.GetTags(&otherUser)
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+}
+
+func TestCreateInvalidTag(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	err = dbService.CreateTag(&user, " ")
+	assert.EqualError(t, err, "tag cannot be empty")
+	var invalidTag *InvalidTagError
+	assert.ErrorAs(t, err, &invalidTag)
+	err = dbService.SetTagged(&user, "", []byte("i1"), true)
+	assert.EqualError(t, err, "tag cannot be empty")
+
+	tags, err := dbService.GetTags(&user)
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+}
+
+func TestSetTagged(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	key1 := []byte("i1")
+	key2 := []byte("i2")
+
+	err = dbService.SetTagged(&user, "digest", key1, true)
+	assert.NoError(t, err)
+	err = dbService.SetTagged(&user, "digest", key2, true)
+	assert.NoError(t, err)
+	err = dbService.SetTagged(&user, "archive", key1, true)
+	assert.NoError(t, err)
+	err = dbService.SetTagged(&user, "digest", key1, false)
+	assert.NoError(t, err)
+
+	tags, err := dbService.GetTags(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"archive", "digest"}, tags)
+
+	items, err := dbService.GetTaggedItems(&user, "digest")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{key2}, items)
+	items, err = dbService.GetTaggedItems(&user, "archive")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{key1}, items)
+}
+
+func TestRenameDeleteTag(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := User{username: "user01"}
+
+	key1 := []byte("i1")
+	key2 := []byte("i2")
+
+	err = dbService.SetTagged(&user, "digest", key1, true)
+	assert.NoError(t, err)
+	err = dbService.SetTagged(&user, "archive", key2, true)
+	assert.NoError(t, err)
+
+	err = dbService.RenameTag(&user, "missing", "other")
+	assert.EqualError(t, err, "tag missing doesn't exist")
+	var invalidTag *InvalidTagError
+	assert.ErrorAs(t, err, &invalidTag)
+
+	err = dbService.RenameTag(&user, "digest", "weekly")
+	assert.NoError(t, err)
+
+	tags, err := dbService.GetTags(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"archive", "weekly"}, tags)
+	items, err := dbService.GetTaggedItems(&user, "weekly")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{key1}, items)
+	items, err = dbService.GetTaggedItems(&user, "digest")
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	// Renaming into an existing tag merges both tags.
+	err = dbService.RenameTag(&user, "archive", "weekly")
+	assert.NoError(t, err)
+
+	tags, err = dbService.GetTags(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"weekly"}, tags)
+	items, err = dbService.GetTaggedItems(&user, "weekly")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]byte{key1, key2}, items)
+
+	err = dbService.DeleteTag(&user, "weekly")
+	assert.NoError(t, err)
+
+	tags, err = dbService.GetTags(&user)
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+	items, err = dbService.GetTaggedItems(&user, "weekly")
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestTaggedItemTTLExpired(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+	var oldTTL = itemTTL
+	itemTTL = time.Nanosecond * 0
+	defer func() { itemTTL = oldTTL }()
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	taggedItem := &Feeditem{
+		Title:    "t1",
+		URL:      "http://item1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c1",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	item := &Feeditem{
+		Title:    "t2",
+		URL:      "http://item2",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c2",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g2"},
+	}
+	feedKey := &UserFeed{URL: item.Key.FeedURL}
+	err = dbService.SaveFeeditems(taggedItem, item)
+	assert.NoError(t, err)
+	err = dbService.SetTagged(user, "digest", taggedItem.Key.CreateKey(), true)
+	assert.NoError(t, err)
+
+	err = dbService.SetFetchStatus(feedKey.CreateKey(), &FetchStatus{LastSuccess: time.Time{}})
+	assert.NoError(t, err)
+
+	err = dbService.deleteStaleFetchStatuses()
+	assert.NoError(t, err)
+
+	dbItem, err := dbService.GetFeeditem(item.Key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbItem)
+
+	dbItem, err = dbService.GetFeeditem(taggedItem.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, taggedItem, dbItem)
+
+	err = dbService.DeleteTag(user, "digest")
+	assert.NoError(t, err)
+
+	err = dbService.deleteStaleFetchStatuses()
+	assert.NoError(t, err)
+
+	dbItem, err = dbService.GetFeeditem(taggedItem.Key)
+	assert.NoError(t, err)
+	assert.Nil(t, dbItem)
+}
+
+func TestRenameUserTags(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	key := []byte("i1")
+	err = dbService.SetTagged(user, "digest", key, true)
+	assert.NoError(t, err)
+	err = dbService.CreateTag(user, "empty")
+	assert.NoError(t, err)
+
+	err = user.SetUsername("user02")
+	assert.NoError(t, err)
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	tags, err := dbService.GetTags(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"digest", "empty"}, tags)
+	items, err := dbService.GetTaggedItems(user, "digest")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{key}, items)
+
+	oldUser := User{username: "user01"}
+	tags, err = dbService.GetTags(&oldUser)
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+	items, err = dbService.GetTaggedItems(&oldUser, "digest")
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestBackupTags(t *testing.T) {
+	err := resetDb()
+	assert.NoError(t, err)
+
+	user := NewUser("user01")
+	user.Subscriptions = []UserFeed{{URL: "http://feed2"}}
+	err = dbService.SaveUser(user)
+	assert.NoError(t, err)
+
+	item := &Feeditem{
+		Title:    "t1",
+		URL:      "http://item1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "c1",
+		Updated:  time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+		Key:      &FeeditemKey{FeedURL: "http://feed1", GUID: "g1"},
+	}
+	err = dbService.SaveFeeditems(item)
+	assert.NoError(t, err)
+	err = dbService.SetTagged(user, "digest", item.Key.CreateKey(), true)
+	assert.NoError(t, err)
+	err = dbService.CreateTag(user, "empty")
+	assert.NoError(t, err)
+
+	var backup bytes.Buffer
+	err = dbService.Backup(&backup, BackupOptions{})
+	assert.NoError(t, err)
+
+	err = resetDb()
+	assert.NoError(t, err)
+	_, err = dbService.Restore(&backup, RestoreOptions{})
+	assert.NoError(t, err)
+
+	dbItem, err := dbService.GetFeeditem(item.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, item, dbItem)
+
+	tags, err := dbService.GetTags(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"digest", "empty"}, tags)
+	items, err := dbService.GetTaggedItems(user, "digest")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{item.Key.CreateKey()}, items)
+}
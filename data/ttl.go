@@ -7,15 +7,23 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// itemTTL specifies the TTL after which items expire.
+// itemTTL specifies the default TTL after which items expire, if not specified by the global RetentionPolicy.
 var itemTTL = 14 * 24 * time.Hour
 
 // skipUpdateTTL how old the item has to be for its "last seen" status to be updated.
 // Prevents excessive writes to the database.
-var skipUpdateTTL = itemTTL / 2
+// Should be less than half of the shortest possible retention period (1 day).
+var skipUpdateTTL = 12 * time.Hour
 
 // SetLastSeen creates or updates the last seen value for key.
 func (s *DBService) SetLastSeen(key []byte) error {
+	return s.update(func() error {
+		return s.setLastSeen(key)
+	})
+}
+
+// setLastSeen creates or updates the last seen value for key, without acquiring a lock.
+func (s *DBService) setLastSeen(key []byte) error {
 	currentTime := time.Now()
 	lastSeenKey := createLastSeenKey(key)
 
@@ -57,23 +65,9 @@ func (s *DBService) deleteExpiredItems(feedKey []byte) error {
 
 	now := time.Now()
 
-	purgeItem := func(key []byte) {
-		contentsKey := append(key, []byte(feedContentsSuffix)...)
-		if err := s.db.Delete(contentsKey); err != nil {
-			log.WithField("key", key).WithError(err).Error("Failed to delete item contents")
-		}
-
-		if err := s.db.Delete(key); err != nil {
-			log.WithField("key", key).WithError(err).Error("Failed to delete item")
-		}
-
-		lastSeenKey := createLastSeenKey(key)
-		if err := s.db.Delete(lastSeenKey); err != nil {
-			log.WithField("key", string(lastSeenKey)).WithError(err).Error("Failed to delete item last seen time")
-		}
-
-		if err := s.deleteReferencedKey(prefix, key); err != nil {
-			log.WithField("key", string(key)).WithError(err).Error("Failed to delete item from index")
+	purgeItem := func(key, guid []byte) {
+		if err := s.purgeItem(prefix, key, guid); err != nil {
+			log.WithField("key", string(key)).WithError(err).Error("Failed to delete item")
 		}
 	}
 
@@ -85,86 +79,143 @@ func (s *DBService) deleteExpiredItems(feedKey []byte) error {
 		itemGUID := encodePart(string(k))
 
 		itemKey := append(prefix, []byte(itemGUID)...)
+		kept, err := s.isKept(itemKey)
+		if err != nil {
+			log.WithField("key", string(itemKey)).WithError(err).Error("Failed to check if item should be kept")
+			continue
+		}
+		if kept {
+			continue
+		}
+
 		lastSeenKey := createLastSeenKey(itemKey)
 		lastSeenValue, err := s.db.Get(lastSeenKey)
 		if err != nil {
 			log.WithField("key", string(itemKey)).WithError(err).Error("Failed to get last seen time item")
-			purgeItem(itemKey)
+			purgeItem(itemKey, k)
 			continue
 		}
 
 		lastSeen := time.Time{}
 		if err := lastSeen.UnmarshalBinary(lastSeenValue); err != nil {
 			log.WithField("key", string(itemKey)).WithError(err).Error("Failed to get last seen time value")
-			purgeItem(itemKey)
+			purgeItem(itemKey, k)
 			continue
 		}
 
-		expires := lastSeen.Add(itemTTL)
+		expires := lastSeen.Add(s.retention.maxAge())
 		if now.After(expires) || now.Equal(expires) {
 			log.Debug("Deleting expired item")
-			purgeItem(itemKey)
+			purgeItem(itemKey, k)
 		}
 	}
 	return nil
 }
 
-// deleteStaleFetchStatuses deletes all FetchStatus items which were not updated for itemTTL.
-func (s *DBService) deleteStaleFetchStatuses() error {
-	now := time.Now()
+// purgeItem deletes the item with key (and guid) from the prefix feed.
+func (s *DBService) purgeItem(prefix, key, guid []byte) error {
+	contentsKey := append(append([]byte{}, key...), []byte(feedContentsSuffix)...)
+	if err := s.db.Delete(contentsKey); err != nil {
+		return fmt.Errorf("failed to delete item contents: %w", err)
+	}
+
+	if err := s.db.Delete(key); err != nil {
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+
+	if err := s.db.Delete(createLastSeenKey(key)); err != nil {
+		return fmt.Errorf("failed to delete item last seen time: %w", err)
+	}
+
+	if err := s.deleteReferencedKey([]byte(lastSeenKeyPrefix), key); err != nil {
+		return fmt.Errorf("failed to delete item from last seen index: %w", err)
+	}
+
+	if err := s.deleteReferencedKey(prefix, guid); err != nil {
+		return fmt.Errorf("failed to delete item from index: %w", err)
+	}
+
+	if err := s.unindexDocument(key); err != nil {
+		return fmt.Errorf("failed to delete item from search index: %w", err)
+	}
+	return nil
+}
 
-	indexKeys, err := s.getReferencedKeys([]byte(fetchStatusKeyPrefix))
+// deleteStaleFetchStatuses deletes all FetchStatus items which were not updated for the global retention period.
+func (s *DBService) deleteStaleFetchStatuses() error {
+	var indexKeys [][]byte
+	err := s.view(func() error {
+		var err error
+		indexKeys, err = s.getReferencedKeys([]byte(fetchStatusKeyPrefix))
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
+	now := time.Now()
 	for _, k := range indexKeys {
-		fetchStatusKey := createFetchStatusKey(k)
-		value, err := s.db.Get(fetchStatusKey)
+		// Use a separate transaction for every item to avoid blocking the database for too long.
+		err := s.update(func() error {
+			return s.deleteStaleFetchStatus(k, now)
+		})
 		if err != nil {
-			return err
+			log.WithField("key", k).WithError(err).Error("Failed to delete expired fetch status")
 		}
+	}
+	return nil
+}
 
-		fetchStatus := &FetchStatus{}
-		if err := fetchStatus.decode(value); err != nil {
-			log.WithField("key", k).WithError(err).Error("Failed to get fetch status value")
-			continue
-		}
+// deleteStaleFetchStatus deletes the FetchStatus for k (and its expired feed items) if it was not updated for the global retention period.
+func (s *DBService) deleteStaleFetchStatus(k []byte, now time.Time) error {
+	fetchStatusKey := createFetchStatusKey(k)
+	fetchStatus, err := s.getFetchStatus(fetchStatusKey)
+	if err != nil || fetchStatus == nil {
+		return err
+	}
+
+	var lastUpdated time.Time
+	if fetchStatus.LastFailure.After(lastUpdated) {
+		lastUpdated = fetchStatus.LastFailure
+	}
+	if fetchStatus.LastSuccess.After(lastUpdated) {
+		lastUpdated = fetchStatus.LastSuccess
+	}
 
-		var lastUpdated time.Time
-		if fetchStatus.LastFailure.After(lastUpdated) {
-			lastUpdated = fetchStatus.LastFailure
+	expires := lastUpdated.Add(s.retention.maxAge())
+	if !now.After(expires) {
+		return nil
+	}
+	log.Debug("Deleting expired fetch status")
+
+	if IsFeeditemKey(k) {
+		if err := s.deleteExpiredItems(k); err != nil {
+			return fmt.Errorf("failed to remove expired feed items: %w", err)
 		}
-		if fetchStatus.LastSuccess.After(lastUpdated) {
-			lastUpdated = fetchStatus.LastSuccess
+		// Keep the fetch status while the feed still has starred, tagged or noted items, so that they're checked again once they can expire.
+		remainingItems, err := s.getReferencedKeys(append(k, []byte(separator)...))
+		if err != nil {
+			return fmt.Errorf("failed to get remaining feed items: %w", err)
 		}
+		if len(remainingItems) > 0 {
+			return nil
+		}
+	}
 
-		expires := lastUpdated.Add(itemTTL)
-		if now.After(expires) {
-			log.Debug("Deleting expired fetch status")
-
-			if IsFeeditemKey(k) {
-				if err := s.deleteExpiredItems(k); err != nil {
-					log.WithField("key", k).WithError(err).Error("Failed to remove expired feed items")
-					continue
-				}
-			}
+	if err := s.db.Delete(k); err != nil {
+		return fmt.Errorf("failed to delete fetch status item: %w", err)
+	}
 
-			if err := s.db.Delete(k); err != nil {
-				log.WithField("key", k).WithError(err).Error("Failed to delete fetch status item")
-				continue
-			}
+	if err := s.deleteIndex(append(k, []byte(separator)...)); err != nil {
+		return fmt.Errorf("failed to delete items index: %w", err)
+	}
 
-			if err := s.db.Delete(fetchStatusKey); err != nil {
-				log.WithField("key", k).WithError(err).Error("Failed to delete fetch status key")
-				continue
-			}
+	if err := s.db.Delete(fetchStatusKey); err != nil {
+		return fmt.Errorf("failed to delete fetch status key: %w", err)
+	}
 
-			if err := s.deleteReferencedKey([]byte(fetchStatusKeyPrefix), k); err != nil {
-				log.WithField("key", k).WithError(err).Error("Failed to remove fetch status from index")
-				continue
-			}
-		}
+	if err := s.deleteReferencedKey([]byte(fetchStatusKeyPrefix), k); err != nil {
+		return fmt.Errorf("failed to remove fetch status from index: %w", err)
 	}
 	return nil
 }
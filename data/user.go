@@ -2,8 +2,9 @@ package data
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base32"
 	"encoding/gob"
-	"encoding/xml"
 	"fmt"
 	"strings"
 
@@ -13,9 +14,19 @@ import (
 
 // User keeps configuration for a user.
 type User struct {
-	Password    string
-	Opml        string
-	Pagemonitor string
+	Password      string
+	Subscriptions []UserFeed
+	Pages         []UserPagemonitor
+	// Opml contains OPML from a previous version which couldn't be converted into Subscriptions.
+	// It's kept until OPML is imported successfully, so that the user can fix it.
+	Opml string `json:",omitempty"`
+	// Pagemonitor contains Pagemonitor XML from a previous version which couldn't be converted into Pages.
+	// It's kept until Pagemonitor XML is imported successfully, so that the user can fix it.
+	Pagemonitor string `json:",omitempty"`
+	MailToken   string `json:",omitempty"`
+	// Admin users can back up and restore the database.
+	Admin bool `json:",omitempty"`
+	RetentionPolicy
 	username    string
 	newUsername string
 }
@@ -26,18 +37,23 @@ type UserService struct {
 	Username string
 }
 
-// UserPagemonitor is a deserialized copy of a page from the Pagemonitor.
+// UserPagemonitor is a web page monitored by a user.
 type UserPagemonitor struct {
 	URL     string `xml:"url,attr"`
 	Title   string `xml:",chardata"`
-	Match   string `xml:"match,attr"`
-	Replace string `xml:"replace,attr"`
+	Match   string `xml:"match,attr,omitempty"`
+	Replace string `xml:"replace,attr,omitempty"`
 }
 
-// UserFeed is a deserialized copy of a page from OPML.
+// UserFeed is a feed subscription of a user.
 type UserFeed struct {
-	URL   string `xml:"xmlUrl,attr"`
-	Title string `xml:"title,attr"`
+	URL        string `xml:"xmlUrl,attr,omitempty"`
+	Title      string `xml:"title,attr"`
+	Type       string `xml:"type,attr,omitempty" json:",omitempty"`
+	PathPrefix string `xml:"pathPrefix,attr,omitempty" json:",omitempty"`
+	// Folder is the folder containing the feed; nested folders are separated by a slash.
+	Folder string `xml:"-" json:",omitempty"`
+	RetentionPolicy
 }
 
 // NewUser creates an instance of User with the provided username.
@@ -54,56 +70,84 @@ func (user *User) decode(val []byte) error {
 func (s *DBService) GetUsers() ([]string, error) {
 	var usernames []string
 	err := s.view(func() error {
-		indexKeys, err := s.getReferencedKeys([]byte(userKeyPrefix))
-		if err != nil {
-			log.WithError(err).Error("Failed to decode list of usernames")
-			return err
-		}
-		usernames = make([]string, 0, len(indexKeys))
-		if len(indexKeys) == 0 {
-			return nil
-		}
-		for i := range indexKeys {
-			username := indexKeys[i]
-			usernames = append(usernames, string(username))
-		}
-		return nil
+		var err error
+		usernames, err = s.getUsers()
+		return err
 	})
 
 	return usernames, err
 }
 
+// getUsers returns all usernames in the database, without acquiring a lock.
+func (s *DBService) getUsers() ([]string, error) {
+	indexKeys, err := s.getReferencedKeys([]byte(userKeyPrefix))
+	if err != nil {
+		log.WithError(err).Error("Failed to decode list of usernames")
+		return nil, err
+	}
+	usernames := make([]string, 0, len(indexKeys))
+	for i := range indexKeys {
+		username := indexKeys[i]
+		usernames = append(usernames, string(username))
+	}
+	return usernames, nil
+}
+
 // GetUser returns the User by username.
 // If user doesn't exist, returns nil.
 func (s *DBService) GetUser(username string) (*User, error) {
-	user := &User{username: username}
+	var user *User
 	err := s.view(func() error {
-		value, err := s.db.Get(user.createKey())
-		if err != nil {
-			return fmt.Errorf("cannot read User %v: %w", username, err)
-		}
-		if value == nil {
-			user = nil
-			return nil
-		}
-		if err := user.decode(value); err != nil {
-			user = nil
-			return err
-		}
-		return nil
+		var err error
+		user, err = s.getUser(username)
+		return err
 	})
 
 	return user, err
 }
 
+// getUser returns the User by username, without acquiring a lock.
+func (s *DBService) getUser(username string) (*User, error) {
+	user := &User{username: username}
+	value, err := s.db.Get(user.createKey())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read User %v: %w", username, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+	if err := user.decode(value); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 // SaveUser saves the user in the database.
 func (s *DBService) SaveUser(user *User) error {
 	if user.newUsername == "" {
 		user.newUsername = user.username
 	}
-	key := createUserKey(user.newUsername)
 
 	err := s.update(func() error {
+		return s.saveUser(user)
+	})
+
+	if err == nil {
+		user.username = user.newUsername
+		user.newUsername = ""
+	}
+	return err
+}
+
+// saveUser saves the user in the database, without acquiring a lock.
+// If the user is renamed, user.newUsername should be set to the new username.
+func (s *DBService) saveUser(user *User) error {
+	if user.newUsername == "" {
+		user.newUsername = user.username
+	}
+	key := createUserKey(user.newUsername)
+
+	{
 		var value bytes.Buffer
 		if err := gob.NewEncoder(&value).Encode(user); err != nil {
 			return fmt.Errorf("cannot marshal user: %w", err)
@@ -128,6 +172,18 @@ func (s *DBService) SaveUser(user *User) error {
 			if err := s.renameReadStatus(user); err != nil {
 				return err
 			}
+			if err := s.renameReadHistory(user); err != nil {
+				return err
+			}
+			if err := s.renameStarred(user); err != nil {
+				return err
+			}
+			if err := s.renameTags(user); err != nil {
+				return err
+			}
+			if err := s.renameNotes(user); err != nil {
+				return err
+			}
 		}
 
 		if err := s.addReferencedKey([]byte(userKeyPrefix), []byte(user.newUsername)); err != nil {
@@ -135,13 +191,7 @@ func (s *DBService) SaveUser(user *User) error {
 		}
 
 		return s.db.Put(key, value.Bytes())
-	})
-
-	if err == nil {
-		user.username = user.newUsername
-		user.newUsername = ""
 	}
-	return err
 }
 
 // GetUsername returns the user's current username.
@@ -174,47 +224,21 @@ func (user *User) ValidatePassword(password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 }
 
-// GetPages parses user's configuration and returns all UserPagemonitor configuration items.
-func (user *User) GetPages() ([]UserPagemonitor, error) {
-	type UserPages struct {
-		XMLName xml.Name          `xml:"pages"`
-		Pages   []UserPagemonitor `xml:"page"`
-	}
-	items := &UserPages{}
-	err := xml.Unmarshal([]byte(user.Pagemonitor), items)
-	if err != nil {
-		err = fmt.Errorf("cannot parse pagemonitor xml: %w", err)
-		return nil, err
+// GenerateMailToken generates a new random token used to receive mail for user.
+// The token will be saved when SaveUser is called.
+func (user *User) GenerateMailToken() error {
+	token := make([]byte, 10)
+	if _, err := rand.Read(token); err != nil {
+		return fmt.Errorf("cannot generate mail token: %w", err)
 	}
-	return items.Pages, nil
+	user.MailToken = strings.ToLower(base32.StdEncoding.EncodeToString(token))
+	return nil
 }
 
-// GetFeeds parses user's configuration and returns all UserFeed configuration items.
-func (user *User) GetFeeds() ([]UserFeed, error) {
-	type UserOPMLOutline struct {
-		UserFeed
-		Children []UserOPMLOutline `xml:"outline"`
-	}
-	type UserOPML struct {
-		XMLName xml.Name          `xml:"opml"`
-		Feeds   []UserOPMLOutline `xml:"body>outline"`
-	}
-	items := &UserOPML{}
-	err := xml.Unmarshal([]byte(user.Opml), items)
-	if err != nil {
-		err = fmt.Errorf("cannot parse opml xml: %w", err)
-		return nil, err
-	}
-	feeds := []UserFeed{}
-	var findFeeds func([]UserOPMLOutline)
-	findFeeds = func(outlines []UserOPMLOutline) {
-		for _, outline := range outlines {
-			if outline.URL != "" {
-				feeds = append(feeds, outline.UserFeed)
-			}
-			findFeeds(outline.Children)
-		}
+// GetMailAddress returns the address used to receive mail for user, or an empty string if the user has no mail token.
+func (user *User) GetMailAddress(domain string) string {
+	if user.MailToken == "" {
+		return ""
 	}
-	findFeeds(items.Feeds)
-	return feeds, nil
+	return user.MailToken + "@" + domain
 }
@@ -27,10 +27,10 @@ func TestCreateGetUser(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := &User{
-		Password:    "password",
-		Opml:        "opml",
-		Pagemonitor: "pagemonitor",
-		username:    "user01",
+		Password:      "password",
+		Subscriptions: []UserFeed{{URL: "http://site", Title: "Site"}},
+		Pages:         []UserPagemonitor{{URL: "http://site/page", Title: "Page"}},
+		username:      "user01",
 	}
 	err = dbService.SaveUser(user)
 	assert.NoError(t, err)
@@ -39,8 +39,8 @@ func TestCreateGetUser(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, user)
 	assert.Equal(t, "password", user.Password)
-	assert.Equal(t, "opml", user.Opml)
-	assert.Equal(t, "pagemonitor", user.Pagemonitor)
+	assert.Equal(t, []UserFeed{{URL: "http://site", Title: "Site"}}, user.Subscriptions)
+	assert.Equal(t, []UserPagemonitor{{URL: "http://site/page", Title: "Page"}}, user.Pages)
 }
 
 func TestReadAllUsers(t *testing.T) {
@@ -48,16 +48,16 @@ func TestReadAllUsers(t *testing.T) {
 	assert.NoError(t, err)
 
 	user1 := User{
-		Password:    "pass1",
-		Opml:        "opml1",
-		Pagemonitor: "pagemonitor1",
-		username:    "user01",
+		Password:      "pass1",
+		Subscriptions: []UserFeed{{URL: "http://site1", Title: "Site 1"}},
+		Pages:         []UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}},
+		username:      "user01",
 	}
 	user2 := User{
-		Password:    "pass2",
-		Opml:        "opml2",
-		Pagemonitor: "pagemonitor2",
-		username:    "user02",
+		Password:      "pass2",
+		Subscriptions: []UserFeed{{URL: "http://site2", Title: "Site 2"}},
+		Pages:         []UserPagemonitor{{URL: "http://site2/page", Title: "Page 2"}},
+		username:      "user02",
 	}
 	users := []*User{&user1, &user2}
 	for _, user := range users {
@@ -89,10 +89,10 @@ func TestSetUsername(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := User{
-		Password:    "pass1",
-		Opml:        "opml1",
-		Pagemonitor: "pagemonitor1",
-		username:    "user01",
+		Password:      "pass1",
+		Subscriptions: []UserFeed{{URL: "http://site1", Title: "Site 1"}},
+		Pages:         []UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}},
+		username:      "user01",
 	}
 	users := []*User{&user}
 	err = dbService.SaveUser(&user)
@@ -118,18 +118,18 @@ func TestSetUsernameAndOtherFields(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := User{
-		Password:    "pass1",
-		Opml:        "opml1",
-		Pagemonitor: "pagemonitor1",
-		username:    "user01",
+		Password:      "pass1",
+		Subscriptions: []UserFeed{{URL: "http://site1", Title: "Site 1"}},
+		Pages:         []UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}},
+		username:      "user01",
 	}
 	users := []*User{&user}
 	err = dbService.SaveUser(&user)
 	assert.NoError(t, err)
 
 	user.Password = "pass1new"
-	user.Opml = "opml1new"
-	user.Pagemonitor = "pagemonitor1new"
+	user.Subscriptions = []UserFeed{{URL: "http://site1new", Title: "Site 1 new"}}
+	user.Pages = []UserPagemonitor{{URL: "http://site1new/page", Title: "Page 1 new"}}
 	err = user.SetUsername("user02")
 	assert.NoError(t, err)
 
@@ -152,16 +152,16 @@ func TestSetUsernameAlreadyExists(t *testing.T) {
 	assert.NoError(t, err)
 
 	user1 := User{
-		Password:    "pass1",
-		Opml:        "opml1",
-		Pagemonitor: "pagemonitor1",
-		username:    "user01",
+		Password:      "pass1",
+		Subscriptions: []UserFeed{{URL: "http://site1", Title: "Site 1"}},
+		Pages:         []UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}},
+		username:      "user01",
 	}
 	user2 := User{
-		Password:    "pass2",
-		Opml:        "opml2",
-		Pagemonitor: "pagemonitor2",
-		username:    "user02",
+		Password:      "pass2",
+		Subscriptions: []UserFeed{{URL: "http://site2", Title: "Site 2"}},
+		Pages:         []UserPagemonitor{{URL: "http://site2/page", Title: "Page 2"}},
+		username:      "user02",
 	}
 	users := []*User{&user1, &user2}
 	err = dbService.SaveUser(&user1)
@@ -195,10 +195,10 @@ func TestSetUsernameEmptyString(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := User{
-		Password:    "pass1",
-		Opml:        "opml1",
-		Pagemonitor: "pagemonitor1",
-		username:    "user01",
+		Password:      "pass1",
+		Subscriptions: []UserFeed{{URL: "http://site1", Title: "Site 1"}},
+		Pages:         []UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}},
+		username:      "user01",
 	}
 	users := []*User{&user}
 	err = dbService.SaveUser(&user)
@@ -218,38 +218,12 @@ func TestSetUsernameEmptyString(t *testing.T) {
 	assert.EqualValues(t, users, dbUsers)
 }
 
-func TestParsePagemonitor(t *testing.T) {
-	user := &User{Pagemonitor: `<pages>` +
-		`<page url="https://site1.com" match="m1" replace="r1">Page 1</page>` +
-		`<page url="http://site2.com">Page 2</page>` +
-		`</pages>`}
-	items, err := user.GetPages()
-	assert.NoError(t, err)
-	assert.NotNil(t, items)
-	assert.Equal(t, []UserPagemonitor{
-		{URL: "https://site1.com", Title: "Page 1", Match: "m1", Replace: "r1"},
-		{URL: "http://site2.com", Title: "Page 2"},
-	}, items)
-}
+func TestGenerateMailToken(t *testing.T) {
+	user := NewUser("user01")
+	assert.Equal(t, "", user.GetMailAddress("nanorss.local"))
 
-func TestParseOPML(t *testing.T) {
-	user := &User{Opml: `<opml version="1.0">` +
-		`<head><title>My OPML list</title></head>` +
-		`<body>` +
-		`<outline text="Sites" title="Sites"><outline text="Site 1" title="Site 1" type="rss" xmlUrl="http://sites-site1.com" htmlUrl="http://sites-site1.com"/></outline>` +
-		`<outline text="Updates" title="Updates">` +
-		`<outline text="Site 2" title="Site 2" type="rss" xmlUrl="http://updates-site2.com" htmlUrl="http://updates-site2.com"/>` +
-		`<outline text="Site 3" title="Site 3" type="rss" xmlUrl="http://updates-site3.com" htmlUrl="http://updates-site3.com"/>` +
-		`</outline>` +
-		`</body>` +
-		`</opml>`}
-	items, err := user.GetFeeds()
-	assert.NoError(t, err)
-	assert.NotNil(t, items)
-
-	assert.Equal(t, []UserFeed{
-		{URL: "http://sites-site1.com", Title: "Site 1"},
-		{URL: "http://updates-site2.com", Title: "Site 2"},
-		{URL: "http://updates-site3.com", Title: "Site 3"},
-	}, items)
+	err := user.GenerateMailToken()
+	assert.NoError(t, err)
+	assert.Regexp(t, "^[a-z2-7]{16}$", user.MailToken)
+	assert.Equal(t, user.MailToken+"@nanorss.local", user.GetMailAddress("nanorss.local"))
 }
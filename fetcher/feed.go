@@ -1,8 +1,11 @@
 package fetcher
 
 import (
+	"bufio"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -10,30 +13,101 @@ import (
 	"github.com/zlogic/nanorss-go/data"
 )
 
-// FetchFeed fetches a feed from feedURL and saves it into the database if fetching was successful.
-func (fetcher *Fetcher) FetchFeed(feedURL string) error {
-	err := func() error {
-		resp, err := fetcher.Client.Get(feedURL)
-		if err == nil {
-			defer resp.Body.Close()
-		}
+// EmailFeedType is the subscription type for virtual feeds containing received mail.
+// Such feeds are not fetched.
+const EmailFeedType = "email"
 
-		if err == nil && resp.StatusCode != http.StatusOK {
-			err = fmt.Errorf("cannot GET feed (status code %v)", resp.StatusCode)
-		}
+// EmailFeedURL returns the URL of the virtual feed containing mail from sender, received with the mailToken address.
+func EmailFeedURL(sender, mailToken string) string {
+	return "mailto:" + strings.ToLower(sender) + "?to=" + url.QueryEscape(mailToken)
+}
+
+// updateChangedItems compares items with their previously saved versions.
+// Items without a date keep their previous date (new items get the current time).
+// Items with a changed date are marked as unread for all users.
+func (fetcher *Fetcher) updateChangedItems(items []*data.Feeditem) error {
+	currentTime := time.Now()
+	for _, item := range items {
+		previousItem, err := fetcher.DB.GetFeeditem(item.Key)
 		if err != nil {
-			return fmt.Errorf("cannot GET feed %v: %w", feedURL, err)
+			log.WithField("key", item.Key).WithError(err).Error("Failed to read previous item")
 		}
+		if previousItem == nil {
+			if item.Date.IsZero() {
+				item.Date = currentTime
+			}
+			continue
+		}
+		if item.Date.IsZero() {
+			item.Date = previousItem.Date
+			continue
+		}
+		if !item.Date.Equal(previousItem.Date) {
+			if err := fetcher.DB.SetReadStatusForAll(item.Key.CreateKey(), false); err != nil {
+				return fmt.Errorf("cannot mark item %v as unread: %w", item.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// downloadFeed downloads and parses a feed (or iCalendar file) from feedURL, using client.
+func (fetcher *Fetcher) downloadFeed(client *http.Client, feedURL string) (*ParsedFeed, error) {
+	resp, err := client.Get(feedURL)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("cannot GET feed (status code %v)", resp.StatusCode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot GET feed %v: %w", feedURL, err)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	var parsed *ParsedFeed
+	if isICalendar(body) {
+		parsed, err = fetcher.parseICalendar(feedURL, body)
+	} else {
+		parsed, err = fetcher.parseFeed(feedURL, body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse feed %v: %w", feedURL, err)
+	}
+	return parsed, nil
+}
 
-		items, err := fetcher.ParseFeed(feedURL, resp.Body)
+// PreviewFeed fetches and parses a feed from feedURL without saving anything into the database.
+func (fetcher *Fetcher) PreviewFeed(feedURL string) (*ParsedFeed, error) {
+	// Don't modify fetcher, it can be shared with other requests and the background refresh.
+	client := fetcher.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	return fetcher.downloadFeed(client, feedURL)
+}
+
+// FetchFeed fetches a feed from feedURL and saves it into the database if fetching was successful.
+func (fetcher *Fetcher) FetchFeed(feedURL string) error {
+	err := func() error {
+		parsed, err := fetcher.downloadFeed(fetcher.Client, feedURL)
 		if err != nil {
-			return fmt.Errorf("cannot parse feed %v: %w", feedURL, err)
+			return err
 		}
 
+		items := parsed.Items
 		if len(items) == 0 {
 			return fmt.Errorf("feed %v has no items", feedURL)
 		}
 
+		if parsed.Format == FeedFormatICalendar {
+			// Rescheduled events should be shown as unread.
+			if err := fetcher.updateChangedItems(items); err != nil {
+				return err
+			}
+		}
+
 		for _, item := range items {
 			item.Updated = time.Now()
 		}
@@ -62,6 +136,8 @@ func (fetcher *Fetcher) FetchAllFeeds() error {
 		log.WithError(err).Error("Failed to get list of users")
 		return err
 	}
+	// Feeds shared by several users are fetched (and counted in the fetch status) only once per round.
+	fetched := make(map[string]bool)
 	for _, username := range usernames {
 		user, err := fetcher.DB.GetUser(username)
 		if err != nil {
@@ -69,17 +145,27 @@ func (fetcher *Fetcher) FetchAllFeeds() error {
 			return err
 		}
 
-		feeds, err := user.GetFeeds()
-		if err != nil {
-			log.WithError(err).WithField("user", user).Error("Failed to get feeds for user")
-			continue
+		feeds := make([]data.UserFeed, 0)
+		for _, feed := range user.GetFeeds() {
+			fetchStatusKey := (&data.UserFeed{URL: feed.URL}).CreateKey()
+			if feed.Type == sitemapFeedType {
+				fetchStatusKey = feed.CreateKey()
+			}
+			if fetched[string(fetchStatusKey)] {
+				continue
+			}
+			fetched[string(fetchStatusKey)] = true
+			feeds = append(feeds, feed)
 		}
 		countFeeds := len(feeds)
 		completed := make(chan int)
 		for i, feed := range feeds {
 			go func(config data.UserFeed, index int) {
-				// TODO: skip this page if it was already fetched this round.
-				fetcher.FetchFeed(config.URL)
+				if config.Type == sitemapFeedType {
+					fetcher.FetchSitemap(&config)
+				} else if config.Type != EmailFeedType {
+					fetcher.FetchFeed(config.URL)
+				}
 				completed <- index
 			}(feed, i)
 		}
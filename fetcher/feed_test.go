@@ -126,6 +126,65 @@ func TestFetchFeedError(t *testing.T) {
 	dbMock.AssertExpectations(t)
 }
 
+func TestPreviewFeed(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/rss").Reply(200).
+		BodyString(`<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0">
+<channel>
+<title>Site 1</title>
+<link>http://site1</link>
+<description>Site 1 news</description>
+<item><title>Title 1</title><link>http://site1/link1</link><description>Text 1<script>alert()</script></description><pubDate>Wed, 08 Jun 2016 10:34:00 GMT</pubDate><guid>Item@1</guid></item>
+<item><title>Title 2</title><link>http://site1/link2</link><description>Text 2</description><pubDate>yesterday</pubDate><guid>Item@1</guid></item>
+<item><description>Text 3</description></item>
+</channel>
+</rss>`)
+
+	dbMock := new(DBMock)
+	fetcher := Fetcher{
+		DB:     dbMock,
+		Client: &http.Client{},
+	}
+	fetcher.TagsPolicy = NewFetcher(dbMock).TagsPolicy
+
+	parsed, err := fetcher.PreviewFeed("http://site1/rss")
+	assert.NoError(t, err)
+	assert.Equal(t, FeedFormatRSS, parsed.Format)
+	assert.Equal(t, "Site 1", parsed.Title)
+	assert.Equal(t, "http://site1", parsed.URL)
+	assert.Equal(t, "Site 1 news", parsed.Description)
+	assert.Len(t, parsed.Items, 3)
+	if len(parsed.Items) == 3 {
+		assert.Equal(t, "Text 1", parsed.Items[0].Contents)
+	}
+	assert.Equal(t, []string{"yesterday"}, parsed.FailedDates)
+	assert.Equal(t, []string{
+		`item "Title 2" has a duplicate GUID Item@1 and will overwrite another item`,
+		"item #3 has no title",
+		"item #3 has no link",
+		"item #3 has no GUID",
+	}, parsed.Warnings)
+	dbMock.AssertExpectations(t)
+}
+
+func TestPreviewFeedError(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/rss").Reply(404)
+
+	// A default client is used without changing the shared fetcher.
+	dbMock := new(DBMock)
+	fetcher := Fetcher{DB: dbMock}
+
+	parsed, err := fetcher.PreviewFeed("http://site1/rss")
+	assert.Error(t, err)
+	assert.Nil(t, fetcher.Client)
+	assert.Nil(t, parsed)
+	dbMock.AssertExpectations(t)
+}
+
 func TestFetchAllFeeds(t *testing.T) {
 	defer gock.Off()
 
@@ -158,14 +217,17 @@ func TestFetchAllFeeds(t *testing.T) {
 		Client: &http.Client{},
 	}
 
-	user := data.User{Opml: `<opml version="1.0">` +
-		`<body>` +
-		`<outline title="Feed 1" type="rss" xmlUrl="http://site1/rss"/>` +
-		`<outline title="Feed 2" type="rss" xmlUrl="http://site2/rss"/>` +
-		`</body>` +
-		`</opml>`}
-	dbMock.On("GetUsers").Return([]string{"user01"}, nil).Once()
+	user := data.User{Subscriptions: []data.UserFeed{
+		{URL: "http://site1/rss", Title: "Feed 1", Type: "rss"},
+		{URL: "http://site2/rss", Title: "Feed 2", Type: "rss"},
+	}}
+	// Feeds shared with another user are fetched only once.
+	user2 := data.User{Subscriptions: []data.UserFeed{
+		{URL: "http://site2/rss", Title: "Shared Feed 2", Type: "rss"},
+	}}
+	dbMock.On("GetUsers").Return([]string{"user01", "user02"}, nil).Once()
 	dbMock.On("GetUser", "user01").Return(&user, nil).Once()
+	dbMock.On("GetUser", "user02").Return(&user2, nil).Once()
 
 	beforeUpdate := time.Now()
 	dbSavedItems := make([][]*data.Feeditem, 0, 2)
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/microcosm-cc/bluemonday"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/charset"
@@ -27,12 +28,21 @@ var dateFormats = []string{
 
 // sanitizeHTML removes unsafe HTML and replaces relative URLs with absolute ones.
 func (fetcher *Fetcher) sanitizeHTML(baseURL string, items []*data.Feeditem) {
-	if fetcher.TagsPolicy == nil {
+	SanitizeHTML(fetcher.TagsPolicy, baseURL, items)
+}
+
+// SanitizeHTML removes unsafe HTML from items according to policy.
+// If baseURL is not empty, relative URLs are replaced with absolute ones.
+func SanitizeHTML(policy *bluemonday.Policy, baseURL string, items []*data.Feeditem) {
+	if policy == nil {
 		return
 	}
 
 	for _, item := range items {
-		item.Contents = fetcher.TagsPolicy.Sanitize(item.Contents)
+		item.Contents = policy.Sanitize(item.Contents)
+		if baseURL == "" {
+			continue
+		}
 		fixedURLs, err := fixURLs(baseURL, item.Contents)
 		if err != nil {
 			log.WithError(err).WithField("itemURL", item.URL).Error("failed to process URLs")
@@ -91,8 +101,75 @@ func fixURLAttributes(token *html.Token, baseURL *url.URL) error {
 	return nil
 }
 
+// Feed formats detected by the parser.
+const (
+	FeedFormatAtom      = "atom"
+	FeedFormatRSS       = "rss"
+	FeedFormatRDF       = "rdf"
+	FeedFormatICalendar = "icalendar"
+)
+
+// ParsedFeed contains a parsed feed and the problems found while parsing it.
+type ParsedFeed struct {
+	Format      string
+	Title       string
+	URL         string
+	Description string
+	Items       []*data.Feeditem
+	FailedDates []string
+	Warnings    []string
+}
+
+// addFailedDate records a date which could not be parsed.
+func (parsed *ParsedFeed) addFailedDate(value string) {
+	value = strings.TrimSpace(value)
+	if value != "" {
+		parsed.FailedDates = append(parsed.FailedDates, value)
+	}
+}
+
+// checkItems adds warnings for items which might not be displayed correctly.
+func (parsed *ParsedFeed) checkItems() {
+	if len(parsed.Items) == 0 {
+		parsed.Warnings = append(parsed.Warnings, "feed has no items")
+		return
+	}
+	itemName := func(i int, item *data.Feeditem) string {
+		if item.Title != "" {
+			return fmt.Sprintf("%q", item.Title)
+		}
+		return fmt.Sprintf("#%v", i+1)
+	}
+	guids := make(map[string]bool, len(parsed.Items))
+	for i, item := range parsed.Items {
+		if item.Title == "" {
+			parsed.Warnings = append(parsed.Warnings, fmt.Sprintf("item %v has no title", itemName(i, item)))
+		}
+		if item.URL == "" {
+			parsed.Warnings = append(parsed.Warnings, fmt.Sprintf("item %v has no link", itemName(i, item)))
+		}
+		if item.Key.GUID == "" {
+			parsed.Warnings = append(parsed.Warnings, fmt.Sprintf("item %v has no GUID", itemName(i, item)))
+			continue
+		}
+		if guids[item.Key.GUID] {
+			parsed.Warnings = append(parsed.Warnings, fmt.Sprintf("item %v has a duplicate GUID %v and will overwrite another item", itemName(i, item), item.Key.GUID))
+		}
+		guids[item.Key.GUID] = true
+	}
+}
+
 // ParseFeed parses a downloaded XML feed.
 func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Feeditem, error) {
+	parsed, err := fetcher.parseFeed(feedURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Items, nil
+}
+
+// parseFeed parses a downloaded XML feed, keeping the feed metadata and all problems found in the feed.
+func (fetcher *Fetcher) parseFeed(feedURL string, reader io.Reader) (*ParsedFeed, error) {
 	// Atom
 	type AtomFeedEntry struct {
 		Title     string `xml:"http://www.w3.org/2005/Atom title"`
@@ -112,6 +189,12 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 		} `xml:"http://www.w3.org/2005/Atom link"`
 	}
 	type AtomFeed struct {
+		AtomTitle    string `xml:"http://www.w3.org/2005/Atom title"`
+		AtomSubtitle string `xml:"http://www.w3.org/2005/Atom subtitle"`
+		AtomLinks    []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"http://www.w3.org/2005/Atom link"`
 		AtomFeedEntries []AtomFeedEntry `xml:"http://www.w3.org/2005/Atom entry"`
 	}
 	// RSS
@@ -137,12 +220,19 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 	type RDFFeed struct {
 		RDFFeedEntries []RDFFeedEntry `xml:"item"`
 	}
+	// Channel metadata (shared by RSS and RDF)
+	type FeedChannel struct {
+		ChannelTitle       string `xml:"channel>title"`
+		ChannelLink        string `xml:"channel>link"`
+		ChannelDescription string `xml:"channel>description"`
+	}
 	// Common feed XML
 	type FeedXML struct {
 		XMLName  xml.Name
 		AtomFeed `xml:"http://www.w3.org/2005/Atom feed"`
 		RSSFeed  `xml:"rss"`
 		RDFFeed  `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# RDF"`
+		FeedChannel
 	}
 
 	//Parse XML
@@ -185,8 +275,19 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 	}
 
 	// Convert into a common format.
+	parsed := &ParsedFeed{}
 	if feedXML.XMLName.Local == "feed" {
 		// Atom.
+		parsed.Format = FeedFormatAtom
+		parsed.Title = strings.TrimSpace(feedXML.AtomFeed.AtomTitle)
+		parsed.Description = strings.TrimSpace(feedXML.AtomFeed.AtomSubtitle)
+		for _, link := range feedXML.AtomFeed.AtomLinks {
+			if link.Href != "" && (link.Rel == "alternate" || link.Rel == "") {
+				parsed.URL = link.Href
+				break
+			}
+		}
+
 		items := make([]*data.Feeditem, len(feedXML.AtomFeed.AtomFeedEntries))
 
 		for i, atomItem := range feedXML.AtomFeed.AtomFeedEntries {
@@ -205,6 +306,8 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 					item.Date = dateParsed
 				} else {
 					log.WithField("date", atomItem.Published).WithError(err).Info("Failed to parse published time")
+					parsed.addFailedDate(atomItem.Updated)
+					parsed.addFailedDate(atomItem.Published)
 				}
 			}
 
@@ -242,9 +345,14 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 
 		fetcher.sanitizeHTML(feedURL, items)
 
-		return items, nil
+		parsed.Items = items
 	} else if feedXML.XMLName.Local == "rss" {
 		// RSS.
+		parsed.Format = FeedFormatRSS
+		parsed.Title = strings.TrimSpace(feedXML.FeedChannel.ChannelTitle)
+		parsed.URL = strings.TrimSpace(feedXML.FeedChannel.ChannelLink)
+		parsed.Description = strings.TrimSpace(feedXML.FeedChannel.ChannelDescription)
+
 		items := make([]*data.Feeditem, len(feedXML.RSSFeed.RSSFeedEntries))
 
 		fallbackDate := currentTime
@@ -253,6 +361,7 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 			fallbackDate = dateParsed
 		} else {
 			log.WithField("date", feedXML.RSSFeed.Published).WithError(err).Debug("Failed to parse feed published time")
+			parsed.addFailedDate(feedXML.RSSFeed.Published)
 		}
 
 		for i, rssItem := range feedXML.RSSFeed.RSSFeedEntries {
@@ -266,6 +375,7 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 				item.Date = dateParsed
 			} else {
 				log.WithField("date", rssItem.Published).WithField("item", rssItem).WithError(err).Info("Failed to parse published time")
+				parsed.addFailedDate(rssItem.Published)
 			}
 
 			item.Contents = strings.TrimSpace(rssItem.Content)
@@ -289,9 +399,14 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 
 		fetcher.sanitizeHTML(feedURL, items)
 
-		return items, nil
+		parsed.Items = items
 	} else if feedXML.XMLName.Local == "RDF" {
 		// RDF.
+		parsed.Format = FeedFormatRDF
+		parsed.Title = strings.TrimSpace(feedXML.FeedChannel.ChannelTitle)
+		parsed.URL = strings.TrimSpace(feedXML.FeedChannel.ChannelLink)
+		parsed.Description = strings.TrimSpace(feedXML.FeedChannel.ChannelDescription)
+
 		items := make([]*data.Feeditem, len(feedXML.RDFFeed.RDFFeedEntries))
 		for i, rdfItem := range feedXML.RDFFeed.RDFFeedEntries {
 			item := &data.Feeditem{
@@ -304,6 +419,7 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 				item.Date = dateParsed
 			} else {
 				log.WithField("date", rdfItem.Date).WithError(err).Info("Failed to parse time")
+				parsed.addFailedDate(rdfItem.Date)
 			}
 
 			item.Contents = strings.TrimSpace(rdfItem.Description)
@@ -321,8 +437,11 @@ func (fetcher *Fetcher) ParseFeed(feedURL string, reader io.Reader) ([]*data.Fee
 
 		fetcher.sanitizeHTML(feedURL, items)
 
-		return items, nil
+		parsed.Items = items
+	} else {
+		return nil, fmt.Errorf("unknown feed type %v", feedXML.XMLName)
 	}
 
-	return nil, fmt.Errorf("unknown feed type %v", feedXML.XMLName)
+	parsed.checkItems()
+	return parsed, nil
 }
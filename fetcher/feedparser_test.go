@@ -385,3 +385,39 @@ func TestParseRdfEmpty(t *testing.T) {
 
 	assert.Equal(t, []*data.Feeditem{}, items)
 }
+
+func TestParseAtomMetadata(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>Site 1</title>
+<subtitle>Site 1 news</subtitle>
+<link rel="self" href="http://site1/atom"/>
+<link rel="alternate" href="http://site1"/>
+<entry>
+<title>Title 1</title>
+<link href="http://site1/link1"/>
+<updated>13 Dec 2003</updated>
+<published>12 Dec 2003</published>
+</entry>
+</feed>`
+	fetcher := NewFetcher(nil)
+	parsed, err := fetcher.parseFeed("http://site1/atom", bytes.NewBufferString(feed))
+	assert.NoError(t, err)
+	assert.Equal(t, FeedFormatAtom, parsed.Format)
+	assert.Equal(t, "Site 1", parsed.Title)
+	assert.Equal(t, "http://site1", parsed.URL)
+	assert.Equal(t, "Site 1 news", parsed.Description)
+	assert.Len(t, parsed.Items, 1)
+	assert.Equal(t, []string{"13 Dec 2003", "12 Dec 2003"}, parsed.FailedDates)
+	assert.Empty(t, parsed.Warnings)
+}
+
+func TestParseEmptyWarning(t *testing.T) {
+	fetcher := NewFetcher(nil)
+	parsed, err := fetcher.parseFeed("http://site1/rdf", bytes.NewBufferString(`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"><channel><title>Site 1</title></channel></rdf:RDF>`))
+	assert.NoError(t, err)
+	assert.Equal(t, FeedFormatRDF, parsed.Format)
+	assert.Equal(t, "Site 1", parsed.Title)
+	assert.Empty(t, parsed.Items)
+	assert.Equal(t, []string{"feed has no items"}, parsed.Warnings)
+}
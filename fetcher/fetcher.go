@@ -13,6 +13,7 @@ import (
 type DB interface {
 	GetPage(*data.UserPagemonitor) (*data.PagemonitorPage, error)
 	SavePage(*data.PagemonitorPage) error
+	GetFeeditem(*data.FeeditemKey) (*data.Feeditem, error)
 	SaveFeeditems(...*data.Feeditem) (err error)
 	SetFetchStatus([]byte, *data.FetchStatus) error
 	SetReadStatusForAll(k []byte, read bool) error
@@ -27,9 +28,17 @@ type Fetcher struct {
 	TagsPolicy *bluemonday.Policy
 }
 
+// NewTagsPolicy creates the policy used to sanitize HTML from all sources.
+func NewTagsPolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	// Inline images from emails are converted into data URIs.
+	policy.AllowDataURIImages()
+	return policy
+}
+
 // NewFetcher creates a new Fetcher instance with db.
 func NewFetcher(db DB) *Fetcher {
-	policy := bluemonday.UGCPolicy()
+	policy := NewTagsPolicy()
 	return &Fetcher{DB: db, TagsPolicy: policy}
 }
 
@@ -47,6 +47,14 @@ func (m *DBMock) SetFetchStatus(key []byte, fetchStatus *data.FetchStatus) error
 	return args.Error(0)
 }
 
+func (m *DBMock) GetFeeditem(key *data.FeeditemKey) (*data.Feeditem, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*data.Feeditem), args.Error(1)
+}
+
 func (m *DBMock) SaveFeeditems(feedItems ...*data.Feeditem) error {
 	args := m.Called(feedItems)
 	return args.Error(0)
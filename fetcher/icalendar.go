@@ -0,0 +1,210 @@
+package fetcher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+// icalendarHeader is the first line of an iCalendar file.
+const icalendarHeader = "BEGIN:VCALENDAR"
+
+// recurrenceIDSeparator separates the UID from the RECURRENCE-ID of a modified recurring event instance.
+const recurrenceIDSeparator = "#"
+
+// icalendarProperty is a single (unfolded) content line from an iCalendar file.
+type icalendarProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// isICalendar checks if reader contains an iCalendar file, without consuming any data.
+func isICalendar(reader *bufio.Reader) bool {
+	// Skip a possible UTF-8 BOM and leading whitespace.
+	header, _ := reader.Peek(len(icalendarHeader) + 16)
+	header = bytes.TrimPrefix(header, []byte("\xef\xbb\xbf"))
+	header = bytes.TrimLeft(header, " \t\r\n")
+	return bytes.HasPrefix(bytes.ToUpper(header), []byte(icalendarHeader))
+}
+
+// readICalendarLines reads and unfolds all content lines from reader.
+func readICalendarLines(reader io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lines := make([]string, 0)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// parseICalendarProperty parses a content line into a property.
+func parseICalendarProperty(line string) (*icalendarProperty, error) {
+	// Find the first colon which is not inside a quoted parameter value.
+	inQuotes := false
+	valueStart := -1
+	for i, c := range line {
+		if c == '"' {
+			inQuotes = !inQuotes
+		} else if c == ':' && !inQuotes {
+			valueStart = i
+			break
+		}
+	}
+	if valueStart < 0 {
+		return nil, fmt.Errorf("invalid iCalendar content line %v", line)
+	}
+
+	property := &icalendarProperty{Params: map[string]string{}, Value: line[valueStart+1:]}
+	parts := strings.Split(line[:valueStart], ";")
+	property.Name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		paramName, paramValue, _ := strings.Cut(param, "=")
+		property.Params[strings.ToUpper(paramName)] = strings.Trim(paramValue, `"`)
+	}
+	return property, nil
+}
+
+// unescapeICalendarText decodes an iCalendar TEXT value.
+func unescapeICalendarText(value string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
+
+// parseICalendarTime parses a DATE or DATE-TIME property, taking the TZID parameter into account.
+func parseICalendarTime(property *icalendarProperty) (time.Time, error) {
+	value := strings.TrimSpace(property.Value)
+	if property.Params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		return time.Parse("20060102", value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	location := time.UTC
+	if tzid := property.Params["TZID"]; tzid != "" {
+		tzLocation, err := time.LoadLocation(tzid)
+		if err != nil {
+			log.WithField("tzid", tzid).WithError(err).Info("Failed to load timezone, using UTC")
+		} else {
+			location = tzLocation
+		}
+	}
+	return time.ParseInLocation("20060102T150405", value, location)
+}
+
+// ParseICalendar parses a downloaded iCalendar file and returns an item for every event.
+func (fetcher *Fetcher) ParseICalendar(feedURL string, reader io.Reader) ([]*data.Feeditem, error) {
+	parsed, err := fetcher.parseICalendar(feedURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Items, nil
+}
+
+// parseICalendar parses a downloaded iCalendar file, keeping the calendar metadata and all problems found in the file.
+func (fetcher *Fetcher) parseICalendar(feedURL string, reader io.Reader) (*ParsedFeed, error) {
+	lines, err := readICalendarLines(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &ParsedFeed{Format: FeedFormatICalendar}
+	items := make([]*data.Feeditem, 0)
+	var event *data.Feeditem
+	var recurrenceID string
+	// Components nested in a VEVENT (such as VALARM) have their own properties which should be ignored.
+	nestedComponents := 0
+	for _, line := range lines {
+		property, err := parseICalendarProperty(line)
+		if err != nil {
+			log.WithError(err).Debug("Skipping invalid iCalendar line")
+			continue
+		}
+		value := strings.ToUpper(strings.TrimSpace(property.Value))
+		if property.Name == "BEGIN" && value == "VEVENT" {
+			event = &data.Feeditem{Key: &data.FeeditemKey{FeedURL: feedURL}}
+			recurrenceID = ""
+			nestedComponents = 0
+			continue
+		}
+		if event == nil {
+			switch property.Name {
+			case "X-WR-CALNAME":
+				parsed.Title = unescapeICalendarText(property.Value)
+			case "X-WR-CALDESC":
+				parsed.Description = unescapeICalendarText(property.Value)
+			}
+			continue
+		}
+		if property.Name == "BEGIN" {
+			nestedComponents++
+			continue
+		}
+		if property.Name == "END" && value != "VEVENT" {
+			nestedComponents--
+			continue
+		}
+		if property.Name == "END" {
+			if event.Key.GUID == "" {
+				log.WithField("event", event.Title).Info("Skipping event without UID")
+				parsed.Warnings = append(parsed.Warnings, fmt.Sprintf("event %q has no UID and was skipped", event.Title))
+			} else {
+				if recurrenceID != "" {
+					event.Key.GUID += recurrenceIDSeparator + recurrenceID
+				}
+				items = append(items, event)
+			}
+			event = nil
+			continue
+		}
+		if nestedComponents > 0 {
+			continue
+		}
+
+		switch property.Name {
+		case "UID":
+			event.Key.GUID = strings.TrimSpace(property.Value)
+		case "RECURRENCE-ID":
+			recurrenceID = strings.TrimSpace(property.Value)
+		case "SUMMARY":
+			event.Title = unescapeICalendarText(property.Value)
+		case "DESCRIPTION":
+			description := html.EscapeString(strings.TrimSpace(unescapeICalendarText(property.Value)))
+			event.Contents = strings.ReplaceAll(description, "\n", "<br>")
+		case "URL":
+			event.URL = strings.TrimSpace(property.Value)
+		case "DTSTART":
+			event.Date, err = parseICalendarTime(property)
+			if err != nil {
+				log.WithField("date", property.Value).WithError(err).Info("Failed to parse event start time")
+				parsed.addFailedDate(property.Value)
+			}
+		}
+	}
+
+	fetcher.sanitizeHTML(feedURL, items)
+
+	parsed.Items = items
+	parsed.checkItems()
+	return parsed, nil
+}
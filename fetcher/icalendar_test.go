@@ -0,0 +1,167 @@
+package fetcher
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+var icalendarFeed = strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//Test//EN
+BEGIN:VEVENT
+UID:event1@site1
+SUMMARY:Conference\, day 1
+DESCRIPTION:First line\nSecond <line>
+DTSTART:20190216T230000Z
+URL:http://site1/event1
+BEGIN:VALARM
+DESCRIPTION:Alarm
+END:VALARM
+END:VEVENT
+BEGIN:VEVENT
+UID:event2@site1
+SUMMARY:Release
+DESCRIPTION:A long description which is fol
+ ded
+DTSTART;TZID=Europe/Berlin:20190218T100000
+END:VEVENT
+BEGIN:VEVENT
+UID:event3@site1
+SUMMARY:Holiday
+DTSTART;VALUE=DATE:20190220
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:No UID
+DTSTART:20190221T100000Z
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")
+
+var berlin, _ = time.LoadLocation("Europe/Berlin")
+
+var expectedIcalendarItems = []*data.Feeditem{
+	{
+		Title:    "Conference, day 1",
+		URL:      "http://site1/event1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "First line<br>Second &lt;line&gt;",
+		Key:      &data.FeeditemKey{FeedURL: "http://site1/calendar.ics", GUID: "event1@site1"},
+	},
+	{
+		Title:    "Release",
+		Date:     time.Date(2019, time.February, 18, 10, 0, 0, 0, berlin),
+		Contents: "A long description which is folded",
+		Key:      &data.FeeditemKey{FeedURL: "http://site1/calendar.ics", GUID: "event2@site1"},
+	},
+	{
+		Title: "Holiday",
+		Date:  time.Date(2019, time.February, 20, 0, 0, 0, 0, time.UTC),
+		Key:   &data.FeeditemKey{FeedURL: "http://site1/calendar.ics", GUID: "event3@site1"},
+	},
+}
+
+func TestParseICalendar(t *testing.T) {
+	fetcher := NewFetcher(nil)
+	items, err := fetcher.ParseICalendar("http://site1/calendar.ics", strings.NewReader(icalendarFeed))
+	assert.NoError(t, err)
+	assert.Equal(t, expectedIcalendarItems, items)
+}
+
+func TestParseICalendarMetadata(t *testing.T) {
+	feed := "BEGIN:VCALENDAR\r\n" +
+		"X-WR-CALNAME:Releases\r\n" +
+		"X-WR-CALDESC:Release calendar\\, updated daily\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:event1@site1\r\n" +
+		"SUMMARY:Release\r\n" +
+		"URL:http://site1/event1\r\n" +
+		"DTSTART:tomorrow\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	fetcher := NewFetcher(nil)
+	parsed, err := fetcher.parseICalendar("http://site1/calendar.ics", strings.NewReader(feed))
+	assert.NoError(t, err)
+	assert.Equal(t, FeedFormatICalendar, parsed.Format)
+	assert.Equal(t, "Releases", parsed.Title)
+	assert.Equal(t, "Release calendar, updated daily", parsed.Description)
+	assert.Len(t, parsed.Items, 1)
+	assert.Equal(t, []string{"tomorrow"}, parsed.FailedDates)
+	assert.Empty(t, parsed.Warnings)
+}
+
+func TestParseICalendarRecurrence(t *testing.T) {
+	feed := `BEGIN:VCALENDAR` + "\n" +
+		`BEGIN:VEVENT` + "\n" +
+		`UID:standup` + "\n" +
+		`SUMMARY:Standup` + "\n" +
+		`DTSTART:20190218T090000Z` + "\n" +
+		`RRULE:FREQ=DAILY` + "\n" +
+		`END:VEVENT` + "\n" +
+		`BEGIN:VEVENT` + "\n" +
+		`UID:standup` + "\n" +
+		`RECURRENCE-ID:20190219T090000Z` + "\n" +
+		`SUMMARY:Standup (moved)` + "\n" +
+		`DTSTART:20190219T110000Z` + "\n" +
+		`END:VEVENT` + "\n" +
+		`END:VCALENDAR`
+	fetcher := NewFetcher(nil)
+	items, err := fetcher.ParseICalendar("http://site1/calendar.ics", strings.NewReader(feed))
+	assert.NoError(t, err)
+	assert.Equal(t, []*data.Feeditem{
+		{
+			Title: "Standup",
+			Date:  time.Date(2019, time.February, 18, 9, 0, 0, 0, time.UTC),
+			Key:   &data.FeeditemKey{FeedURL: "http://site1/calendar.ics", GUID: "standup"},
+		},
+		{
+			Title: "Standup (moved)",
+			Date:  time.Date(2019, time.February, 19, 11, 0, 0, 0, time.UTC),
+			Key:   &data.FeeditemKey{FeedURL: "http://site1/calendar.ics", GUID: "standup#20190219T090000Z"},
+		},
+	}, items)
+}
+
+func TestFetchICalendarRescheduled(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/calendar.ics").Reply(200).
+		BodyString(icalendarFeed)
+
+	dbMock := new(DBMock)
+	fetcher := Fetcher{
+		DB:     dbMock,
+		Client: &http.Client{},
+	}
+
+	feedURL := "http://site1/calendar.ics"
+	previousDate := time.Date(2019, time.February, 17, 10, 0, 0, 0, berlin)
+	dbMock.On("GetFeeditem", expectedIcalendarItems[0].Key).Return(nil, nil).Once()
+	dbMock.On("GetFeeditem", expectedIcalendarItems[1].Key).Return(&data.Feeditem{Date: previousDate}, nil).Once()
+	dbMock.On("GetFeeditem", expectedIcalendarItems[2].Key).Return(&data.Feeditem{Date: expectedIcalendarItems[2].Date}, nil).Once()
+	dbMock.On("SetReadStatusForAll", expectedIcalendarItems[1].Key.CreateKey(), false).Return(nil).Once()
+
+	beforeUpdate := time.Now()
+	dbMock.On("SaveFeeditems", mock.AnythingOfType("[]*data.Feeditem")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			currentTime := time.Now()
+			savedItems := args.Get(0).([]*data.Feeditem)
+			for _, savedItem := range savedItems {
+				assertTimeBetween(t, beforeUpdate, currentTime, savedItem.Updated)
+				savedItem.Updated = time.Time{}
+			}
+			assert.Equal(t, expectedIcalendarItems, savedItems)
+		})
+	dbMock.On("SetFetchStatus", (&data.UserFeed{URL: feedURL}).CreateKey(), mock.AnythingOfType("*data.FetchStatus")).Return(nil).Once()
+
+	err := fetcher.FetchFeed(feedURL)
+	assert.NoError(t, err)
+	dbMock.AssertExpectations(t)
+}
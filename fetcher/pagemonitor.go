@@ -28,55 +28,105 @@ func (fetcher *Fetcher) getPreviousResult(config *data.UserPagemonitor) *data.Pa
 	return page
 }
 
+// PagePreview is the result of checking a page without saving it.
+type PagePreview struct {
+	// Contents is the page text, filtered by the match regex.
+	Contents string
+	// Delta is the diff between the last saved version and Contents; it's empty if the page hasn't changed.
+	Delta string
+}
+
+// getPageText fetches a page using client and converts it into plain text.
+func (fetcher *Fetcher) getPageText(client *http.Client, config *data.UserPagemonitor) (string, error) {
+	resp, err := client.Get(config.URL)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("cannot GET page (status code %v)", resp.StatusCode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot GET page %v: %w", config, err)
+	}
+
+	text, err := convertHTMLtoText(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("cannot convert HTML to text %v: %w", config, err)
+	}
+	return text, nil
+}
+
+// diffPage filters text and previousText based on config.
+// Returns the filtered text and its diff with the filtered previousText, or an empty diff if nothing changed.
+func diffPage(config *data.UserPagemonitor, previousText, text string) (string, string, error) {
+	var textFiltered, previousTextFiltered string
+	if config.Match != "" {
+		regex, err := regexp.Compile(config.Match)
+		if err != nil {
+			return "", "", fmt.Errorf("cannot compile match regex %v: %w", config, err)
+		}
+		textFiltered = regex.ReplaceAllString(text, config.Replace)
+		previousTextFiltered = regex.ReplaceAllString(previousText, config.Replace)
+	} else {
+		textFiltered = text
+		previousTextFiltered = previousText
+	}
+
+	if previousTextFiltered == textFiltered {
+		return textFiltered, "", nil
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:       difflib.SplitLines(previousTextFiltered),
+		B:       difflib.SplitLines(textFiltered),
+		Context: 3,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("cannot create diff for page %v: %w", config, err)
+	}
+	return textFiltered, diff, nil
+}
+
+// PreviewPage fetches a page and compares it with the last saved version, without saving anything into the database.
+func (fetcher *Fetcher) PreviewPage(config *data.UserPagemonitor) (*PagePreview, error) {
+	// Don't modify fetcher, it can be shared with other requests and the background refresh.
+	client := fetcher.Client
+	if client == nil {
+		client = &http.Client{}
+	}
+	text, err := fetcher.getPageText(client, config)
+	if err != nil {
+		return nil, err
+	}
+	textFiltered, diff, err := diffPage(config, fetcher.getPreviousResult(config).Contents, text)
+	if err != nil {
+		return nil, err
+	}
+	return &PagePreview{Contents: textFiltered, Delta: diff}, nil
+}
+
 // FetchPage fetches a page and performs a diff based on config.
 // On success, it's saved into the database.
 func (fetcher *Fetcher) FetchPage(config *data.UserPagemonitor) error {
 	err := func() error {
 		page := fetcher.getPreviousResult(config)
 
-		resp, err := fetcher.Client.Get(config.URL)
-		if err == nil {
-			defer resp.Body.Close()
-		}
-
-		if err == nil && resp.StatusCode != http.StatusOK {
-			err = fmt.Errorf("cannot GET page (status code %v)", resp.StatusCode)
-		}
+		text, err := fetcher.getPageText(fetcher.Client, config)
 		if err != nil {
-			return fmt.Errorf("cannot GET page %v: %w", config, err)
+			return err
 		}
 
-		text, err := convertHTMLtoText(resp.Body)
+		_, diff, err := diffPage(config, page.Contents, text)
 		if err != nil {
-			return fmt.Errorf("cannot convert HTML to text %v: %w", config, err)
-		}
-
-		var textFiltered, previousTextFiltered string
-		if config.Match != "" {
-			regex, err := regexp.Compile(config.Match)
-			if err != nil {
-				return fmt.Errorf("cannot compile match regex %v: %w", config, err)
-			}
-			textFiltered = regex.ReplaceAllString(text, config.Replace)
-			previousTextFiltered = regex.ReplaceAllString(page.Contents, config.Replace)
-		} else {
-			textFiltered = text
-			previousTextFiltered = page.Contents
+			return err
 		}
 
-		if previousTextFiltered == textFiltered {
+		if diff == "" {
 			// Save if nothing changed to update last seen time
 			return fetcher.DB.SavePage(page)
 		}
 
-		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
-			A:       difflib.SplitLines(previousTextFiltered),
-			B:       difflib.SplitLines(textFiltered),
-			Context: 3,
-		})
-		if err != nil {
-			return fmt.Errorf("cannot create diff for page %v: %w", config, err)
-		}
 		page.Delta = diff
 		page.Contents = text
 		page.Updated = time.Now()
@@ -113,22 +163,26 @@ func (fetcher *Fetcher) FetchAllPages() error {
 		log.WithError(err).Error("Failed to get list of users")
 		return err
 	}
+	// Pages shared by several users are fetched (and counted in the fetch status) only once per round.
+	fetched := make(map[string]bool)
 	for _, username := range usernames {
 		user, err := fetcher.DB.GetUser(username)
 		if err != nil {
 			log.WithField("username", username).WithError(err).Error("Failed to get user")
 			return err
 		}
-		pages, err := user.GetPages()
-		if err != nil {
-			log.WithError(err).Error("Failed to get pages")
-			continue
+		pages := make([]data.UserPagemonitor, 0)
+		for _, page := range user.GetPages() {
+			if fetched[string(page.CreateKey())] {
+				continue
+			}
+			fetched[string(page.CreateKey())] = true
+			pages = append(pages, page)
 		}
 		countPages := len(pages)
 		completed := make(chan int)
 		for i, page := range pages {
 			go func(config data.UserPagemonitor, index int) {
-				// TODO: skip this page if it was already fetched this round.
 				fetcher.FetchPage(&config)
 				completed <- index
 			}(page, i)
@@ -216,6 +216,65 @@ func TestFetchPageError(t *testing.T) {
 	dbMock.AssertExpectations(t)
 }
 
+func TestPreviewPage(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/1").Reply(200).
+		BodyString("Hello World<br>Updated page<br>New Line")
+	gock.New("http://site1").Get("/1").Reply(200).
+		BodyString("Hello World<br>Updated page<br>New Line")
+
+	dbMock := new(DBMock)
+	fetcher := Fetcher{
+		DB:     dbMock,
+		Client: &http.Client{},
+	}
+
+	pageConfig := data.UserPagemonitor{
+		URL:     "http://site1/1",
+		Title:   "Site 1",
+		Match:   "(?msi)^.*(hello .* page).*$",
+		Replace: "$1",
+	}
+	existingResult := data.PagemonitorPage{
+		Contents: "Hello World\nFirst page",
+		Updated:  time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+	}
+	dbMock.On("GetPage", &pageConfig).Return(&existingResult, nil).Once()
+	dbMock.On("GetPage", &pageConfig).Return(nil, nil).Once()
+
+	preview, err := fetcher.PreviewPage(&pageConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, &PagePreview{
+		Contents: "Hello World\nUpdated page",
+		Delta:    "@@ -1,2 +1,2 @@\n Hello World\n-First page\n+Updated page\n",
+	}, preview)
+
+	preview, err = fetcher.PreviewPage(&pageConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, &PagePreview{
+		Contents: "Hello World\nUpdated page",
+		Delta:    "@@ -1 +1,2 @@\n-\n+Hello World\n+Updated page\n",
+	}, preview)
+	dbMock.AssertExpectations(t)
+}
+
+func TestPreviewPageError(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/1").Reply(404)
+
+	// A default client is used without changing the shared fetcher.
+	dbMock := new(DBMock)
+	fetcher := Fetcher{DB: dbMock}
+
+	preview, err := fetcher.PreviewPage(&data.UserPagemonitor{URL: "http://site1/1"})
+	assert.Error(t, err)
+	assert.Nil(t, preview)
+	assert.Nil(t, fetcher.Client)
+	dbMock.AssertExpectations(t)
+}
+
 func TestFetchTwoPages(t *testing.T) {
 	defer gock.Off()
 
@@ -242,12 +301,12 @@ func TestFetchTwoPages(t *testing.T) {
 	}
 	beforeUpdate := time.Now()
 
-	user := data.User{Pagemonitor: `<pages>` +
-		`<page url="http://site1/1">Site 1</page>` +
-		`<page url="http://site1/2">Site 2</page>` +
-		`</pages>`}
-	dbMock.On("GetUsers").Return([]string{"user01"}, nil).Once()
+	user := data.User{Pages: []data.UserPagemonitor{pageConfig1, pageConfig2}}
+	// Pages shared with another user are fetched only once.
+	user2 := data.User{Pages: []data.UserPagemonitor{{URL: "http://site1/2", Title: "Shared Site 2"}}}
+	dbMock.On("GetUsers").Return([]string{"user01", "user02"}, nil).Once()
 	dbMock.On("GetUser", "user01").Return(&user, nil).Once()
+	dbMock.On("GetUser", "user02").Return(&user2, nil).Once()
 	dbMock.On("GetPage", &pageConfig1).Return(&existingResult1, nil)
 	dbMock.On("GetPage", &pageConfig2).Return(&existingResult2, nil)
 	dbSavedItems := make([]*data.PagemonitorPage, 0, 2)
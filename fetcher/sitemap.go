@@ -0,0 +1,199 @@
+package fetcher
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/html/charset"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+// sitemapFeedType is the subscription type for sitemaps.
+const sitemapFeedType = "sitemap"
+
+// sitemapMaxDepth limits how many levels of nested sitemap indexes will be followed.
+const sitemapMaxDepth = 3
+
+var sitemapDateFormats = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+// sitemapEntry is a url (or sitemap) entry from a sitemap or sitemap index.
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapXML is a sitemap or a sitemap index.
+type sitemapXML struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+// parseSitemapTime parses a W3C datetime used by lastmod.
+func parseSitemapTime(timeStr string) (time.Time, error) {
+	timeStr = strings.TrimSpace(timeStr)
+	for _, format := range sitemapDateFormats {
+		date, err := time.Parse(format, timeStr)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to parse sitemap time %v", timeStr)
+}
+
+// matchesPathPrefix returns true if location matches at least one of prefixes.
+// Prefixes can be either absolute URLs or paths.
+// If prefixes is empty, all locations match.
+func matchesPathPrefix(location string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	locationURL, err := url.Parse(location)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(location, prefix) || strings.HasPrefix(locationURL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSitemap parses a downloaded sitemap or sitemap index, which can optionally be gzip-compressed.
+func parseSitemap(reader io.Reader) (*sitemapXML, error) {
+	bufReader := bufio.NewReader(reader)
+	magic, err := bufReader.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open gzip sitemap: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	} else {
+		reader = bufReader
+	}
+
+	decoder := xml.NewDecoder(reader)
+	decoder.CharsetReader = charset.NewReaderLabel
+	sitemap := &sitemapXML{}
+	if err := decoder.Decode(sitemap); err != nil {
+		return nil, err
+	}
+	if sitemap.XMLName.Local != "urlset" && sitemap.XMLName.Local != "sitemapindex" {
+		return nil, fmt.Errorf("unknown sitemap type %v", sitemap.XMLName)
+	}
+	return sitemap, nil
+}
+
+// fetchSitemapItems downloads the sitemap from sitemapURL and converts it into items.
+// Sitemap indexes are followed recursively, up to sitemapMaxDepth levels.
+func (fetcher *Fetcher) fetchSitemapItems(config *data.UserFeed, sitemapURL string, depth int) ([]*data.Feeditem, error) {
+	resp, err := fetcher.Client.Get(sitemapURL)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("cannot GET sitemap (status code %v)", resp.StatusCode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot GET sitemap %v: %w", sitemapURL, err)
+	}
+
+	sitemap, err := parseSitemap(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse sitemap %v: %w", sitemapURL, err)
+	}
+
+	items := make([]*data.Feeditem, 0, len(sitemap.URLs))
+	for _, childSitemap := range sitemap.Sitemaps {
+		if depth >= sitemapMaxDepth {
+			log.WithField("sitemap", sitemapURL).WithField("child", childSitemap.Loc).Warn("Sitemap index is nested too deep, skipping")
+			continue
+		}
+		childItems, err := fetcher.fetchSitemapItems(config, strings.TrimSpace(childSitemap.Loc), depth+1)
+		if err != nil {
+			log.WithField("sitemap", sitemapURL).WithField("child", childSitemap.Loc).WithError(err).Warn("Failed to get child sitemap, skipping")
+			continue
+		}
+		items = append(items, childItems...)
+	}
+
+	prefixes := strings.Fields(config.PathPrefix)
+	for _, entry := range sitemap.URLs {
+		location := strings.TrimSpace(entry.Loc)
+		if location == "" || !matchesPathPrefix(location, prefixes) {
+			continue
+		}
+		item := &data.Feeditem{
+			Title:    location,
+			URL:      location,
+			Contents: fmt.Sprintf(`<a href="%v">%v</a>`, html.EscapeString(location), html.EscapeString(location)),
+			Key:      &data.FeeditemKey{FeedURL: config.ItemsURL(), GUID: location},
+		}
+		if entry.LastMod != "" {
+			item.Date, err = parseSitemapTime(entry.LastMod)
+			if err != nil {
+				log.WithField("date", entry.LastMod).WithField("url", location).WithError(err).Info("Failed to parse lastmod time")
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// FetchSitemap fetches a sitemap (or sitemap index) from config and saves all new or changed URLs as feed items.
+func (fetcher *Fetcher) FetchSitemap(config *data.UserFeed) error {
+	err := func() error {
+		items, err := fetcher.fetchSitemapItems(config, config.URL, 0)
+		if err != nil {
+			return err
+		}
+
+		if len(items) == 0 {
+			return fmt.Errorf("sitemap %v has no matching items", config.URL)
+		}
+
+		fetcher.sanitizeHTML(config.URL, items)
+
+		if err := fetcher.updateChangedItems(items); err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			item.Updated = time.Now()
+		}
+		return fetcher.DB.SaveFeeditems(items...)
+	}()
+
+	fetchStatus := &data.FetchStatus{}
+	if err != nil {
+		log.WithField("sitemap", config.URL).WithError(err).Error("Failed to get sitemap")
+		fetchStatus.LastFailure = time.Now()
+	} else {
+		fetchStatus.LastSuccess = time.Now()
+	}
+
+	fetchStatusKey := config.CreateKey()
+	if err := fetcher.DB.SetFetchStatus(fetchStatusKey, fetchStatus); err != nil {
+		log.WithField("sitemap", config.URL).WithError(err).Error("Failed to save fetch status for sitemap")
+	}
+	return err
+}
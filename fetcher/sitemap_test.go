@@ -0,0 +1,216 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+const sitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>http://site1/sitemap-docs.xml.gz</loc><lastmod>2019-02-16</lastmod></sitemap>
+</sitemapindex>`
+
+const sitemapDocs = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>http://site1/docs/page1</loc><lastmod>2019-02-16T23:00:00Z</lastmod></url>
+<url><loc>http://site1/docs/page2</loc><lastmod>2019-02-17</lastmod></url>
+<url><loc>http://site1/blog/post1</loc><lastmod>2019-02-18</lastmod></url>
+<url><loc>http://site1/docs/page3</loc></url>
+</urlset>`
+
+func gzipString(t *testing.T, value string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(value))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestFetchSitemapIndex(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/sitemap.xml").Reply(200).
+		BodyString(sitemapIndex)
+	gock.New("http://site1").Get("/sitemap-docs.xml.gz").Reply(200).
+		Body(bytes.NewReader(gzipString(t, sitemapDocs)))
+
+	dbMock := new(DBMock)
+	fetcher := Fetcher{
+		DB:     dbMock,
+		Client: &http.Client{},
+	}
+
+	config := &data.UserFeed{URL: "http://site1/sitemap.xml", Type: "sitemap", PathPrefix: "/docs/"}
+	key1 := &data.FeeditemKey{FeedURL: config.ItemsURL(), GUID: "http://site1/docs/page1"}
+	key2 := &data.FeeditemKey{FeedURL: config.ItemsURL(), GUID: "http://site1/docs/page2"}
+	key3 := &data.FeeditemKey{FeedURL: config.ItemsURL(), GUID: "http://site1/docs/page3"}
+	previousDate := time.Date(2019, time.February, 10, 0, 0, 0, 0, time.UTC)
+
+	dbMock.On("GetFeeditem", key1).Return(&data.Feeditem{Date: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)}, nil).Once()
+	dbMock.On("GetFeeditem", key2).Return(&data.Feeditem{Date: previousDate}, nil).Once()
+	dbMock.On("GetFeeditem", key3).Return(&data.Feeditem{Date: previousDate}, nil).Once()
+	dbMock.On("SetReadStatusForAll", key2.CreateKey(), false).Return(nil).Once()
+
+	expectedItems := []*data.Feeditem{
+		{
+			Title:    "http://site1/docs/page1",
+			URL:      "http://site1/docs/page1",
+			Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			Contents: `<a href="http://site1/docs/page1" rel="nofollow">http://site1/docs/page1</a>`,
+			Key:      key1,
+		},
+		{
+			Title:    "http://site1/docs/page2",
+			URL:      "http://site1/docs/page2",
+			Date:     time.Date(2019, time.February, 17, 0, 0, 0, 0, time.UTC),
+			Contents: `<a href="http://site1/docs/page2" rel="nofollow">http://site1/docs/page2</a>`,
+			Key:      key2,
+		},
+		{
+			Title:    "http://site1/docs/page3",
+			URL:      "http://site1/docs/page3",
+			Date:     previousDate,
+			Contents: `<a href="http://site1/docs/page3" rel="nofollow">http://site1/docs/page3</a>`,
+			Key:      key3,
+		},
+	}
+
+	beforeUpdate := time.Now()
+	fetcher.TagsPolicy = NewFetcher(dbMock).TagsPolicy
+	dbMock.On("SaveFeeditems", mock.AnythingOfType("[]*data.Feeditem")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			currentTime := time.Now()
+			savedItems := args.Get(0).([]*data.Feeditem)
+			for _, savedItem := range savedItems {
+				assertTimeBetween(t, beforeUpdate, currentTime, savedItem.Updated)
+				savedItem.Updated = time.Time{}
+			}
+			assert.Equal(t, expectedItems, savedItems)
+		})
+	dbMock.On("SetFetchStatus", config.CreateKey(), mock.AnythingOfType("*data.FetchStatus")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			currentTime := time.Now()
+			fetchStatus := args.Get(1).(*data.FetchStatus)
+			assertTimeBetween(t, beforeUpdate, currentTime, fetchStatus.LastSuccess)
+			assert.Equal(t, time.Time{}, fetchStatus.LastFailure)
+		})
+
+	err := fetcher.FetchSitemap(config)
+	assert.NoError(t, err)
+	dbMock.AssertExpectations(t)
+}
+
+func TestFetchSitemapNewItems(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/sitemap.xml").Reply(200).
+		BodyString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` +
+			`<url><loc>http://site1/page1</loc></url>` +
+			`</urlset>`)
+
+	dbMock := new(DBMock)
+	fetcher := Fetcher{
+		DB:     dbMock,
+		Client: &http.Client{},
+	}
+
+	config := &data.UserFeed{URL: "http://site1/sitemap.xml", Type: "sitemap"}
+	key := &data.FeeditemKey{FeedURL: config.URL, GUID: "http://site1/page1"}
+	dbMock.On("GetFeeditem", key).Return(nil, nil).Once()
+
+	beforeUpdate := time.Now()
+	dbMock.On("SaveFeeditems", mock.AnythingOfType("[]*data.Feeditem")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			currentTime := time.Now()
+			savedItems := args.Get(0).([]*data.Feeditem)
+			assert.Len(t, savedItems, 1)
+			assertTimeBetween(t, beforeUpdate, currentTime, savedItems[0].Date)
+			assert.Equal(t, key, savedItems[0].Key)
+		})
+	dbMock.On("SetFetchStatus", config.CreateKey(), mock.AnythingOfType("*data.FetchStatus")).Return(nil).Once()
+
+	err := fetcher.FetchSitemap(config)
+	assert.NoError(t, err)
+	dbMock.AssertExpectations(t)
+}
+
+func TestFetchSitemapChildError(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/sitemap.xml").Reply(200).
+		BodyString(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` +
+			`<sitemap><loc>http://site1/sitemap-broken.xml</loc></sitemap>` +
+			`<sitemap><loc>http://site1/sitemap-pages.xml</loc></sitemap>` +
+			`</sitemapindex>`)
+	gock.New("http://site1").Get("/sitemap-broken.xml").Reply(404)
+	gock.New("http://site1").Get("/sitemap-pages.xml").Reply(200).
+		BodyString(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` +
+			`<url><loc>http://site1/page1</loc></url>` +
+			`</urlset>`)
+
+	dbMock := new(DBMock)
+	fetcher := Fetcher{
+		DB:     dbMock,
+		Client: &http.Client{},
+	}
+
+	config := &data.UserFeed{URL: "http://site1/sitemap.xml", Type: "sitemap"}
+	key := &data.FeeditemKey{FeedURL: config.URL, GUID: "http://site1/page1"}
+	dbMock.On("GetFeeditem", key).Return(nil, nil).Once()
+
+	beforeUpdate := time.Now()
+	dbMock.On("SaveFeeditems", mock.AnythingOfType("[]*data.Feeditem")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			savedItems := args.Get(0).([]*data.Feeditem)
+			assert.Len(t, savedItems, 1)
+			assert.Equal(t, key, savedItems[0].Key)
+		})
+	dbMock.On("SetFetchStatus", config.CreateKey(), mock.AnythingOfType("*data.FetchStatus")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			currentTime := time.Now()
+			fetchStatus := args.Get(1).(*data.FetchStatus)
+			assertTimeBetween(t, beforeUpdate, currentTime, fetchStatus.LastSuccess)
+			assert.Equal(t, time.Time{}, fetchStatus.LastFailure)
+		})
+
+	err := fetcher.FetchSitemap(config)
+	assert.NoError(t, err)
+	dbMock.AssertExpectations(t)
+}
+
+func TestFetchSitemapError(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("http://site1").Get("/sitemap.xml").Reply(200).
+		BodyString(rssFeed)
+
+	dbMock := new(DBMock)
+	fetcher := Fetcher{
+		DB:     dbMock,
+		Client: &http.Client{},
+	}
+
+	config := &data.UserFeed{URL: "http://site1/sitemap.xml", Type: "sitemap"}
+	beforeUpdate := time.Now()
+	dbMock.On("SetFetchStatus", config.CreateKey(), mock.AnythingOfType("*data.FetchStatus")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			currentTime := time.Now()
+			fetchStatus := args.Get(1).(*data.FetchStatus)
+			assertTimeBetween(t, beforeUpdate, currentTime, fetchStatus.LastFailure)
+			assert.Equal(t, time.Time{}, fetchStatus.LastSuccess)
+		})
+
+	err := fetcher.FetchSitemap(config)
+	assert.Error(t, err)
+	dbMock.AssertExpectations(t)
+}
@@ -6,10 +6,12 @@ require (
 	github.com/akrylysov/pogreb v0.10.1
 	github.com/go-chi/chi/v5 v5.0.8
 	github.com/go-chi/jwtauth/v5 v5.1.0
+	github.com/klauspost/compress v1.16.7
 	github.com/microcosm-cc/bluemonday v1.0.23
 	github.com/pmezard/go-difflib v1.0.0
 	github.com/sirupsen/logrus v1.9.0
 	github.com/stretchr/testify v1.8.2
+	go.etcd.io/bbolt v1.3.8
 	golang.org/x/crypto v0.7.0
 	golang.org/x/net v0.8.0
 	gopkg.in/h2non/gock.v1 v1.1.2
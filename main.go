@@ -1,21 +1,29 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/zlogic/nanorss-go/data"
 	"github.com/zlogic/nanorss-go/fetcher"
 	"github.com/zlogic/nanorss-go/server"
+	"github.com/zlogic/nanorss-go/smtpd"
 	"github.com/zlogic/nanorss-go/worker"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func createDefaultUser(db *data.DBService) {
+func createDefaultUser(db data.Store) {
 	users, err := db.GetUsers()
 	if err != nil {
 		log.WithError(err).Error("Failed to check users")
@@ -27,6 +35,7 @@ func createDefaultUser(db *data.DBService) {
 	log.Warn("Creating default user")
 	defaultUser := data.NewUser("default")
 	defaultUser.SetPassword("default")
+	defaultUser.Admin = true
 	err = db.SaveUser(defaultUser)
 	if err != nil {
 		log.WithError(err).Error("Failed to save default user")
@@ -34,11 +43,22 @@ func createDefaultUser(db *data.DBService) {
 	}
 }
 
-func serve(db *data.DBService) {
+func serve(db data.Store) {
 
 	// Create default user if necessary
 	createDefaultUser(db)
 
+	// Check the database without repairing anything, if enabled
+	if checkOnStartup, _ := strconv.ParseBool(os.Getenv("FSCK_ON_STARTUP")); checkOnStartup {
+		if report, err := db.Fsck(false); err != nil {
+			log.WithError(err).Error("Failed to check database")
+		} else if report.Count() > 0 {
+			log.WithField("problems", report.Count()).Warn("Database check found problems, run nanorss fsck -repair to fix them")
+		} else {
+			log.Info("Checked database")
+		}
+	}
+
 	// Schedule the fetcher worker
 	worker.Start(func() {
 		fetcher := fetcher.NewFetcher(db)
@@ -46,6 +66,15 @@ func serve(db *data.DBService) {
 		db.GC()
 	})
 
+	// Schedule backups if enabled
+	backupConfig, err := worker.BackupConfigFromEnv()
+	if err != nil {
+		log.WithError(err).Error("Invalid backup configuration, scheduled backups are disabled")
+	} else if backupConfig != nil {
+		worker.StartBackups(db, backupConfig)
+		defer worker.StopBackups()
+	}
+
 	// Create the router and webserver
 	services, err := server.CreateServices(db)
 	if err != nil {
@@ -58,13 +87,22 @@ func serve(db *data.DBService) {
 		return
 	}
 
-	errs := make(chan error, 2)
+	errs := make(chan error, 3)
 	go func() {
 		errs <- http.ListenAndServe(":8080", router)
 	}()
 
+	// Receive mail if enabled
+	if smtpAddress, ok := os.LookupEnv("SMTP_ADDRESS"); ok && smtpAddress != "" {
+		mailServer := smtpd.NewServer(db)
+		defer mailServer.Close()
+		go func() {
+			errs <- mailServer.ListenAndServe(smtpAddress)
+		}()
+	}
+
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		errs <- fmt.Errorf("%s", <-c)
 	}()
@@ -74,35 +112,236 @@ func serve(db *data.DBService) {
 
 const backupFilename = "nanorss.json"
 
-func backupData(db *data.DBService) {
-	data, err := db.Backup()
+// stdioFilename is used instead of a filename to write a backup to stdout, or to read it from stdin.
+const stdioFilename = "-"
+
+// readPassphrase returns the backup passphrase from passphraseFile if set, or from the BACKUP_PASSPHRASE environment variable.
+func readPassphrase(passphraseFile string) string {
+	if passphraseFile == "" {
+		return os.Getenv("BACKUP_PASSPHRASE")
+	}
+	passphrase, err := os.ReadFile(passphraseFile)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to read passphrase file")
+	}
+	return strings.TrimRight(string(passphrase), "\r\n")
+}
+
+// backupFlags contains command line options for the backup directive.
+type backupFlags struct {
+	filename string
+	options  data.BackupOptions
+}
+
+func parseBackupFlags(args []string) backupFlags {
+	var backup backupFlags
+	var compression, since, passphraseFile string
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.StringVar(&backup.filename, "o", backupFilename, "output file, or - to write to stdout")
+	flags.StringVar(&compression, "compress", "", "compression: gzip or zstd (default is based on the output file extension)")
+	flags.BoolVar(&backup.options.ConfigOnly, "config-only", false, "back up only the server configuration")
+	flags.StringVar(&backup.options.Username, "user", "", "back up only this user")
+	flags.StringVar(&since, "since", "", "back up only items since this date (YYYY-MM-DD)")
+	flags.StringVar(&passphraseFile, "passphrase-file", "", "encrypt the backup with the passphrase from this file (default is BACKUP_PASSPHRASE)")
+	flags.Parse(args)
+
+	backup.options.Passphrase = readPassphrase(passphraseFile)
+
+	if compression == "" {
+		switch filepath.Ext(backup.filename) {
+		case ".gz":
+			compression = string(data.CompressionGzip)
+		case ".zst":
+			compression = string(data.CompressionZstd)
+		}
+	}
+	var err error
+	backup.options.Compression, err = data.ParseCompression(compression)
 	if err != nil {
-		log.WithError(err).Fatal("Failed to back up json")
+		log.WithError(err).Fatal("Invalid compression")
+	}
+	if since != "" {
+		backup.options.Since, err = time.Parse("2006-01-02", since)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid since date")
+		}
+	}
+	return backup
+}
+
+func backupData(db data.Store, backup backupFlags) {
+	var err error
+	if backup.filename == stdioFilename {
+		err = db.Backup(os.Stdout, backup.options)
+	} else {
+		err = worker.WriteBackupFile(db, backup.filename, backup.options)
 	}
-	err = os.WriteFile(backupFilename, []byte(data), 0644)
 	if err != nil {
-		log.WithError(err).Fatal("Failed to write file")
+		log.WithError(err).Fatal("Failed to back up data")
 	}
-	log.WithField("filename", backupFilename).Info("Backed up")
+	log.WithField("filename", backup.filename).WithField("encrypted", backup.options.Passphrase != "").Info("Backed up")
 }
 
-func restoreData(db *data.DBService) {
-	data, err := os.ReadFile(backupFilename)
+// restoreFlags contains command line options for the restore directive.
+type restoreFlags struct {
+	filename string
+	options  data.RestoreOptions
+}
+
+func parseRestoreFlags(args []string) restoreFlags {
+	var restore restoreFlags
+	var mode, passphraseFile string
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.StringVar(&restore.filename, "i", backupFilename, "input file, or - to read from stdin")
+	flags.StringVar(&mode, "mode", string(data.RestoreMerge), "restore mode: merge into existing data, or replace all existing data")
+	flags.BoolVar(&restore.options.DryRun, "dry-run", false, "validate the backup and report changes without saving them")
+	flags.StringVar(&passphraseFile, "passphrase-file", "", "decrypt an encrypted backup with the passphrase from this file (default is BACKUP_PASSPHRASE)")
+	flags.Parse(args)
+
+	restore.options.Passphrase = readPassphrase(passphraseFile)
+
+	var err error
+	restore.options.Mode, err = data.ParseRestoreMode(mode)
 	if err != nil {
-		log.Fatalf("Failed to read file %v", err)
+		log.WithError(err).Fatal("Invalid restore mode")
+	}
+	return restore
+}
+
+func restoreData(db data.Store, restore restoreFlags) {
+	input := os.Stdin
+	if restore.filename != stdioFilename {
+		file, err := os.Open(restore.filename)
+		if err != nil {
+			log.Fatalf("Failed to read file %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+	report, err := db.Restore(input, restore.options)
+	if errors.Is(err, data.ErrPassphraseRequired) {
+		log.Fatal("Backup is encrypted, set BACKUP_PASSPHRASE or use -passphrase-file to restore it")
 	}
-	err = db.Restore(string(data))
 	if err != nil {
 		log.Fatalf("Failed to restore data %v", err)
 	}
-	log.WithField("filename", backupFilename).Info("Restored")
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.WithError(err).Fatal("Failed to write restore report")
+	}
+	log.WithField("filename", restore.filename).
+		WithField("mode", report.Mode).
+		WithField("dryrun", report.DryRun).
+		WithField("conflicts", len(report.Conflicts)).
+		WithField("skipped", len(report.Skipped)).
+		Info("Restored")
+}
+
+// grantAdminFlags contains command line options for the grant-admin directive.
+type grantAdminFlags struct {
+	username string
+	revoke   bool
+}
+
+func parseGrantAdminFlags(args []string) grantAdminFlags {
+	var grantAdmin grantAdminFlags
+	flags := flag.NewFlagSet("grant-admin", flag.ExitOnError)
+	flags.BoolVar(&grantAdmin.revoke, "revoke", false, "revoke admin permissions instead of granting them")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: nanorss grant-admin [-revoke] <username>")
+	}
+	grantAdmin.username = flags.Arg(0)
+	return grantAdmin
+}
+
+func grantAdmin(db data.Store, grantAdmin grantAdminFlags) {
+	user, err := db.GetUser(grantAdmin.username)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to get user")
+	}
+	if user == nil {
+		log.WithField("username", grantAdmin.username).Fatal("User doesn't exist")
+	}
+	user.Admin = !grantAdmin.revoke
+	if err := db.SaveUser(user); err != nil {
+		log.WithError(err).Fatal("Failed to save user")
+	}
+	log.WithField("username", grantAdmin.username).WithField("admin", user.Admin).Info("Updated admin permissions")
+}
+
+func checkDatabase(db data.Store, repair bool) {
+	report, err := db.Fsck(repair)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to check database")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.WithError(err).Fatal("Failed to write database check report")
+	}
+	log.WithField("problems", report.Count()).WithField("repaired", report.Repaired).Info("Checked database")
+}
+
+func previewFeed(feedURL string) {
+	// Previewing a feed doesn't need the database.
+	parsed, err := fetcher.NewFetcher(nil).PreviewFeed(feedURL)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to preview feed")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(parsed); err != nil {
+		log.WithError(err).Fatal("Failed to write preview")
+	}
 }
 
 func main() {
+	if len(os.Args) >= 2 && os.Args[1] == "preview" {
+		if len(os.Args) != 3 {
+			log.Fatal("Usage: nanorss preview <url>")
+		}
+		previewFeed(os.Args[2])
+		return
+	}
+
+	options := data.DefaultOptions()
+	var backup backupFlags
+	var restore restoreFlags
+	var admin grantAdminFlags
+	var repair bool
+	// Only reclaim space after directives which could have changed the database.
+	gc := true
+	if len(os.Args) >= 2 {
+		switch os.Args[1] {
+		case "migrate":
+			flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+			flags.BoolVar(&options.DryRun, "dry-run", false, "apply migrations without saving any changes")
+			flags.Parse(os.Args[2:])
+			gc = !options.DryRun
+		case "backup":
+			backup = parseBackupFlags(os.Args[2:])
+			gc = false
+		case "restore":
+			restore = parseRestoreFlags(os.Args[2:])
+			gc = !restore.options.DryRun
+		case "grant-admin":
+			admin = parseGrantAdminFlags(os.Args[2:])
+		case "fsck":
+			flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+			flags.BoolVar(&repair, "repair", false, "repair problems instead of only reporting them")
+			flags.Parse(os.Args[2:])
+			gc = repair
+		}
+	}
+
 	// Init data layer
-	db, err := data.Open(data.DefaultOptions())
+	db, err := data.Open(options)
 	defer func() {
-		db.GC()
+		if gc {
+			db.GC()
+		}
 		db.Close()
 	}()
 	if err != nil {
@@ -115,9 +354,16 @@ func main() {
 	} else {
 		switch directive := os.Args[1]; directive {
 		case "backup":
-			backupData(db)
+			backupData(db, backup)
 		case "restore":
-			restoreData(db)
+			restoreData(db, restore)
+		case "grant-admin":
+			grantAdmin(db, admin)
+		case "fsck":
+			checkDatabase(db, repair)
+		case "migrate":
+			// Migrations are applied when the database is opened.
+			log.WithField("version", data.SchemaVersion()).WithField("dryrun", options.DryRun).Info("Migrated database")
 		default:
 			db.Close()
 			log.Fatalf("Unrecognized directive %v", directive)
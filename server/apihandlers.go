@@ -2,9 +2,11 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -29,6 +31,19 @@ func APIAuthHandler(next http.Handler) http.Handler {
 	})
 }
 
+// AdminAuthHandler checks to see if the API is accessed by an admin user,
+// and returns an error if the request is done by a user without admin permissions.
+func AdminAuthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil || !user.Admin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // LoginHandler authenticates the user and sets the encrypted session cookie if the user provided valid credentials.
 func LoginHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -74,7 +89,27 @@ func LoginHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// writeItems returns items (or err, if not nil) as a response.
+func writeItems(w http.ResponseWriter, r *http.Request, items []*Item, err error) {
+	if items == nil {
+		items = make([]*Item, 0)
+	}
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(items)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+}
+
 // FeedHandler returns all feed (and page monitor) items for an authenticated user.
+// The filter parameter can be used to return only starred items, the tag parameter to return only items with a tag,
+// and the folder parameter to return only items from a folder (including its nested folders).
 func FeedHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := auth.GetUser(r.Context())
@@ -83,17 +118,166 @@ func FeedHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		items, err := s.feedListHelper.GetAllItems(user)
-		if items == nil {
-			items = make([]*Item, 0)
+		tag := r.URL.Query().Get("tag")
+		folder := r.URL.Query().Get("folder")
+		switch filter := r.URL.Query().Get("filter"); {
+		case filter == "" && tag != "" && folder == "":
+			items, err := s.feedListHelper.GetTaggedItems(user, tag)
+			writeItems(w, r, items, err)
+		case filter == "" && folder != "" && tag == "":
+			items, err := s.feedListHelper.GetFolderItems(user, folder)
+			writeItems(w, r, items, err)
+		case filter == "" && tag == "" && folder == "":
+			items, err := s.feedListHelper.GetAllItems(user)
+			writeItems(w, r, items, err)
+		case filter == "starred" && tag == "" && folder == "":
+			items, err := s.feedListHelper.GetStarredItems(user)
+			writeItems(w, r, items, err)
+		default:
+			http.Error(w, "Unsupported filter", http.StatusBadRequest)
+		}
+	}
+}
+
+// StarredHandler returns all starred items for an authenticated user.
+func StarredHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		items, err := s.feedListHelper.GetStarredItems(user)
+		writeItems(w, r, items, err)
+	}
+}
+
+// TagsHandler lists or updates tags of an authenticated user.
+// To create a tag, POST its Name; to rename a tag, POST its Name and NewName; to delete a tag, POST its Name and Delete=true.
+func TagsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+
+			name := r.Form.Get("Name")
+			var err error
+			if r.Form.Get("Delete") == "true" {
+				err = s.db.DeleteTag(user, name)
+			} else if r.Form.Has("NewName") {
+				err = s.db.RenameTag(user, name, r.Form.Get("NewName"))
+			} else {
+				err = s.db.CreateTag(user, name)
+			}
+			var invalidTag *data.InvalidTagError
+			if errors.As(err, &invalidTag) {
+				http.Error(w, "Invalid tag: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+
+		tags, err := s.db.GetTags(user)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		if tags == nil {
+			tags = make([]string, 0)
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(tags); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+}
+
+// FoldersHandler lists folders of an authenticated user, with the number of unread items in each folder.
+// To mark all items in a folder (and its nested folders) as read, POST its Name and Read=true.
+func FoldersHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+
+			name := r.Form.Get("Name")
+			if name == "" || r.Form.Get("Read") != "true" {
+				http.Error(w, "Unsupported folder operation", http.StatusBadRequest)
+				return
+			}
+			if _, err := s.db.MarkRead(user, data.ReadFilter{Folder: name}); err != nil {
+				handleError(w, r, err)
+				return
+			}
 		}
+
+		folders, err := s.feedListHelper.GetFolders(user)
 		if err != nil {
 			handleError(w, r, err)
 			return
 		}
+		if folders == nil {
+			folders = make([]*Folder, 0)
+		}
 
 		w.Header().Add("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(items)
+		if err := json.NewEncoder(w).Encode(folders); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+}
+
+// NotesHandler exports all notes of an authenticated user, as JSON (default) or as Markdown if format=markdown.
+func NotesHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "markdown" {
+			http.Error(w, "Unsupported format", http.StatusBadRequest)
+			return
+		}
+
+		notes, err := getExportedNotes(s.db, user)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		if format == "markdown" {
+			w.Header().Add("Content-Type", "text/markdown; charset=utf-8")
+			err = writeNotesMarkdown(w, notes)
+		} else {
+			w.Header().Add("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(notes)
+		}
 		if err != nil {
 			handleError(w, r, err)
 			return
@@ -101,6 +285,73 @@ func FeedHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// searchDateFormat is the format of dates in search queries.
+const searchDateFormat = "2006-01-02"
+
+// parseSearchQuery parses the search query from request parameters.
+func parseSearchQuery(r *http.Request) (*data.SearchQuery, error) {
+	params := r.URL.Query()
+	query := &data.SearchQuery{
+		Query:   params.Get("q"),
+		FeedURL: params.Get("feed"),
+	}
+	if strings.TrimSpace(query.Query) == "" {
+		return nil, fmt.Errorf("query is empty")
+	}
+
+	if from := params.Get("from"); from != "" {
+		date, err := time.Parse(searchDateFormat, from)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse from date: %w", err)
+		}
+		query.From = date
+	}
+	if to := params.Get("to"); to != "" {
+		date, err := time.Parse(searchDateFormat, to)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse to date: %w", err)
+		}
+		// Include the whole day.
+		query.To = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	if read := params.Get("read"); read != "" {
+		value, err := strconv.ParseBool(read)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse read status: %w", err)
+		}
+		query.Read = &value
+	}
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid limit %v", limit)
+		}
+		query.Limit = value
+	}
+	return query, nil
+}
+
+// SearchHandler returns items from an authenticated user's subscriptions which match the search query.
+func SearchHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		query, err := parseSearchQuery(r)
+		if err != nil {
+			log.WithError(err).Error("Invalid search query")
+			http.Error(w, "Invalid search query", http.StatusBadRequest)
+			return
+		}
+
+		items, err := s.feedListHelper.Search(user, *query)
+		writeItems(w, r, items, err)
+	}
+}
+
 // FeedItemHandler returns a feed (or page monitor) item for an authenticated user.
 func FeedItemHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -117,6 +368,7 @@ func FeedItemHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 			Date          time.Time
 			Plaintext     bool
 			MarkUnreadURL string
+			Note          *data.ItemNote `json:",omitempty"`
 		}
 
 		getItem := func(key []byte) *clientFeedItem {
@@ -185,6 +437,12 @@ func FeedItemHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Not found", http.StatusNotFound)
 				return
 			}
+			note, err := s.db.GetNote(user, []byte(key))
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+			item.Note = note
 			if err := json.NewEncoder(w).Encode(item); err != nil {
 				handleError(w, r, err)
 			}
@@ -194,13 +452,47 @@ func FeedItemHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			readStatus := r.Form.Get("Read")
-			if readStatus != "false" {
+			var err error
+			if r.Form.Has("Starred") {
+				starred, parseErr := strconv.ParseBool(r.Form.Get("Starred"))
+				if parseErr != nil {
+					http.Error(w, "Invalid starred status", http.StatusBadRequest)
+					return
+				}
+				err = s.db.SetStarred(user, []byte(key), starred)
+			} else if r.Form.Has("Tag") {
+				tagged, parseErr := strconv.ParseBool(r.Form.Get("Tagged"))
+				if parseErr != nil {
+					http.Error(w, "Invalid tagged status", http.StatusBadRequest)
+					return
+				}
+				err = s.db.SetTagged(user, r.Form.Get("Tag"), []byte(key), tagged)
+				var invalidTag *data.InvalidTagError
+				if errors.As(err, &invalidTag) {
+					http.Error(w, "Invalid tag: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			} else if r.Form.Has("Note") {
+				note := &data.ItemNote{Text: r.Form.Get("Note")}
+				if highlights := r.Form.Get("Highlights"); highlights != "" {
+					if parseErr := json.Unmarshal([]byte(highlights), &note.Highlights); parseErr != nil {
+						http.Error(w, "Invalid highlights", http.StatusBadRequest)
+						return
+					}
+				}
+				err = s.db.SaveNote(user, []byte(key), note)
+				var invalidHighlights *data.InvalidHighlightsError
+				if errors.As(err, &invalidHighlights) {
+					http.Error(w, "Invalid highlights: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			} else if r.Form.Get("Read") == "false" {
+				err = s.db.SetReadStatus(user, []byte(key), false)
+			} else {
 				handleError(w, r, fmt.Errorf("unsupported update operation %v", r.Form))
 				return
 			}
-
-			if err := s.db.SetReadStatus(user, []byte(key), false); err != nil {
+			if err != nil {
 				handleError(w, r, err)
 				return
 			}
@@ -212,8 +504,10 @@ func FeedItemHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// SettingsHandler gets or updates settings for an authenticated user.
-func SettingsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+// ItemsHandler changes the read status of multiple items for an authenticated user.
+// Items are selected either by their Key (using the same format as in item URLs), or with a filter
+// (Feed, Folder, Tag and Before); All=true selects all items.
+func ItemsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := auth.GetUser(r.Context())
 		if user == nil {
@@ -221,64 +515,63 @@ func SettingsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if r.Method == http.MethodPost {
-			if err := r.ParseForm(); err != nil {
-				handleError(w, r, err)
-				return
-			}
+		if err := r.ParseForm(); err != nil {
+			handleError(w, r, err)
+			return
+		}
 
-			newPassword := r.Form.Get("Password")
-			if newPassword != "" {
-				user.SetPassword(newPassword)
-			}
-			user.Opml = r.Form.Get("Opml")
-			user.Pagemonitor = r.Form.Get("Pagemonitor")
+		read, err := strconv.ParseBool(r.Form.Get("Read"))
+		if err != nil {
+			http.Error(w, "Invalid read status", http.StatusBadRequest)
+			return
+		}
 
-			newUsername := r.Form.Get("Username")
-			err := user.SetUsername(newUsername)
-			if err != nil {
-				handleError(w, r, err)
-				return
+		var updated int
+		if keys := r.Form["Key"]; len(keys) > 0 {
+			itemKeys := make([][]byte, len(keys))
+			for i := range keys {
+				itemKeys[i] = []byte(strings.Replace(keys[i], "-", "/", -1))
 			}
-
-			if err := s.db.SaveUser(user); err != nil {
-				handleError(w, r, err)
-				return
+			updated, err = s.db.SetReadStatuses(user, itemKeys, read)
+		} else {
+			filter := data.ReadFilter{
+				FeedURL: r.Form.Get("Feed"),
+				Folder:  r.Form.Get("Folder"),
+				Tag:     r.Form.Get("Tag"),
 			}
-
-			if user.GetUsername() != newUsername {
-				// Force logout.
-				err := s.cookieHandler.SetCookieUsername(w, "", false)
-				if err != nil {
-					log.WithError(err).Error("Error while clearing the cookie during logout")
+			if before := r.Form.Get("Before"); before != "" {
+				date, parseErr := time.Parse(searchDateFormat, before)
+				if parseErr != nil {
+					http.Error(w, "Invalid before date", http.StatusBadRequest)
+					return
 				}
+				filter.Before = date
 			}
-
-			// Reload user to return updated values.
-			user, err = s.db.GetUser(user.GetUsername())
-			if err != nil {
-				handleError(w, r, err)
+			if filter == (data.ReadFilter{}) && r.Form.Get("All") != "true" {
+				http.Error(w, "No items selected", http.StatusBadRequest)
+				return
+			}
+			if !read {
+				http.Error(w, "Only selected keys can be marked as unread", http.StatusBadRequest)
 				return
 			}
+			updated, err = s.db.MarkRead(user, filter)
 		}
-
-		type clientUser struct {
-			Username    string
-			Opml        string
-			Pagemonitor string
+		if err != nil {
+			handleError(w, r, err)
+			return
 		}
 
-		returnUser := &clientUser{Username: user.GetUsername(), Opml: user.Opml, Pagemonitor: user.Pagemonitor}
-
 		w.Header().Add("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(returnUser); err != nil {
+		if err := json.NewEncoder(w).Encode(struct{ Updated int }{updated}); err != nil {
 			handleError(w, r, err)
+			return
 		}
 	}
 }
 
-// RefreshHandler refreshes all items for an authenticated user.
-func RefreshHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+// UndoMarkReadHandler reverts the last bulk read status change of an authenticated user.
+func UndoMarkReadHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := auth.GetUser(r.Context())
 		if user == nil {
@@ -286,16 +579,25 @@ func RefreshHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		s.fetcher.Refresh()
+		updated, err := s.db.UndoMarkRead(user)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
 
-		if _, err := io.WriteString(w, "OK"); err != nil {
-			log.WithError(err).Error("Failed to write response")
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct{ Updated int }{updated}); err != nil {
+			handleError(w, r, err)
+			return
 		}
 	}
 }
 
-// StatusHandler returns the fetch status for all monitored items for an authenticated user.
-func StatusHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+// historyLimit is the default number of items returned by HistoryHandler.
+const historyLimit = 50
+
+// HistoryHandler returns items recently read by an authenticated user, newest first.
+func HistoryHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := auth.GetUser(r.Context())
 		if user == nil {
@@ -303,52 +605,813 @@ func StatusHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		feeds, err := user.GetFeeds()
+		limit := historyLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		items, err := s.feedListHelper.GetRecentlyRead(user, limit)
 		if err != nil {
 			handleError(w, r, err)
 			return
 		}
-		pages, err := user.GetPages()
-		if err != nil {
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(items); err != nil {
 			handleError(w, r, err)
 			return
 		}
+	}
+}
 
-		type itemStatus struct {
-			Name        string
-			Success     bool
-			LastFailure *time.Time `json:",omitempty"`
-			LastSuccess *time.Time `json:",omitempty"`
+// ReadingStatsHandler returns the reading stats of an authenticated user for the last days.
+func ReadingStatsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
 		}
-		itemStatuses := make([]itemStatus, len(feeds)+len(pages))
 
-		convertItemStatus := func(name string, status *data.FetchStatus) *itemStatus {
-			itemStatus := itemStatus{Name: name}
-			if status == nil {
-				return &itemStatus
-			}
-			var emptyTime = time.Time{}
-			if status.LastFailure != emptyTime {
-				itemStatus.LastFailure = &status.LastFailure
-			}
-			if status.LastSuccess != emptyTime {
-				itemStatus.LastSuccess = &status.LastSuccess
-			}
-			itemStatus.Success = status.LastSuccess.After(status.LastFailure)
-			return &itemStatus
+		from, err := parseReadingStatsFrom(r, time.Now())
+		if err != nil {
+			http.Error(w, "Invalid number of days", http.StatusBadRequest)
+			return
 		}
 
-		for i, feed := range feeds {
-			fetchStatus, err := s.db.GetFetchStatus(feed.CreateKey())
-			if err != nil {
-				handleError(w, r, err)
-				return
-			}
-			itemStatuses[i] = *convertItemStatus(feed.Title, fetchStatus)
+		stats, err := s.db.GetReadingStats(user, from)
+		if err != nil {
+			handleError(w, r, err)
+			return
 		}
+		feedTitles := make(map[string]string)
+		addFeedTitles(feedTitles, user)
 
-		for i, page := range pages {
-			fetchStatus, err := s.db.GetFetchStatus(page.CreateKey())
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(getReadingReport(feedTitles, stats)); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+}
+
+// AllReadingStatsHandler returns the reading stats of all users for the last days.
+// Feeds which nobody reads are listed first.
+func AllReadingStatsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, err := parseReadingStatsFrom(r, time.Now())
+		if err != nil {
+			http.Error(w, "Invalid number of days", http.StatusBadRequest)
+			return
+		}
+
+		stats, err := s.db.GetAllReadingStats(from)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		usernames, err := s.db.GetUsers()
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		feedTitles := make(map[string]string)
+		for _, username := range usernames {
+			user, err := s.db.GetUser(username)
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+			if user != nil {
+				addFeedTitles(feedTitles, user)
+			}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(getReadingReport(feedTitles, stats)); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+}
+
+// parseRetentionPolicy parses a retention policy from form values; empty values are inherited from the global policy.
+func parseRetentionPolicy(maxAgeDays, maxItems string) (*data.RetentionPolicy, error) {
+	parseValue := func(value string) (int, error) {
+		if value == "" {
+			return 0, nil
+		}
+		return strconv.Atoi(value)
+	}
+
+	policy := &data.RetentionPolicy{}
+	var err error
+	if policy.MaxAgeDays, err = parseValue(maxAgeDays); err != nil {
+		return nil, fmt.Errorf("cannot parse max age days: %w", err)
+	}
+	if policy.MaxItems, err = parseValue(maxItems); err != nil {
+		return nil, fmt.Errorf("cannot parse max items: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// SettingsHandler gets or updates settings for an authenticated user.
+func SettingsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+
+			newPassword := r.Form.Get("Password")
+			if newPassword != "" {
+				user.SetPassword(newPassword)
+			}
+
+			retention, err := parseRetentionPolicy(r.Form.Get("MaxAgeDays"), r.Form.Get("MaxItems"))
+			if err != nil {
+				http.Error(w, "Invalid retention policy: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			user.RetentionPolicy = *retention
+
+			// Opml and Pagemonitor are deprecated, and replace all subscriptions or pages, as in previous versions.
+			if _, ok := r.Form["Opml"]; ok {
+				if _, err := user.ImportOPML(r.Form.Get("Opml"), true); err != nil {
+					http.Error(w, "Invalid OPML: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if _, ok := r.Form["Pagemonitor"]; ok {
+				if _, err := user.ImportPagemonitorXML(r.Form.Get("Pagemonitor"), true); err != nil {
+					http.Error(w, "Invalid Pagemonitor XML: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			newUsername := r.Form.Get("Username")
+			err = user.SetUsername(newUsername)
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+
+			if err := s.db.SaveUser(user); err != nil {
+				handleError(w, r, err)
+				return
+			}
+
+			if user.GetUsername() != newUsername {
+				// Force logout.
+				err := s.cookieHandler.SetCookieUsername(w, "", false)
+				if err != nil {
+					log.WithError(err).Error("Error while clearing the cookie during logout")
+				}
+			}
+
+			// Reload user to return updated values.
+			user, err = s.db.GetUser(user.GetUsername())
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+
+		// Opml and Pagemonitor are deprecated, and are kept for compatibility with existing clients;
+		// subscriptions and pages can be exported with /api/subscriptions/opml and /api/pages/xml.
+		// Configuration from a previous version which couldn't be converted is returned as-is, so that it can be fixed.
+		opml, pagemonitor := user.Opml, user.Pagemonitor
+		if opml == "" {
+			var err error
+			if opml, err = user.ExportOPML(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+		if pagemonitor == "" {
+			var err error
+			if pagemonitor, err = user.ExportPagemonitorXML(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+
+		type clientUser struct {
+			Username               string
+			Admin                  bool `json:",omitempty"`
+			Opml                   string
+			Pagemonitor            string
+			UnconvertedOpml        bool   `json:",omitempty"`
+			UnconvertedPagemonitor bool   `json:",omitempty"`
+			MailAddress            string `json:",omitempty"`
+			MaxAgeDays             int    `json:",omitempty"`
+			MaxItems               int    `json:",omitempty"`
+			DefaultMaxAgeDays      int    `json:",omitempty"`
+			DefaultMaxItems        int    `json:",omitempty"`
+		}
+
+		returnUser := &clientUser{
+			Username:               user.GetUsername(),
+			Admin:                  user.Admin,
+			Opml:                   opml,
+			Pagemonitor:            pagemonitor,
+			UnconvertedOpml:        user.Opml != "",
+			UnconvertedPagemonitor: user.Pagemonitor != "",
+			MailAddress:            user.GetMailAddress(s.mailDomain),
+			MaxAgeDays:             user.MaxAgeDays,
+			MaxItems:               user.MaxItems,
+			DefaultMaxAgeDays:      s.defaultRetention.MaxAgeDays,
+			DefaultMaxItems:        s.defaultRetention.MaxItems,
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(returnUser); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
+
+// MailAddressHandler generates a new address to receive mail for an authenticated user.
+// The previous address (if any) will stop working.
+func MailAddressHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if err := user.GenerateMailToken(); err != nil {
+			handleError(w, r, err)
+			return
+		}
+		if err := s.db.SaveUser(user); err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		type clientMailAddress struct {
+			MailAddress string
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&clientMailAddress{MailAddress: user.GetMailAddress(s.mailDomain)}); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
+
+// writeSubscriptions writes all of user's subscriptions as JSON.
+func writeSubscriptions(w http.ResponseWriter, r *http.Request, user *data.User) {
+	type clientSubscription struct {
+		data.UserFeed
+		Key string
+	}
+
+	feeds := user.GetFeeds()
+	subscriptions := make([]*clientSubscription, len(feeds))
+	for i := range feeds {
+		subscriptions[i] = &clientSubscription{UserFeed: feeds[i], Key: escapeKeyForURL(feeds[i].CreateKey())}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(subscriptions); err != nil {
+		handleError(w, r, err)
+	}
+}
+
+// parseSubscription parses a subscription from form values.
+func parseSubscription(r *http.Request) (*data.UserFeed, error) {
+	retention, err := parseRetentionPolicy(r.Form.Get("MaxAgeDays"), r.Form.Get("MaxItems"))
+	if err != nil {
+		return nil, err
+	}
+	return &data.UserFeed{
+		URL:             r.Form.Get("URL"),
+		Title:           r.Form.Get("Title"),
+		Type:            r.Form.Get("Type"),
+		PathPrefix:      r.Form.Get("PathPrefix"),
+		Folder:          r.Form.Get("Folder"),
+		RetentionPolicy: *retention,
+	}, nil
+}
+
+// SubscriptionsHandler returns or adds feed subscriptions for an authenticated user.
+func SubscriptionsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+			feed, err := parseSubscription(r)
+			if err != nil {
+				http.Error(w, "Invalid subscription: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			added, err := user.AddFeed(*feed)
+			if err != nil {
+				http.Error(w, "Invalid subscription: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !added {
+				http.Error(w, "Subscription already exists", http.StatusBadRequest)
+				return
+			}
+			if err := s.db.SaveUser(user); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+
+		writeSubscriptions(w, r, user)
+	}
+}
+
+// SubscriptionHandler updates or deletes a feed subscription for an authenticated user.
+func SubscriptionHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		key := chi.URLParam(r, "key")
+		var existingFeed *data.UserFeed
+		for _, feed := range user.GetFeeds() {
+			if escapeKeyForURL(feed.CreateKey()) == key {
+				existingFeed = &feed
+				break
+			}
+		}
+		if existingFeed == nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			if err := r.ParseForm(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+			feed, err := parseSubscription(r)
+			if err != nil {
+				http.Error(w, "Invalid subscription: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := user.UpdateFeed(existingFeed.URL, *feed); err != nil {
+				http.Error(w, "Invalid subscription: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if r.Method == http.MethodDelete {
+			user.DeleteFeed(existingFeed.URL)
+		}
+		if err := s.db.SaveUser(user); err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		writeSubscriptions(w, r, user)
+	}
+}
+
+// maxOPMLMemory is the maximum size of an uploaded OPML file.
+const maxOPMLMemory = 8 << 20
+
+// OPMLHandler exports or imports subscriptions of an authenticated user in the OPML format.
+func OPMLHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			opml, err := user.ExportOPML()
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+			w.Header().Add("Content-Type", "text/x-opml; charset=utf-8")
+			w.Header().Add("Content-Disposition", "attachment; filename=\"subscriptions.opml\"")
+			if _, err := io.WriteString(w, opml); err != nil {
+				log.WithError(err).Error("Failed to write response")
+			}
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxOPMLMemory)
+		if err := r.ParseMultipartForm(maxOPMLMemory); err != nil {
+			http.Error(w, "Invalid OPML upload", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		replace := false
+		if r.Form.Get("Replace") != "" {
+			var err error
+			replace, err = strconv.ParseBool(r.Form.Get("Replace"))
+			if err != nil {
+				http.Error(w, "Invalid replace value", http.StatusBadRequest)
+				return
+			}
+		}
+
+		file, _, err := r.FormFile("Opml")
+		if err != nil {
+			http.Error(w, "Missing OPML file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		opml, err := io.ReadAll(file)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		if _, err := user.ImportOPML(string(opml), replace); err != nil {
+			http.Error(w, "Invalid OPML: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.db.SaveUser(user); err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		writeSubscriptions(w, r, user)
+	}
+}
+
+// writePages writes all of user's monitored pages as JSON.
+func writePages(w http.ResponseWriter, r *http.Request, user *data.User) {
+	type clientPage struct {
+		data.UserPagemonitor
+		Key string
+	}
+
+	userPages := user.GetPages()
+	pages := make([]*clientPage, len(userPages))
+	for i := range userPages {
+		pages[i] = &clientPage{UserPagemonitor: userPages[i], Key: escapeKeyForURL(userPages[i].CreateKey())}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pages); err != nil {
+		handleError(w, r, err)
+	}
+}
+
+// parsePage parses a monitored page from form values.
+func parsePage(r *http.Request) *data.UserPagemonitor {
+	return &data.UserPagemonitor{
+		URL:     r.Form.Get("URL"),
+		Title:   r.Form.Get("Title"),
+		Match:   r.Form.Get("Match"),
+		Replace: r.Form.Get("Replace"),
+	}
+}
+
+// PagesHandler returns or adds monitored pages for an authenticated user.
+func PagesHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if err := r.ParseForm(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+			added, err := user.AddPage(*parsePage(r))
+			if err != nil {
+				http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !added {
+				http.Error(w, "Page already exists", http.StatusBadRequest)
+				return
+			}
+			if err := s.db.SaveUser(user); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
+
+		writePages(w, r, user)
+	}
+}
+
+// PageHandler updates or deletes a monitored page for an authenticated user.
+func PageHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		key := chi.URLParam(r, "key")
+		var existingKey []byte
+		for _, page := range user.GetPages() {
+			if escapeKeyForURL(page.CreateKey()) == key {
+				existingKey = page.CreateKey()
+				break
+			}
+		}
+		if existingKey == nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			if err := r.ParseForm(); err != nil {
+				handleError(w, r, err)
+				return
+			}
+			if err := user.UpdatePage(existingKey, *parsePage(r)); err != nil {
+				http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if r.Method == http.MethodDelete {
+			user.DeletePage(existingKey)
+		}
+		if err := s.db.SaveUser(user); err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		writePages(w, r, user)
+	}
+}
+
+// maxPagemonitorXMLMemory is the maximum size of an uploaded Pagemonitor XML file.
+const maxPagemonitorXMLMemory = 8 << 20
+
+// PagemonitorXMLHandler exports or imports monitored pages of an authenticated user in the Pagemonitor XML format.
+func PagemonitorXMLHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			pagemonitor, err := user.ExportPagemonitorXML()
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+			w.Header().Add("Content-Type", "application/xml; charset=utf-8")
+			w.Header().Add("Content-Disposition", "attachment; filename=\"pagemonitor.xml\"")
+			if _, err := io.WriteString(w, pagemonitor); err != nil {
+				log.WithError(err).Error("Failed to write response")
+			}
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxPagemonitorXMLMemory)
+		if err := r.ParseMultipartForm(maxPagemonitorXMLMemory); err != nil {
+			http.Error(w, "Invalid Pagemonitor XML upload", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		replace := false
+		if r.Form.Get("Replace") != "" {
+			var err error
+			replace, err = strconv.ParseBool(r.Form.Get("Replace"))
+			if err != nil {
+				http.Error(w, "Invalid replace value", http.StatusBadRequest)
+				return
+			}
+		}
+
+		file, _, err := r.FormFile("Pagemonitor")
+		if err != nil {
+			http.Error(w, "Missing Pagemonitor XML file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		pagemonitor, err := io.ReadAll(file)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		if _, err := user.ImportPagemonitorXML(string(pagemonitor), replace); err != nil {
+			http.Error(w, "Invalid Pagemonitor XML: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.db.SaveUser(user); err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		writePages(w, r, user)
+	}
+}
+
+// PagePreviewHandler fetches a page and returns its filtered text and the diff with the last saved version.
+// Nothing is saved, so that match and replace can be checked before adding or updating a page.
+func PagePreviewHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			handleError(w, r, err)
+			return
+		}
+		page := parsePage(r)
+		if err := page.Validate(); err != nil {
+			http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		type clientPagePreview struct {
+			Error    string `json:",omitempty"`
+			Contents string
+			Delta    string
+		}
+
+		preview := &clientPagePreview{}
+		parsed, err := s.fetcher.PreviewPage(page)
+		if err != nil {
+			log.WithField("url", page.URL).WithError(err).Info("Failed to preview page")
+			preview.Error = err.Error()
+		} else {
+			preview.Contents = parsed.Contents
+			preview.Delta = parsed.Delta
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(preview); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
+
+// RefreshHandler refreshes all items for an authenticated user.
+func RefreshHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		s.fetcher.Refresh()
+
+		if _, err := io.WriteString(w, "OK"); err != nil {
+			log.WithError(err).Error("Failed to write response")
+		}
+	}
+}
+
+// PreviewHandler fetches and parses a feed for an authenticated user without saving it.
+func PreviewHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		feedURL := strings.TrimSpace(r.URL.Query().Get("url"))
+		if feedURL == "" {
+			http.Error(w, "Missing url parameter", http.StatusBadRequest)
+			return
+		}
+
+		type clientPreviewItem struct {
+			Title    string
+			URL      string
+			GUID     string
+			Date     *time.Time `json:",omitempty"`
+			Contents string
+		}
+		type clientPreview struct {
+			Error       string `json:",omitempty"`
+			Format      string
+			Title       string
+			URL         string
+			Description string
+			Items       []clientPreviewItem
+			FailedDates []string
+			Warnings    []string
+		}
+
+		preview := &clientPreview{Items: []clientPreviewItem{}, FailedDates: []string{}, Warnings: []string{}}
+		parsed, err := s.fetcher.PreviewFeed(feedURL)
+		if err != nil {
+			log.WithField("url", feedURL).WithError(err).Info("Failed to preview feed")
+			preview.Error = err.Error()
+		} else {
+			preview.Format = parsed.Format
+			preview.Title = parsed.Title
+			preview.URL = parsed.URL
+			preview.Description = parsed.Description
+			for _, item := range parsed.Items {
+				previewItem := clientPreviewItem{
+					Title:    item.Title,
+					URL:      item.URL,
+					GUID:     item.Key.GUID,
+					Contents: item.Contents,
+				}
+				if !item.Date.IsZero() {
+					date := item.Date
+					previewItem.Date = &date
+				}
+				preview.Items = append(preview.Items, previewItem)
+			}
+			preview.FailedDates = append(preview.FailedDates, parsed.FailedDates...)
+			preview.Warnings = append(preview.Warnings, parsed.Warnings...)
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(preview); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
+
+// StatusHandler returns the fetch status for all monitored items for an authenticated user.
+func StatusHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		feeds := user.GetFeeds()
+		pages := user.GetPages()
+
+		type itemStatus struct {
+			Name        string
+			Success     bool
+			LastFailure *time.Time `json:",omitempty"`
+			LastSuccess *time.Time `json:",omitempty"`
+		}
+		itemStatuses := make([]itemStatus, len(feeds)+len(pages))
+
+		convertItemStatus := func(name string, status *data.FetchStatus) *itemStatus {
+			itemStatus := itemStatus{Name: name}
+			if status == nil {
+				return &itemStatus
+			}
+			var emptyTime = time.Time{}
+			if status.LastFailure != emptyTime {
+				itemStatus.LastFailure = &status.LastFailure
+			}
+			if status.LastSuccess != emptyTime {
+				itemStatus.LastSuccess = &status.LastSuccess
+			}
+			itemStatus.Success = status.LastSuccess.After(status.LastFailure)
+			return &itemStatus
+		}
+
+		for i, feed := range feeds {
+			fetchStatus, err := s.db.GetFetchStatus(feed.CreateKey())
+			if err != nil {
+				handleError(w, r, err)
+				return
+			}
+			itemStatuses[i] = *convertItemStatus(feed.Title, fetchStatus)
+		}
+
+		for i, page := range pages {
+			fetchStatus, err := s.db.GetFetchStatus(page.CreateKey())
 			if err != nil {
 				handleError(w, r, err)
 				return
@@ -362,3 +1425,141 @@ func StatusHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// FeedStatsHandler returns statistics for all feeds and pages of an authenticated user.
+func FeedStatsHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			// This should never happen.
+			return
+		}
+
+		feedStats, err := s.db.GetFeedStats(user)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(feedStats); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
+
+// HealthHandler returns the server health and the time of the last scheduled backup.
+// It doesn't require authentication, and can be used as a liveness probe.
+func HealthHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lastBackup, err := s.db.GetLastBackupTime()
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		type health struct {
+			Status     string
+			LastBackup *time.Time `json:",omitempty"`
+		}
+		response := health{Status: "ok"}
+		if !lastBackup.IsZero() {
+			response.LastBackup = &lastBackup
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
+
+// BackupHandler returns a backup of the database as an attachment.
+func BackupHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			handleError(w, r, err)
+			return
+		}
+		compression, err := data.ParseCompression(r.Form.Get("Compression"))
+		if err != nil {
+			http.Error(w, "Unsupported compression", http.StatusBadRequest)
+			return
+		}
+		options := data.BackupOptions{Compression: compression, Passphrase: r.Form.Get("Passphrase")}
+
+		// Write the backup into a temporary file first, so that errors can be reported before the download starts.
+		file, err := os.CreateTemp("", "nanorss-backup-*.tmp")
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		if err := s.db.Backup(file, options); err != nil {
+			handleError(w, r, err)
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		filename := "nanorss-" + time.Now().UTC().Format("20060102-150405") + ".json" + compression.Extension()
+		w.Header().Add("Content-Type", "application/octet-stream")
+		w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%v\"", filename))
+		if _, err := io.Copy(w, file); err != nil {
+			log.WithError(err).Error("Failed to send backup")
+		}
+	}
+}
+
+// maxRestoreMemory is the maximum size of an uploaded backup to keep in memory; larger backups are saved into temporary files.
+const maxRestoreMemory = 32 << 20
+
+// RestoreHandler restores an uploaded backup, and returns the restore report.
+func RestoreHandler(s *Services) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(maxRestoreMemory); err != nil {
+			http.Error(w, "Invalid backup upload", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		mode, err := data.ParseRestoreMode(r.Form.Get("Mode"))
+		if err != nil {
+			http.Error(w, "Unsupported restore mode", http.StatusBadRequest)
+			return
+		}
+		options := data.RestoreOptions{Mode: mode, Passphrase: r.Form.Get("Passphrase")}
+		if r.Form.Get("DryRun") != "" {
+			options.DryRun, err = strconv.ParseBool(r.Form.Get("DryRun"))
+			if err != nil {
+				http.Error(w, "Invalid dry run value", http.StatusBadRequest)
+				return
+			}
+		}
+
+		file, _, err := r.FormFile("Backup")
+		if err != nil {
+			http.Error(w, "Missing backup file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		report, err := s.db.Restore(file, options)
+		var invalidBackup *data.InvalidBackupError
+		if errors.As(err, &invalidBackup) {
+			http.Error(w, "Invalid backup: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			handleError(w, r, err)
+		}
+	}
+}
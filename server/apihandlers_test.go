@@ -1,9 +1,12 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
@@ -12,6 +15,7 @@ import (
 	"github.com/stretchr/testify/mock"
 
 	"github.com/zlogic/nanorss-go/data"
+	"github.com/zlogic/nanorss-go/fetcher"
 )
 
 type FeedListHelperMock struct {
@@ -23,6 +27,36 @@ func (m *FeedListHelperMock) GetAllItems(user *data.User) ([]*Item, error) {
 	return args.Get(0).([]*Item), args.Error(1)
 }
 
+func (m *FeedListHelperMock) GetStarredItems(user *data.User) ([]*Item, error) {
+	args := m.Called(user)
+	return args.Get(0).([]*Item), args.Error(1)
+}
+
+func (m *FeedListHelperMock) GetTaggedItems(user *data.User, tag string) ([]*Item, error) {
+	args := m.Called(user, tag)
+	return args.Get(0).([]*Item), args.Error(1)
+}
+
+func (m *FeedListHelperMock) GetFolderItems(user *data.User, folder string) ([]*Item, error) {
+	args := m.Called(user, folder)
+	return args.Get(0).([]*Item), args.Error(1)
+}
+
+func (m *FeedListHelperMock) GetFolders(user *data.User) ([]*Folder, error) {
+	args := m.Called(user)
+	return args.Get(0).([]*Folder), args.Error(1)
+}
+
+func (m *FeedListHelperMock) GetRecentlyRead(user *data.User, limit int) ([]*HistoryItem, error) {
+	args := m.Called(user, limit)
+	return args.Get(0).([]*HistoryItem), args.Error(1)
+}
+
+func (m *FeedListHelperMock) Search(user *data.User, query data.SearchQuery) ([]*Item, error) {
+	args := m.Called(user, query)
+	return args.Get(0).([]*Item), args.Error(1)
+}
+
 type FetcherMock struct {
 	mock.Mock
 }
@@ -31,6 +65,26 @@ func (m *FetcherMock) Refresh() {
 	m.Called()
 }
 
+func (m *FetcherMock) PreviewFeed(feedURL string) (*fetcher.ParsedFeed, error) {
+	args := m.Called(feedURL)
+	parsed := args.Get(0)
+	var returnParsed *fetcher.ParsedFeed
+	if parsed != nil {
+		returnParsed = parsed.(*fetcher.ParsedFeed)
+	}
+	return returnParsed, args.Error(1)
+}
+
+func (m *FetcherMock) PreviewPage(config *data.UserPagemonitor) (*fetcher.PagePreview, error) {
+	args := m.Called(config)
+	preview := args.Get(0)
+	var returnPreview *fetcher.PagePreview
+	if preview != nil {
+		returnPreview = preview.(*fetcher.PagePreview)
+	}
+	return returnPreview, args.Error(1)
+}
+
 func TestLoginHandlerSuccessful(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
@@ -150,7 +204,7 @@ func TestFeedHandlerAuthorized(t *testing.T) {
 
 	router.ServeHTTP(res, req)
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, `[{"Title":"","Origin":"Site 1","FetchURL":"fetchurl1","IsRead":true},{"Title":"t2","Origin":"Feed 1","FetchURL":"fetchurl2","IsRead":false}]`+"\n", res.Body.String())
+	assert.Equal(t, `[{"Title":"","Origin":"Site 1","FetchURL":"fetchurl1","IsRead":true,"IsStarred":false},{"Title":"t2","Origin":"Feed 1","FetchURL":"fetchurl2","IsRead":false,"IsStarred":false}]`+"\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
@@ -208,11 +262,13 @@ func TestFeedHandlerNotAuthorized(t *testing.T) {
 	feedListHelper.AssertExpectations(t)
 }
 
-func TestFeedItemAuthorized(t *testing.T) {
+func TestFeedHandlerStarredFilter(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
@@ -221,34 +277,86 @@ func TestFeedItemAuthorized(t *testing.T) {
 
 	authHandler.AllowUser(user)
 
-	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
-	item := &data.Feeditem{
-		Title:    "Title 1",
-		URL:      "http://site1/link1",
-		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
-		Contents: "Text 1",
-		Key:      key,
+	feedListHelper.On("GetStarredItems", user).Return([]*Item{
+		{
+			Title:     "t1",
+			Origin:    "http://site1/rss",
+			SortDate:  time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			FetchURL:  "fetchurl1",
+			IsStarred: true,
+		},
+	}, nil).Twice()
+
+	for _, url := range []string{"/api/feed?filter=starred", "/api/starred"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, `[{"Title":"t1","Origin":"http://site1/rss","FetchURL":"fetchurl1","IsRead":false,"IsStarred":true}]`+"\n", res.Body.String())
 	}
 
-	dbMock.On("GetFeeditem", key).Return(item, nil).Once()
-	dbMock.On("SetReadStatus", user, key.CreateKey(), true).Return(nil).Once()
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
+}
 
-	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(key.CreateKey()), nil)
+func TestFeedHandlerUnsupportedFilter(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/feed?filter=unknown", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, `{"URL":"http://site1/link1","Contents":"Text 1","Date":"2019-02-16T23:00:00Z","Plaintext":false,"MarkUnreadURL":"api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE"}`+"\n", res.Body.String())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Unsupported filter\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
+}
+
+func TestStarredNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/api/starred", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestPageAuthorized(t *testing.T) {
+func TestFeedHandlerTagFilter(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
@@ -257,32 +365,42 @@ func TestPageAuthorized(t *testing.T) {
 
 	authHandler.AllowUser(user)
 
-	config := &data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}
-	page := &data.PagemonitorPage{
-		Updated: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
-		Delta:   "Text 1",
-		Config:  config,
-	}
-
-	dbMock.On("GetPage", config).Return(page, nil).Once()
-	dbMock.On("SetReadStatus", user, config.CreateKey(), true).Return(nil).Once()
+	feedListHelper.On("GetTaggedItems", user, "weekly digest").Return([]*Item{
+		{
+			Title:    "t1",
+			Origin:   "http://site1/rss",
+			SortDate: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			FetchURL: "fetchurl1",
+			Tags:     []string{"weekly digest"},
+		},
+	}, nil).Once()
 
-	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(config.CreateKey()), nil)
+	req, _ := http.NewRequest("GET", "/api/feed?tag=weekly+digest", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, `{"URL":"http://site1/1","Contents":"Text 1","Date":"2019-02-16T23:00:00Z","Plaintext":true,"MarkUnreadURL":"api/items/pagemonitor-aHR0cDovL3NpdGUxLzE-bTE-cjE"}`+"\n", res.Body.String())
+	assert.Equal(t, `[{"Title":"t1","Origin":"http://site1/rss","FetchURL":"fetchurl1","IsRead":false,"IsStarred":false,"Tags":["weekly digest"]}]`+"\n", res.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/feed?tag=digest&filter=starred", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Unsupported filter\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestFeedItemAuthorizedNotFound(t *testing.T) {
+func TestFeedHandlerFolderFilter(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
@@ -291,22 +409,38 @@ func TestFeedItemAuthorizedNotFound(t *testing.T) {
 
 	authHandler.AllowUser(user)
 
-	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
-
-	dbMock.On("GetFeeditem", key).Return(nil, nil).Once()
+	feedListHelper.On("GetFolderItems", user, "News/Local").Return([]*Item{
+		{
+			Title:    "t1",
+			Origin:   "http://site1/rss",
+			Folder:   "News/Local",
+			SortDate: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			FetchURL: "fetchurl1",
+		},
+	}, nil).Once()
 
-	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(key.CreateKey()), nil)
+	req, _ := http.NewRequest("GET", "/api/feed?folder=News%2FLocal", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusNotFound, res.Code)
-	assert.Equal(t, "Not found\n", res.Body.String())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"Title":"t1","Origin":"http://site1/rss","Folder":"News/Local","FetchURL":"fetchurl1","IsRead":false,"IsStarred":false}]`+"\n", res.Body.String())
+
+	for _, query := range []string{"folder=News&filter=starred", "folder=News&tag=digest"} {
+		req, _ = http.NewRequest("GET", "/api/feed?"+query, nil)
+		res = httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "Unsupported filter\n", res.Body.String())
+	}
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestPageAuthorizedNotFound(t *testing.T) {
+func TestTagsAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -319,22 +453,43 @@ func TestPageAuthorizedNotFound(t *testing.T) {
 
 	authHandler.AllowUser(user)
 
-	config := &data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}
-
-	dbMock.On("GetPage", config).Return(nil, nil).Once()
+	dbMock.On("GetTags", user).Return(nil, nil).Once()
+	dbMock.On("CreateTag", user, "digest").Return(nil).Once()
+	dbMock.On("GetTags", user).Return([]string{"digest"}, nil).Once()
+	dbMock.On("RenameTag", user, "digest", "weekly").Return(nil).Once()
+	dbMock.On("GetTags", user).Return([]string{"weekly"}, nil).Once()
+	dbMock.On("DeleteTag", user, "weekly").Return(nil).Once()
+	dbMock.On("GetTags", user).Return([]string{}, nil).Once()
 
-	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(config.CreateKey()), nil)
+	req, _ := http.NewRequest("GET", "/api/tags", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusNotFound, res.Code)
-	assert.Equal(t, "Not found\n", res.Body.String())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "[]\n", res.Body.String())
+
+	for _, test := range []struct {
+		form     string
+		expected string
+	}{
+		{form: "Name=digest", expected: `["digest"]`},
+		{form: "Name=digest&NewName=weekly", expected: `["weekly"]`},
+		{form: "Name=weekly&Delete=true", expected: `[]`},
+	} {
+		req, _ := http.NewRequest("POST", "/api/tags", strings.NewReader(test.form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, test.expected+"\n", res.Body.String())
+	}
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestGetUnknownItemTypeAuthorized(t *testing.T) {
+func TestCreateTagErrorAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -347,18 +502,21 @@ func TestGetUnknownItemTypeAuthorized(t *testing.T) {
 
 	authHandler.AllowUser(user)
 
-	req, _ := http.NewRequest("GET", "/api/items/magic", nil)
+	dbMock.On("CreateTag", user, "").Return(&data.InvalidTagError{Err: fmt.Errorf("tag cannot be empty")}).Once()
+
+	req, _ := http.NewRequest("POST", "/api/tags", strings.NewReader("Name="))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusNotFound, res.Code)
-	assert.Equal(t, "Not found\n", res.Body.String())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid tag: tag cannot be empty\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestGetItemNotAuthorized(t *testing.T) {
+func TestTagsNotAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -366,22 +524,26 @@ func TestGetItemNotAuthorized(t *testing.T) {
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
-	req, _ := http.NewRequest("GET", "/api/items/feeditem--", nil)
-	res := httptest.NewRecorder()
+	for _, method := range []string{"GET", "POST"} {
+		req, _ := http.NewRequest(method, "/api/tags", nil)
+		res := httptest.NewRecorder()
 
-	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusUnauthorized, res.Code)
-	assert.Equal(t, "Bad credentials\n", res.Body.String())
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, "Bad credentials\n", res.Body.String())
+	}
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestSetUnreadAuthorized(t *testing.T) {
+func TestFoldersAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
@@ -390,27 +552,50 @@ func TestSetUnreadAuthorized(t *testing.T) {
 
 	authHandler.AllowUser(user)
 
-	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+	feedListHelper.On("GetFolders", user).Return([]*Folder(nil), nil).Once()
+	feedListHelper.On("GetFolders", user).Return([]*Folder{{Name: "News", Unread: 3}, {Name: "News/Local", Unread: 1}}, nil).Once()
+	dbMock.On("MarkRead", user, data.ReadFilter{Folder: "News/Local"}).Return(1, nil).Once()
+	feedListHelper.On("GetFolders", user).Return([]*Folder{{Name: "News", Unread: 2}, {Name: "News/Local", Unread: 0}}, nil).Once()
 
-	dbMock.On("SetReadStatus", user, key.CreateKey(), false).Return(nil).Once()
+	for _, expected := range []string{`[]`, `[{"Name":"News","Unread":3},{"Name":"News/Local","Unread":1}]`} {
+		req, _ := http.NewRequest("GET", "/api/folders", nil)
+		res := httptest.NewRecorder()
 
-	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Read=false"))
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, expected+"\n", res.Body.String())
+	}
+
+	req, _ := http.NewRequest("POST", "/api/folders", strings.NewReader("Name=News%2FLocal&Read=true"))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, "OK", res.Body.String())
+	assert.Equal(t, `[{"Name":"News","Unread":2},{"Name":"News/Local","Unread":0}]`+"\n", res.Body.String())
+
+	for _, form := range []string{"Name=&Read=true", "Name=News", "Name=News&Read=false"} {
+		req, _ := http.NewRequest("POST", "/api/folders", strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "Unsupported folder operation\n", res.Body.String())
+	}
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestPostItemUnsupportedOperationAuthorized(t *testing.T) {
+func TestMarkFolderReadErrorAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
@@ -419,9 +604,9 @@ func TestPostItemUnsupportedOperationAuthorized(t *testing.T) {
 
 	authHandler.AllowUser(user)
 
-	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+	dbMock.On("MarkRead", user, data.ReadFilter{Folder: "News"}).Return(0, fmt.Errorf("error")).Once()
 
-	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Read=true"))
+	req, _ := http.NewRequest("POST", "/api/folders", strings.NewReader("Name=News&Read=true"))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	res := httptest.NewRecorder()
 
@@ -431,111 +616,138 @@ func TestPostItemUnsupportedOperationAuthorized(t *testing.T) {
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestSetUnreadNotAuthorized(t *testing.T) {
+func TestFoldersNotAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
-	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
-
-	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Read=false"))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	res := httptest.NewRecorder()
+	for _, method := range []string{"GET", "POST"} {
+		req, _ := http.NewRequest(method, "/api/folders", nil)
+		res := httptest.NewRecorder()
 
-	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusUnauthorized, res.Code)
-	assert.Equal(t, "Bad credentials\n", res.Body.String())
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, "Bad credentials\n", res.Body.String())
+	}
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestGetSettingsAuthorized(t *testing.T) {
+func TestSearchAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
 	user := data.NewUser("user01")
-	user.Opml = "opml1"
-	user.Pagemonitor = "pagemonitor1"
 	user.SetPassword("pass")
 
 	authHandler.AllowUser(user)
 
-	req, _ := http.NewRequest("GET", "/api/configuration", nil)
+	read := false
+	query := data.SearchQuery{
+		Query:   `"hello world" test`,
+		FeedURL: "http://site1/rss",
+		From:    time.Date(2019, time.February, 1, 0, 0, 0, 0, time.UTC),
+		To:      time.Date(2019, time.February, 17, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond),
+		Read:    &read,
+		Limit:   10,
+	}
+	feedListHelper.On("Search", user, query).Return([]*Item{
+		{
+			Title:    "t1",
+			Origin:   "Site 1",
+			SortDate: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			FetchURL: "fetchurl1",
+			Score:    1.5,
+		},
+	}, nil).Once()
+
+	params := url.Values{}
+	params.Set("q", query.Query)
+	params.Set("feed", query.FeedURL)
+	params.Set("from", "2019-02-01")
+	params.Set("to", "2019-02-16")
+	params.Set("read", "false")
+	params.Set("limit", "10")
+	req, _ := http.NewRequest("GET", "/api/search?"+params.Encode(), nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, `{"Username":"user01","Opml":"opml1","Pagemonitor":"pagemonitor1"}`+"\n", res.Body.String())
+	assert.Equal(t, `[{"Title":"t1","Origin":"Site 1","FetchURL":"fetchurl1","IsRead":false,"IsStarred":false,"Score":1.5}]`+"\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestGetSettingsNotAuthorized(t *testing.T) {
+func TestSearchInvalidQueryAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
-	req, _ := http.NewRequest("GET", "/api/configuration", nil)
-	res := httptest.NewRecorder()
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
 
-	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusUnauthorized, res.Code)
-	assert.Equal(t, "Bad credentials\n", res.Body.String())
+	authHandler.AllowUser(user)
+
+	for _, params := range []string{"", "q=+", "q=test&from=yesterday", "q=test&read=maybe", "q=test&limit=0"} {
+		req, _ := http.NewRequest("GET", "/api/search?"+params, nil)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "Invalid search query\n", res.Body.String())
+	}
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestSaveSettingsAuthorized(t *testing.T) {
+func TestSearchNotAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	feedListHelper := new(FeedListHelperMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
-	user := data.NewUser("user01")
-	user.Opml = "opml1"
-	user.Pagemonitor = "pagemonitor1"
-	user.SetPassword("pass")
-
-	authHandler.AllowUser(user)
-	dbMock.On("GetUser", "user01").Return(user, nil).Once()
-
-	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user01&Opml=opml2&Pagemonitor=pagemonitor2"))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req, _ := http.NewRequest("GET", "/api/search?q=test", nil)
 	res := httptest.NewRecorder()
 
-	saveUser := *user
-	saveUser.Opml = "opml2"
-	saveUser.Pagemonitor = "pagemonitor2"
-	err = saveUser.SetUsername("user01")
-	assert.NoError(t, err)
-	dbMock.On("SaveUser", &saveUser).Return(nil).Once()
-
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, `{"Username":"user01","Opml":"opml2","Pagemonitor":"pagemonitor2"}`+"\n", res.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
+	feedListHelper.AssertExpectations(t)
 }
 
-func TestSaveSettingsChangePasswordAuthorized(t *testing.T) {
+func TestFeedItemAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -544,34 +756,35 @@ func TestSaveSettingsChangePasswordAuthorized(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := data.NewUser("user01")
-	user.Opml = "opml1"
-	user.Pagemonitor = "pagemonitor1"
 	user.SetPassword("pass")
 
 	authHandler.AllowUser(user)
-	dbMock.On("GetUser", "user01").Return(user, nil).Once()
 
-	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user01&Password=newpass&Opml=opml2&Pagemonitor=pagemonitor2"))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	res := httptest.NewRecorder()
-
-	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
-		Run(func(args mock.Arguments) {
-			saveUser := args.Get(0).(*data.User)
-			assert.NoError(t, saveUser.ValidatePassword("newpass"))
-			assert.Equal(t, "opml2", saveUser.Opml)
-			assert.Equal(t, "pagemonitor2", saveUser.Pagemonitor)
-		})
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+	item := &data.Feeditem{
+		Title:    "Title 1",
+		URL:      "http://site1/link1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "Text 1",
+		Key:      key,
+	}
+
+	dbMock.On("GetFeeditem", key).Return(item, nil).Once()
+	dbMock.On("SetReadStatus", user, key.CreateKey(), true).Return(nil).Once()
+	dbMock.On("GetNote", user, key.CreateKey()).Return(nil, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(key.CreateKey()), nil)
+	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, `{"Username":"user01","Opml":"opml2","Pagemonitor":"pagemonitor2"}`+"\n", res.Body.String())
+	assert.Equal(t, `{"URL":"http://site1/link1","Contents":"Text 1","Date":"2019-02-16T23:00:00Z","Plaintext":false,"MarkUnreadURL":"api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE"}`+"\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestSaveSettingsChangeUsernameAuthorized(t *testing.T) {
+func TestPageAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -580,42 +793,77 @@ func TestSaveSettingsChangeUsernameAuthorized(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := data.NewUser("user01")
-	user.Opml = "opml1"
-	user.Pagemonitor = "pagemonitor1"
 	user.SetPassword("pass")
 
 	authHandler.AllowUser(user)
 
-	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user02&Opml=opml2&Pagemonitor=pagemonitor2"))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	config := &data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}
+	page := &data.PagemonitorPage{
+		Updated: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Delta:   "Text 1",
+		Config:  config,
+	}
+
+	dbMock.On("GetPage", config).Return(page, nil).Once()
+	dbMock.On("SetReadStatus", user, config.CreateKey(), true).Return(nil).Once()
+	dbMock.On("GetNote", user, config.CreateKey()).Return(nil, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(config.CreateKey()), nil)
 	res := httptest.NewRecorder()
 
-	saveUser := *user
-	saveUser.Opml = "opml2"
-	saveUser.Pagemonitor = "pagemonitor2"
-	err = saveUser.SetUsername("user02")
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"URL":"http://site1/1","Contents":"Text 1","Date":"2019-02-16T23:00:00Z","Plaintext":true,"MarkUnreadURL":"api/items/pagemonitor-aHR0cDovL3NpdGUxLzE-bTE-cjE"}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestFeedItemWithNoteAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
-	getUpdatedUser := data.NewUser("user02")
-	getUpdatedUser.Opml = "opml2"
-	getUpdatedUser.Pagemonitor = "pagemonitor2"
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
 
-	dbMock.On("SaveUser", &saveUser).Return(nil).Once().
-		Run(func(args mock.Arguments) {
-			userArg := args.Get(0).(*data.User)
-			*userArg = *getUpdatedUser
-		})
-	dbMock.On("GetUser", "user02").Return(getUpdatedUser, nil).Once()
+	authHandler.AllowUser(user)
+
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+	item := &data.Feeditem{
+		Title:    "Title 1",
+		URL:      "http://site1/link1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "Text 1",
+		Key:      key,
+	}
+	note := &data.ItemNote{
+		Text:       "Note 1",
+		Highlights: []data.Highlight{{Start: 0, End: 4, Text: "Text"}},
+		Updated:    time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC),
+		Key:        key.CreateKey(),
+	}
+
+	dbMock.On("GetFeeditem", key).Return(item, nil).Once()
+	dbMock.On("SetReadStatus", user, key.CreateKey(), true).Return(nil).Once()
+	dbMock.On("GetNote", user, key.CreateKey()).Return(note, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(key.CreateKey()), nil)
+	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, `{"Username":"user02","Opml":"opml2","Pagemonitor":"pagemonitor2"}`+"\n", res.Body.String())
+	assert.Equal(t, `{"URL":"http://site1/link1","Contents":"Text 1","Date":"2019-02-16T23:00:00Z","Plaintext":false,"MarkUnreadURL":"api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE",`+
+		`"Note":{"Text":"Note 1","Highlights":[{"Start":0,"End":4,"Text":"Text"}],"Updated":"2019-02-17T23:00:00Z"}}`+"\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestSaveSettingsChangeUsernameFailedAuthorized(t *testing.T) {
+func TestSaveNoteAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -624,32 +872,49 @@ func TestSaveSettingsChangeUsernameFailedAuthorized(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := data.NewUser("user01")
-	user.Opml = "opml1"
-	user.Pagemonitor = "pagemonitor1"
 	user.SetPassword("pass")
 
 	authHandler.AllowUser(user)
 
-	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user02&Opml=opml2&Pagemonitor=pagemonitor2"))
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+
+	dbMock.On("SaveNote", user, key.CreateKey(), &data.ItemNote{Text: "Note 1", Highlights: []data.Highlight{{Start: 0, End: 4}}}).Return(nil).Once()
+	dbMock.On("SaveNote", user, key.CreateKey(), &data.ItemNote{}).Return(nil).Once()
+
+	for _, form := range []string{"Note=Note+1&Highlights=" + url.QueryEscape(`[{"Start":0,"End":4}]`), "Note="} {
+		req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "OK", res.Body.String())
+	}
+
+	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Note=&Highlights=invalid"))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	res := httptest.NewRecorder()
 
-	saveUser := *user
-	saveUser.Opml = "opml2"
-	saveUser.Pagemonitor = "pagemonitor2"
-	err = saveUser.SetUsername("user02")
-	assert.NoError(t, err)
-	dbMock.On("SaveUser", &saveUser).Return(fmt.Errorf("Username already in use")).Once()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid highlights\n", res.Body.String())
+
+	highlights := []data.Highlight{{Start: 0, End: 100}}
+	dbMock.On("SaveNote", user, key.CreateKey(), &data.ItemNote{Highlights: highlights}).Return(&data.InvalidHighlightsError{Err: fmt.Errorf("highlight 0-100 is out of range")}).Once()
+
+	req, _ = http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Note=&Highlights="+url.QueryEscape(`[{"Start":0,"End":100}]`)))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusInternalServerError, res.Code)
-	assert.Equal(t, "Internal server error\n", res.Body.String())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid highlights: highlight 0-100 is out of range\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestSaveSettingsUnauthorized(t *testing.T) {
+func TestNotesExportAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -657,56 +922,96 @@ func TestSaveSettingsUnauthorized(t *testing.T) {
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
-	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user01&Opml=opml2&Pagemonitor=pagemonitor2"))
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+
+	authHandler.AllowUser(user)
+
+	itemKey := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+	pageKey := &data.UserPagemonitor{URL: "http://site1/page"}
+	item := &data.Feeditem{
+		Title:    "Title 1",
+		URL:      "http://site1/link1",
+		Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+		Contents: "Text 1\nText 2",
+		Key:      itemKey,
+	}
+	notes := []*data.ItemNote{
+		{
+			Text:    "Page *note*",
+			Updated: time.Date(2019, time.February, 17, 23, 0, 0, 0, time.UTC),
+			Key:     pageKey.CreateKey(),
+		},
+		{
+			Text:       "Item note",
+			Highlights: []data.Highlight{{Start: 0, End: 13, Text: "Text 1\nText 2"}},
+			Updated:    time.Date(2019, time.February, 18, 23, 0, 0, 0, time.UTC),
+			Key:        itemKey.CreateKey(),
+		},
+	}
+
+	dbMock.On("GetNotes", user).Return(notes, nil).Twice()
+	dbMock.On("GetFeeditem", itemKey).Return(item, nil).Twice()
+	dbMock.On("GetPage", pageKey).Return(nil, nil).Twice()
+
+	req, _ := http.NewRequest("GET", "/api/notes", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusUnauthorized, res.Code)
-	assert.Equal(t, "Bad credentials\n", res.Body.String())
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	assert.Equal(t, `[{"Title":"Title 1","Origin":"Feed 1","URL":"http://site1/link1","Date":"2019-02-16T23:00:00Z","Text":"Item note","Highlights":[{"Start":0,"End":13,"Text":"Text 1\nText 2"}],"Updated":"2019-02-18T23:00:00Z"},`+
+		`{"Title":"","Origin":"Page 1","URL":"http://site1/page","Date":"0001-01-01T00:00:00Z","Text":"Page *note*","Updated":"2019-02-17T23:00:00Z"}]`+"\n", res.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/notes?format=markdown", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "text/markdown; charset=utf-8", res.Header().Get("Content-Type"))
+	assert.Equal(t, "# Notes\n"+
+		"\n## [Title 1](http://site1/link1)\n\nFeed 1, 2019-02-16\n\n> Text 1\n> Text 2\n\nItem note\n"+
+		"\n## [Page 1](http://site1/page)\n\nPage 1\n\nPage *note*\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestRefreshAuthorized(t *testing.T) {
+func TestNotesExportUnsupportedFormatAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	fetcherMock := new(FetcherMock)
-	fetcherMock.On("Refresh").Once()
-
-	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
 	user := data.NewUser("user01")
+	user.SetPassword("pass")
 
 	authHandler.AllowUser(user)
 
-	req, _ := http.NewRequest("GET", "/api/refresh", nil)
+	req, _ := http.NewRequest("GET", "/api/notes?format=html", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, "OK", res.Body.String())
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Unsupported format\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
-	fetcherMock.AssertExpectations(t)
 }
 
-func TestRefreshNotAuthorized(t *testing.T) {
+func TestNotesNotAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
-	fetcherMock := new(FetcherMock)
-
-	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
-	req, _ := http.NewRequest("GET", "/api/refresh", nil)
+	req, _ := http.NewRequest("GET", "/api/notes", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
@@ -715,10 +1020,9 @@ func TestRefreshNotAuthorized(t *testing.T) {
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
-	fetcherMock.AssertExpectations(t)
 }
 
-func TestGetStatusAuthorizedSuccess(t *testing.T) {
+func TestFeedItemAuthorizedNotFound(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -727,34 +1031,26 @@ func TestGetStatusAuthorizedSuccess(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := data.NewUser("user01")
-	user.Opml = defaultOpml
-	user.Pagemonitor = defaultPagemonitor
+	user.SetPassword("pass")
 
 	authHandler.AllowUser(user)
 
-	date1 := time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)
-	date2 := time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC)
-	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site1/rss"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date2, LastFailure: date1}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site2/rss"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date1}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date2, LastFailure: date1}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/2"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date2}, nil).Once()
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
 
-	req, _ := http.NewRequest("GET", "/api/status", nil)
+	dbMock.On("GetFeeditem", key).Return(nil, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(key.CreateKey()), nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, "["+`{"Name":"Feed 1","Success":true,"LastFailure":"2019-02-16T23:00:00Z","LastSuccess":"2019-02-16T23:01:00Z"},`+
-		`{"Name":"Feed 2","Success":true,"LastSuccess":"2019-02-16T23:00:00Z"},`+
-		`{"Name":"Site 1","Success":true,"LastFailure":"2019-02-16T23:00:00Z","LastSuccess":"2019-02-16T23:01:00Z"},`+
-		`{"Name":"Site 2","Success":true,"LastSuccess":"2019-02-16T23:01:00Z"}`+
-		"]\n", res.Body.String())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Equal(t, "Not found\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestGetStatusAuthorizedFailure(t *testing.T) {
+func TestPageAuthorizedNotFound(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -763,34 +1059,26 @@ func TestGetStatusAuthorizedFailure(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := data.NewUser("user01")
-	user.Opml = defaultOpml
-	user.Pagemonitor = defaultPagemonitor
+	user.SetPassword("pass")
 
 	authHandler.AllowUser(user)
 
-	date1 := time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)
-	date2 := time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC)
-	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site1/rss"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date1, LastFailure: date2}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site2/rss"}).CreateKey()).Return(&data.FetchStatus{LastFailure: date1}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date1, LastFailure: date2}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/2"}).CreateKey()).Return(&data.FetchStatus{LastFailure: date2}, nil).Once()
+	config := &data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}
 
-	req, _ := http.NewRequest("GET", "/api/status", nil)
+	dbMock.On("GetPage", config).Return(nil, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/items/"+escapeKeyForURL(config.CreateKey()), nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, "["+`{"Name":"Feed 1","Success":false,"LastFailure":"2019-02-16T23:01:00Z","LastSuccess":"2019-02-16T23:00:00Z"},`+
-		`{"Name":"Feed 2","Success":false,"LastFailure":"2019-02-16T23:00:00Z"},`+
-		`{"Name":"Site 1","Success":false,"LastFailure":"2019-02-16T23:01:00Z","LastSuccess":"2019-02-16T23:00:00Z"},`+
-		`{"Name":"Site 2","Success":false,"LastFailure":"2019-02-16T23:01:00Z"}`+
-		"]\n", res.Body.String())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Equal(t, "Not found\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestGetStatusAuthorizedUnknown(t *testing.T) {
+func TestGetUnknownItemTypeAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -799,32 +1087,22 @@ func TestGetStatusAuthorizedUnknown(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := data.NewUser("user01")
-	user.Opml = defaultOpml
-	user.Pagemonitor = defaultPagemonitor
+	user.SetPassword("pass")
 
 	authHandler.AllowUser(user)
 
-	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site1/rss"}).CreateKey()).Return(&data.FetchStatus{}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site2/rss"}).CreateKey()).Return(&data.FetchStatus{}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}).CreateKey()).Return(&data.FetchStatus{}, nil).Once()
-	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/2"}).CreateKey()).Return(&data.FetchStatus{}, nil).Once()
-
-	req, _ := http.NewRequest("GET", "/api/status", nil)
+	req, _ := http.NewRequest("GET", "/api/items/magic", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
-	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, "["+`{"Name":"Feed 1","Success":false},`+
-		`{"Name":"Feed 2","Success":false},`+
-		`{"Name":"Site 1","Success":false},`+
-		`{"Name":"Site 2","Success":false}`+
-		"]\n", res.Body.String())
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Equal(t, "Not found\n", res.Body.String())
 
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
 
-func TestGetStatusNotAuthorized(t *testing.T) {
+func TestGetItemNotAuthorized(t *testing.T) {
 	dbMock := new(DBMock)
 	authHandler := AuthHandlerMock{}
 
@@ -832,7 +1110,7 @@ func TestGetStatusNotAuthorized(t *testing.T) {
 	router, err := CreateRouter(services)
 	assert.NoError(t, err)
 
-	req, _ := http.NewRequest("GET", "/api/status", nil)
+	req, _ := http.NewRequest("GET", "/api/items/feeditem--", nil)
 	res := httptest.NewRecorder()
 
 	router.ServeHTTP(res, req)
@@ -842,3 +1120,2359 @@ func TestGetStatusNotAuthorized(t *testing.T) {
 	dbMock.AssertExpectations(t)
 	authHandler.AssertExpectations(t)
 }
+
+func TestSetUnreadAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+
+	dbMock.On("SetReadStatus", user, key.CreateKey(), false).Return(nil).Once()
+
+	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Read=false"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "OK", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestPostItemUnsupportedOperationAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+
+	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Read=true"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSetUnreadNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+
+	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Read=false"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSetReadStatusesAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	key1 := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+	key2 := &data.UserPagemonitor{URL: "http://site2/page"}
+
+	dbMock.On("SetReadStatuses", user, [][]byte{key1.CreateKey(), key2.CreateKey()}, true).Return(2, nil).Once()
+	dbMock.On("SetReadStatuses", user, [][]byte{key1.CreateKey()}, false).Return(1, nil).Once()
+
+	for form, expected := range map[string]string{
+		"Read=true&Key=" + escapeKeyForURL(key1.CreateKey()) + "&Key=" + escapeKeyForURL(key2.CreateKey()): `{"Updated":2}`,
+		"Read=false&Key=" + escapeKeyForURL(key1.CreateKey()):                                              `{"Updated":1}`,
+	} {
+		req, _ := http.NewRequest("POST", "/api/items", strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, expected+"\n", res.Body.String())
+	}
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestMarkReadAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	dbMock.On("MarkRead", user, data.ReadFilter{}).Return(10, nil).Once()
+	dbMock.On("MarkRead", user, data.ReadFilter{FeedURL: "http://site1/rss"}).Return(3, nil).Once()
+	dbMock.On("MarkRead", user, data.ReadFilter{Folder: "News/Local"}).Return(2, nil).Once()
+	dbMock.On("MarkRead", user, data.ReadFilter{Tag: "tag1", Before: time.Date(2019, time.February, 17, 0, 0, 0, 0, time.UTC)}).Return(1, nil).Once()
+
+	for form, expected := range map[string]string{
+		"Read=true&All=true":                      `{"Updated":10}`,
+		"Read=true&Feed=http%3A%2F%2Fsite1%2Frss": `{"Updated":3}`,
+		"Read=true&Folder=News%2FLocal":           `{"Updated":2}`,
+		"Read=true&Tag=tag1&Before=2019-02-17":    `{"Updated":1}`,
+	} {
+		req, _ := http.NewRequest("POST", "/api/items", strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, expected+"\n", res.Body.String())
+	}
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestBulkReadStatusErrorsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	for form, expectedError := range map[string]string{
+		"All=true":                    "Invalid read status",
+		"Read=true":                   "No items selected",
+		"Read=true&All=false":         "No items selected",
+		"Read=true&Before=17.02.2019": "Invalid before date",
+		"Read=false&Folder=News":      "Only selected keys can be marked as unread",
+	} {
+		req, _ := http.NewRequest("POST", "/api/items", strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, expectedError+"\n", res.Body.String())
+	}
+
+	dbMock.On("MarkRead", user, data.ReadFilter{}).Return(0, fmt.Errorf("error")).Once()
+
+	req, _ := http.NewRequest("POST", "/api/items", strings.NewReader("Read=true&All=true"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestBulkReadStatusNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/api/items", strings.NewReader("Read=true&All=true"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestUndoMarkReadAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	dbMock.On("UndoMarkRead", user).Return(5, nil).Once()
+
+	req, _ := http.NewRequest("POST", "/api/items/undo", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Updated":5}`+"\n", res.Body.String())
+
+	dbMock.On("UndoMarkRead", user).Return(0, fmt.Errorf("error")).Once()
+
+	req, _ = http.NewRequest("POST", "/api/items/undo", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestHistoryAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	feedListHelper := new(FeedListHelperMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, feedListHelper: feedListHelper}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	items := []*HistoryItem{
+		{
+			Item:     &Item{Title: "t1", Origin: "Feed 1", FetchURL: "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE", IsRead: true},
+			ReadTime: time.Date(2019, time.February, 18, 10, 0, 0, 0, time.UTC),
+		},
+	}
+	feedListHelper.On("GetRecentlyRead", user, 50).Return(items, nil).Once()
+	feedListHelper.On("GetRecentlyRead", user, 5).Return([]*HistoryItem{}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/history", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"Title":"t1","Origin":"Feed 1","FetchURL":"api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE","IsRead":true,"IsStarred":false,"ReadTime":"2019-02-18T10:00:00Z"}]`+"\n", res.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/history?limit=5", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "[]\n", res.Body.String())
+
+	for _, limit := range []string{"0", "-1", "many"} {
+		req, _ = http.NewRequest("GET", "/api/history?limit="+limit, nil)
+		res = httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "Invalid limit\n", res.Body.String())
+	}
+
+	feedListHelper.AssertExpectations(t)
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestReadingStatsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = defaultSubscriptions
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/1", Title: "Site 1"}}
+
+	authHandler.AllowUser(user)
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	stats := []*data.ReadingStats{
+		{Date: time.Date(2019, time.February, 17, 0, 0, 0, 0, time.UTC), FeedURL: "http://site1/rss", Read: 2},
+		{Date: time.Date(2019, time.February, 17, 0, 0, 0, 0, time.UTC), FeedURL: "http://site3/rss", Read: 1},
+		{Date: time.Date(2019, time.February, 18, 0, 0, 0, 0, time.UTC), FeedURL: "http://site1/rss", Read: 1, MarkedRead: 5},
+	}
+	dbMock.On("GetReadingStats", user, today.AddDate(0, 0, -29)).Return(stats, nil).Once()
+	dbMock.On("GetReadingStats", user, today.AddDate(0, 0, -6)).Return([]*data.ReadingStats{}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/history/stats", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Days":[`+
+		`{"Date":"2019-02-17T00:00:00Z","FeedURL":"http://site1/rss","Read":2,"MarkedRead":0},`+
+		`{"Date":"2019-02-17T00:00:00Z","FeedURL":"http://site3/rss","Read":1,"MarkedRead":0},`+
+		`{"Date":"2019-02-18T00:00:00Z","FeedURL":"http://site1/rss","Read":1,"MarkedRead":5}],`+
+		`"Feeds":[`+
+		`{"URL":"http://site2/rss","Title":"Feed 2","Read":0,"MarkedRead":0},`+
+		`{"URL":"http://site1/1","Title":"Site 1","Read":0,"MarkedRead":0},`+
+		`{"URL":"http://site3/rss","Title":"http://site3/rss","Read":1,"MarkedRead":0},`+
+		`{"URL":"http://site1/rss","Title":"Feed 1","Read":3,"MarkedRead":5}]}`+"\n", res.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/history/stats?days=7", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Days":[],"Feeds":[`+
+		`{"URL":"http://site1/rss","Title":"Feed 1","Read":0,"MarkedRead":0},`+
+		`{"URL":"http://site2/rss","Title":"Feed 2","Read":0,"MarkedRead":0},`+
+		`{"URL":"http://site1/1","Title":"Site 1","Read":0,"MarkedRead":0}]}`+"\n", res.Body.String())
+
+	for _, days := range []string{"0", "-1", "week"} {
+		req, _ = http.NewRequest("GET", "/api/history/stats?days="+days, nil)
+		res = httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "Invalid number of days\n", res.Body.String())
+	}
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestHistoryNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	for _, url := range []string{"/api/history", "/api/history/stats"} {
+		req, _ := http.NewRequest("GET", url, nil)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, "Bad credentials\n", res.Body.String())
+	}
+
+	req, _ := http.NewRequest("POST", "/api/items/undo", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSetStarredAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+
+	dbMock.On("SetStarred", user, key.CreateKey(), true).Return(nil).Once()
+	dbMock.On("SetStarred", user, key.CreateKey(), false).Return(nil).Once()
+
+	for _, starred := range []string{"true", "false"} {
+		req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Starred="+starred))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "OK", res.Body.String())
+	}
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSetTaggedAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+
+	dbMock.On("SetTagged", user, "digest", key.CreateKey(), true).Return(nil).Once()
+	dbMock.On("SetTagged", user, "digest", key.CreateKey(), false).Return(nil).Once()
+
+	for _, tagged := range []string{"true", "false"} {
+		req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Tag=digest&Tagged="+tagged))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "OK", res.Body.String())
+	}
+
+	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Tag=digest&Tagged=maybe"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid tagged status\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSetStarredInvalidValueAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	key := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+
+	req, _ := http.NewRequest("POST", "/api/items/"+escapeKeyForURL(key.CreateKey()), strings.NewReader("Starred=maybe"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid starred status\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+// settingsExportJSON contains the deprecated Opml and Pagemonitor fields returned by /api/configuration,
+// for a user subscribed to Feed 1 and monitoring Page 1.
+const settingsExportJSON = `"Opml":"\u003c?xml version=\"1.0\" encoding=\"UTF-8\"?\u003e\n\u003copml version=\"1.0\"\u003e\n  \u003cbody\u003e\n    \u003coutline text=\"Feed 1\" xmlUrl=\"http://site1/rss\" title=\"Feed 1\"\u003e\u003c/outline\u003e\n  \u003c/body\u003e\n\u003c/opml\u003e",` +
+	`"Pagemonitor":"\u003c?xml version=\"1.0\" encoding=\"UTF-8\"?\u003e\n\u003cpages\u003e\n  \u003cpage url=\"http://site1/page\"\u003ePage 1\u003c/page\u003e\n\u003c/pages\u003e"`
+
+func TestGetSettingsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/configuration", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user01",`+settingsExportJSON+`}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetSettingsAdminAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	user.Admin = true
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/configuration", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user01","Admin":true,`+settingsExportJSON+`}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetSettingsUnconvertedAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Opml = "<opml"
+	user.Pagemonitor = "<pages"
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/configuration", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user01","Opml":"\u003copml","Pagemonitor":"\u003cpages","UnconvertedOpml":true,"UnconvertedPagemonitor":true}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetSettingsNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/api/configuration", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetSettingsMailAddressAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, mailDomain: "nanorss.local"}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	user.MailToken = "token1"
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/configuration", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user01",`+settingsExportJSON+`,"MailAddress":"token1@nanorss.local"}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGenerateMailAddressAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, mailDomain: "nanorss.local"}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.MailToken = "token1"
+
+	authHandler.AllowUser(user)
+
+	var savedToken string
+	dbMock.On("SaveUser", user).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			savedToken = args.Get(0).(*data.User).MailToken
+		})
+
+	req, _ := http.NewRequest("POST", "/api/configuration/mailaddress", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.NotEqual(t, "token1", savedToken)
+	assert.NotEmpty(t, savedToken)
+	assert.Equal(t, `{"MailAddress":"`+savedToken+`@nanorss.local"}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSaveSettingsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+	dbMock.On("GetUser", "user01").Return(user, nil).Once()
+
+	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user01"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	saveUser := *user
+	err = saveUser.SetUsername("user01")
+	assert.NoError(t, err)
+	dbMock.On("SaveUser", &saveUser).Return(nil).Once()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user01",`+settingsExportJSON+`}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSaveSettingsRetentionAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, defaultRetention: data.RetentionPolicy{MaxAgeDays: 14}}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+	dbMock.On("GetUser", "user01").Return(user, nil).Once()
+
+	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user01&MaxAgeDays=30&MaxItems=100"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	saveUser := *user
+	saveUser.MaxAgeDays = 30
+	saveUser.MaxItems = 100
+	err = saveUser.SetUsername("user01")
+	assert.NoError(t, err)
+	dbMock.On("SaveUser", &saveUser).Return(nil).Once()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user01",`+settingsExportJSON+`,"MaxAgeDays":30,"MaxItems":100,"DefaultMaxAgeDays":14}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSaveSettingsOpmlPagemonitorAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site2/rss", Title: "Feed 2"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site2/page", Title: "Page 2"}}
+	user.Opml = "<opml"
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+	dbMock.On("GetUser", "user01").Return(user, nil).Once()
+
+	form := url.Values{
+		"Username":    {"user01"},
+		"Opml":        {`<opml version="1.0"><body><outline text="Feed 1" xmlUrl="http://site1/rss"/></body></opml>`},
+		"Pagemonitor": {`<pages><page url="http://site1/page">Page 1</page></pages>`},
+	}
+	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	// Posted configuration replaces all subscriptions and pages.
+	saveUser := *user
+	saveUser.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	saveUser.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	saveUser.Opml = ""
+	err = saveUser.SetUsername("user01")
+	assert.NoError(t, err)
+	dbMock.On("SaveUser", &saveUser).Return(nil).Once()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user01",`+settingsExportJSON+`}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSaveSettingsInvalidOpmlPagemonitorAuthorized(t *testing.T) {
+	for form, message := range map[string]string{
+		"Username=user01&Opml=%3Copml": "Invalid OPML: cannot parse opml xml: XML syntax error on line 1: unexpected EOF",
+		"Username=user01&Opml=%3Copml%3E%3Cbody%3E%3Coutline+xmlUrl%3D%22site1%22%2F%3E%3C%2Fbody%3E%3C%2Fopml%3E": "Invalid OPML: invalid feed site1: feed URL site1 is not absolute",
+		"Username=user01&Pagemonitor=%3Cpages": "Invalid Pagemonitor XML: cannot parse pagemonitor xml: XML syntax error on line 1: unexpected EOF",
+	} {
+		dbMock := new(DBMock)
+		authHandler := AuthHandlerMock{}
+
+		services := &Services{db: dbMock, cookieHandler: &authHandler}
+		router, err := CreateRouter(services)
+		assert.NoError(t, err)
+
+		user := data.NewUser("user01")
+		user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+		user.SetPassword("pass")
+
+		authHandler.AllowUser(user)
+
+		req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, message+"\n", res.Body.String())
+		assert.Equal(t, []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}, user.Subscriptions)
+
+		dbMock.AssertExpectations(t)
+		authHandler.AssertExpectations(t)
+	}
+}
+
+func TestSaveSettingsInvalidRetentionAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	for form, message := range map[string]string{
+		"MaxAgeDays=-1": "Invalid retention policy: max age days cannot be negative",
+		"MaxItems=many": "Invalid retention policy: cannot parse max items: strconv.Atoi: parsing \"many\": invalid syntax",
+	} {
+		req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user01&"+form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, message+"\n", res.Body.String())
+	}
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSaveSettingsChangePasswordAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+	dbMock.On("GetUser", "user01").Return(user, nil).Once()
+
+	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user01&Password=newpass"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.NoError(t, saveUser.ValidatePassword("newpass"))
+			assert.Equal(t, []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}, saveUser.Subscriptions)
+			assert.Equal(t, []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}, saveUser.Pages)
+		})
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user01",`+settingsExportJSON+`}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSaveSettingsChangeUsernameAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user02"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	saveUser := *user
+	err = saveUser.SetUsername("user02")
+	assert.NoError(t, err)
+
+	getUpdatedUser := data.NewUser("user02")
+	getUpdatedUser.Subscriptions = user.Subscriptions
+	getUpdatedUser.Pages = user.Pages
+
+	dbMock.On("SaveUser", &saveUser).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			userArg := args.Get(0).(*data.User)
+			*userArg = *getUpdatedUser
+		})
+	dbMock.On("GetUser", "user02").Return(getUpdatedUser, nil).Once()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Username":"user02",`+settingsExportJSON+`}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSaveSettingsChangeUsernameFailedAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+	user.SetPassword("pass")
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user02"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	saveUser := *user
+	err = saveUser.SetUsername("user02")
+	assert.NoError(t, err)
+	dbMock.On("SaveUser", &saveUser).Return(fmt.Errorf("Username already in use")).Once()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSaveSettingsUnauthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/api/configuration", strings.NewReader("Username=user01"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetSubscriptionsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{
+		{URL: "http://site1/rss", Title: "Feed 1", Folder: "News"},
+		{URL: "http://site2/rss", Title: "Feed 2", Type: "rss", RetentionPolicy: data.RetentionPolicy{MaxItems: 10}},
+	}
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/subscriptions", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site1/rss","Title":"Feed 1","Folder":"News","Key":"feed-aHR0cDovL3NpdGUxL3Jzcw"},`+
+		`{"URL":"http://site2/rss","Title":"Feed 2","Type":"rss","MaxItems":10,"Key":"feed-aHR0cDovL3NpdGUyL3Jzcw"}]`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestAddSubscriptionAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("POST", "/api/subscriptions", strings.NewReader("URL=http://site2/rss&Title=Feed+2&Folder=News&MaxAgeDays=5"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.Equal(t, []data.UserFeed{
+				{URL: "http://site1/rss", Title: "Feed 1"},
+				{URL: "http://site2/rss", Title: "Feed 2", Folder: "News", RetentionPolicy: data.RetentionPolicy{MaxAgeDays: 5}},
+			}, saveUser.Subscriptions)
+		})
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site1/rss","Title":"Feed 1","Key":"feed-aHR0cDovL3NpdGUxL3Jzcw"},`+
+		`{"URL":"http://site2/rss","Title":"Feed 2","Folder":"News","MaxAgeDays":5,"Key":"feed-aHR0cDovL3NpdGUyL3Jzcw"}]`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestAddSubscriptionErrorsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+
+	authHandler.AllowUser(user)
+
+	for form, expectedError := range map[string]string{
+		"URL=http://site1/rss":               "Subscription already exists",
+		"URL=":                               "Invalid subscription: feed URL cannot be empty",
+		"URL=site2":                          "Invalid subscription: feed URL site2 is not absolute",
+		"URL=http://site2/rss&MaxItems=-1":   "Invalid subscription: max items cannot be negative",
+		"URL=http://site2/rss&MaxItems=many": "Invalid subscription: cannot parse max items: strconv.Atoi: parsing \"many\": invalid syntax",
+	} {
+		req, _ := http.NewRequest("POST", "/api/subscriptions", strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, expectedError+"\n", res.Body.String())
+	}
+	assert.Equal(t, []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}, user.Subscriptions)
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestUpdateSubscriptionAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}, {URL: "http://site2/rss", Title: "Feed 2"}}
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("PUT", "/api/subscriptions/feed-aHR0cDovL3NpdGUxL3Jzcw", strings.NewReader("URL=http://site1/atom&Title=Feed+1&Folder=News"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.Equal(t, []data.UserFeed{
+				{URL: "http://site1/atom", Title: "Feed 1", Folder: "News"},
+				{URL: "http://site2/rss", Title: "Feed 2"},
+			}, saveUser.Subscriptions)
+		})
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site1/atom","Title":"Feed 1","Folder":"News","Key":"feed-aHR0cDovL3NpdGUxL2F0b20"},`+
+		`{"URL":"http://site2/rss","Title":"Feed 2","Key":"feed-aHR0cDovL3NpdGUyL3Jzcw"}]`+"\n", res.Body.String())
+
+	req, _ = http.NewRequest("PUT", "/api/subscriptions/feed-aHR0cDovL3NpdGUyL3Jzcw", strings.NewReader("URL=http://site1/atom"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid subscription: feed http://site1/atom already exists\n", res.Body.String())
+
+	req, _ = http.NewRequest("PUT", "/api/subscriptions/feed-aHR0cDovL3NpdGUxL3Jzcw", strings.NewReader("URL=http://site1/rss"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Equal(t, "Not found\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestDeleteSubscriptionAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}, {URL: "http://site2/rss", Title: "Feed 2"}}
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("DELETE", "/api/subscriptions/feed-aHR0cDovL3NpdGUxL3Jzcw", nil)
+	res := httptest.NewRecorder()
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.Equal(t, []data.UserFeed{{URL: "http://site2/rss", Title: "Feed 2"}}, saveUser.Subscriptions)
+		})
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site2/rss","Title":"Feed 2","Key":"feed-aHR0cDovL3NpdGUyL3Jzcw"}]`+"\n", res.Body.String())
+
+	req, _ = http.NewRequest("DELETE", "/api/subscriptions/feed-aHR0cDovL3NpdGUxL3Jzcw", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Equal(t, "Not found\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestExportOPMLAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1", Folder: "News"}}
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/subscriptions/opml", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "text/x-opml; charset=utf-8", res.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="subscriptions.opml"`, res.Header().Get("Content-Disposition"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.0">
+  <body>
+    <outline text="News" title="News">
+      <outline text="Feed 1" xmlUrl="http://site1/rss" title="Feed 1"></outline>
+    </outline>
+  </body>
+</opml>`, res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func createOPMLRequest(t *testing.T, opml string, values map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range values {
+		err := writer.WriteField(key, value)
+		assert.NoError(t, err)
+	}
+	part, err := writer.CreateFormFile("Opml", "subscriptions.opml")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte(opml))
+	assert.NoError(t, err)
+	err = writer.Close()
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/api/subscriptions/opml", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestImportOPMLAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+
+	authHandler.AllowUser(user)
+
+	req := createOPMLRequest(t, `<opml version="1.0"><body><outline text="News"><outline text="Feed 2" xmlUrl="http://site2/rss"/></outline></body></opml>`, map[string]string{"Replace": "true"})
+	res := httptest.NewRecorder()
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.Equal(t, []data.UserFeed{{URL: "http://site2/rss", Title: "Feed 2", Folder: "News"}}, saveUser.Subscriptions)
+		})
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site2/rss","Title":"Feed 2","Folder":"News","Key":"feed-aHR0cDovL3NpdGUyL3Jzcw"}]`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestImportOPMLErrorsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+
+	authHandler.AllowUser(user)
+
+	req := createOPMLRequest(t, `<opml version="1.0"><body><outline`, nil)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid OPML: cannot parse opml xml: XML syntax error on line 1: unexpected EOF\n", res.Body.String())
+
+	req = createOPMLRequest(t, `<opml version="1.0"><body></body></opml>`, map[string]string{"Replace": "maybe"})
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid replace value\n", res.Body.String())
+
+	req, _ = http.NewRequest("POST", "/api/subscriptions/opml", strings.NewReader("Replace=true"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid OPML upload\n", res.Body.String())
+
+	assert.Equal(t, []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}, user.Subscriptions)
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestSubscriptionsUnauthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	for _, request := range []struct{ method, url string }{
+		{"GET", "/api/subscriptions"},
+		{"POST", "/api/subscriptions"},
+		{"PUT", "/api/subscriptions/feed-aHR0cDovL3NpdGUxL3Jzcw"},
+		{"DELETE", "/api/subscriptions/feed-aHR0cDovL3NpdGUxL3Jzcw"},
+		{"GET", "/api/subscriptions/opml"},
+		{"POST", "/api/subscriptions/opml"},
+	} {
+		req, _ := http.NewRequest(request.method, request.url, nil)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, "Bad credentials\n", res.Body.String())
+	}
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetPagesAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Pages = []data.UserPagemonitor{
+		{URL: "http://site1/page", Title: "Page 1"},
+		{URL: "http://site2/page", Title: "Page 2", Match: "([0-9]+)", Replace: "$1"},
+	}
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/pages", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site1/page","Title":"Page 1","Match":"","Replace":"","Key":"pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U--"},`+
+		`{"URL":"http://site2/page","Title":"Page 2","Match":"([0-9]+)","Replace":"$1","Key":"pagemonitor-aHR0cDovL3NpdGUyL3BhZ2U-KFswLTldKyk-JDE"}]`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestAddPageAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("POST", "/api/pages", strings.NewReader("URL=http://site2/page&Title=Page+2&Match=%28%5B0-9%5D%2B%29&Replace=%241"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.Equal(t, []data.UserPagemonitor{
+				{URL: "http://site1/page", Title: "Page 1"},
+				{URL: "http://site2/page", Title: "Page 2", Match: "([0-9]+)", Replace: "$1"},
+			}, saveUser.Pages)
+		})
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site1/page","Title":"Page 1","Match":"","Replace":"","Key":"pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U--"},`+
+		`{"URL":"http://site2/page","Title":"Page 2","Match":"([0-9]+)","Replace":"$1","Key":"pagemonitor-aHR0cDovL3NpdGUyL3BhZ2U-KFswLTldKyk-JDE"}]`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestAddPageErrorsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+
+	authHandler.AllowUser(user)
+
+	for form, expectedError := range map[string]string{
+		"URL=http://site1/page":              "Page already exists",
+		"URL=":                               "Invalid page: page URL cannot be empty",
+		"URL=site2":                          "Invalid page: page URL site2 is not absolute",
+		"URL=http://site2/page&Match=%5B0-9": "Invalid page: cannot compile match regex: error parsing regexp: missing closing ]: `[0-9`",
+		"URL=http://site2/page&Replace=r1":   "Invalid page: replace cannot be used without match",
+		"URL=http://site2/page&Match=%5B0-9%5D&Replace=$1": "Invalid page: replace references unknown group 1",
+	} {
+		req, _ := http.NewRequest("POST", "/api/pages", strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, expectedError+"\n", res.Body.String())
+	}
+	assert.Equal(t, []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}, user.Pages)
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestUpdateDeletePageAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}, {URL: "http://site2/page", Title: "Page 2"}}
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("PUT", "/api/pages/pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U--", strings.NewReader("URL=http://site1/page&Title=Page+1&Match=m1&Replace=r1"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.Equal(t, []data.UserPagemonitor{
+				{URL: "http://site1/page", Title: "Page 1", Match: "m1", Replace: "r1"},
+				{URL: "http://site2/page", Title: "Page 2"},
+			}, saveUser.Pages)
+		})
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site1/page","Title":"Page 1","Match":"m1","Replace":"r1","Key":"pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U-bTE-cjE"},`+
+		`{"URL":"http://site2/page","Title":"Page 2","Match":"","Replace":"","Key":"pagemonitor-aHR0cDovL3NpdGUyL3BhZ2U--"}]`+"\n", res.Body.String())
+
+	req, _ = http.NewRequest("PUT", "/api/pages/pagemonitor-aHR0cDovL3NpdGUyL3BhZ2U--", strings.NewReader("URL=http://site1/page&Match=m1&Replace=r1"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid page: page http://site1/page already exists\n", res.Body.String())
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.Equal(t, []data.UserPagemonitor{{URL: "http://site2/page", Title: "Page 2"}}, saveUser.Pages)
+		})
+
+	req, _ = http.NewRequest("DELETE", "/api/pages/pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U-bTE-cjE", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site2/page","Title":"Page 2","Match":"","Replace":"","Key":"pagemonitor-aHR0cDovL3NpdGUyL3BhZ2U--"}]`+"\n", res.Body.String())
+
+	for _, method := range []string{"PUT", "DELETE"} {
+		req, _ = http.NewRequest(method, "/api/pages/pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U-bTE-cjE", strings.NewReader("URL=http://site1/page"))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res = httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Equal(t, "Not found\n", res.Body.String())
+	}
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestExportPagemonitorXMLAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Pages = defaultPages
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/pages/xml", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/xml; charset=utf-8", res.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="pagemonitor.xml"`, res.Header().Get("Content-Disposition"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<pages>
+  <page url="http://site1/1" match="m1" replace="r1">Site 1</page>
+  <page url="http://site1/2">Site 2</page>
+</pages>`, res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func createPagemonitorXMLRequest(t *testing.T, pagemonitor string, values map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range values {
+		err := writer.WriteField(key, value)
+		assert.NoError(t, err)
+	}
+	part, err := writer.CreateFormFile("Pagemonitor", "pagemonitor.xml")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte(pagemonitor))
+	assert.NoError(t, err)
+	err = writer.Close()
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/api/pages/xml", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestImportPagemonitorXMLAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+
+	authHandler.AllowUser(user)
+
+	req := createPagemonitorXMLRequest(t, `<pages><page url="http://site2/page">Page 2</page></pages>`, nil)
+	res := httptest.NewRecorder()
+
+	dbMock.On("SaveUser", mock.AnythingOfType("*data.User")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			saveUser := args.Get(0).(*data.User)
+			assert.Equal(t, []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}, {URL: "http://site2/page", Title: "Page 2"}}, saveUser.Pages)
+		})
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `[{"URL":"http://site1/page","Title":"Page 1","Match":"","Replace":"","Key":"pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U--"},`+
+		`{"URL":"http://site2/page","Title":"Page 2","Match":"","Replace":"","Key":"pagemonitor-aHR0cDovL3NpdGUyL3BhZ2U--"}]`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestImportPagemonitorXMLErrorsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Pages = []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}
+
+	authHandler.AllowUser(user)
+
+	req := createPagemonitorXMLRequest(t, `<pages><page url="http://site2/page" match="(">Page 2</page></pages>`, map[string]string{"Replace": "true"})
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid Pagemonitor XML: invalid page Page 2: cannot compile match regex: error parsing regexp: missing closing ): `(`\n", res.Body.String())
+
+	req = createPagemonitorXMLRequest(t, `<pages></pages>`, map[string]string{"Replace": "maybe"})
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid replace value\n", res.Body.String())
+
+	req, _ = http.NewRequest("POST", "/api/pages/xml", strings.NewReader("Replace=true"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid Pagemonitor XML upload\n", res.Body.String())
+
+	assert.Equal(t, []data.UserPagemonitor{{URL: "http://site1/page", Title: "Page 1"}}, user.Pages)
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestPagePreviewAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	fetcherMock := new(FetcherMock)
+	fetcherMock.On("PreviewPage", &data.UserPagemonitor{URL: "http://site1/page", Match: "([0-9]+)", Replace: "$1"}).Return(&fetcher.PagePreview{
+		Contents: "42",
+		Delta:    "@@ -1 +1 @@\n-41\n+42\n",
+	}, nil).Once()
+	fetcherMock.On("PreviewPage", &data.UserPagemonitor{URL: "http://site2/page"}).Return(nil, fmt.Errorf("cannot GET page")).Once()
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+
+	authHandler.AllowUser(user)
+
+	for form, expected := range map[string]string{
+		"URL=http://site1/page&Match=%28%5B0-9%5D%2B%29&Replace=%241": `{"Contents":"42","Delta":"@@ -1 +1 @@\n-41\n+42\n"}`,
+		"URL=http://site2/page": `{"Error":"cannot GET page","Contents":"","Delta":""}`,
+	} {
+		req, _ := http.NewRequest("POST", "/api/pages/preview", strings.NewReader(form))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, expected+"\n", res.Body.String())
+	}
+
+	req, _ := http.NewRequest("POST", "/api/pages/preview", strings.NewReader("URL=http://site1/page&Match=%28"))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid page: cannot compile match regex: error parsing regexp: missing closing ): `(`\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	fetcherMock.AssertExpectations(t)
+}
+
+func TestPagesUnauthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	for _, request := range []struct{ method, url string }{
+		{"GET", "/api/pages"},
+		{"POST", "/api/pages"},
+		{"PUT", "/api/pages/pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U--"},
+		{"DELETE", "/api/pages/pagemonitor-aHR0cDovL3NpdGUxL3BhZ2U--"},
+		{"GET", "/api/pages/xml"},
+		{"POST", "/api/pages/xml"},
+		{"POST", "/api/pages/preview"},
+	} {
+		req, _ := http.NewRequest(request.method, request.url, nil)
+		res := httptest.NewRecorder()
+
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, "Bad credentials\n", res.Body.String())
+	}
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestRefreshAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	fetcherMock := new(FetcherMock)
+	fetcherMock.On("Refresh").Once()
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/refresh", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "OK", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	fetcherMock.AssertExpectations(t)
+}
+
+func TestRefreshNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	fetcherMock := new(FetcherMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/api/refresh", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	fetcherMock.AssertExpectations(t)
+}
+
+func TestPreviewAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	fetcherMock := new(FetcherMock)
+	fetcherMock.On("PreviewFeed", "http://site1/rss").Return(&fetcher.ParsedFeed{
+		Format: "rss",
+		Title:  "Site 1",
+		URL:    "http://site1",
+		Items: []*data.Feeditem{
+			{
+				Title:    "Title 1",
+				URL:      "http://site1/link1",
+				Date:     time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+				Contents: "Text 1",
+				Key:      &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "Item@1"},
+			},
+			{
+				Title: "Title 2",
+				Key:   &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "Item@2"},
+			},
+		},
+		FailedDates: []string{"yesterday"},
+		Warnings:    []string{`item "Title 2" has no link`},
+	}, nil).Once()
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/preview?url=http%3A%2F%2Fsite1%2Frss", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Format":"rss","Title":"Site 1","URL":"http://site1","Description":"",`+
+		`"Items":[{"Title":"Title 1","URL":"http://site1/link1","GUID":"Item@1","Date":"2019-02-16T23:00:00Z","Contents":"Text 1"},`+
+		`{"Title":"Title 2","URL":"","GUID":"Item@2","Contents":""}],`+
+		`"FailedDates":["yesterday"],"Warnings":["item \"Title 2\" has no link"]}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	fetcherMock.AssertExpectations(t)
+}
+
+func TestPreviewError(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	fetcherMock := new(FetcherMock)
+	fetcherMock.On("PreviewFeed", "http://site1/rss").Return(nil, fmt.Errorf("cannot GET feed")).Once()
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/preview?url=http%3A%2F%2Fsite1%2Frss", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Error":"cannot GET feed","Format":"","Title":"","URL":"","Description":"","Items":[],"FailedDates":[],"Warnings":[]}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	fetcherMock.AssertExpectations(t)
+}
+
+func TestPreviewMissingURL(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	fetcherMock := new(FetcherMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/preview", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Missing url parameter\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	fetcherMock.AssertExpectations(t)
+}
+
+func TestPreviewNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	fetcherMock := new(FetcherMock)
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler, fetcher: fetcherMock}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/api/preview?url=http%3A%2F%2Fsite1%2Frss", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+	fetcherMock.AssertExpectations(t)
+}
+
+func TestGetStatusAuthorizedSuccess(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = defaultSubscriptions
+	user.Pages = defaultPages
+
+	authHandler.AllowUser(user)
+
+	date1 := time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)
+	date2 := time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC)
+	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site1/rss"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date2, LastFailure: date1}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site2/rss"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date1}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date2, LastFailure: date1}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/2"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date2}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/status", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "["+`{"Name":"Feed 1","Success":true,"LastFailure":"2019-02-16T23:00:00Z","LastSuccess":"2019-02-16T23:01:00Z"},`+
+		`{"Name":"Feed 2","Success":true,"LastSuccess":"2019-02-16T23:00:00Z"},`+
+		`{"Name":"Site 1","Success":true,"LastFailure":"2019-02-16T23:00:00Z","LastSuccess":"2019-02-16T23:01:00Z"},`+
+		`{"Name":"Site 2","Success":true,"LastSuccess":"2019-02-16T23:01:00Z"}`+
+		"]\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetStatusAuthorizedFailure(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = defaultSubscriptions
+	user.Pages = defaultPages
+
+	authHandler.AllowUser(user)
+
+	date1 := time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)
+	date2 := time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC)
+	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site1/rss"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date1, LastFailure: date2}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site2/rss"}).CreateKey()).Return(&data.FetchStatus{LastFailure: date1}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}).CreateKey()).Return(&data.FetchStatus{LastSuccess: date1, LastFailure: date2}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/2"}).CreateKey()).Return(&data.FetchStatus{LastFailure: date2}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/status", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "["+`{"Name":"Feed 1","Success":false,"LastFailure":"2019-02-16T23:01:00Z","LastSuccess":"2019-02-16T23:00:00Z"},`+
+		`{"Name":"Feed 2","Success":false,"LastFailure":"2019-02-16T23:00:00Z"},`+
+		`{"Name":"Site 1","Success":false,"LastFailure":"2019-02-16T23:01:00Z","LastSuccess":"2019-02-16T23:00:00Z"},`+
+		`{"Name":"Site 2","Success":false,"LastFailure":"2019-02-16T23:01:00Z"}`+
+		"]\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetStatusAuthorizedUnknown(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = defaultSubscriptions
+	user.Pages = defaultPages
+
+	authHandler.AllowUser(user)
+
+	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site1/rss"}).CreateKey()).Return(&data.FetchStatus{}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserFeed{URL: "http://site2/rss"}).CreateKey()).Return(&data.FetchStatus{}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/1", Match: "m1", Replace: "r1"}).CreateKey()).Return(&data.FetchStatus{}, nil).Once()
+	dbMock.On("GetFetchStatus", (&data.UserPagemonitor{URL: "http://site1/2"}).CreateKey()).Return(&data.FetchStatus{}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/status", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "["+`{"Name":"Feed 1","Success":false},`+
+		`{"Name":"Feed 2","Success":false},`+
+		`{"Name":"Site 1","Success":false},`+
+		`{"Name":"Site 2","Success":false}`+
+		"]\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetStatusNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/api/status", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetFeedStatsAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Subscriptions = defaultSubscriptions
+
+	authHandler.AllowUser(user)
+
+	feedStats := []*data.FeedStats{
+		{
+			URL:              "http://site1/rss",
+			Title:            "Feed 1",
+			Items:            3,
+			ItemsPerWeek:     1.5,
+			AverageSize:      120,
+			LastItem:         time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			Unread:           2,
+			Fetches:          4,
+			FetchSuccessRate: 0.75,
+		},
+		{URL: "http://site2/rss", Title: "Feed 2"},
+	}
+	dbMock.On("GetFeedStats", user).Return(feedStats, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/status/feeds", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "["+`{"URL":"http://site1/rss","Title":"Feed 1","Items":3,"ItemsPerWeek":1.5,"AverageSize":120,"LastItem":"2019-02-16T23:00:00Z","Unread":2,"Fetches":4,"FetchSuccessRate":0.75},`+
+		`{"URL":"http://site2/rss","Title":"Feed 2","Items":0,"ItemsPerWeek":0,"AverageSize":0,"LastItem":"0001-01-01T00:00:00Z","Unread":0,"Fetches":0,"FetchSuccessRate":0}`+
+		"]\n", res.Body.String())
+
+	dbMock.On("GetFeedStats", user).Return([]*data.FeedStats{}, fmt.Errorf("error")).Once()
+
+	req, _ = http.NewRequest("GET", "/api/status/feeds", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestGetFeedStatsNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/api/status/feeds", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestHealth(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	dbMock.On("GetLastBackupTime").Return(time.Time{}, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Status":"ok"}`+"\n", res.Body.String())
+
+	lastBackup := time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)
+	dbMock.On("GetLastBackupTime").Return(lastBackup, nil).Once()
+
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Status":"ok","LastBackup":"2019-02-16T23:00:00Z"}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestHealthError(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	dbMock.On("GetLastBackupTime").Return(time.Time{}, fmt.Errorf("error")).Once()
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func createRestoreRequest(t *testing.T, backup string, values map[string]string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, value := range values {
+		err := writer.WriteField(key, value)
+		assert.NoError(t, err)
+	}
+	part, err := writer.CreateFormFile("Backup", "nanorss.json")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte(backup))
+	assert.NoError(t, err)
+	err = writer.Close()
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/api/admin/restore", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestBackupAdmin(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Admin = true
+	authHandler.AllowUser(user)
+
+	dbMock.On("Backup", data.BackupOptions{}).Return(`{"Version":1}`, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/admin/backup", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "application/octet-stream", res.Header().Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="nanorss-\d{8}-\d{6}\.json"$`, res.Header().Get("Content-Disposition"))
+	assert.Equal(t, `{"Version":1}`, res.Body.String())
+
+	dbMock.On("Backup", data.BackupOptions{Compression: data.CompressionZstd, Passphrase: "pass"}).Return("encrypted", nil).Once()
+
+	req, _ = http.NewRequest("POST", "/api/admin/backup", strings.NewReader("Compression=zstd&Passphrase=pass"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Regexp(t, `^attachment; filename="nanorss-\d{8}-\d{6}\.json\.zst"$`, res.Header().Get("Content-Disposition"))
+	assert.Equal(t, "encrypted", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestBackupAdminErrors(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Admin = true
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/admin/backup?Compression=bzip2", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Unsupported compression\n", res.Body.String())
+
+	dbMock.On("Backup", data.BackupOptions{}).Return("", fmt.Errorf("error")).Once()
+
+	req, _ = http.NewRequest("GET", "/api/admin/backup", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestAllReadingStatsAdmin(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Admin = true
+	user.Subscriptions = []data.UserFeed{{URL: "http://site1/rss", Title: "Feed 1"}}
+	authHandler.AllowUser(user)
+
+	user2 := data.NewUser("user02")
+	user2.Subscriptions = []data.UserFeed{{URL: "http://site2/rss", Title: "Feed 2"}}
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	stats := []*data.ReadingStats{
+		{Date: time.Date(2019, time.February, 17, 0, 0, 0, 0, time.UTC), FeedURL: "http://site1/rss", Read: 4},
+	}
+	dbMock.On("GetAllReadingStats", today.AddDate(0, 0, -89)).Return(stats, nil).Once()
+	dbMock.On("GetUsers").Return([]string{"user01", "user02", "user03"}, nil).Once()
+	dbMock.On("GetUser", "user01").Return(user, nil).Once()
+	dbMock.On("GetUser", "user02").Return(user2, nil).Once()
+	dbMock.On("GetUser", "user03").Return(nil, nil).Once()
+
+	req, _ := http.NewRequest("GET", "/api/admin/readingstats?days=90", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Days":[{"Date":"2019-02-17T00:00:00Z","FeedURL":"http://site1/rss","Read":4,"MarkedRead":0}],"Feeds":[`+
+		`{"URL":"http://site2/rss","Title":"Feed 2","Read":0,"MarkedRead":0},`+
+		`{"URL":"http://site1/rss","Title":"Feed 1","Read":4,"MarkedRead":0}]}`+"\n", res.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/admin/readingstats?days=none", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid number of days\n", res.Body.String())
+
+	dbMock.On("GetAllReadingStats", today.AddDate(0, 0, -29)).Return([]*data.ReadingStats{}, fmt.Errorf("error")).Once()
+
+	req, _ = http.NewRequest("GET", "/api/admin/readingstats", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestAdminNotAdmin(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	authHandler.AllowUser(user)
+
+	req, _ := http.NewRequest("GET", "/api/admin/backup", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.Equal(t, "Forbidden\n", res.Body.String())
+
+	req = createRestoreRequest(t, "{}", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.Equal(t, "Forbidden\n", res.Body.String())
+
+	req, _ = http.NewRequest("GET", "/api/admin/readingstats", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.Equal(t, "Forbidden\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestAdminNotAuthorized(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/api/admin/backup", nil)
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	req = createRestoreRequest(t, "{}", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, "Bad credentials\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestRestoreAdmin(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Admin = true
+	authHandler.AllowUser(user)
+
+	report := &data.RestoreReport{
+		Version:   1,
+		Mode:      data.RestoreReplace,
+		DryRun:    true,
+		Restored:  data.RestoreCounts{Users: 1},
+		Conflicts: []data.RestoreIssue{},
+		Skipped:   []data.RestoreIssue{{Type: "User", Key: "", Reason: "username is empty"}},
+	}
+	dbMock.On("Restore", `{"Version":1}`, data.RestoreOptions{Mode: data.RestoreReplace, DryRun: true, Passphrase: "pass"}).Return(report, nil).Once()
+
+	req := createRestoreRequest(t, `{"Version":1}`, map[string]string{"Mode": "replace", "DryRun": "true", "Passphrase": "pass"})
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"Version":1,"Mode":"replace","DryRun":true,`+
+		`"Restored":{"Users":1,"ReadItems":0,"StarredItems":0,"Tags":0,"TaggedItems":0,"Notes":0,"ReadEvents":0,"Feeds":0,"Pagemonitor":0,"ServerConfig":0},`+
+		`"Conflicts":[],"Skipped":[{"Type":"User","Key":"","Reason":"username is empty"}]}`+"\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
+
+func TestRestoreAdminErrors(t *testing.T) {
+	dbMock := new(DBMock)
+	authHandler := AuthHandlerMock{}
+
+	services := &Services{db: dbMock, cookieHandler: &authHandler}
+	router, err := CreateRouter(services)
+	assert.NoError(t, err)
+
+	user := data.NewUser("user01")
+	user.Admin = true
+	authHandler.AllowUser(user)
+
+	req := createRestoreRequest(t, "{}", map[string]string{"Mode": "append"})
+	res := httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Unsupported restore mode\n", res.Body.String())
+
+	req = createRestoreRequest(t, "{}", map[string]string{"DryRun": "maybe"})
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid dry run value\n", res.Body.String())
+
+	req, _ = http.NewRequest("POST", "/api/admin/restore", strings.NewReader("Mode=merge"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid backup upload\n", res.Body.String())
+
+	dbMock.On("Restore", "{", data.RestoreOptions{Mode: data.RestoreMerge}).Return(nil, &data.InvalidBackupError{Err: fmt.Errorf("failed to unmarshal json: unexpected EOF")}).Once()
+	req = createRestoreRequest(t, "{", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "Invalid backup: failed to unmarshal json: unexpected EOF\n", res.Body.String())
+
+	dbMock.On("Restore", "{}", data.RestoreOptions{Mode: data.RestoreMerge}).Return(nil, fmt.Errorf("error")).Once()
+	req = createRestoreRequest(t, "{}", nil)
+	res = httptest.NewRecorder()
+
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "Internal server error\n", res.Body.String())
+
+	dbMock.AssertExpectations(t)
+	authHandler.AssertExpectations(t)
+}
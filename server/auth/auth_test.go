@@ -169,7 +169,7 @@ func TestAuthHandlerFunc(t *testing.T) {
 		"valid cookie and user exists": {
 			Cookie:        validCookie,
 			ExpectGetUser: "user01",
-			ReturnUser:    &data.User{Opml: "opml", Password: "pass"},
+			ReturnUser:    &data.User{Pages: []data.UserPagemonitor{{URL: "http://site/page"}}, Password: "pass"},
 		},
 		"valid cookie but user doesn't exist": {
 			Cookie:        validCookie,
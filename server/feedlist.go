@@ -10,11 +10,15 @@ import (
 
 // Item is a generic item (RSS feed item or pagemonitor page).
 type Item struct {
-	Title    string
-	Origin   string
-	SortDate time.Time `json:"-"`
-	FetchURL string
-	IsRead   bool
+	Title     string
+	Origin    string
+	Folder    string    `json:",omitempty"`
+	SortDate  time.Time `json:"-"`
+	FetchURL  string
+	IsRead    bool
+	IsStarred bool
+	Tags      []string `json:",omitempty"`
+	Score     float64  `json:",omitempty"`
 }
 
 // FeedListService is a service which gets feed items for a user.
@@ -40,20 +44,29 @@ func (a itemsSortable) Less(i, j int) bool {
 	return a[i].SortDate.After(a[j].SortDate)
 }
 
+// Folder is a folder containing the user's feeds.
+type Folder struct {
+	Name   string
+	Unread int
+}
+
 // GetAllItems returns all Items for user.
 func (h *FeedListService) GetAllItems(user *data.User) ([]*Item, error) {
-	feedTitles, err := getFeedTitles(user)
+	feedTitles := getFeedTitles(user)
+	feedFolders := getFeedFolders(user)
+	pageTitles := getPageTitles(user)
+
+	items := make(itemsSortable, 0)
+
+	readStatuses, err := h.getReadStatuses(user)
 	if err != nil {
 		return nil, err
 	}
-	pageTitles, err := getPageTitles(user)
+	starredStatuses, err := h.getStarredStatuses(user)
 	if err != nil {
 		return nil, err
 	}
-
-	items := make(itemsSortable, 0)
-
-	readStatuses, err := h.getReadStatuses(user)
+	itemTags, err := h.getItemTags(user)
 	if err != nil {
 		return nil, err
 	}
@@ -70,11 +83,14 @@ func (h *FeedListService) GetAllItems(user *data.User) ([]*Item, error) {
 		}
 		isRead := readStatuses[string(feedItem.Key.CreateKey())]
 		item := &Item{
-			Title:    feedItem.Title,
-			Origin:   title,
-			FetchURL: "api/items/" + escapeKeyForURL(feedItem.Key.CreateKey()),
-			SortDate: feedItem.Date,
-			IsRead:   isRead,
+			Title:     feedItem.Title,
+			Origin:    title,
+			Folder:    feedFolders[feedItem.Key.FeedURL],
+			FetchURL:  "api/items/" + escapeKeyForURL(feedItem.Key.CreateKey()),
+			SortDate:  feedItem.Date,
+			IsRead:    isRead,
+			IsStarred: starredStatuses[string(feedItem.Key.CreateKey())],
+			Tags:      itemTags[string(feedItem.Key.CreateKey())],
 		}
 		items = append(items, item)
 	}
@@ -88,11 +104,13 @@ func (h *FeedListService) GetAllItems(user *data.User) ([]*Item, error) {
 		}
 		isRead := readStatuses[string(page.Config.CreateKey())]
 		item := &Item{
-			Title:    "",
-			Origin:   title,
-			FetchURL: "api/items/" + escapeKeyForURL(page.Config.CreateKey()),
-			SortDate: page.Updated,
-			IsRead:   isRead,
+			Title:     "",
+			Origin:    title,
+			FetchURL:  "api/items/" + escapeKeyForURL(page.Config.CreateKey()),
+			SortDate:  page.Updated,
+			IsRead:    isRead,
+			IsStarred: starredStatuses[string(page.Config.CreateKey())],
+			Tags:      itemTags[string(page.Config.CreateKey())],
 		}
 		items = append(items, item)
 	}
@@ -106,33 +124,75 @@ func (h *FeedListService) GetAllItems(user *data.User) ([]*Item, error) {
 }
 
 // getFeedTitles returns a map of user's feed titles.
-func getFeedTitles(user *data.User) (map[string]string, error) {
-	feeds, err := user.GetFeeds()
-	if err != nil {
-		return nil, err
-	}
+func getFeedTitles(user *data.User) map[string]string {
+	feeds := user.GetFeeds()
 
 	feedTitles := make(map[string]string, len(feeds))
 	for i := range feeds {
-		url := feeds[i].URL
+		url := feeds[i].ItemsURL()
 		feedTitles[url] = feeds[i].Title
 	}
-	return feedTitles, nil
+	return feedTitles
+}
+
+// getFeedFolders returns a map of user's feed folders.
+func getFeedFolders(user *data.User) map[string]string {
+	feeds := user.GetFeeds()
+
+	feedFolders := make(map[string]string, len(feeds))
+	for i := range feeds {
+		feedFolders[feeds[i].ItemsURL()] = feeds[i].Folder
+	}
+	return feedFolders
 }
 
-// getFeedTitles returns a map of user's page titles.
-func getPageTitles(user *data.User) (map[string]string, error) {
-	userPages, err := user.GetPages()
+// GetFolderItems returns all feed Items from folder and its nested folders.
+func (h *FeedListService) GetFolderItems(user *data.User, folder string) ([]*Item, error) {
+	items, err := h.GetAllItems(user)
 	if err != nil {
 		return nil, err
 	}
 
+	folderItems := make([]*Item, 0, len(items))
+	for _, item := range items {
+		if data.FolderContains(folder, item.Folder) {
+			folderItems = append(folderItems, item)
+		}
+	}
+	return folderItems, nil
+}
+
+// GetFolders returns all of the user's folders, with the number of unread items in each folder and its nested folders.
+func (h *FeedListService) GetFolders(user *data.User) ([]*Folder, error) {
+	items, err := h.GetAllItems(user)
+	if err != nil {
+		return nil, err
+	}
+
+	folderNames := user.GetFolders()
+	folders := make([]*Folder, 0, len(folderNames))
+	for _, folderName := range folderNames {
+		folder := &Folder{Name: folderName}
+		for _, item := range items {
+			if !item.IsRead && data.FolderContains(folderName, item.Folder) {
+				folder.Unread++
+			}
+		}
+		folders = append(folders, folder)
+	}
+	return folders, nil
+}
+
+// getPageTitles returns a map of user's page titles.
+func getPageTitles(user *data.User) map[string]string {
+	userPages := user.GetPages()
+
 	pageTitles := make(map[string]string, len(userPages))
 	for i := range userPages {
 		url := string(userPages[i].CreateKey())
 		pageTitles[url] = userPages[i].Title
 	}
-	return pageTitles, nil
+	return pageTitles
 }
 
 // getFeedTitles returns a map with item read statuses.
@@ -150,3 +210,217 @@ func (h *FeedListService) getReadStatuses(user *data.User) (map[string]bool, err
 
 	return readStatuses, nil
 }
+
+// getStarredStatuses returns a map with item starred statuses.
+func (h *FeedListService) getStarredStatuses(user *data.User) (map[string]bool, error) {
+	userStarredItems, err := h.db.GetStarredItems(user)
+	if err != nil {
+		return nil, err
+	}
+
+	starredStatuses := make(map[string]bool, len(userStarredItems))
+	for i := range userStarredItems {
+		starredStatuses[string(userStarredItems[i])] = true
+	}
+
+	return starredStatuses, nil
+}
+
+// GetStarredItems returns all starred Items for user, including items from feeds the user is no longer subscribed to.
+func (h *FeedListService) GetStarredItems(user *data.User) ([]*Item, error) {
+	starredItems, err := h.db.GetStarredItems(user)
+	if err != nil {
+		return nil, err
+	}
+	return h.getItems(user, starredItems)
+}
+
+// GetTaggedItems returns all Items which have tag assigned, including items from feeds the user is no longer subscribed to.
+func (h *FeedListService) GetTaggedItems(user *data.User, tag string) ([]*Item, error) {
+	taggedItems, err := h.db.GetTaggedItems(user, tag)
+	if err != nil {
+		return nil, err
+	}
+	return h.getItems(user, taggedItems)
+}
+
+// getItems returns Items for keys; keys for items which no longer exist are skipped.
+func (h *FeedListService) getItems(user *data.User, keys [][]byte) ([]*Item, error) {
+	feedTitles := getFeedTitles(user)
+	feedFolders := getFeedFolders(user)
+	pageTitles := getPageTitles(user)
+
+	readStatuses, err := h.getReadStatuses(user)
+	if err != nil {
+		return nil, err
+	}
+	starredStatuses, err := h.getStarredStatuses(user)
+	if err != nil {
+		return nil, err
+	}
+	itemTags, err := h.getItemTags(user)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(itemsSortable, 0, len(keys))
+	for _, key := range keys {
+		item := &Item{
+			FetchURL:  "api/items/" + escapeKeyForURL(key),
+			IsRead:    readStatuses[string(key)],
+			IsStarred: starredStatuses[string(key)],
+			Tags:      itemTags[string(key)],
+		}
+		if data.IsFeeditemKey(key) {
+			feeditemKey, err := data.DecodeFeeditemKey(key)
+			if err != nil {
+				return nil, err
+			}
+			feedItem, err := h.db.GetFeeditem(feeditemKey)
+			if err != nil {
+				return nil, err
+			}
+			if feedItem == nil {
+				continue
+			}
+			title, ok := feedTitles[feeditemKey.FeedURL]
+			if !ok {
+				// User is no longer subscribed to this feed.
+				title = feeditemKey.FeedURL
+			}
+			item.Title = feedItem.Title
+			item.Origin = title
+			item.Folder = feedFolders[feeditemKey.FeedURL]
+			item.SortDate = feedItem.Date
+		} else if data.IsPagemonitorKey(key) {
+			pagemonitorKey, err := data.DecodePagemonitorKey(key)
+			if err != nil {
+				return nil, err
+			}
+			page, err := h.db.GetPage(pagemonitorKey)
+			if err != nil {
+				return nil, err
+			}
+			if page == nil {
+				continue
+			}
+			title, ok := pageTitles[string(key)]
+			if !ok {
+				// User is no longer monitoring this page.
+				title = pagemonitorKey.URL
+			}
+			item.Origin = title
+			item.SortDate = page.Updated
+		} else {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	sort.Sort(items)
+
+	return items, nil
+}
+
+// GetRecentlyRead returns up to limit Items most recently read by user, newest first.
+// Items which no longer exist are skipped.
+func (h *FeedListService) GetRecentlyRead(user *data.User, limit int) ([]*HistoryItem, error) {
+	events, err := h.db.GetRecentlyRead(user, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([][]byte, len(events))
+	for i := range events {
+		keys[i] = events[i].Key
+	}
+	items, err := h.getItems(user, keys)
+	if err != nil {
+		return nil, err
+	}
+	fetchURLItems := make(map[string]*Item, len(items))
+	for _, item := range items {
+		fetchURLItems[item.FetchURL] = item
+	}
+
+	historyItems := make([]*HistoryItem, 0, len(events))
+	for _, event := range events {
+		item, ok := fetchURLItems["api/items/"+escapeKeyForURL(event.Key)]
+		if !ok {
+			continue
+		}
+		historyItems = append(historyItems, &HistoryItem{Item: item, ReadTime: event.Time})
+	}
+	return historyItems, nil
+}
+
+// getItemTags returns a map with tags assigned to each item.
+func (h *FeedListService) getItemTags(user *data.User) (map[string][]string, error) {
+	tags, err := h.db.GetTags(user)
+	if err != nil {
+		return nil, err
+	}
+
+	itemTags := make(map[string][]string)
+	for _, tag := range tags {
+		taggedItems, err := h.db.GetTaggedItems(user, tag)
+		if err != nil {
+			return nil, err
+		}
+		for i := range taggedItems {
+			itemKey := string(taggedItems[i])
+			itemTags[itemKey] = append(itemTags[itemKey], tag)
+		}
+	}
+
+	return itemTags, nil
+}
+
+// Search returns Items matching query, ordered by relevance.
+func (h *FeedListService) Search(user *data.User, query data.SearchQuery) ([]*Item, error) {
+	feedTitles := getFeedTitles(user)
+	feedFolders := getFeedFolders(user)
+	pageTitles := getPageTitles(user)
+
+	starredStatuses, err := h.getStarredStatuses(user)
+	if err != nil {
+		return nil, err
+	}
+	itemTags, err := h.getItemTags(user)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := h.db.Search(user, query)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*Item, 0, len(results))
+	for _, result := range results {
+		var title, folder string
+		if data.IsFeeditemKey(result.Key) {
+			feeditemKey, err := data.DecodeFeeditemKey(result.Key)
+			if err != nil {
+				return nil, err
+			}
+			title = feedTitles[feeditemKey.FeedURL]
+			folder = feedFolders[feeditemKey.FeedURL]
+		} else {
+			title = pageTitles[string(result.Key)]
+		}
+		items = append(items, &Item{
+			Title:     result.Title,
+			Origin:    title,
+			Folder:    folder,
+			FetchURL:  "api/items/" + escapeKeyForURL(result.Key),
+			SortDate:  result.Date,
+			IsRead:    result.Read,
+			IsStarred: starredStatuses[string(result.Key)],
+			Tags:      itemTags[string(result.Key)],
+			Score:     result.Score,
+		})
+	}
+
+	return items, nil
+}
@@ -9,29 +9,29 @@ import (
 	"github.com/zlogic/nanorss-go/data"
 )
 
-const defaultOpml = `<opml version="1.0">` +
-	`<body>` +
-	`<outline title="Feed 1" type="rss" xmlUrl="http://site1/rss"/>` +
-	`<outline title="Feed 2" type="rss" xmlUrl="http://site2/rss"/>` +
-	`</body>` +
-	`</opml>`
-
-const defaultPagemonitor = `<pages>` +
-	`<page url="http://site1/1" match="m1" replace="r1">Site 1</page>` +
-	`<page url="http://site1/2">Site 2</page>` +
-	`</pages>`
+var defaultSubscriptions = []data.UserFeed{
+	{URL: "http://site1/rss", Title: "Feed 1", Type: "rss"},
+	{URL: "http://site2/rss", Title: "Feed 2", Type: "rss"},
+}
+
+var defaultPages = []data.UserPagemonitor{
+	{URL: "http://site1/1", Title: "Site 1", Match: "m1", Replace: "r1"},
+	{URL: "http://site1/2", Title: "Site 2"},
+}
 
 func TestFeedListHelperEmptyList(t *testing.T) {
 	dbMock := new(DBMock)
 	feedListService := FeedListService{db: dbMock}
 	user := &data.User{
-		Opml:        defaultOpml,
-		Pagemonitor: defaultPagemonitor,
+		Subscriptions: defaultSubscriptions,
+		Pages:         defaultPages,
 	}
 
 	dbMock.On("GetFeeditems", user).Return([]*data.Feeditem{}, nil).Once()
 	dbMock.On("GetPages", user).Return([]*data.PagemonitorPage{}, nil).Once()
 	dbMock.On("GetReadItems", user).Return(nil, nil).Once()
+	dbMock.On("GetStarredItems", user).Return(nil, nil).Once()
+	dbMock.On("GetTags", user).Return(nil, nil).Once()
 
 	items, err := feedListService.GetAllItems(user)
 	assert.NoError(t, err)
@@ -44,8 +44,8 @@ func TestFeedListHelperOrdering(t *testing.T) {
 	dbMock := new(DBMock)
 	feedListService := FeedListService{db: dbMock}
 	user := &data.User{
-		Opml:        defaultOpml,
-		Pagemonitor: defaultPagemonitor,
+		Subscriptions: defaultSubscriptions,
+		Pages:         defaultPages,
 	}
 
 	expectedItems := []*Item{
@@ -54,13 +54,15 @@ func TestFeedListHelperOrdering(t *testing.T) {
 			SortDate: time.Date(2019, time.February, 18, 23, 3, 0, 0, time.UTC),
 			FetchURL: "api/items/pagemonitor-aHR0cDovL3NpdGUxLzI--",
 			IsRead:   false,
+			Tags:     []string{"digest"},
 		},
 		{
-			Title:    "t2",
-			Origin:   "Feed 1",
-			SortDate: time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
-			FetchURL: "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzI",
-			IsRead:   false,
+			Title:     "t2",
+			Origin:    "Feed 1",
+			SortDate:  time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+			FetchURL:  "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzI",
+			IsRead:    false,
+			IsStarred: true,
 		},
 		{
 			Title:    "t1",
@@ -68,6 +70,7 @@ func TestFeedListHelperOrdering(t *testing.T) {
 			SortDate: time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
 			FetchURL: "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE",
 			IsRead:   false,
+			Tags:     []string{"archive", "digest"},
 		},
 		{
 			Title:    "t21",
@@ -124,6 +127,10 @@ func TestFeedListHelperOrdering(t *testing.T) {
 	dbMock.On("GetFeeditems", user).Return(feedItems, nil).Once()
 	dbMock.On("GetPages", user).Return(pages, nil).Once()
 	dbMock.On("GetReadItems", user).Return(readItems, nil).Once()
+	dbMock.On("GetStarredItems", user).Return([][]byte{feedItems[2].Key.CreateKey()}, nil).Once()
+	dbMock.On("GetTags", user).Return([]string{"archive", "digest"}, nil).Once()
+	dbMock.On("GetTaggedItems", user, "archive").Return([][]byte{feedItems[0].Key.CreateKey()}, nil).Once()
+	dbMock.On("GetTaggedItems", user, "digest").Return([][]byte{feedItems[0].Key.CreateKey(), pages[1].Config.CreateKey()}, nil).Once()
 
 	items, err := feedListService.GetAllItems(user)
 	assert.NoError(t, err)
@@ -136,8 +143,8 @@ func TestFeedListHelperIgnoreUnknownItems(t *testing.T) {
 	dbMock := new(DBMock)
 	feedListService := FeedListService{db: dbMock}
 	user := &data.User{
-		Opml:        defaultOpml,
-		Pagemonitor: defaultPagemonitor,
+		Subscriptions: defaultSubscriptions,
+		Pages:         defaultPages,
 	}
 
 	feedItems := []*data.Feeditem{
@@ -163,6 +170,8 @@ func TestFeedListHelperIgnoreUnknownItems(t *testing.T) {
 	dbMock.On("GetFeeditems", user).Return(feedItems, nil).Once()
 	dbMock.On("GetPages", user).Return(pages, nil).Once()
 	dbMock.On("GetReadItems", user).Return(nil, nil).Once()
+	dbMock.On("GetStarredItems", user).Return(nil, nil).Once()
+	dbMock.On("GetTags", user).Return(nil, nil).Once()
 
 	items, err := feedListService.GetAllItems(user)
 	assert.NoError(t, err)
@@ -170,3 +179,321 @@ func TestFeedListHelperIgnoreUnknownItems(t *testing.T) {
 
 	dbMock.AssertExpectations(t)
 }
+
+func TestFeedListHelperStarredItems(t *testing.T) {
+	dbMock := new(DBMock)
+	feedListService := FeedListService{db: dbMock}
+	user := &data.User{
+		Subscriptions: defaultSubscriptions,
+		Pages:         defaultPages,
+	}
+
+	subscribedItem := &data.Feeditem{
+		Title: "t1",
+		Key:   &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"},
+		Date:  time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+	}
+	unsubscribedItem := &data.Feeditem{
+		Title: "t3",
+		Key:   &data.FeeditemKey{FeedURL: "http://site3/rss", GUID: "g1"},
+		Date:  time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+	}
+	page := &data.PagemonitorPage{
+		Config:  &data.UserPagemonitor{URL: "http://site1/2"},
+		Updated: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+	}
+	missingItemKey := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g2"}
+
+	starredItems := [][]byte{
+		subscribedItem.Key.CreateKey(),
+		unsubscribedItem.Key.CreateKey(),
+		page.Config.CreateKey(),
+		missingItemKey.CreateKey(),
+	}
+
+	dbMock.On("GetReadItems", user).Return([][]byte{subscribedItem.Key.CreateKey()}, nil).Once()
+	dbMock.On("GetStarredItems", user).Return(starredItems, nil).Twice()
+	dbMock.On("GetTags", user).Return(nil, nil).Once()
+	dbMock.On("GetFeeditem", subscribedItem.Key).Return(subscribedItem, nil).Once()
+	dbMock.On("GetFeeditem", unsubscribedItem.Key).Return(unsubscribedItem, nil).Once()
+	dbMock.On("GetFeeditem", missingItemKey).Return(nil, nil).Once()
+	dbMock.On("GetPage", page.Config).Return(page, nil).Once()
+
+	items, err := feedListService.GetStarredItems(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Item{
+		{
+			Title:     "t3",
+			Origin:    "http://site3/rss",
+			SortDate:  time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+			FetchURL:  "api/items/feed-aHR0cDovL3NpdGUzL3Jzcw-ZzE",
+			IsStarred: true,
+		},
+		{
+			Origin:    "Site 2",
+			SortDate:  time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			FetchURL:  "api/items/pagemonitor-aHR0cDovL3NpdGUxLzI--",
+			IsStarred: true,
+		},
+		{
+			Title:     "t1",
+			Origin:    "Feed 1",
+			SortDate:  time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+			FetchURL:  "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE",
+			IsRead:    true,
+			IsStarred: true,
+		},
+	}, items)
+
+	dbMock.AssertExpectations(t)
+}
+
+func TestFeedListHelperRecentlyRead(t *testing.T) {
+	dbMock := new(DBMock)
+	feedListService := FeedListService{db: dbMock}
+	user := &data.User{
+		Subscriptions: defaultSubscriptions,
+		Pages:         defaultPages,
+	}
+
+	item := &data.Feeditem{
+		Title: "t1",
+		Key:   &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"},
+		Date:  time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+	}
+	page := &data.PagemonitorPage{
+		Config:  &data.UserPagemonitor{URL: "http://site1/2"},
+		Updated: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+	}
+	missingItemKey := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g2"}
+
+	events := []*data.ReadEvent{
+		{Key: item.Key.CreateKey(), Time: time.Date(2019, time.February, 18, 10, 0, 0, 0, time.UTC)},
+		{Key: missingItemKey.CreateKey(), Time: time.Date(2019, time.February, 18, 9, 0, 0, 0, time.UTC)},
+		{Key: page.Config.CreateKey(), Time: time.Date(2019, time.February, 17, 8, 0, 0, 0, time.UTC)},
+	}
+
+	dbMock.On("GetRecentlyRead", user, 10).Return(events, nil).Once()
+	dbMock.On("GetReadItems", user).Return([][]byte{item.Key.CreateKey(), page.Config.CreateKey()}, nil).Once()
+	dbMock.On("GetStarredItems", user).Return(nil, nil).Once()
+	dbMock.On("GetTags", user).Return(nil, nil).Once()
+	dbMock.On("GetFeeditem", item.Key).Return(item, nil).Once()
+	dbMock.On("GetFeeditem", missingItemKey).Return(nil, nil).Once()
+	dbMock.On("GetPage", page.Config).Return(page, nil).Once()
+
+	items, err := feedListService.GetRecentlyRead(user, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []*HistoryItem{
+		{
+			Item: &Item{
+				Title:    "t1",
+				Origin:   "Feed 1",
+				SortDate: time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+				FetchURL: "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE",
+				IsRead:   true,
+			},
+			ReadTime: time.Date(2019, time.February, 18, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			Item: &Item{
+				Origin:   "Site 2",
+				SortDate: time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+				FetchURL: "api/items/pagemonitor-aHR0cDovL3NpdGUxLzI--",
+				IsRead:   true,
+			},
+			ReadTime: time.Date(2019, time.February, 17, 8, 0, 0, 0, time.UTC),
+		},
+	}, items)
+
+	dbMock.AssertExpectations(t)
+}
+
+func TestFeedListHelperSearch(t *testing.T) {
+	dbMock := new(DBMock)
+	feedListService := FeedListService{db: dbMock}
+	user := &data.User{
+		Subscriptions: defaultSubscriptions,
+		Pages:         defaultPages,
+	}
+
+	itemKey := &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"}
+	pageKey := &data.UserPagemonitor{URL: "http://site1/2"}
+	query := data.SearchQuery{Query: "test"}
+
+	dbMock.On("GetStarredItems", user).Return([][]byte{pageKey.CreateKey()}, nil).Once()
+	dbMock.On("GetTags", user).Return([]string{"digest"}, nil).Once()
+	dbMock.On("GetTaggedItems", user, "digest").Return([][]byte{itemKey.CreateKey()}, nil).Once()
+	dbMock.On("Search", user, query).Return([]*data.SearchResult{
+		{
+			Key:   itemKey.CreateKey(),
+			Title: "t1",
+			Date:  time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+			Read:  true,
+			Score: 2,
+		},
+		{
+			Key:   pageKey.CreateKey(),
+			Date:  time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			Score: 1,
+		},
+	}, nil).Once()
+
+	items, err := feedListService.Search(user, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Item{
+		{
+			Title:    "t1",
+			Origin:   "Feed 1",
+			SortDate: time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+			FetchURL: "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE",
+			IsRead:   true,
+			Tags:     []string{"digest"},
+			Score:    2,
+		},
+		{
+			Origin:    "Site 2",
+			SortDate:  time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC),
+			FetchURL:  "api/items/pagemonitor-aHR0cDovL3NpdGUxLzI--",
+			IsStarred: true,
+			Score:     1,
+		},
+	}, items)
+
+	dbMock.AssertExpectations(t)
+}
+
+func TestFeedListHelperTaggedItems(t *testing.T) {
+	dbMock := new(DBMock)
+	feedListService := FeedListService{db: dbMock}
+	user := &data.User{
+		Subscriptions: defaultSubscriptions,
+		Pages:         defaultPages,
+	}
+
+	subscribedItem := &data.Feeditem{
+		Title: "t1",
+		Key:   &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"},
+		Date:  time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+	}
+	unsubscribedItem := &data.Feeditem{
+		Title: "t3",
+		Key:   &data.FeeditemKey{FeedURL: "http://site3/rss", GUID: "g1"},
+		Date:  time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+	}
+
+	taggedItems := [][]byte{
+		subscribedItem.Key.CreateKey(),
+		unsubscribedItem.Key.CreateKey(),
+	}
+
+	dbMock.On("GetReadItems", user).Return(nil, nil).Once()
+	dbMock.On("GetStarredItems", user).Return([][]byte{subscribedItem.Key.CreateKey()}, nil).Once()
+	dbMock.On("GetTags", user).Return([]string{"digest"}, nil).Once()
+	dbMock.On("GetTaggedItems", user, "digest").Return(taggedItems, nil).Twice()
+	dbMock.On("GetFeeditem", subscribedItem.Key).Return(subscribedItem, nil).Once()
+	dbMock.On("GetFeeditem", unsubscribedItem.Key).Return(unsubscribedItem, nil).Once()
+
+	items, err := feedListService.GetTaggedItems(user, "digest")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Item{
+		{
+			Title:    "t3",
+			Origin:   "http://site3/rss",
+			SortDate: time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+			FetchURL: "api/items/feed-aHR0cDovL3NpdGUzL3Jzcw-ZzE",
+			Tags:     []string{"digest"},
+		},
+		{
+			Title:     "t1",
+			Origin:    "Feed 1",
+			SortDate:  time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+			FetchURL:  "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE",
+			IsStarred: true,
+			Tags:      []string{"digest"},
+		},
+	}, items)
+
+	dbMock.AssertExpectations(t)
+}
+
+func TestFeedListHelperFolders(t *testing.T) {
+	dbMock := new(DBMock)
+	feedListService := FeedListService{db: dbMock}
+	user := &data.User{
+		Subscriptions: []data.UserFeed{
+			{URL: "http://site1/rss", Title: "Feed 1", Folder: "News"},
+			{URL: "http://site2/rss", Title: "Feed 2", Folder: "News/Local"},
+			{URL: "http://site3/rss", Title: "Feed 3"},
+		},
+		Pages: defaultPages,
+	}
+
+	feedItems := []*data.Feeditem{
+		{
+			Title: "t1",
+			Key:   &data.FeeditemKey{FeedURL: "http://site1/rss", GUID: "g1"},
+			Date:  time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+		},
+		{
+			Title: "t21",
+			Key:   &data.FeeditemKey{FeedURL: "http://site2/rss", GUID: "g1"},
+			Date:  time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+		},
+		{
+			Title: "t22",
+			Key:   &data.FeeditemKey{FeedURL: "http://site2/rss", GUID: "g2"},
+			Date:  time.Date(2019, time.February, 16, 23, 3, 0, 0, time.UTC),
+		},
+		{
+			Title: "t31",
+			Key:   &data.FeeditemKey{FeedURL: "http://site3/rss", GUID: "g1"},
+			Date:  time.Date(2019, time.February, 16, 23, 4, 0, 0, time.UTC),
+		},
+	}
+	pages := []*data.PagemonitorPage{
+		{
+			Config:  &data.UserPagemonitor{URL: "http://site1/2"},
+			Updated: time.Date(2019, time.February, 16, 23, 5, 0, 0, time.UTC),
+		},
+	}
+	readItems := [][]byte{feedItems[2].Key.CreateKey()}
+
+	dbMock.On("GetFeeditems", user).Return(feedItems, nil).Twice()
+	dbMock.On("GetPages", user).Return(pages, nil).Twice()
+	dbMock.On("GetReadItems", user).Return(readItems, nil).Twice()
+	dbMock.On("GetStarredItems", user).Return(nil, nil).Twice()
+	dbMock.On("GetTags", user).Return(nil, nil).Twice()
+
+	items, err := feedListService.GetFolderItems(user, "News")
+	assert.NoError(t, err)
+	assert.Equal(t, []*Item{
+		{
+			Title:    "t21",
+			Origin:   "Feed 2",
+			Folder:   "News/Local",
+			SortDate: time.Date(2019, time.February, 16, 23, 2, 0, 0, time.UTC),
+			FetchURL: "api/items/feed-aHR0cDovL3NpdGUyL3Jzcw-ZzE",
+		},
+		{
+			Title:    "t1",
+			Origin:   "Feed 1",
+			Folder:   "News",
+			SortDate: time.Date(2019, time.February, 16, 23, 1, 0, 0, time.UTC),
+			FetchURL: "api/items/feed-aHR0cDovL3NpdGUxL3Jzcw-ZzE",
+		},
+		{
+			Title:    "t22",
+			Origin:   "Feed 2",
+			Folder:   "News/Local",
+			SortDate: time.Date(2019, time.February, 16, 23, 3, 0, 0, time.UTC),
+			FetchURL: "api/items/feed-aHR0cDovL3NpdGUyL3Jzcw-ZzI",
+			IsRead:   true,
+		},
+	}, items)
+
+	folders, err := feedListService.GetFolders(user)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Folder{{Name: "News", Unread: 2}, {Name: "News/Local", Unread: 1}}, folders)
+
+	dbMock.AssertExpectations(t)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+// HistoryItem is an Item which was recently read by the user.
+type HistoryItem struct {
+	*Item
+	ReadTime time.Time
+}
+
+// feedReadingStats is the total number of items read from a feed (or monitored page).
+type feedReadingStats struct {
+	URL        string
+	Title      string
+	Read       int
+	MarkedRead int
+}
+
+// readingReport contains the number of read items for every day and feed, and the totals for every feed.
+type readingReport struct {
+	Days  []*data.ReadingStats
+	Feeds []*feedReadingStats
+}
+
+// readingStatsDays is the default number of days included in reading stats.
+const readingStatsDays = 30
+
+// parseReadingStatsFrom returns the first day included in reading stats, using the days request parameter.
+func parseReadingStatsFrom(r *http.Request, now time.Time) (time.Time, error) {
+	days := readingStatsDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			return time.Time{}, fmt.Errorf("invalid number of days %v", value)
+		}
+	}
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return today.AddDate(0, 0, 1-days), nil
+}
+
+// addFeedTitles adds the titles of the user's feeds and pages into feedTitles.
+func addFeedTitles(feedTitles map[string]string, user *data.User) {
+	for url, title := range getFeedTitles(user) {
+		feedTitles[url] = title
+	}
+	for _, pm := range user.GetPages() {
+		feedTitles[pm.URL] = pm.Title
+	}
+}
+
+// getReadingReport returns stats together with the totals for every feed, least read feeds first.
+// All feeds from feedTitles are included, even if none of their items were read.
+func getReadingReport(feedTitles map[string]string, stats []*data.ReadingStats) *readingReport {
+	feedStats := make(map[string]*feedReadingStats, len(feedTitles))
+	for url, title := range feedTitles {
+		feedStats[url] = &feedReadingStats{URL: url, Title: title}
+	}
+	for _, dayStats := range stats {
+		totals, ok := feedStats[dayStats.FeedURL]
+		if !ok {
+			// Nobody is subscribed to this feed anymore.
+			totals = &feedReadingStats{URL: dayStats.FeedURL, Title: dayStats.FeedURL}
+			feedStats[dayStats.FeedURL] = totals
+		}
+		totals.Read += dayStats.Read
+		totals.MarkedRead += dayStats.MarkedRead
+	}
+
+	report := &readingReport{Days: stats, Feeds: make([]*feedReadingStats, 0, len(feedStats))}
+	if report.Days == nil {
+		report.Days = make([]*data.ReadingStats, 0)
+	}
+	for _, totals := range feedStats {
+		report.Feeds = append(report.Feeds, totals)
+	}
+	sort.Slice(report.Feeds, func(i, j int) bool {
+		if report.Feeds[i].Read != report.Feeds[j].Read {
+			return report.Feeds[i].Read < report.Feeds[j].Read
+		}
+		if report.Feeds[i].Title != report.Feeds[j].Title {
+			return report.Feeds[i].Title < report.Feeds[j].Title
+		}
+		return report.Feeds[i].URL < report.Feeds[j].URL
+	})
+	return report
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+// exportedNote is a note together with details of its item.
+type exportedNote struct {
+	Title      string
+	Origin     string
+	URL        string
+	Date       time.Time
+	Text       string
+	Highlights []data.Highlight `json:",omitempty"`
+	Updated    time.Time
+}
+
+// getExportedNotes returns all notes of user, most recently updated first.
+// Notes for items which no longer exist are still returned, without item details.
+func getExportedNotes(db DB, user *data.User) ([]*exportedNote, error) {
+	feedTitles := getFeedTitles(user)
+	pageTitles := getPageTitles(user)
+
+	notes, err := db.GetNotes(user)
+	if err != nil {
+		return nil, err
+	}
+
+	exportedNotes := make([]*exportedNote, 0, len(notes))
+	for _, note := range notes {
+		exported := &exportedNote{
+			Text:       note.Text,
+			Highlights: note.Highlights,
+			Updated:    note.Updated,
+		}
+		if data.IsFeeditemKey(note.Key) {
+			feeditemKey, err := data.DecodeFeeditemKey(note.Key)
+			if err != nil {
+				return nil, err
+			}
+			feedItem, err := db.GetFeeditem(feeditemKey)
+			if err != nil {
+				return nil, err
+			}
+			exported.Origin = feeditemKey.FeedURL
+			if title, ok := feedTitles[feeditemKey.FeedURL]; ok {
+				exported.Origin = title
+			}
+			if feedItem != nil {
+				exported.Title = feedItem.Title
+				exported.URL = feedItem.URL
+				exported.Date = feedItem.Date
+			}
+		} else if data.IsPagemonitorKey(note.Key) {
+			pagemonitorKey, err := data.DecodePagemonitorKey(note.Key)
+			if err != nil {
+				return nil, err
+			}
+			page, err := db.GetPage(pagemonitorKey)
+			if err != nil {
+				return nil, err
+			}
+			exported.Origin = pagemonitorKey.URL
+			if title, ok := pageTitles[string(note.Key)]; ok {
+				exported.Origin = title
+			}
+			exported.URL = pagemonitorKey.URL
+			if page != nil {
+				exported.Date = page.Updated
+			}
+		}
+		exportedNotes = append(exportedNotes, exported)
+	}
+
+	sort.SliceStable(exportedNotes, func(i, j int) bool {
+		return exportedNotes[i].Updated.After(exportedNotes[j].Updated)
+	})
+
+	return exportedNotes, nil
+}
+
+// writeNotesMarkdown writes notes as a Markdown document.
+func writeNotesMarkdown(w io.Writer, notes []*exportedNote) error {
+	var document strings.Builder
+	document.WriteString("# Notes\n")
+	for _, note := range notes {
+		title := note.Title
+		if title == "" {
+			title = note.Origin
+		}
+		if note.URL != "" {
+			fmt.Fprintf(&document, "\n## [%v](%v)\n\n", title, note.URL)
+		} else {
+			fmt.Fprintf(&document, "\n## %v\n\n", title)
+		}
+		fmt.Fprintf(&document, "%v", note.Origin)
+		if !note.Date.IsZero() {
+			fmt.Fprintf(&document, ", %v", note.Date.Format(searchDateFormat))
+		}
+		document.WriteString("\n")
+
+		for _, highlight := range note.Highlights {
+			document.WriteString("\n> " + strings.ReplaceAll(strings.TrimSpace(highlight.Text), "\n", "\n> ") + "\n")
+		}
+		if note.Text != "" {
+			document.WriteString("\n" + strings.TrimSpace(note.Text) + "\n")
+		}
+	}
+	_, err := io.WriteString(w, document.String())
+	return err
+}
@@ -161,7 +161,7 @@ func TestHtmlFeedHandlerLoggedIn(t *testing.T) {
 	router.ServeHTTP(res, req)
 
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, "User {    }\nName feed\nContent feedpage", res.Body.String())
+	assert.Equal(t, "User { [] []    false {0 0}  }\nName feed\nContent feedpage", res.Body.String())
 
 	authHandler.AssertExpectations(t)
 }
@@ -200,7 +200,7 @@ func TestHtmlSettingsHandlerLoggedIn(t *testing.T) {
 	router.ServeHTTP(res, req)
 
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, "User {    }\nName settings\nContent settingspage", res.Body.String())
+	assert.Equal(t, "User { [] []    false {0 0}  }\nName settings\nContent settingspage", res.Body.String())
 
 	authHandler.AssertExpectations(t)
 }
@@ -239,7 +239,7 @@ func TestHtmlStatusHandlerLoggedIn(t *testing.T) {
 	router.ServeHTTP(res, req)
 
 	assert.Equal(t, http.StatusOK, res.Code)
-	assert.Equal(t, "User {    }\nName status\nContent feedpage", res.Body.String())
+	assert.Equal(t, "User { [] []    false {0 0}  }\nName status\nContent feedpage", res.Body.String())
 
 	authHandler.AssertExpectations(t)
 }
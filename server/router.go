@@ -48,6 +48,7 @@ func CreateRouter(s *Services) (*chi.Mux, error) {
 
 	r.Get("/", RootHandler(s))
 	r.Get("/login", HTMLLoginHandler(s))
+	r.With(NoCacheHeaderMiddlewareFunc).Get("/health", HealthHandler(s))
 
 	r.Group(func(authorized chi.Router) {
 		authorized.Use(s.cookieHandler.AuthHandlerFunc)
@@ -69,11 +70,45 @@ func CreateRouter(s *Services) (*chi.Mux, error) {
 			authorized.Use(middleware.Compress(5))
 			authorized.Get("/configuration", SettingsHandler(s))
 			authorized.Post("/configuration", SettingsHandler(s))
+			authorized.Post("/configuration/mailaddress", MailAddressHandler(s))
+			authorized.Get("/subscriptions", SubscriptionsHandler(s))
+			authorized.Post("/subscriptions", SubscriptionsHandler(s))
+			authorized.Get("/subscriptions/opml", OPMLHandler(s))
+			authorized.Post("/subscriptions/opml", OPMLHandler(s))
+			authorized.Put("/subscriptions/{key}", SubscriptionHandler(s))
+			authorized.Delete("/subscriptions/{key}", SubscriptionHandler(s))
+			authorized.Get("/pages", PagesHandler(s))
+			authorized.Post("/pages", PagesHandler(s))
+			authorized.Get("/pages/xml", PagemonitorXMLHandler(s))
+			authorized.Post("/pages/xml", PagemonitorXMLHandler(s))
+			authorized.Post("/pages/preview", PagePreviewHandler(s))
+			authorized.Put("/pages/{key}", PageHandler(s))
+			authorized.Delete("/pages/{key}", PageHandler(s))
 			authorized.Get("/feed", FeedHandler(s))
+			authorized.Get("/starred", StarredHandler(s))
+			authorized.Get("/search", SearchHandler(s))
+			authorized.Get("/tags", TagsHandler(s))
+			authorized.Post("/tags", TagsHandler(s))
+			authorized.Get("/folders", FoldersHandler(s))
+			authorized.Post("/folders", FoldersHandler(s))
+			authorized.Get("/notes", NotesHandler(s))
+			authorized.Get("/history", HistoryHandler(s))
+			authorized.Get("/history/stats", ReadingStatsHandler(s))
+			authorized.Post("/items", ItemsHandler(s))
+			authorized.Post("/items/undo", UndoMarkReadHandler(s))
 			authorized.Get("/items/{key}", FeedItemHandler(s))
 			authorized.Post("/items/{key}", FeedItemHandler(s))
 			authorized.Get("/refresh", RefreshHandler(s))
+			authorized.Get("/preview", PreviewHandler(s))
 			authorized.Get("/status", StatusHandler(s))
+			authorized.Get("/status/feeds", FeedStatsHandler(s))
+			authorized.Group(func(admin chi.Router) {
+				admin.Use(AdminAuthHandler)
+				admin.Get("/admin/backup", BackupHandler(s))
+				admin.Post("/admin/backup", BackupHandler(s))
+				admin.Post("/admin/restore", RestoreHandler(s))
+				admin.Get("/admin/readingstats", AllReadingStatsHandler(s))
+			})
 		})
 	})
 	return r, nil
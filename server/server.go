@@ -1,19 +1,23 @@
 package server
 
 import (
+	"io"
 	"io/fs"
 	"net/http"
+	"time"
 
 	"github.com/zlogic/nanorss-go/data"
 	"github.com/zlogic/nanorss-go/fetcher"
 	"github.com/zlogic/nanorss-go/server/auth"
 	"github.com/zlogic/nanorss-go/server/templates"
+	"github.com/zlogic/nanorss-go/smtpd"
 )
 
 // DB provides functions to read and write items in the database.
 type DB interface {
 	GetOrCreateConfigVariable(varName string, generator func() (string, error)) (string, error)
 	GetUser(username string) (*data.User, error)
+	GetUsers() ([]string, error)
 	SaveUser(*data.User) error
 	GetFeeditem(*data.FeeditemKey) (*data.Feeditem, error)
 	GetFeeditems(*data.User) ([]*data.Feeditem, error)
@@ -21,17 +25,47 @@ type DB interface {
 	GetPages(*data.User) ([]*data.PagemonitorPage, error)
 	GetReadItems(user *data.User) ([][]byte, error)
 	SetReadStatus(user *data.User, itemKey []byte, read bool) error
+	SetReadStatuses(user *data.User, itemKeys [][]byte, read bool) (int, error)
+	MarkRead(user *data.User, filter data.ReadFilter) (int, error)
+	UndoMarkRead(user *data.User) (int, error)
+	GetRecentlyRead(user *data.User, limit int) ([]*data.ReadEvent, error)
+	GetReadingStats(user *data.User, from time.Time) ([]*data.ReadingStats, error)
+	GetAllReadingStats(from time.Time) ([]*data.ReadingStats, error)
+	GetStarredItems(user *data.User) ([][]byte, error)
+	SetStarred(user *data.User, itemKey []byte, starred bool) error
+	GetTags(user *data.User) ([]string, error)
+	CreateTag(user *data.User, tag string) error
+	RenameTag(user *data.User, tag, newTag string) error
+	DeleteTag(user *data.User, tag string) error
+	GetTaggedItems(user *data.User, tag string) ([][]byte, error)
+	SetTagged(user *data.User, tag string, itemKey []byte, tagged bool) error
+	GetNote(user *data.User, itemKey []byte) (*data.ItemNote, error)
+	GetNotes(user *data.User) ([]*data.ItemNote, error)
+	SaveNote(user *data.User, itemKey []byte, note *data.ItemNote) error
 	GetFetchStatus(key []byte) (*data.FetchStatus, error)
+	GetFeedStats(user *data.User) ([]*data.FeedStats, error)
+	Search(user *data.User, query data.SearchQuery) ([]*data.SearchResult, error)
+	GetLastBackupTime() (time.Time, error)
+	Backup(w io.Writer, options data.BackupOptions) error
+	Restore(r io.Reader, options data.RestoreOptions) (*data.RestoreReport, error)
 }
 
-// Fetcher provides a method to refresh all feeds.
+// Fetcher provides methods to refresh all feeds and to preview a feed.
 type Fetcher interface {
 	Refresh()
+	PreviewFeed(feedURL string) (*fetcher.ParsedFeed, error)
+	PreviewPage(config *data.UserPagemonitor) (*fetcher.PagePreview, error)
 }
 
 // FeedListHelper returns all feed (and page monitor) items for a user.
 type FeedListHelper interface {
 	GetAllItems(*data.User) ([]*Item, error)
+	GetStarredItems(*data.User) ([]*Item, error)
+	GetTaggedItems(user *data.User, tag string) ([]*Item, error)
+	GetFolderItems(user *data.User, folder string) ([]*Item, error)
+	GetFolders(*data.User) ([]*Folder, error)
+	GetRecentlyRead(user *data.User, limit int) ([]*HistoryItem, error)
+	Search(*data.User, data.SearchQuery) ([]*Item, error)
 }
 
 // AuthHandler handles authentication and authentication cookies.
@@ -48,10 +82,13 @@ type Services struct {
 	fetcher        Fetcher
 	feedListHelper FeedListHelper
 	templates      fs.FS
+	mailDomain     string
+	// defaultRetention is the global retention policy, shown in settings.
+	defaultRetention data.RetentionPolicy
 }
 
 // CreateServices creates a Services instance with db and default implementations of other services.
-func CreateServices(db *data.DBService) (*Services, error) {
+func CreateServices(db data.Store) (*Services, error) {
 	cookieHandler, err := auth.NewCookieHandler(db)
 	if err != nil {
 		return nil, err
@@ -62,5 +99,8 @@ func CreateServices(db *data.DBService) (*Services, error) {
 		fetcher:        fetcher.NewFetcher(db),
 		feedListHelper: &FeedListService{db: db},
 		templates:      templates.Templates,
+		mailDomain:     smtpd.Domain(),
+
+		defaultRetention: db.GetDefaultRetentionPolicy(),
 	}, nil
 }
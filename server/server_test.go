@@ -2,7 +2,9 @@ package server
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 
@@ -89,6 +91,143 @@ func (m *DBMock) SetReadStatus(user *data.User, itemKey []byte, read bool) error
 	return args.Error(0)
 }
 
+func (m *DBMock) SetReadStatuses(user *data.User, itemKeys [][]byte, read bool) (int, error) {
+	args := m.Called(user, itemKeys, read)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *DBMock) MarkRead(user *data.User, filter data.ReadFilter) (int, error) {
+	args := m.Called(user, filter)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *DBMock) UndoMarkRead(user *data.User) (int, error) {
+	args := m.Called(user)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *DBMock) GetRecentlyRead(user *data.User, limit int) ([]*data.ReadEvent, error) {
+	args := m.Called(user, limit)
+	return args.Get(0).([]*data.ReadEvent), args.Error(1)
+}
+
+func (m *DBMock) GetReadingStats(user *data.User, from time.Time) ([]*data.ReadingStats, error) {
+	args := m.Called(user, from)
+	return args.Get(0).([]*data.ReadingStats), args.Error(1)
+}
+
+func (m *DBMock) GetAllReadingStats(from time.Time) ([]*data.ReadingStats, error) {
+	args := m.Called(from)
+	return args.Get(0).([]*data.ReadingStats), args.Error(1)
+}
+
+func (m *DBMock) GetStarredItems(user *data.User) ([][]byte, error) {
+	args := m.Called(user)
+	items := args.Get(0)
+	var returnItems [][]byte
+	if items != nil {
+		returnItems = items.([][]byte)
+	}
+	return returnItems, args.Error(1)
+}
+
+func (m *DBMock) SetStarred(user *data.User, itemKey []byte, starred bool) error {
+	args := m.Called(user, itemKey, starred)
+	return args.Error(0)
+}
+
+func (m *DBMock) GetTags(user *data.User) ([]string, error) {
+	args := m.Called(user)
+	tags := args.Get(0)
+	var returnTags []string
+	if tags != nil {
+		returnTags = tags.([]string)
+	}
+	return returnTags, args.Error(1)
+}
+
+func (m *DBMock) CreateTag(user *data.User, tag string) error {
+	args := m.Called(user, tag)
+	return args.Error(0)
+}
+
+func (m *DBMock) RenameTag(user *data.User, tag, newTag string) error {
+	args := m.Called(user, tag, newTag)
+	return args.Error(0)
+}
+
+func (m *DBMock) DeleteTag(user *data.User, tag string) error {
+	args := m.Called(user, tag)
+	return args.Error(0)
+}
+
+func (m *DBMock) GetTaggedItems(user *data.User, tag string) ([][]byte, error) {
+	args := m.Called(user, tag)
+	items := args.Get(0)
+	var returnItems [][]byte
+	if items != nil {
+		returnItems = items.([][]byte)
+	}
+	return returnItems, args.Error(1)
+}
+
+func (m *DBMock) SetTagged(user *data.User, tag string, itemKey []byte, tagged bool) error {
+	args := m.Called(user, tag, itemKey, tagged)
+	return args.Error(0)
+}
+
+func (m *DBMock) GetNote(user *data.User, itemKey []byte) (*data.ItemNote, error) {
+	args := m.Called(user, itemKey)
+	note := args.Get(0)
+	var returnNote *data.ItemNote
+	if note != nil {
+		returnNote = note.(*data.ItemNote)
+	}
+	return returnNote, args.Error(1)
+}
+
+func (m *DBMock) GetNotes(user *data.User) ([]*data.ItemNote, error) {
+	args := m.Called(user)
+	return args.Get(0).([]*data.ItemNote), args.Error(1)
+}
+
+func (m *DBMock) SaveNote(user *data.User, itemKey []byte, note *data.ItemNote) error {
+	args := m.Called(user, itemKey, note)
+	return args.Error(0)
+}
+
+func (m *DBMock) Search(user *data.User, query data.SearchQuery) ([]*data.SearchResult, error) {
+	args := m.Called(user, query)
+	return args.Get(0).([]*data.SearchResult), args.Error(1)
+}
+
+func (m *DBMock) GetLastBackupTime() (time.Time, error) {
+	args := m.Called()
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *DBMock) Backup(w io.Writer, options data.BackupOptions) error {
+	args := m.Called(options)
+	if _, err := w.Write([]byte(args.String(0))); err != nil {
+		return err
+	}
+	return args.Error(1)
+}
+
+func (m *DBMock) Restore(r io.Reader, options data.RestoreOptions) (*data.RestoreReport, error) {
+	backup, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	args := m.Called(string(backup), options)
+	report := args.Get(0)
+	var returnReport *data.RestoreReport
+	if report != nil {
+		returnReport = report.(*data.RestoreReport)
+	}
+	return returnReport, args.Error(1)
+}
+
 func (m *DBMock) GetFetchStatus(key []byte) (*data.FetchStatus, error) {
 	args := m.Called(key)
 	fetchStatus := args.Get(0)
@@ -99,6 +238,11 @@ func (m *DBMock) GetFetchStatus(key []byte) (*data.FetchStatus, error) {
 	return returnFetchStatus, args.Error(1)
 }
 
+func (m *DBMock) GetFeedStats(user *data.User) ([]*data.FeedStats, error) {
+	args := m.Called(user)
+	return args.Get(0).([]*data.FeedStats), args.Error(1)
+}
+
 var testAuthCookie = "testusername"
 
 type AuthHandlerMock struct {
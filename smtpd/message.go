@@ -0,0 +1,178 @@
+package smtpd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/html/charset"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+// messagePart is a decoded leaf part of a MIME message.
+type messagePart struct {
+	ContentType string
+	ContentID   string
+	Inline      bool
+	Body        []byte
+}
+
+// wordDecoder decodes RFC 2047 encoded headers.
+var wordDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// parseSender returns the message sender from the From header, falling back to envelopeFrom.
+func parseSender(header mail.Header, envelopeFrom string) (*mail.Address, error) {
+	parser := &mail.AddressParser{WordDecoder: wordDecoder}
+	if from := header.Get("From"); from != "" {
+		addresses, err := parser.ParseList(from)
+		if err == nil && len(addresses) > 0 {
+			return addresses[0], nil
+		}
+		log.WithField("from", from).WithError(err).Info("Failed to parse From header")
+	}
+	if envelopeFrom == "" {
+		return nil, fmt.Errorf("message has no sender")
+	}
+	return &mail.Address{Address: envelopeFrom}, nil
+}
+
+// decodeBody decodes the transfer encoding and charset of a part body.
+func decodeBody(body io.Reader, transferEncoding, mediaType string, params map[string]string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		if charsetLabel := params["charset"]; charsetLabel != "" {
+			charsetReader, err := charset.NewReaderLabel(charsetLabel, body)
+			if err != nil {
+				log.WithField("charset", charsetLabel).WithError(err).Info("Unsupported charset")
+			} else {
+				body = charsetReader
+			}
+		}
+	}
+	return io.ReadAll(body)
+}
+
+// collectParts walks through a (possibly multipart) body and returns all leaf parts.
+func collectParts(header map[string][]string, body io.Reader, depth int) ([]*messagePart, error) {
+	partHeader := mail.Header(header)
+	contentType := partHeader.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		log.WithField("contentType", contentType).WithError(err).Info("Failed to parse content type")
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < 10 {
+		reader := multipart.NewReader(body, params["boundary"])
+		parts := make([]*messagePart, 0)
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return parts, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot read multipart message: %w", err)
+			}
+			childParts, err := collectParts(part.Header, part, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, childParts...)
+		}
+	}
+
+	decoded, err := decodeBody(body, partHeader.Get("Content-Transfer-Encoding"), mediaType, params)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode message part: %w", err)
+	}
+	disposition, _, _ := mime.ParseMediaType(partHeader.Get("Content-Disposition"))
+	return []*messagePart{{
+		ContentType: mediaType,
+		ContentID:   strings.Trim(partHeader.Get("Content-ID"), "<> "),
+		Inline:      disposition != "attachment",
+		Body:        decoded,
+	}}, nil
+}
+
+// embedInlineImages replaces cid: references in contents with data URIs.
+func embedInlineImages(contents string, parts []*messagePart) string {
+	for _, part := range parts {
+		if part.ContentID == "" || !strings.HasPrefix(part.ContentType, "image/") {
+			continue
+		}
+		dataURI := "data:" + part.ContentType + ";base64," + base64.StdEncoding.EncodeToString(part.Body)
+		contents = strings.ReplaceAll(contents, "cid:"+part.ContentID, dataURI)
+	}
+	return contents
+}
+
+// textToHTML converts a plaintext message into HTML.
+func textToHTML(text string) string {
+	text = strings.ReplaceAll(strings.TrimSpace(text), "\r\n", "\n")
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+}
+
+// parseMessage converts a received message into a Feeditem for the feedURL feed.
+func parseMessage(feedURL string, msg *mail.Message, rawMessage []byte) (*data.Feeditem, error) {
+	parts, err := collectParts(msg.Header, msg.Body, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlPart, textPart *messagePart
+	for _, part := range parts {
+		if !part.Inline {
+			continue
+		}
+		if part.ContentType == "text/html" && htmlPart == nil {
+			htmlPart = part
+		} else if part.ContentType == "text/plain" && textPart == nil {
+			textPart = part
+		}
+	}
+
+	item := &data.Feeditem{Key: &data.FeeditemKey{FeedURL: feedURL}}
+	if htmlPart != nil {
+		item.Contents = embedInlineImages(string(htmlPart.Body), parts)
+	} else if textPart != nil {
+		item.Contents = textToHTML(string(textPart.Body))
+	}
+
+	subject := msg.Header.Get("Subject")
+	if decodedSubject, err := wordDecoder.DecodeHeader(subject); err == nil {
+		subject = decodedSubject
+	}
+	item.Title = strings.TrimSpace(subject)
+
+	item.Date, err = msg.Header.Date()
+	if err != nil {
+		item.Date = time.Now()
+	}
+
+	item.Key.GUID = strings.Trim(msg.Header.Get("Message-Id"), "<> ")
+	if item.Key.GUID == "" {
+		hash := sha256.Sum256(bytes.TrimSpace(rawMessage))
+		item.Key.GUID = hex.EncodeToString(hash[:])
+	}
+	return item, nil
+}
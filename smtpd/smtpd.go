@@ -0,0 +1,380 @@
+package smtpd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/zlogic/nanorss-go/data"
+	"github.com/zlogic/nanorss-go/fetcher"
+)
+
+// DB provides functions to read and write items in the database.
+type DB interface {
+	GetUsers() ([]string, error)
+	GetUser(username string) (*data.User, error)
+	AddUserFeed(username string, feed data.UserFeed) error
+	SaveFeeditems(...*data.Feeditem) (err error)
+	SetFetchStatus([]byte, *data.FetchStatus) error
+}
+
+// defaultDomain is the mail domain used if SMTP_DOMAIN is not set.
+const defaultDomain = "nanorss.local"
+
+// maxMessageSize is the maximum size of an accepted message.
+const maxMessageSize = 16 * 1024 * 1024
+
+// maxRecipients is the maximum number of recipients for a single message.
+const maxRecipients = 100
+
+// maxLineLength is the maximum length of a single line sent by the client.
+const maxLineLength = 1024 * 1024
+
+// commandTimeout is the maximum time to wait for a command (or message data) from the client.
+const commandTimeout = 5 * time.Minute
+
+// errMessageTooLarge is returned if the message exceeds maxMessageSize.
+var errMessageTooLarge = errors.New("message too large")
+
+// Domain returns the mail domain, customized based on environment variables.
+func Domain() string {
+	domain, ok := os.LookupEnv("SMTP_DOMAIN")
+	if !ok || domain == "" {
+		return defaultDomain
+	}
+	return domain
+}
+
+// Server receives mail for users and saves every message as a Feeditem.
+type Server struct {
+	DB         DB
+	Domain     string
+	TagsPolicy *bluemonday.Policy
+
+	listener  net.Listener
+	listening sync.WaitGroup
+}
+
+// NewServer creates a new Server instance with db.
+func NewServer(db DB) *Server {
+	return &Server{DB: db, Domain: Domain(), TagsPolicy: fetcher.NewTagsPolicy()}
+}
+
+// ListenAndServe listens on the TCP network address addr and handles incoming SMTP connections.
+func (server *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(listener)
+}
+
+// Serve accepts incoming SMTP connections on listener.
+// Serve always returns a non-nil error; after Close, the returned error is net.ErrClosed.
+func (server *Server) Serve(listener net.Listener) error {
+	server.listener = listener
+	log.WithField("address", listener.Addr()).WithField("domain", server.Domain).Info("Receiving mail")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			server.listening.Wait()
+			return err
+		}
+		server.listening.Add(1)
+		go func() {
+			defer server.listening.Done()
+			server.handleConnection(conn)
+		}()
+	}
+}
+
+// Close stops accepting new connections.
+func (server *Server) Close() error {
+	if server.listener == nil {
+		return nil
+	}
+	return server.listener.Close()
+}
+
+// session keeps the state of an SMTP session.
+type session struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	from       string
+	recipients []*data.User
+}
+
+// reply sends a reply to the client.
+func (s *session) reply(code int, message string) error {
+	if _, err := fmt.Fprintf(s.writer, "%d %s\r\n", code, message); err != nil {
+		return err
+	}
+	return s.writer.Flush()
+}
+
+// reset clears the state of the current mail transaction.
+func (s *session) reset() {
+	s.from = ""
+	s.recipients = nil
+}
+
+// readLine reads a single command line.
+func (s *session) readLine() (string, error) {
+	if err := s.conn.SetReadDeadline(time.Now().Add(commandTimeout)); err != nil {
+		return "", err
+	}
+	line, err := s.readRawLine()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readRawLine reads a line (including the line ending), failing if the line is longer than maxLineLength.
+func (s *session) readRawLine() (string, error) {
+	var line strings.Builder
+	for {
+		fragment, err := s.reader.ReadSlice('\n')
+		if line.Len()+len(fragment) > maxLineLength {
+			return "", fmt.Errorf("line too long")
+		}
+		line.Write(fragment)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return line.String(), nil
+	}
+}
+
+// readData reads message data until the terminating dot line.
+func (s *session) readData() ([]byte, error) {
+	if err := s.conn.SetReadDeadline(time.Now().Add(commandTimeout)); err != nil {
+		return nil, err
+	}
+	var message strings.Builder
+	tooLarge := false
+	for {
+		line, err := s.readRawLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" || line == ".\n" {
+			break
+		}
+		// Remove dot-stuffing.
+		line = strings.TrimPrefix(line, ".")
+		if message.Len()+len(line) > maxMessageSize {
+			tooLarge = true
+			continue
+		}
+		message.WriteString(line)
+	}
+	if tooLarge {
+		return nil, errMessageTooLarge
+	}
+	return []byte(message.String()), nil
+}
+
+// parseAddress extracts the address from a MAIL FROM or RCPT TO argument.
+func parseAddress(argument, prefix string) (string, bool) {
+	if len(argument) < len(prefix) || !strings.EqualFold(argument[:len(prefix)], prefix) {
+		return "", false
+	}
+	address := strings.TrimSpace(argument[len(prefix):])
+	// Remove ESMTP parameters such as SIZE or BODY.
+	if end := strings.Index(address, ">"); end >= 0 {
+		address = address[:end+1]
+	}
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "<"), ">")
+	return address, true
+}
+
+// findRecipient returns the user owning address, or nil if address doesn't belong to any user.
+func (server *Server) findRecipient(address string) (*data.User, error) {
+	token, domain, ok := strings.Cut(address, "@")
+	if !ok || token == "" || !strings.EqualFold(domain, server.Domain) {
+		return nil, nil
+	}
+	token = strings.ToLower(token)
+	usernames, err := server.DB.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range usernames {
+		user, err := server.DB.GetUser(username)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil && user.MailToken != "" && user.MailToken == token {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+// handleConnection handles an SMTP session until the client disconnects or sends QUIT.
+func (server *Server) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	s := &session{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+	logger := log.WithField("remote", conn.RemoteAddr())
+
+	if err := s.reply(220, server.Domain+" ESMTP nanoRSS"); err != nil {
+		logger.WithError(err).Error("Failed to send greeting")
+		return
+	}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			if err != io.EOF {
+				logger.WithError(err).Error("Failed to read command")
+			}
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		argument = strings.TrimSpace(argument)
+		switch strings.ToUpper(command) {
+		case "HELO":
+			s.reset()
+			err = s.reply(250, server.Domain)
+		case "EHLO":
+			s.reset()
+			_, err = fmt.Fprintf(s.writer, "250-%s\r\n250-8BITMIME\r\n250 SIZE %d\r\n", server.Domain, maxMessageSize)
+			if err == nil {
+				err = s.writer.Flush()
+			}
+		case "MAIL":
+			address, ok := parseAddress(argument, "FROM:")
+			if !ok {
+				err = s.reply(501, "Syntax: MAIL FROM:<address>")
+				break
+			}
+			s.reset()
+			s.from = address
+			err = s.reply(250, "OK")
+		case "RCPT":
+			address, ok := parseAddress(argument, "TO:")
+			if !ok {
+				err = s.reply(501, "Syntax: RCPT TO:<address>")
+				break
+			}
+			if len(s.recipients) >= maxRecipients {
+				err = s.reply(452, "Too many recipients")
+				break
+			}
+			user, findErr := server.findRecipient(address)
+			if findErr != nil {
+				logger.WithError(findErr).Error("Failed to find recipient")
+				err = s.reply(451, "Temporary failure")
+			} else if user == nil {
+				err = s.reply(550, "No such user")
+			} else {
+				s.recipients = append(s.recipients, user)
+				err = s.reply(250, "OK")
+			}
+		case "DATA":
+			if len(s.recipients) == 0 {
+				err = s.reply(503, "No valid recipients")
+				break
+			}
+			if err = s.reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+				break
+			}
+			message, readErr := s.readData()
+			if readErr == errMessageTooLarge {
+				err = s.reply(552, "Message too large")
+			} else if readErr != nil {
+				logger.WithError(readErr).Error("Failed to read message")
+				return
+			} else if saveErr := server.deliver(s.from, s.recipients, message); saveErr != nil {
+				logger.WithError(saveErr).Error("Failed to save message")
+				err = s.reply(554, "Failed to process message")
+			} else {
+				err = s.reply(250, "OK")
+			}
+			s.reset()
+		case "RSET":
+			s.reset()
+			err = s.reply(250, "OK")
+		case "NOOP":
+			err = s.reply(250, "OK")
+		case "QUIT":
+			s.reply(221, "Bye")
+			return
+		default:
+			err = s.reply(502, "Command not implemented")
+		}
+		if err != nil {
+			logger.WithError(err).Error("Failed to send reply")
+			return
+		}
+	}
+}
+
+// deliver saves message for all recipients.
+func (server *Server) deliver(envelopeFrom string, recipients []*data.User, message []byte) error {
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		return fmt.Errorf("cannot parse message: %w", err)
+	}
+
+	sender, err := parseSender(msg.Header, envelopeFrom)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range recipients {
+		if err := server.saveMessage(user, sender, msg, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveMessage converts msg into a Feeditem and saves it into the sender's virtual feed for user.
+func (server *Server) saveMessage(user *data.User, sender *mail.Address, msg *mail.Message, message []byte) error {
+	feed := data.UserFeed{
+		URL:   fetcher.EmailFeedURL(sender.Address, user.MailToken),
+		Title: sender.Name,
+		Type:  fetcher.EmailFeedType,
+	}
+	if feed.Title == "" {
+		feed.Title = sender.Address
+	}
+
+	item, err := parseMessage(feed.URL, msg, message)
+	if err != nil {
+		return err
+	}
+	items := []*data.Feeditem{item}
+	// Emails have no base URL to resolve relative links against.
+	fetcher.SanitizeHTML(server.TagsPolicy, "", items)
+
+	if err := server.DB.AddUserFeed(user.GetUsername(), feed); err != nil {
+		return fmt.Errorf("cannot add mail feed for user %v: %w", user.GetUsername(), err)
+	}
+
+	item.Updated = time.Now()
+	if err := server.DB.SaveFeeditems(items...); err != nil {
+		return fmt.Errorf("cannot save message: %w", err)
+	}
+
+	fetchStatus := &data.FetchStatus{LastSuccess: time.Now()}
+	if err := server.DB.SetFetchStatus(feed.CreateKey(), fetchStatus); err != nil {
+		log.WithField("feed", feed.URL).WithError(err).Error("Failed to save fetch status for mail feed")
+	}
+	return nil
+}
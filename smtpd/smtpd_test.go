@@ -0,0 +1,190 @@
+package smtpd
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+type DBMock struct {
+	mock.Mock
+}
+
+func (m *DBMock) GetUsers() ([]string, error) {
+	args := m.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *DBMock) GetUser(username string) (*data.User, error) {
+	args := m.Called(username)
+	user := args.Get(0)
+	var returnUser *data.User
+	if user != nil {
+		returnUser = user.(*data.User)
+	}
+	return returnUser, args.Error(1)
+}
+
+func (m *DBMock) AddUserFeed(username string, feed data.UserFeed) error {
+	args := m.Called(username, feed)
+	return args.Error(0)
+}
+
+func (m *DBMock) SaveFeeditems(feedItems ...*data.Feeditem) error {
+	args := m.Called(feedItems)
+	return args.Error(0)
+}
+
+func (m *DBMock) SetFetchStatus(key []byte, fetchStatus *data.FetchStatus) error {
+	args := m.Called(key, fetchStatus)
+	return args.Error(0)
+}
+
+func startServer(t *testing.T, dbMock *DBMock) (*Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := NewServer(dbMock)
+	server.Domain = "nanorss.local"
+	go server.Serve(listener)
+	return server, listener.Addr().String()
+}
+
+const htmlMessage = "From: \"Newsletter\" <news@site1.com>\r\n" +
+	"To: token1@nanorss.local\r\n" +
+	"Subject: =?UTF-8?Q?Weekly_=E2=80=94_issue_1?=\r\n" +
+	"Date: Sat, 16 Feb 2019 23:00:00 +0000\r\n" +
+	"Message-ID: <m1@site1.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/related; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Plain text\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<p onclick=3D\"alert()\">Hello <img src=3D\"cid:img1\"></p><script>alert()</script>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-ID: <img1>\r\n" +
+	"Content-Disposition: inline\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--outer--\r\n"
+
+func TestReceiveMessage(t *testing.T) {
+	dbMock := new(DBMock)
+	server, addr := startServer(t, dbMock)
+	defer server.Close()
+
+	user := data.NewUser("user01")
+	user.MailToken = "token1"
+	otherUser := data.NewUser("user02")
+
+	dbMock.On("GetUsers").Return([]string{"user02", "user01"}, nil)
+	dbMock.On("GetUser", "user01").Return(user, nil)
+	dbMock.On("GetUser", "user02").Return(otherUser, nil)
+
+	feed := data.UserFeed{URL: "mailto:news@site1.com?to=token1"}
+	dbMock.On("AddUserFeed", "user01", data.UserFeed{URL: feed.URL, Title: "Newsletter", Type: "email"}).Return(nil).Once()
+	var savedItems []*data.Feeditem
+	dbMock.On("SaveFeeditems", mock.AnythingOfType("[]*data.Feeditem")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			savedItems = args.Get(0).([]*data.Feeditem)
+		})
+	dbMock.On("SetFetchStatus", feed.CreateKey(), mock.AnythingOfType("*data.FetchStatus")).Return(nil).Once()
+
+	err := smtp.SendMail(addr, nil, "bounce@site1.com", []string{"token1@nanorss.local"}, []byte(htmlMessage))
+	assert.NoError(t, err)
+
+	assert.Len(t, savedItems, 1)
+	if len(savedItems) == 1 {
+		assert.False(t, savedItems[0].Updated.IsZero())
+		savedItems[0].Updated = time.Time{}
+		expectedDate := time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)
+		assert.True(t, expectedDate.Equal(savedItems[0].Date))
+		savedItems[0].Date = expectedDate
+		assert.Equal(t, &data.Feeditem{
+			Title:    "Weekly — issue 1",
+			Date:     expectedDate,
+			Contents: `<p>Hello <img src="data:image/png;base64,iVBORw0KGgo="></p>`,
+			Key:      &data.FeeditemKey{FeedURL: feed.URL, GUID: "m1@site1.com"},
+		}, savedItems[0])
+	}
+	dbMock.AssertExpectations(t)
+}
+
+func TestReceivePlaintextMessage(t *testing.T) {
+	dbMock := new(DBMock)
+	server, addr := startServer(t, dbMock)
+	defer server.Close()
+
+	user := data.NewUser("user01")
+	user.MailToken = "token1"
+	user.Subscriptions = []data.UserFeed{{URL: "mailto:plain@site1.com?to=token1", Title: "Plain", Type: "email"}}
+
+	dbMock.On("GetUsers").Return([]string{"user01"}, nil)
+	dbMock.On("GetUser", "user01").Return(user, nil)
+
+	feed := data.UserFeed{URL: "mailto:plain@site1.com?to=token1"}
+	dbMock.On("AddUserFeed", "user01", data.UserFeed{URL: feed.URL, Title: "plain@site1.com", Type: "email"}).Return(nil).Once()
+	var savedItems []*data.Feeditem
+	dbMock.On("SaveFeeditems", mock.AnythingOfType("[]*data.Feeditem")).Return(nil).Once().
+		Run(func(args mock.Arguments) {
+			savedItems = args.Get(0).([]*data.Feeditem)
+		})
+	dbMock.On("SetFetchStatus", feed.CreateKey(), mock.AnythingOfType("*data.FetchStatus")).Return(nil).Once()
+
+	message := "From: plain@site1.com\r\n" +
+		"Subject: Plain\r\n" +
+		"\r\n" +
+		"Line 1 <b>\r\n" +
+		"..Line 2\r\n"
+	err := smtp.SendMail(addr, nil, "plain@site1.com", []string{"TOKEN1@nanorss.local"}, []byte(message))
+	assert.NoError(t, err)
+
+	assert.Len(t, savedItems, 1)
+	if len(savedItems) == 1 {
+		assert.Equal(t, "Plain", savedItems[0].Title)
+		assert.Equal(t, "Line 1 &lt;b&gt;<br>..Line 2", savedItems[0].Contents)
+		assert.Equal(t, feed.URL, savedItems[0].Key.FeedURL)
+		assert.Len(t, savedItems[0].Key.GUID, 64)
+	}
+	dbMock.AssertExpectations(t)
+}
+
+func TestReceiveUnknownRecipient(t *testing.T) {
+	dbMock := new(DBMock)
+	server, addr := startServer(t, dbMock)
+	defer server.Close()
+
+	user := data.NewUser("user01")
+	user.MailToken = "token1"
+
+	dbMock.On("GetUsers").Return([]string{"user01"}, nil)
+	dbMock.On("GetUser", "user01").Return(user, nil)
+
+	err := smtp.SendMail(addr, nil, "news@site1.com", []string{"token2@nanorss.local"}, []byte(htmlMessage))
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "550"))
+
+	err = smtp.SendMail(addr, nil, "news@site1.com", []string{"token1@example.com"}, []byte(htmlMessage))
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "550"))
+
+	dbMock.AssertExpectations(t)
+}
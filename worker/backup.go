@@ -0,0 +1,256 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zlogic/nanorss-go/data"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BackupDB provides functions to back up the database.
+type BackupDB interface {
+	Backup(w io.Writer, options data.BackupOptions) error
+	GetLastBackupTime() (time.Time, error)
+	SetLastBackupTime(lastBackup time.Time) error
+}
+
+// BackupConfig specifies how scheduled backups should be created and rotated.
+type BackupConfig struct {
+	// Dir is the directory where backups are saved.
+	Dir string
+	// Interval is the time between backups.
+	Interval time.Duration
+	// KeepDaily is the number of days for which the latest daily backup is kept.
+	KeepDaily int
+	// KeepWeekly is the number of weeks for which the latest weekly backup is kept.
+	KeepWeekly int
+	// Options specifies how backups are written.
+	Options data.BackupOptions
+}
+
+// backupFilePrefix is the prefix of scheduled backup files, followed by the backup time.
+const backupFilePrefix = "nanorss-"
+
+// backupTimeFormat is the format of the backup time in scheduled backup filenames.
+const backupTimeFormat = "20060102-150405"
+
+// backupRetryInterval is the maximum time to wait before retrying a failed backup.
+const backupRetryInterval = time.Hour
+
+// parseIntEnv will try to parse the varName into an integer.
+// If varName is not set, will return defaultValue instead.
+func parseIntEnv(varName string, defaultValue int) (int, error) {
+	valueStr, ok := os.LookupEnv(varName)
+	if !ok || valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %v: %w", varName, err)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("%v cannot be negative", varName)
+	}
+	return value, nil
+}
+
+// BackupConfigFromEnv returns the scheduled backup configuration from environment variables.
+// If BACKUP_DIR is not set, scheduled backups are disabled and nil is returned.
+func BackupConfigFromEnv() (*BackupConfig, error) {
+	dir, ok := os.LookupEnv("BACKUP_DIR")
+	if !ok || dir == "" {
+		return nil, nil
+	}
+	config := &BackupConfig{Dir: dir}
+
+	intervalHours, err := parseIntEnv("BACKUP_INTERVAL_HOURS", 24)
+	if err != nil {
+		return nil, err
+	}
+	if intervalHours == 0 {
+		return nil, fmt.Errorf("BACKUP_INTERVAL_HOURS cannot be zero")
+	}
+	config.Interval = time.Duration(intervalHours) * time.Hour
+
+	if config.KeepDaily, err = parseIntEnv("BACKUP_KEEP_DAILY", 7); err != nil {
+		return nil, err
+	}
+	if config.KeepWeekly, err = parseIntEnv("BACKUP_KEEP_WEEKLY", 4); err != nil {
+		return nil, err
+	}
+	if config.KeepDaily == 0 && config.KeepWeekly == 0 {
+		return nil, fmt.Errorf("BACKUP_KEEP_DAILY and BACKUP_KEEP_WEEKLY cannot both be zero")
+	}
+
+	compression, ok := os.LookupEnv("BACKUP_COMPRESSION")
+	if !ok {
+		compression = string(data.CompressionGzip)
+	}
+	if config.Options.Compression, err = data.ParseCompression(compression); err != nil {
+		return nil, err
+	}
+	config.Options.Passphrase = os.Getenv("BACKUP_PASSPHRASE")
+	return config, nil
+}
+
+// WriteBackupFile writes a backup into filename.
+// The backup is written into a temporary file first, to avoid overwriting a previous backup with an incomplete one.
+func WriteBackupFile(db BackupDB, filename string, options data.BackupOptions) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if err := db.Backup(file, options); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// backupFilename returns the filename for a backup created at backupTime.
+func (config *BackupConfig) backupFilename(backupTime time.Time) string {
+	return backupFilePrefix + backupTime.UTC().Format(backupTimeFormat) + ".json" + config.Options.Compression.Extension()
+}
+
+// parseBackupFilename returns the time when a scheduled backup was created, or false if filename is not a scheduled backup.
+func parseBackupFilename(filename string) (time.Time, bool) {
+	if !strings.HasPrefix(filename, backupFilePrefix) || strings.HasSuffix(filename, ".tmp") {
+		return time.Time{}, false
+	}
+	timeStr := strings.TrimPrefix(filename, backupFilePrefix)
+	if len(timeStr) < len(backupTimeFormat) || !strings.HasPrefix(timeStr[len(backupTimeFormat):], ".json") {
+		return time.Time{}, false
+	}
+	backupTime, err := time.Parse(backupTimeFormat, timeStr[:len(backupTimeFormat)])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return backupTime, true
+}
+
+// runBackup creates a new backup and deletes old backups.
+func (config *BackupConfig) runBackup(db BackupDB, now time.Time) error {
+	filename := filepath.Join(config.Dir, config.backupFilename(now))
+	if err := WriteBackupFile(db, filename, config.Options); err != nil {
+		return fmt.Errorf("failed to write backup %v: %w", filename, err)
+	}
+	if err := db.SetLastBackupTime(now); err != nil {
+		return fmt.Errorf("failed to save last backup time: %w", err)
+	}
+	log.WithField("filename", filename).Info("Backed up")
+	return config.rotateBackups()
+}
+
+// rotateBackups deletes all scheduled backups, except the latest backup for the last KeepDaily days and KeepWeekly weeks.
+func (config *BackupConfig) rotateBackups() error {
+	entries, err := os.ReadDir(config.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	type backupFile struct {
+		name string
+		time time.Time
+	}
+	backups := make([]backupFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if backupTime, ok := parseBackupFilename(entry.Name()); ok {
+			backups = append(backups, backupFile{name: entry.Name(), time: backupTime})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+
+	keep := make(map[string]bool, len(backups))
+	days := make(map[string]bool, config.KeepDaily)
+	weeks := make(map[string]bool, config.KeepWeekly)
+	for _, backup := range backups {
+		day := backup.time.Format("2006-01-02")
+		if !days[day] && len(days) < config.KeepDaily {
+			days[day] = true
+			keep[backup.name] = true
+		}
+		year, week := backup.time.ISOWeek()
+		weekStr := fmt.Sprintf("%v-%v", year, week)
+		if !weeks[weekStr] && len(weeks) < config.KeepWeekly {
+			weeks[weekStr] = true
+			keep[backup.name] = true
+		}
+	}
+
+	for _, backup := range backups {
+		if keep[backup.name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(config.Dir, backup.name)); err != nil {
+			return fmt.Errorf("failed to delete old backup %v: %w", backup.name, err)
+		}
+		log.WithField("filename", backup.name).Info("Deleted old backup")
+	}
+	return nil
+}
+
+// nextBackupDelay returns the time until the next backup should be created.
+func (config *BackupConfig) nextBackupDelay(db BackupDB, now time.Time) time.Duration {
+	lastBackup, err := db.GetLastBackupTime()
+	if err != nil {
+		log.WithError(err).Error("Failed to get last backup time")
+	}
+	delay := lastBackup.Add(config.Interval).Sub(now)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+var backupQuit chan struct{}
+
+// StartBackups starts the scheduled backup goroutine.
+func StartBackups(db BackupDB, config *BackupConfig) {
+	retryInterval := backupRetryInterval
+	if config.Interval < retryInterval {
+		retryInterval = config.Interval
+	}
+	backupQuit = make(chan struct{})
+	go func() {
+		delay := config.nextBackupDelay(db, time.Now())
+		for {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+				if err := config.runBackup(db, time.Now()); err != nil {
+					log.WithError(err).Error("Scheduled backup failed")
+					delay = retryInterval
+				} else {
+					delay = config.Interval
+				}
+			case <-backupQuit:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// StopBackups stops the scheduled backup goroutine.
+func StopBackups() {
+	close(backupQuit)
+}
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/zlogic/nanorss-go/data"
+)
+
+type BackupDBMock struct {
+	mock.Mock
+}
+
+func (m *BackupDBMock) Backup(w io.Writer, options data.BackupOptions) error {
+	args := m.Called(options)
+	if _, err := w.Write([]byte(args.String(0))); err != nil {
+		return err
+	}
+	return args.Error(1)
+}
+
+func (m *BackupDBMock) GetLastBackupTime() (time.Time, error) {
+	args := m.Called()
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *BackupDBMock) SetLastBackupTime(lastBackup time.Time) error {
+	args := m.Called(lastBackup)
+	return args.Error(0)
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestParseBackupFilename(t *testing.T) {
+	backupTime, ok := parseBackupFilename("nanorss-20190216-230102.json.gz")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2019, time.February, 16, 23, 1, 2, 0, time.UTC), backupTime)
+
+	backupTime, ok = parseBackupFilename("nanorss-20190216-230102.json")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2019, time.February, 16, 23, 1, 2, 0, time.UTC), backupTime)
+
+	for _, filename := range []string{"nanorss.json", "nanorss-20190216.json", "nanorss-20190216-230102.txt", "nanorss-20190216-230102.json.123.tmp", "other-20190216-230102.json"} {
+		_, ok := parseBackupFilename(filename)
+		assert.False(t, ok, filename)
+	}
+}
+
+func TestRunBackup(t *testing.T) {
+	dir := t.TempDir()
+	dbMock := new(BackupDBMock)
+	config := &BackupConfig{Dir: dir, Interval: 24 * time.Hour, KeepDaily: 1, Options: data.BackupOptions{Compression: data.CompressionZstd}}
+
+	backupTime := time.Date(2019, time.February, 16, 23, 1, 2, 0, time.FixedZone("UTC+1", 60*60))
+	dbMock.On("Backup", config.Options).Return("backup1", nil).Once()
+	dbMock.On("SetLastBackupTime", backupTime).Return(nil).Once()
+
+	err := config.runBackup(dbMock, backupTime)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nanorss-20190216-220102.json.zst"}, listFiles(t, dir))
+
+	contents, err := os.ReadFile(filepath.Join(dir, "nanorss-20190216-220102.json.zst"))
+	assert.NoError(t, err)
+	assert.Equal(t, "backup1", string(contents))
+
+	backupTime = backupTime.Add(24 * time.Hour)
+	dbMock.On("Backup", config.Options).Return("backup2", fmt.Errorf("error")).Once()
+
+	err = config.runBackup(dbMock, backupTime)
+	assert.EqualError(t, err, "failed to write backup "+filepath.Join(dir, "nanorss-20190217-220102.json.zst")+": error")
+	assert.Equal(t, []string{"nanorss-20190216-220102.json.zst"}, listFiles(t, dir))
+
+	dbMock.On("Backup", config.Options).Return("backup2", nil).Once()
+	dbMock.On("SetLastBackupTime", backupTime).Return(nil).Once()
+
+	err = config.runBackup(dbMock, backupTime)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nanorss-20190217-220102.json.zst"}, listFiles(t, dir))
+
+	dbMock.AssertExpectations(t)
+}
+
+func TestRotateBackups(t *testing.T) {
+	dir := t.TempDir()
+	config := &BackupConfig{Dir: dir, KeepDaily: 3, KeepWeekly: 3}
+
+	start := time.Date(2019, time.January, 20, 1, 0, 0, 0, time.UTC)
+	for i := 0; i < 21; i++ {
+		for _, hour := range []int{0, 12} {
+			backupTime := start.AddDate(0, 0, i).Add(time.Duration(hour) * time.Hour)
+			err := os.WriteFile(filepath.Join(dir, config.backupFilename(backupTime)), []byte{}, 0644)
+			assert.NoError(t, err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(dir, "nanorss.json"), []byte{}, 0644)
+	assert.NoError(t, err)
+	err = os.Mkdir(filepath.Join(dir, "nanorss-20190101-000000.json"), 0755)
+	assert.NoError(t, err)
+
+	err = config.rotateBackups()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"nanorss-20190101-000000.json",
+		"nanorss-20190127-130000.json",
+		"nanorss-20190203-130000.json",
+		"nanorss-20190207-130000.json",
+		"nanorss-20190208-130000.json",
+		"nanorss-20190209-130000.json",
+		"nanorss.json",
+	}, listFiles(t, dir))
+}
+
+func TestNextBackupDelay(t *testing.T) {
+	dbMock := new(BackupDBMock)
+	config := &BackupConfig{Interval: 24 * time.Hour}
+	now := time.Date(2019, time.February, 16, 23, 0, 0, 0, time.UTC)
+
+	dbMock.On("GetLastBackupTime").Return(time.Time{}, nil).Once()
+	assert.Equal(t, time.Duration(0), config.nextBackupDelay(dbMock, now))
+
+	dbMock.On("GetLastBackupTime").Return(now.Add(-time.Hour), nil).Once()
+	assert.Equal(t, 23*time.Hour, config.nextBackupDelay(dbMock, now))
+
+	dbMock.On("GetLastBackupTime").Return(now.Add(-48*time.Hour), nil).Once()
+	assert.Equal(t, time.Duration(0), config.nextBackupDelay(dbMock, now))
+
+	dbMock.On("GetLastBackupTime").Return(time.Time{}, fmt.Errorf("error")).Once()
+	assert.Equal(t, time.Duration(0), config.nextBackupDelay(dbMock, now))
+
+	dbMock.AssertExpectations(t)
+}
+
+func TestBackupConfigFromEnv(t *testing.T) {
+	config, err := BackupConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	t.Setenv("BACKUP_DIR", "/backups")
+	config, err = BackupConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &BackupConfig{
+		Dir:        "/backups",
+		Interval:   24 * time.Hour,
+		KeepDaily:  7,
+		KeepWeekly: 4,
+		Options:    data.BackupOptions{Compression: data.CompressionGzip},
+	}, config)
+
+	t.Setenv("BACKUP_INTERVAL_HOURS", "6")
+	t.Setenv("BACKUP_KEEP_DAILY", "0")
+	t.Setenv("BACKUP_KEEP_WEEKLY", "10")
+	t.Setenv("BACKUP_COMPRESSION", "none")
+	t.Setenv("BACKUP_PASSPHRASE", "pass")
+	config, err = BackupConfigFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, &BackupConfig{
+		Dir:        "/backups",
+		Interval:   6 * time.Hour,
+		KeepDaily:  0,
+		KeepWeekly: 10,
+		Options:    data.BackupOptions{Passphrase: "pass"},
+	}, config)
+
+	t.Setenv("BACKUP_KEEP_WEEKLY", "0")
+	_, err = BackupConfigFromEnv()
+	assert.EqualError(t, err, "BACKUP_KEEP_DAILY and BACKUP_KEEP_WEEKLY cannot both be zero")
+
+	t.Setenv("BACKUP_KEEP_WEEKLY", "-1")
+	_, err = BackupConfigFromEnv()
+	assert.EqualError(t, err, "BACKUP_KEEP_WEEKLY cannot be negative")
+
+	t.Setenv("BACKUP_INTERVAL_HOURS", "0")
+	_, err = BackupConfigFromEnv()
+	assert.EqualError(t, err, "BACKUP_INTERVAL_HOURS cannot be zero")
+
+	t.Setenv("BACKUP_INTERVAL_HOURS", "daily")
+	_, err = BackupConfigFromEnv()
+	assert.EqualError(t, err, "cannot parse BACKUP_INTERVAL_HOURS: strconv.Atoi: parsing \"daily\": invalid syntax")
+
+	t.Setenv("BACKUP_INTERVAL_HOURS", "")
+	t.Setenv("BACKUP_KEEP_WEEKLY", "")
+	t.Setenv("BACKUP_COMPRESSION", "bzip2")
+	_, err = BackupConfigFromEnv()
+	assert.EqualError(t, err, "unsupported compression bzip2")
+}